@@ -10,8 +10,11 @@ import (
 
     "deliveryinfra/internal/config"
     "deliveryinfra/internal/db"
+    "deliveryinfra/internal/notify"
     "deliveryinfra/internal/rate"
+    "deliveryinfra/internal/secrets"
     "deliveryinfra/internal/server"
+    "deliveryinfra/internal/tracking"
 )
 
 func main() {
@@ -33,10 +36,114 @@ func main() {
         log.Fatalf("database ping failed: %v", err)
     }
 
+    // Stored secrets (webhook endpoints, outbound webhook signing) need
+    // SECRET_KEYS; reject bad keys here rather than at first use
+    var keys *secrets.Keyring
+    if strings.TrimSpace(cfg.SecretKeys) != "" {
+        if keys, err = secrets.NewKeyring(cfg.SecretKeys); err != nil {
+            log.Fatalf("invalid SECRET_KEYS: %v", err)
+        }
+    } else {
+        log.Printf("SECRET_KEYS not set; webhook endpoints and outbound webhooks are disabled")
+    }
+
+    // Declarative webhook mappings: the file first, then the database, which
+    // wins for the same source
+    if cfg.WebhookMappingsFile != "" {
+        data, err := os.ReadFile(cfg.WebhookMappingsFile)
+        if err != nil {
+            log.Fatalf("read WEBHOOK_MAPPINGS_FILE: %v", err)
+        }
+        specs, err := server.ParseMappingSpecs(data)
+        if err != nil {
+            log.Fatalf("invalid WEBHOOK_MAPPINGS_FILE: %v", err)
+        }
+        server.RegisterMappings(specs...)
+    }
+    specs, err := server.LoadMappingSpecs(ctx, pool)
+    if err != nil {
+        log.Fatalf("load webhook mappings: %v", err)
+    }
+    server.RegisterMappings(specs...)
+
     // Select rate provider from config
     provider := cfg.RateProvider
     est := rate.NewByName(provider)
-    r := server.NewWithEstimator(pool, est)
+    r := server.NewWithOptions(pool, server.Options{
+        Estimator:      est,
+        WebhookMaxBody: cfg.WebhookMaxBodyBytes,
+    })
+
+    // Tracking poller (opt-in); only the advisory-lock leader across replicas polls
+    if cfg.TrackingProvider != "" {
+        poller := server.NewPoller(pool, tracking.NewProviderByName(cfg.TrackingProvider), server.PollerConfig{
+            Tick:               cfg.TrackingPollInterval,
+            Concurrency:        cfg.TrackingPollConcurrency,
+            CarrierConcurrency: cfg.TrackingPollCarrierConcurrency,
+            Carriers:           cfg.TrackingPollCarriers,
+        })
+        pollCtx, stopPolling := context.WithCancel(context.Background())
+        defer stopPolling()
+        go poller.Run(pollCtx)
+        log.Printf("tracking poller enabled (TRACKING_PROVIDER=%s)", cfg.TrackingProvider)
+    }
+
+    // Webhook inbox: ingests accepted webhooks; safe to run on every replica
+    inbox := server.NewInboxProcessor(pool, server.InboxProcessorConfig{
+        Tick:        cfg.WebhookInboxInterval,
+        Concurrency: cfg.WebhookInboxConcurrency,
+        MaxAttempts: cfg.WebhookInboxMaxAttempts,
+    })
+    inboxCtx, stopInbox := context.WithCancel(context.Background())
+    defer stopInbox()
+    go inbox.Run(inboxCtx)
+
+    // Outbox relay: publishes domain events to outbound webhooks, live
+    // streams and, with channels configured, customer notifications (sent by
+    // cmd/worker); safe to run on every replica
+    var consumers []server.OutboxConsumer
+    var notifyKinds []string
+    for _, ch := range notify.NewChannels(cfg.Notify) {
+        notifyKinds = append(notifyKinds, ch.Kind())
+    }
+    if len(notifyKinds) > 0 {
+        consumers = append(consumers, server.NewNotificationConsumer(notifyKinds, cfg.PublicBaseURL))
+        log.Printf("customer notifications enabled (%s)", strings.Join(notifyKinds, ", "))
+    }
+    relay := server.NewOutboxRelay(pool, server.OutboxRelayConfig{
+        Tick:        cfg.OutboxRelayInterval,
+        Concurrency: cfg.OutboxRelayConcurrency,
+    }, consumers...)
+    relayCtx, stopRelay := context.WithCancel(context.Background())
+    defer stopRelay()
+    go relay.Run(relayCtx)
+
+    // Outbound webhooks: signed deliveries of emitted events; safe to run on
+    // every replica
+    if keys != nil {
+        dispatcher := server.NewWebhookDispatcher(pool, keys, server.WebhookDispatcherConfig{
+            Tick:             cfg.WebhookDispatchInterval,
+            Concurrency:      cfg.WebhookDispatchConcurrency,
+            MaxAttempts:      cfg.WebhookDispatchMaxAttempts,
+            AllowPrivateURLs: cfg.WebhookAllowPrivateURLs,
+        })
+        dispatchCtx, stopDispatch := context.WithCancel(context.Background())
+        defer stopDispatch()
+        go dispatcher.Run(dispatchCtx)
+    }
+
+    // Exception detector: stalled parcels, failed deliveries, returns, ETA breaches
+    rules, err := tracking.ParseExceptionRules(cfg.TrackingExceptionRules)
+    if err != nil {
+        log.Fatalf("invalid TRACKING_EXCEPTION_RULES: %v", err)
+    }
+    detector := server.NewExceptionDetector(pool, server.ExceptionDetectorConfig{
+        Tick:  cfg.TrackingExceptionInterval,
+        Rules: rules,
+    })
+    detectCtx, stopDetecting := context.WithCancel(context.Background())
+    defer stopDetecting()
+    go detector.Run(detectCtx)
 
     srv := &http.Server{
         Addr:              ":" + cfg.Port,
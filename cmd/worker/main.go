@@ -0,0 +1,63 @@
+package main
+
+import (
+    "context"
+    "log"
+    "os"
+    "os/signal"
+    "strings"
+    "syscall"
+    "time"
+
+    "deliveryinfra/internal/config"
+    "deliveryinfra/internal/db"
+    "deliveryinfra/internal/jobs"
+    "deliveryinfra/internal/notify"
+    "deliveryinfra/internal/server"
+    "deliveryinfra/internal/tracking"
+)
+
+func main() {
+    cfg := config.Load()
+
+    if strings.TrimSpace(cfg.DatabaseURL) == "" {
+        log.Fatalf("DATABASE_URL not set. Please export DATABASE_URL before running.")
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    pool, err := db.NewPool(ctx, cfg.DatabaseURL)
+    if err != nil {
+        log.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+    if err := pool.Ping(ctx); err != nil {
+        log.Fatalf("database ping failed: %v", err)
+    }
+
+    w := jobs.NewWorker(pool, jobs.Config{
+        Concurrency:  cfg.WorkerConcurrency,
+        PollInterval: cfg.WorkerPollInterval,
+        DrainTimeout: cfg.WorkerDrainTimeout,
+    })
+    channels := notify.NewChannels(cfg.Notify)
+    // Without TRACKING_PROVIDER, tracking.update jobs are acknowledged unfetched
+    var provider tracking.Provider
+    if cfg.TrackingProvider != "" {
+        provider = tracking.NewProviderByName(cfg.TrackingProvider)
+    }
+    server.RegisterJobHandlers(w, pool, provider, channels)
+    if len(channels) == 0 {
+        log.Printf("no notification channels configured (SMTP_*, TWILIO_*, LINE_*); notifications are not sent")
+    }
+
+    // SIGTERM stops claiming and drains running jobs before exiting
+    runCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+    defer stop()
+    log.Printf("worker running (kinds: %s)", strings.Join(w.Kinds(), ", "))
+    if err := w.Run(runCtx); err != nil {
+        log.Println("worker error:", err)
+        os.Exit(1)
+    }
+    log.Println("worker stopped")
+}
@@ -77,6 +77,8 @@ CREATE TABLE IF NOT EXISTS shipments (
   org_id UUID NOT NULL REFERENCES orgs(id) ON DELETE CASCADE,
   order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
   carrier_account_id UUID REFERENCES carrier_accounts(id) ON DELETE SET NULL,
+  -- Carrier service level (e.g. express, cool); selects exception thresholds
+  service_code TEXT,
   status TEXT NOT NULL DEFAULT 'created',
   rate_currency TEXT,
   rate_amount NUMERIC(12,2),
@@ -89,6 +91,31 @@ CREATE TABLE IF NOT EXISTS shipments (
 );
 CREATE INDEX IF NOT EXISTS idx_shipments_org_status ON shipments(org_id, status);
 CREATE INDEX IF NOT EXISTS idx_shipments_order ON shipments(order_id);
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS service_code TEXT;
+
+-- Order Line Items
+CREATE TABLE IF NOT EXISTS order_items (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  sku TEXT NOT NULL,
+  name TEXT,
+  quantity INTEGER NOT NULL CHECK (quantity > 0),
+  weight_oz NUMERIC(10,2),
+  unit_price NUMERIC(12,2),
+  currency TEXT,
+  metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (order_id, sku)
+);
+
+-- Shipment Items (order line item quantities packed into each shipment)
+CREATE TABLE IF NOT EXISTS shipment_items (
+  shipment_id UUID NOT NULL REFERENCES shipments(id) ON DELETE CASCADE,
+  order_item_id UUID NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+  quantity INTEGER NOT NULL CHECK (quantity > 0),
+  PRIMARY KEY (shipment_id, order_item_id)
+);
+CREATE INDEX IF NOT EXISTS idx_shipment_items_order_item ON shipment_items(order_item_id);
 
 -- Labels
 CREATE TABLE IF NOT EXISTS labels (
@@ -109,25 +136,103 @@ CREATE TABLE IF NOT EXISTS trackers (
   id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
   shipment_id UUID REFERENCES shipments(id) ON DELETE CASCADE,
   carrier_tracking_code TEXT NOT NULL UNIQUE,
+  carrier_code TEXT,
   status TEXT,
+  substatus TEXT,
   last_event_at TIMESTAMPTZ,
+  next_poll_at TIMESTAMPTZ,
+  last_polled_at TIMESTAMPTZ,
+  poll_failures INTEGER NOT NULL DEFAULT 0,
+  -- Predicted delivery with an 80% interval from lane history (lane_transit_times)
+  eta_estimated_at TIMESTAMPTZ,
+  eta_earliest TIMESTAMPTZ,
+  eta_latest TIMESTAMPTZ,
+  eta_samples INTEGER,
+  eta_basis TEXT,
+  eta_updated_at TIMESTAMPTZ,
+  -- Org whose webhook endpoint created the tracker before any shipment
+  -- claimed it; only that org's endpoints and shipments may use it
+  created_by_org_id UUID REFERENCES orgs(id) ON DELETE SET NULL,
   metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 CREATE INDEX IF NOT EXISTS idx_trackers_shipment ON trackers(shipment_id);
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS substatus TEXT;
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS carrier_code TEXT;
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMPTZ;
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS last_polled_at TIMESTAMPTZ;
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS poll_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS eta_estimated_at TIMESTAMPTZ;
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS eta_earliest TIMESTAMPTZ;
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS eta_latest TIMESTAMPTZ;
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS eta_samples INTEGER;
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS eta_basis TEXT;
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS eta_updated_at TIMESTAMPTZ;
+ALTER TABLE trackers ADD COLUMN IF NOT EXISTS created_by_org_id UUID REFERENCES orgs(id) ON DELETE SET NULL;
+-- Poller scan: active trackers with a carrier, ordered by due time
+CREATE INDEX IF NOT EXISTS idx_trackers_next_poll ON trackers(next_poll_at)
+  WHERE carrier_code IS NOT NULL AND status IS DISTINCT FROM 'delivered' AND status IS DISTINCT FROM 'return_to_sender';
 
 -- Tracking Events
 CREATE TABLE IF NOT EXISTS tracking_events (
   id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
   tracker_id UUID NOT NULL REFERENCES trackers(id) ON DELETE CASCADE,
   occurred_at TIMESTAMPTZ NOT NULL,
+  -- Canonical status (pre_transit, in_transit, out_for_delivery, delivered,
+  -- available_for_pickup, return_to_sender, failure, exception, unknown)
   status TEXT,
+  substatus TEXT,
+  -- Status exactly as reported by the carrier/source
+  carrier_status TEXT,
   description TEXT,
   location JSONB NOT NULL DEFAULT '{}'::jsonb,
   raw JSONB NOT NULL DEFAULT '{}'::jsonb,
+  -- Ingestion order
+  seq BIGINT GENERATED ALWAYS AS IDENTITY,
+  -- Publish order, assigned by the outbox relay; SSE event id used for
+  -- Last-Event-ID resume. NULL until the event is published
+  stream_seq BIGINT,
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 CREATE INDEX IF NOT EXISTS idx_tracking_events_tracker_occurred ON tracking_events(tracker_id, occurred_at);
+ALTER TABLE tracking_events ADD COLUMN IF NOT EXISTS substatus TEXT;
+ALTER TABLE tracking_events ADD COLUMN IF NOT EXISTS carrier_status TEXT;
+-- ADD COLUMN IF NOT EXISTS would still create an identity sequence, so check first
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_schema = 'public' AND table_name = 'tracking_events' AND column_name = 'seq'
+  ) THEN
+    ALTER TABLE tracking_events ADD COLUMN seq BIGINT GENERATED ALWAYS AS IDENTITY;
+  END IF;
+END $$;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tracking_events_seq ON tracking_events(seq);
+CREATE INDEX IF NOT EXISTS idx_tracking_events_tracker_seq ON tracking_events(tracker_id, seq);
+
+-- Stream positions are taken from a single counter row rather than a
+-- sequence: the row lock is held until the relay commits, so positions become
+-- visible in order and a stream cursor never skips a later-committed event.
+-- Existing events keep their seq as position when the column is added
+CREATE TABLE IF NOT EXISTS tracking_stream_counter (
+  id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+  last_seq BIGINT NOT NULL
+);
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_schema = 'public' AND table_name = 'tracking_events' AND column_name = 'stream_seq'
+  ) THEN
+    ALTER TABLE tracking_events ADD COLUMN stream_seq BIGINT;
+    UPDATE tracking_events SET stream_seq = seq;
+  END IF;
+END $$;
+INSERT INTO tracking_stream_counter (last_seq)
+SELECT COALESCE(MAX(stream_seq), 0) FROM tracking_events
+ON CONFLICT (id) DO NOTHING;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tracking_events_stream_seq ON tracking_events(stream_seq);
+CREATE INDEX IF NOT EXISTS idx_tracking_events_tracker_stream_seq ON tracking_events(tracker_id, stream_seq);
 
 -- Idempotency: prevent duplicate events for same tracker/time/status/description
 -- Note: NULLs are treated as empty strings for status/description via COALESCE
@@ -139,6 +244,63 @@ CREATE UNIQUE INDEX IF NOT EXISTS idx_tracking_events_dedupe
     COALESCE(description, ''::text)
   );
 
+-- Tracking Exceptions (stalled, delivery_failures, return_to_sender, eta_breached)
+CREATE TABLE IF NOT EXISTS tracking_exceptions (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  tracker_id UUID NOT NULL REFERENCES trackers(id) ON DELETE CASCADE,
+  shipment_id UUID REFERENCES shipments(id) ON DELETE CASCADE,
+  reason TEXT NOT NULL,
+  detail JSONB NOT NULL DEFAULT '{}'::jsonb,
+  detected_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  resolved_at TIMESTAMPTZ
+);
+-- At most one open exception per tracker and reason
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tracking_exceptions_open
+  ON tracking_exceptions(tracker_id, reason) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_tracking_exceptions_shipment ON tracking_exceptions(shipment_id);
+
+-- Lane Transit Times: pickup-to-delivery time of each delivered tracker, the
+-- history behind ETA prediction. Lanes are carrier, service and origin/destination
+-- (country, plus state/prefecture as region, lowercased).
+CREATE TABLE IF NOT EXISTS lane_transit_times (
+  tracker_id UUID PRIMARY KEY REFERENCES trackers(id) ON DELETE CASCADE,
+  carrier_code TEXT NOT NULL,
+  service_code TEXT NOT NULL DEFAULT '',
+  origin_country TEXT NOT NULL DEFAULT '',
+  origin_region TEXT NOT NULL DEFAULT '',
+  destination_country TEXT NOT NULL DEFAULT '',
+  destination_region TEXT NOT NULL DEFAULT '',
+  picked_up_at TIMESTAMPTZ NOT NULL,
+  delivered_at TIMESTAMPTZ NOT NULL,
+  transit_seconds BIGINT NOT NULL CHECK (transit_seconds > 0)
+);
+CREATE INDEX IF NOT EXISTS idx_lane_transit_times_lane
+  ON lane_transit_times(carrier_code, origin_country, destination_country, origin_region, destination_region, delivered_at DESC);
+-- Backfill from trackers delivered before the table existed
+INSERT INTO lane_transit_times (
+  tracker_id, carrier_code, service_code, origin_country, origin_region,
+  destination_country, destination_region, picked_up_at, delivered_at, transit_seconds
+)
+SELECT t.id, t.carrier_code, lower(btrim(COALESCE(s.service_code, ''))),
+       upper(btrim(COALESCE(s.ship_from->>'country', ''))),
+       lower(btrim(COALESCE(NULLIF(btrim(s.ship_from->>'state'), ''), s.ship_from->>'prefecture', ''))),
+       upper(btrim(COALESCE(s.ship_to->>'country', ''))),
+       lower(btrim(COALESCE(NULLIF(btrim(s.ship_to->>'state'), ''), s.ship_to->>'prefecture', ''))),
+       p.picked_up_at, d.delivered_at, EXTRACT(EPOCH FROM d.delivered_at - p.picked_up_at)::bigint
+FROM trackers t
+JOIN shipments s ON s.id = t.shipment_id
+JOIN LATERAL (
+  SELECT MIN(e.occurred_at) AS picked_up_at FROM tracking_events e
+  WHERE e.tracker_id = t.id AND e.status IS NOT NULL AND e.status NOT IN ('pre_transit', 'unknown')
+) p ON TRUE
+JOIN LATERAL (
+  SELECT MAX(e.occurred_at) AS delivered_at FROM tracking_events e
+  WHERE e.tracker_id = t.id AND e.status = 'delivered'
+) d ON TRUE
+WHERE t.status = 'delivered' AND t.carrier_code IS NOT NULL
+  AND EXTRACT(EPOCH FROM d.delivered_at - p.picked_up_at) >= 1
+ON CONFLICT (tracker_id) DO NOTHING;
+
 -- Pickups
 CREATE TABLE IF NOT EXISTS pickups (
   id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -152,7 +314,7 @@ CREATE TABLE IF NOT EXISTS pickups (
 );
 CREATE INDEX IF NOT EXISTS idx_pickups_org_date ON pickups(org_id, pickup_date);
 
--- Notifications (generic outbound integrations)
+-- Notifications (customer messages over email/SMS/LINE)
 CREATE TABLE IF NOT EXISTS notifications (
   id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
   org_id UUID REFERENCES orgs(id) ON DELETE CASCADE,
@@ -164,6 +326,26 @@ CREATE TABLE IF NOT EXISTS notifications (
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 CREATE INDEX IF NOT EXISTS idx_notifications_org_kind ON notifications(org_id, kind);
+-- Notification log: one row per message to one recipient (kind is the
+-- channel: email/sms/line; endpoint is the address), created from a domain
+-- event and sent by a notify:* job
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS shipment_id UUID REFERENCES shipments(id) ON DELETE CASCADE;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS event TEXT;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS event_id UUID;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS subject TEXT;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS body TEXT;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'pending'
+  CHECK (status IN ('pending', 'sent', 'failed'));
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS provider TEXT;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS provider_message_id TEXT;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS sent_at TIMESTAMPTZ;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+-- One message per event, channel and recipient, however often the event is relayed
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_notifications_event_recipient ON notifications(event_id, kind, endpoint)
+  WHERE event_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_notifications_shipment ON notifications(shipment_id, created_at);
 
 -- Webhooks (event subscriptions)
 CREATE TABLE IF NOT EXISTS webhooks (
@@ -177,6 +359,203 @@ CREATE TABLE IF NOT EXISTS webhooks (
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 CREATE INDEX IF NOT EXISTS idx_webhooks_org_event ON webhooks(org_id, event);
+-- Delivery health: the dispatcher disables a webhook after sustained failures
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS consecutive_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS failing_since TIMESTAMPTZ;
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS last_success_at TIMESTAMPTZ;
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS last_failure_at TIMESTAMPTZ;
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS disabled_reason TEXT;
+-- Managed through /webhook-subscriptions; a rotated-out secret keeps signing
+-- alongside the new one until previous_secret_expires_at
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS previous_secret_encrypted BYTEA;
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS previous_secret_expires_at TIMESTAMPTZ;
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS secret_rotated_at TIMESTAMPTZ;
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Webhook Mappings: declarative normalizer specs for webhook sources without
+-- a dedicated normalizer (see server.MappingSpec), loaded at API startup
+CREATE TABLE IF NOT EXISTS webhook_mappings (
+  source TEXT PRIMARY KEY,
+  spec JSONB NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Webhook Deliveries: delivery IDs (nonces) of accepted inbound webhooks, for
+-- replay protection; pruned after the retention window
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  source TEXT NOT NULL,
+  delivery_id TEXT NOT NULL,
+  received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (source, delivery_id)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_received ON webhook_deliveries(received_at);
+
+-- Webhook Endpoints: per-org inbound webhook endpoints at
+-- /webhooks/{source}/{id}
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  org_id UUID NOT NULL REFERENCES orgs(id) ON DELETE CASCADE,
+  source TEXT NOT NULL,
+  normalizer TEXT,
+  verifier JSONB NOT NULL DEFAULT '{}'::jsonb,
+  description TEXT,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_org ON webhook_endpoints(org_id);
+
+-- Webhook Endpoint Secrets: an endpoint's signing secrets, sealed with
+-- SECRET_KEYS; every secret within its validity window verifies deliveries,
+-- so secrets rotate without downtime
+CREATE TABLE IF NOT EXISTS webhook_endpoint_secrets (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  endpoint_id UUID NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+  secret_encrypted BYTEA NOT NULL,
+  is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+  active_from TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ,
+  last_matched_at TIMESTAMPTZ,
+  match_count BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoint_secrets_endpoint ON webhook_endpoint_secrets(endpoint_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_webhook_endpoint_secrets_primary ON webhook_endpoint_secrets(endpoint_id) WHERE is_primary;
+
+-- Endpoints created before rotation kept a single secret on the endpoint row
+DO $$
+BEGIN
+  IF EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'webhook_endpoints' AND column_name = 'secret_encrypted'
+  ) THEN
+    INSERT INTO webhook_endpoint_secrets (endpoint_id, secret_encrypted, is_primary, active_from, created_at)
+    SELECT id, secret_encrypted, TRUE, created_at, created_at FROM webhook_endpoints;
+    ALTER TABLE webhook_endpoints DROP COLUMN secret_encrypted;
+  END IF;
+END $$;
+
+-- Webhook Inbox: every verified inbound webhook, stored before it is
+-- acknowledged and ingested asynchronously by the inbox processor; entries out
+-- of retries or with unparseable payloads are dead-lettered
+CREATE TABLE IF NOT EXISTS webhook_inbox (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  source TEXT NOT NULL,
+  normalizer TEXT NOT NULL,
+  endpoint_id UUID REFERENCES webhook_endpoints(id) ON DELETE SET NULL,
+  org_id UUID REFERENCES orgs(id) ON DELETE CASCADE,
+  delivery_id TEXT,
+  body BYTEA NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processed', 'dead')),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_error TEXT,
+  result JSONB,
+  received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  processed_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_inbox_due ON webhook_inbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_inbox_status_received ON webhook_inbox(status, received_at);
+
+-- Webhook Events: outbound events (shipment.created, tracker.updated, ...),
+-- recorded only when some webhook subscribes
+CREATE TABLE IF NOT EXISTS webhook_events (
+  id UUID PRIMARY KEY,
+  org_id UUID NOT NULL REFERENCES orgs(id) ON DELETE CASCADE,
+  type TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_events_org_created ON webhook_events(org_id, created_at);
+
+-- Webhook Dispatches: one per event and subscribed webhook, retried with
+-- backoff by the dispatcher until delivered or failed
+CREATE TABLE IF NOT EXISTS webhook_dispatches (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+  event_id UUID NOT NULL REFERENCES webhook_events(id) ON DELETE CASCADE,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_error TEXT,
+  delivered_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_dispatches_due ON webhook_dispatches(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_dispatches_webhook_created ON webhook_dispatches(webhook_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_dispatches_event ON webhook_dispatches(event_id);
+
+-- Webhook Attempts: every outbound request with its response or error
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  dispatch_id UUID NOT NULL REFERENCES webhook_dispatches(id) ON DELETE CASCADE,
+  webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+  event_id UUID NOT NULL,
+  attempt INTEGER NOT NULL,
+  request_url TEXT NOT NULL,
+  request_headers JSONB NOT NULL DEFAULT '{}'::jsonb,
+  request_body TEXT,
+  response_status INTEGER,
+  response_body TEXT,
+  latency_ms BIGINT,
+  error TEXT,
+  attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_dispatch ON webhook_attempts(dispatch_id, attempt);
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_webhook_time ON webhook_attempts(webhook_id, attempted_at DESC);
+
+-- Outbox: domain events (shipment.created, tracker.updated, tracking event
+-- ingested, ...) written in the transaction of their change and published to
+-- consumers (outbound webhooks, live streams) by the outbox relay, in id order
+-- per aggregate
+CREATE TABLE IF NOT EXISTS outbox (
+  id BIGSERIAL PRIMARY KEY,
+  event_id UUID NOT NULL DEFAULT gen_random_uuid() UNIQUE,
+  org_id UUID REFERENCES orgs(id) ON DELETE CASCADE,
+  aggregate_type TEXT NOT NULL,
+  aggregate_id UUID NOT NULL,
+  type TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'published', 'dead')),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_error TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  published_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_outbox_pending_aggregate ON outbox(aggregate_id, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_outbox_published ON outbox(published_at) WHERE status = 'published';
+
+-- Jobs: background work (tracking.update, notify:*, ...) claimed by workers
+-- with FOR UPDATE SKIP LOCKED. Running jobs hold a lease (locked_until) that
+-- another worker takes over once it lapses; dead jobs wait for a retry
+CREATE TABLE IF NOT EXISTS jobs (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  kind TEXT NOT NULL,
+  payload JSONB NOT NULL DEFAULT '{}'::jsonb,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'dead')),
+  unique_key TEXT,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  max_attempts INTEGER NOT NULL DEFAULT 10 CHECK (max_attempts > 0),
+  run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  locked_by TEXT,
+  locked_until TIMESTAMPTZ,
+  last_error TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  finished_at TIMESTAMPTZ
+);
+-- At most one live job per kind and unique key
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_jobs_kind_unique_key ON jobs(kind, unique_key)
+  WHERE unique_key IS NOT NULL AND status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(kind, run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_jobs_lease ON jobs(locked_until) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS idx_jobs_finished ON jobs(finished_at) WHERE status = 'succeeded';
 
 -- FX Rates
 CREATE TABLE IF NOT EXISTS fx_rates (
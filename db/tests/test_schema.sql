@@ -57,6 +57,140 @@ INSERT INTO test_unique_orders_org_external(ok)
 SELECT to_regclass('public.orders_org_id_external_order_id_key') IS NOT NULL;
 ALTER TABLE test_unique_orders_org_external ADD CONSTRAINT check_unique_orders_org_external CHECK (ok);
 
+-- Order line items / shipment items
+CREATE TEMPORARY TABLE test_unique_order_items_sku(ok BOOLEAN);
+INSERT INTO test_unique_order_items_sku(ok)
+SELECT to_regclass('public.order_items_order_id_sku_key') IS NOT NULL;
+ALTER TABLE test_unique_order_items_sku ADD CONSTRAINT check_unique_order_items_sku CHECK (ok);
+
+CREATE TEMPORARY TABLE test_idx_shipment_items_order_item(ok BOOLEAN);
+INSERT INTO test_idx_shipment_items_order_item(ok)
+SELECT to_regclass('public.idx_shipment_items_order_item') IS NOT NULL;
+ALTER TABLE test_idx_shipment_items_order_item ADD CONSTRAINT check_idx_shipment_items_order_item CHECK (ok);
+
+-- Live streams resume from the relay-assigned stream position
+CREATE TEMPORARY TABLE test_tracking_stream_counter(ok BOOLEAN);
+INSERT INTO test_tracking_stream_counter(ok)
+SELECT (SELECT COUNT(*) FROM tracking_stream_counter) = 1
+   AND to_regclass('public.idx_tracking_events_stream_seq') IS NOT NULL;
+ALTER TABLE test_tracking_stream_counter ADD CONSTRAINT check_tracking_stream_counter CHECK (ok);
+
+-- Status taxonomy columns
+CREATE TEMPORARY TABLE test_tracking_events_status_columns(ok BOOLEAN);
+INSERT INTO test_tracking_events_status_columns(ok)
+SELECT (SELECT COUNT(*) FROM information_schema.columns
+        WHERE table_schema = 'public' AND table_name = 'tracking_events'
+          AND column_name IN ('substatus', 'carrier_status')) = 2;
+ALTER TABLE test_tracking_events_status_columns ADD CONSTRAINT check_tracking_events_status_columns CHECK (ok);
+
+-- Trackers record the carrier they were registered with
+CREATE TEMPORARY TABLE test_trackers_carrier_code(ok BOOLEAN);
+INSERT INTO test_trackers_carrier_code(ok)
+SELECT EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = 'public' AND table_name = 'trackers' AND column_name = 'carrier_code');
+ALTER TABLE test_trackers_carrier_code ADD CONSTRAINT check_trackers_carrier_code CHECK (ok);
+
+-- Poller scheduling index
+CREATE TEMPORARY TABLE test_idx_trackers_next_poll(ok BOOLEAN);
+INSERT INTO test_idx_trackers_next_poll(ok)
+SELECT to_regclass('public.idx_trackers_next_poll') IS NOT NULL;
+ALTER TABLE test_idx_trackers_next_poll ADD CONSTRAINT check_idx_trackers_next_poll CHECK (ok);
+
+-- Stream resume cursor
+CREATE TEMPORARY TABLE test_idx_tracking_events_seq(ok BOOLEAN);
+INSERT INTO test_idx_tracking_events_seq(ok)
+SELECT to_regclass('public.idx_tracking_events_seq') IS NOT NULL
+   AND to_regclass('public.idx_tracking_events_tracker_seq') IS NOT NULL;
+ALTER TABLE test_idx_tracking_events_seq ADD CONSTRAINT check_idx_tracking_events_seq CHECK (ok);
+
+-- Exception detection: one open exception per tracker/reason, service level on shipments
+CREATE TEMPORARY TABLE test_tracking_exceptions(ok BOOLEAN);
+INSERT INTO test_tracking_exceptions(ok)
+SELECT to_regclass('public.tracking_exceptions') IS NOT NULL
+   AND to_regclass('public.idx_tracking_exceptions_open') IS NOT NULL
+   AND EXISTS (
+     SELECT 1 FROM information_schema.columns
+     WHERE table_schema = 'public' AND table_name = 'shipments' AND column_name = 'service_code'
+   );
+ALTER TABLE test_tracking_exceptions ADD CONSTRAINT check_tracking_exceptions CHECK (ok);
+
+-- ETA prediction: lane history and predicted delivery on trackers
+CREATE TEMPORARY TABLE test_lane_transit_times(ok BOOLEAN);
+INSERT INTO test_lane_transit_times(ok)
+SELECT to_regclass('public.lane_transit_times') IS NOT NULL
+   AND to_regclass('public.idx_lane_transit_times_lane') IS NOT NULL
+   AND (SELECT COUNT(*) FROM information_schema.columns
+        WHERE table_schema = 'public' AND table_name = 'trackers'
+          AND column_name IN ('eta_estimated_at', 'eta_earliest', 'eta_latest', 'eta_samples', 'eta_basis', 'eta_updated_at')) = 6;
+ALTER TABLE test_lane_transit_times ADD CONSTRAINT check_lane_transit_times CHECK (ok);
+
+CREATE TEMPORARY TABLE test_webhook_mappings(ok BOOLEAN);
+INSERT INTO test_webhook_mappings(ok)
+SELECT to_regclass('public.webhook_mappings') IS NOT NULL;
+ALTER TABLE test_webhook_mappings ADD CONSTRAINT check_webhook_mappings CHECK (ok);
+
+CREATE TEMPORARY TABLE test_webhook_deliveries(ok BOOLEAN);
+INSERT INTO test_webhook_deliveries(ok)
+SELECT to_regclass('public.webhook_deliveries') IS NOT NULL
+   AND to_regclass('public.idx_webhook_deliveries_received') IS NOT NULL;
+ALTER TABLE test_webhook_deliveries ADD CONSTRAINT check_webhook_deliveries CHECK (ok);
+
+CREATE TEMPORARY TABLE test_webhook_endpoints(ok BOOLEAN);
+INSERT INTO test_webhook_endpoints(ok)
+SELECT to_regclass('public.webhook_endpoints') IS NOT NULL
+   AND to_regclass('public.idx_webhook_endpoints_org') IS NOT NULL;
+ALTER TABLE test_webhook_endpoints ADD CONSTRAINT check_webhook_endpoints CHECK (ok);
+
+CREATE TEMPORARY TABLE test_webhook_endpoint_secrets(ok BOOLEAN);
+INSERT INTO test_webhook_endpoint_secrets(ok)
+SELECT to_regclass('public.webhook_endpoint_secrets') IS NOT NULL
+   AND to_regclass('public.uniq_webhook_endpoint_secrets_primary') IS NOT NULL;
+ALTER TABLE test_webhook_endpoint_secrets ADD CONSTRAINT check_webhook_endpoint_secrets CHECK (ok);
+
+CREATE TEMPORARY TABLE test_trackers_created_by_org(ok BOOLEAN);
+INSERT INTO test_trackers_created_by_org(ok)
+SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'trackers' AND column_name = 'created_by_org_id');
+ALTER TABLE test_trackers_created_by_org ADD CONSTRAINT check_trackers_created_by_org CHECK (ok);
+
+CREATE TEMPORARY TABLE test_webhook_inbox(ok BOOLEAN);
+INSERT INTO test_webhook_inbox(ok)
+SELECT to_regclass('public.webhook_inbox') IS NOT NULL
+   AND to_regclass('public.idx_webhook_inbox_due') IS NOT NULL;
+ALTER TABLE test_webhook_inbox ADD CONSTRAINT check_webhook_inbox CHECK (ok);
+
+CREATE TEMPORARY TABLE test_webhook_dispatch(ok BOOLEAN);
+INSERT INTO test_webhook_dispatch(ok)
+SELECT to_regclass('public.webhook_events') IS NOT NULL
+   AND to_regclass('public.webhook_dispatches') IS NOT NULL
+   AND to_regclass('public.webhook_attempts') IS NOT NULL
+   AND to_regclass('public.idx_webhook_dispatches_due') IS NOT NULL
+   AND EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'webhooks' AND column_name = 'consecutive_failures');
+ALTER TABLE test_webhook_dispatch ADD CONSTRAINT check_webhook_dispatch CHECK (ok);
+
+CREATE TEMPORARY TABLE test_webhook_subscriptions(ok BOOLEAN);
+INSERT INTO test_webhook_subscriptions(ok)
+SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'webhooks' AND column_name = 'previous_secret_encrypted')
+   AND EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'webhooks' AND column_name = 'updated_at');
+ALTER TABLE test_webhook_subscriptions ADD CONSTRAINT check_webhook_subscriptions CHECK (ok);
+
+CREATE TEMPORARY TABLE test_outbox(ok BOOLEAN);
+INSERT INTO test_outbox(ok)
+SELECT to_regclass('public.outbox') IS NOT NULL
+   AND to_regclass('public.idx_outbox_pending_aggregate') IS NOT NULL;
+ALTER TABLE test_outbox ADD CONSTRAINT check_outbox CHECK (ok);
+
+CREATE TEMPORARY TABLE test_jobs(ok BOOLEAN);
+INSERT INTO test_jobs(ok)
+SELECT to_regclass('public.jobs') IS NOT NULL
+   AND to_regclass('public.uniq_jobs_kind_unique_key') IS NOT NULL;
+ALTER TABLE test_jobs ADD CONSTRAINT check_jobs CHECK (ok);
+
+CREATE TEMPORARY TABLE test_notifications(ok BOOLEAN);
+INSERT INTO test_notifications(ok)
+SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'notifications' AND column_name = 'provider_message_id')
+   AND to_regclass('public.uniq_notifications_event_recipient') IS NOT NULL;
+ALTER TABLE test_notifications ADD CONSTRAINT check_notifications CHECK (ok);
+
 -- Verify unique dedupe prevents exact duplicates (status/description equality, nulls treated as empty)
 CREATE TEMPORARY TABLE test_tracking_events_dedupe(ok BOOLEAN);
 DO $$
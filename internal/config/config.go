@@ -2,12 +2,59 @@ package config
 
 import (
     "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "deliveryinfra/internal/notify"
 )
 
 type Config struct {
-    DatabaseURL string
-    Port        string
+    DatabaseURL  string
+    Port         string
     RateProvider string
+    // TrackingProvider enables the tracking poller when set (e.g. "noop").
+    TrackingProvider     string
+    TrackingPollInterval time.Duration
+    // TrackingPollCarriers limits polling to carriers without webhooks.
+    TrackingPollCarriers    []string
+    TrackingPollConcurrency int
+    // TrackingPollCarrierConcurrency overrides per carrier, from "dhl=2,yamato=8".
+    TrackingPollCarrierConcurrency map[string]int
+    // TrackingExceptionRules is JSON with default and per carrier/service
+    // thresholds for the exception detector (see tracking.ParseExceptionRules).
+    TrackingExceptionRules    string
+    TrackingExceptionInterval time.Duration
+    // WebhookMappingsFile is a JSON file of declarative webhook mapping specs.
+    WebhookMappingsFile string
+    // SecretKeys are the versioned keys encrypting stored secrets, as
+    // "1:<base64>,2:<base64>" (see secrets.NewKeyring).
+    SecretKeys string
+    // WebhookInbox* tune the inbound webhook inbox processor.
+    WebhookInboxInterval    time.Duration
+    WebhookInboxConcurrency int
+    WebhookInboxMaxAttempts int
+    // WebhookDispatch* tune the outbound webhook dispatcher.
+    WebhookDispatchInterval    time.Duration
+    WebhookDispatchConcurrency int
+    WebhookDispatchMaxAttempts int
+    // WebhookMaxBodyBytes caps inbound webhook bodies (default 1 MiB).
+    WebhookMaxBodyBytes int64
+    // WebhookAllowPrivateURLs permits http and internal addresses as webhook
+    // destinations; only for development.
+    WebhookAllowPrivateURLs bool
+    // OutboxRelay* tune the outbox relay.
+    OutboxRelayInterval    time.Duration
+    OutboxRelayConcurrency int
+    // Worker* tune the job worker (cmd/worker).
+    WorkerConcurrency  int
+    WorkerPollInterval time.Duration
+    WorkerDrainTimeout time.Duration
+    // Notify configures the notification channels (SMTP_*, TWILIO_*, LINE_*).
+    Notify notify.Config
+    // PublicBaseURL is the API's public origin, used for tracking page links
+    // in notifications.
+    PublicBaseURL string
 }
 
 func Load() Config {
@@ -16,8 +63,86 @@ func Load() Config {
         port = "8080"
     }
     return Config{
-        DatabaseURL: os.Getenv("DATABASE_URL"),
-        Port:        port,
-        RateProvider: os.Getenv("RATE_PROVIDER"),
+        DatabaseURL:                    os.Getenv("DATABASE_URL"),
+        Port:                           port,
+        RateProvider:                   os.Getenv("RATE_PROVIDER"),
+        TrackingProvider:               os.Getenv("TRACKING_PROVIDER"),
+        TrackingPollInterval:           durationEnv("TRACKING_POLL_INTERVAL"),
+        TrackingPollCarriers:           listEnv("TRACKING_POLL_CARRIERS"),
+        TrackingPollConcurrency:        intEnv("TRACKING_POLL_CONCURRENCY"),
+        TrackingPollCarrierConcurrency: limitsEnv("TRACKING_POLL_CARRIER_CONCURRENCY"),
+        TrackingExceptionRules:         os.Getenv("TRACKING_EXCEPTION_RULES"),
+        TrackingExceptionInterval:      durationEnv("TRACKING_EXCEPTION_INTERVAL"),
+        WebhookMappingsFile:            os.Getenv("WEBHOOK_MAPPINGS_FILE"),
+        SecretKeys:                     os.Getenv("SECRET_KEYS"),
+        WebhookInboxInterval:           durationEnv("WEBHOOK_INBOX_INTERVAL"),
+        WebhookInboxConcurrency:        intEnv("WEBHOOK_INBOX_CONCURRENCY"),
+        WebhookInboxMaxAttempts:        intEnv("WEBHOOK_INBOX_MAX_ATTEMPTS"),
+        WebhookDispatchInterval:        durationEnv("WEBHOOK_DISPATCH_INTERVAL"),
+        WebhookDispatchConcurrency:     intEnv("WEBHOOK_DISPATCH_CONCURRENCY"),
+        WebhookDispatchMaxAttempts:     intEnv("WEBHOOK_DISPATCH_MAX_ATTEMPTS"),
+        WebhookMaxBodyBytes:            int64(intEnv("WEBHOOK_MAX_BODY_BYTES")),
+        WebhookAllowPrivateURLs:        boolEnv("WEBHOOK_ALLOW_PRIVATE_URLS"),
+        OutboxRelayInterval:            durationEnv("OUTBOX_RELAY_INTERVAL"),
+        OutboxRelayConcurrency:         intEnv("OUTBOX_RELAY_CONCURRENCY"),
+        WorkerConcurrency:              intEnv("WORKER_CONCURRENCY"),
+        WorkerPollInterval:             durationEnv("WORKER_POLL_INTERVAL"),
+        WorkerDrainTimeout:             durationEnv("WORKER_DRAIN_TIMEOUT"),
+        Notify: notify.Config{
+            SMTPHost:         os.Getenv("SMTP_HOST"),
+            SMTPPort:         intEnv("SMTP_PORT"),
+            SMTPUsername:     os.Getenv("SMTP_USERNAME"),
+            SMTPPassword:     os.Getenv("SMTP_PASSWORD"),
+            SMTPFrom:         os.Getenv("SMTP_FROM"),
+            TwilioAccountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+            TwilioAuthToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+            TwilioFrom:       os.Getenv("TWILIO_FROM"),
+            TwilioBaseURL:    os.Getenv("TWILIO_BASE_URL"),
+            LINEChannelToken: os.Getenv("LINE_CHANNEL_ACCESS_TOKEN"),
+            LINEBaseURL:      os.Getenv("LINE_BASE_URL"),
+        },
+        PublicBaseURL: os.Getenv("PUBLIC_BASE_URL"),
+    }
+}
+
+// durationEnv parses a Go duration ("30s"); invalid or unset values are zero,
+// leaving the consumer's default in place.
+func durationEnv(name string) time.Duration {
+    d, _ := time.ParseDuration(strings.TrimSpace(os.Getenv(name)))
+    return d
+}
+
+func intEnv(name string) int {
+    n, _ := strconv.Atoi(strings.TrimSpace(os.Getenv(name)))
+    return n
+}
+
+func boolEnv(name string) bool {
+    b, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv(name)))
+    return b
+}
+
+func listEnv(name string) []string {
+    var out []string
+    for _, v := range strings.Split(os.Getenv(name), ",") {
+        if v = strings.TrimSpace(v); v != "" {
+            out = append(out, v)
+        }
     }
-}
\ No newline at end of file
+    return out
+}
+
+// limitsEnv parses "key=n" pairs separated by commas, skipping malformed ones.
+func limitsEnv(name string) map[string]int {
+    out := map[string]int{}
+    for _, pair := range listEnv(name) {
+        k, v, ok := strings.Cut(pair, "=")
+        if !ok {
+            continue
+        }
+        if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+            out[strings.TrimSpace(k)] = n
+        }
+    }
+    return out
+}
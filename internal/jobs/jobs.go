@@ -0,0 +1,168 @@
+// Package jobs is a job queue on Postgres. Jobs are rows of the jobs table,
+// enqueued (optionally inside the transaction of the change that needs them)
+// and run by Workers, which lease them with FOR UPDATE SKIP LOCKED so any
+// number of workers can share the queue.
+package jobs
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgconn"
+)
+
+// Job statuses. Running jobs hold a lease (locked_until) that their worker
+// extends; a job whose lease lapses is taken over by another worker. Dead
+// jobs failed permanently or ran out of attempts and wait for Retry.
+const (
+    StatusPending   = "pending"
+    StatusRunning   = "running"
+    StatusSucceeded = "succeeded"
+    StatusDead      = "dead"
+)
+
+// DefaultMaxAttempts applies when Options.MaxAttempts is zero.
+const DefaultMaxAttempts = 10
+
+// ErrNotDead is returned by Retry for jobs that are missing or not dead.
+var ErrNotDead = errors.New("jobs: job not found or not dead")
+
+// DB is satisfied by *pgxpool.Pool, *pgx.Conn and pgx.Tx.
+type DB interface {
+    Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+    QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Job is a claimed job as seen by its handler.
+type Job struct {
+    ID   uuid.UUID
+    Kind string
+    // Payload is the JSON-encoded arguments.
+    Payload json.RawMessage
+    // Attempt counts runs including this one, starting at 1.
+    Attempt     int
+    MaxAttempts int
+    UniqueKey   string
+    RunAt       time.Time
+    CreatedAt   time.Time
+}
+
+// Options control an enqueued job. Zero values run it now, once per call,
+// with up to DefaultMaxAttempts attempts.
+type Options struct {
+    // RunAt schedules the job; Delay is added to it (or to now).
+    RunAt time.Time
+    Delay time.Duration
+    // UniqueKey makes Enqueue a no-op while a job of the same kind and key is
+    // pending or running.
+    UniqueKey   string
+    MaxAttempts int
+}
+
+// Kind names a job kind and ties it to its argument type, so enqueuing and
+// handling agree on the payload.
+type Kind[T any] struct {
+    Name string
+}
+
+// Enqueue adds a job of this kind; see Enqueue.
+func (k Kind[T]) Enqueue(ctx context.Context, db DB, args T, opts Options) (uuid.UUID, bool, error) {
+    return Enqueue(ctx, db, k.Name, args, opts)
+}
+
+// Enqueue adds a job with args encoded as JSON. It returns the job id and
+// whether a job was created: with a UniqueKey already pending or running,
+// the existing job's id is returned instead.
+func Enqueue(ctx context.Context, db DB, kind string, args any, opts Options) (uuid.UUID, bool, error) {
+    if strings.TrimSpace(kind) == "" {
+        return uuid.Nil, false, errors.New("jobs: kind required")
+    }
+    payload, err := json.Marshal(args)
+    if err != nil {
+        return uuid.Nil, false, err
+    }
+    runAt := opts.RunAt
+    if runAt.IsZero() {
+        runAt = time.Now()
+    }
+    runAt = runAt.Add(opts.Delay)
+    maxAttempts := opts.MaxAttempts
+    if maxAttempts <= 0 {
+        maxAttempts = DefaultMaxAttempts
+    }
+    var uniqueKey *string
+    if opts.UniqueKey != "" {
+        uniqueKey = &opts.UniqueKey
+    }
+    // The existing job may finish between the insert and the lookup; try
+    // again then.
+    for i := 0; i < 3; i++ {
+        var id uuid.UUID
+        err = db.QueryRow(ctx, `
+            INSERT INTO jobs (kind, payload, unique_key, max_attempts, run_at)
+            VALUES ($1, $2::jsonb, $3, $4, $5)
+            ON CONFLICT (kind, unique_key) WHERE unique_key IS NOT NULL AND status IN ('pending', 'running')
+            DO NOTHING
+            RETURNING id
+        `, kind, string(payload), uniqueKey, maxAttempts, runAt).Scan(&id)
+        if err == nil {
+            return id, true, nil
+        }
+        if !errors.Is(err, pgx.ErrNoRows) {
+            return uuid.Nil, false, err
+        }
+        err = db.QueryRow(ctx, `
+            SELECT id FROM jobs
+            WHERE kind = $1 AND unique_key = $2 AND status IN ('pending', 'running')
+        `, kind, opts.UniqueKey).Scan(&id)
+        if err == nil {
+            return id, false, nil
+        }
+        if !errors.Is(err, pgx.ErrNoRows) {
+            return uuid.Nil, false, err
+        }
+    }
+    return uuid.Nil, false, err
+}
+
+// Retry requeues a dead job with fresh attempts.
+func Retry(ctx context.Context, db DB, id uuid.UUID) error {
+    tag, err := db.Exec(ctx, `
+        UPDATE jobs
+        SET status = 'pending', attempts = 0, run_at = NOW(), last_error = NULL,
+            finished_at = NULL, updated_at = NOW()
+        WHERE id = $1 AND status = 'dead'
+    `, id)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrNotDead
+    }
+    return nil
+}
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job is dead-lettered without further attempts.
+func Permanent(err error) error {
+    if err == nil {
+        return nil
+    }
+    return &permanentError{err}
+}
+
+// IsPermanent reports whether err was wrapped by Permanent.
+func IsPermanent(err error) bool {
+    var p *permanentError
+    return errors.As(err, &p)
+}
@@ -0,0 +1,188 @@
+package jobs
+
+import (
+    "context"
+    "errors"
+    "os"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+
+    "deliveryinfra/internal/db"
+)
+
+type jobRow struct {
+    Status    string
+    Attempts  int
+    LastError *string
+}
+
+func loadJob(t *testing.T, q DB, id uuid.UUID) jobRow {
+    t.Helper()
+    var r jobRow
+    if err := q.QueryRow(t.Context(), `SELECT status, attempts, last_error FROM jobs WHERE id = $1`, id).Scan(&r.Status, &r.Attempts, &r.LastError); err != nil {
+        t.Fatalf("load job: %v", err)
+    }
+    return r
+}
+
+// runDue claims and runs every due job of w's kinds once.
+func runDue(t *testing.T, w *Worker) int {
+    t.Helper()
+    claimed, err := w.claim(t.Context(), w.Kinds(), 10)
+    if err != nil {
+        t.Fatalf("claim: %v", err)
+    }
+    for _, j := range claimed {
+        w.run(context.Background(), j)
+    }
+    return len(claimed)
+}
+
+func TestWorker_RetriesAndDeadLetters(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+    ctx := t.Context()
+
+    kind := Kind[greetArgs]{Name: "test.greet." + uuid.NewString()}
+    w := NewWorker(pool, Config{BackoffBase: time.Millisecond, BackoffMax: time.Millisecond})
+    var runs atomic.Int32
+    Handle(w, kind, func(ctx context.Context, job Job, args greetArgs) error {
+        runs.Add(1)
+        if args.Name == "fail" {
+            return errors.New("provider down")
+        }
+        if args.Name == "reject" {
+            return Permanent(errors.New("unknown recipient"))
+        }
+        return nil
+    })
+
+    ok, _, err := kind.Enqueue(ctx, pool, greetArgs{Name: "ana"}, Options{})
+    if err != nil {
+        t.Fatalf("enqueue: %v", err)
+    }
+    failing, _, _ := kind.Enqueue(ctx, pool, greetArgs{Name: "fail"}, Options{MaxAttempts: 2})
+    rejected, _, _ := kind.Enqueue(ctx, pool, greetArgs{Name: "reject"}, Options{})
+    later, _, _ := kind.Enqueue(ctx, pool, greetArgs{Name: "later"}, Options{Delay: time.Hour})
+
+    if n := runDue(t, w); n != 3 {
+        t.Fatalf("expected 3 due jobs, claimed %d", n)
+    }
+    if r := loadJob(t, pool, ok); r.Status != StatusSucceeded || r.Attempts != 1 {
+        t.Fatalf("ok job: %+v", r)
+    }
+    if r := loadJob(t, pool, rejected); r.Status != StatusDead || r.Attempts != 1 {
+        t.Fatalf("permanent failure should dead-letter at once: %+v", r)
+    }
+    if r := loadJob(t, pool, failing); r.Status != StatusPending || r.LastError == nil || *r.LastError != "provider down" {
+        t.Fatalf("failed job should be retried: %+v", r)
+    }
+    time.Sleep(5 * time.Millisecond)
+    if n := runDue(t, w); n != 1 {
+        t.Fatalf("expected the retry only, claimed %d", n)
+    }
+    if r := loadJob(t, pool, failing); r.Status != StatusDead || r.Attempts != 2 {
+        t.Fatalf("job should be dead after max attempts: %+v", r)
+    }
+    if r := loadJob(t, pool, later); r.Status != StatusPending || r.Attempts != 0 {
+        t.Fatalf("delayed job must not run early: %+v", r)
+    }
+
+    if err := Retry(ctx, pool, failing); err != nil {
+        t.Fatalf("retry: %v", err)
+    }
+    if err := Retry(ctx, pool, ok); !errors.Is(err, ErrNotDead) {
+        t.Fatalf("retrying a succeeded job: %v", err)
+    }
+    if r := loadJob(t, pool, failing); r.Status != StatusPending || r.Attempts != 0 {
+        t.Fatalf("retried job: %+v", r)
+    }
+}
+
+func TestEnqueue_UniqueKey(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+    ctx := t.Context()
+
+    kind := Kind[greetArgs]{Name: "test.unique." + uuid.NewString()}
+    first, created, err := kind.Enqueue(ctx, pool, greetArgs{Name: "a"}, Options{UniqueKey: "k"})
+    if err != nil || !created {
+        t.Fatalf("first enqueue: %v %v", created, err)
+    }
+    second, created, err := kind.Enqueue(ctx, pool, greetArgs{Name: "b"}, Options{UniqueKey: "k"})
+    if err != nil || created || second != first {
+        t.Fatalf("duplicate enqueue should return the live job: %v %v %v", second, created, err)
+    }
+    other, created, _ := kind.Enqueue(ctx, pool, greetArgs{Name: "c"}, Options{UniqueKey: "other"})
+    if !created || other == first {
+        t.Fatalf("different keys are independent")
+    }
+
+    w := NewWorker(pool, Config{})
+    Handle(w, kind, func(context.Context, Job, greetArgs) error { return nil })
+    runDue(t, w)
+    again, created, err := kind.Enqueue(ctx, pool, greetArgs{Name: "d"}, Options{UniqueKey: "k"})
+    if err != nil || !created || again == first {
+        t.Fatalf("key is free once the job finished: %v %v", created, err)
+    }
+}
+
+func TestWorker_DrainReleasesUnfinishedJobs(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    kind := Kind[greetArgs]{Name: "test.drain." + uuid.NewString()}
+    w := NewWorker(pool, Config{PollInterval: 10 * time.Millisecond, DrainTimeout: 50 * time.Millisecond})
+    started := make(chan struct{})
+    Handle(w, kind, func(ctx context.Context, _ Job, _ greetArgs) error {
+        close(started)
+        <-ctx.Done()
+        return ctx.Err()
+    })
+    id, _, err := kind.Enqueue(t.Context(), pool, greetArgs{}, Options{})
+    if err != nil {
+        t.Fatalf("enqueue: %v", err)
+    }
+    ctx, cancel := context.WithCancel(t.Context())
+    done := make(chan error, 1)
+    go func() { done <- w.Run(ctx) }()
+    select {
+    case <-started:
+    case <-time.After(5 * time.Second):
+        t.Fatalf("job did not start")
+    }
+    cancel()
+    if err := <-done; err == nil {
+        t.Fatalf("expected a drain timeout")
+    }
+    if r := loadJob(t, pool, id); r.Status != StatusPending || r.Attempts != 0 {
+        t.Fatalf("interrupted job should go back to the queue: %+v", r)
+    }
+}
@@ -0,0 +1,92 @@
+package jobs
+
+import (
+    "context"
+    "log"
+    "sync"
+    "time"
+)
+
+// pruneInterval is how often Poll and Worker.Run delete finished rows.
+const pruneInterval = time.Hour
+
+// ClaimFunc claims one due row, typically with FOR UPDATE SKIP LOCKED, and
+// handles it. It reports false when nothing was due.
+type ClaimFunc func(ctx context.Context) (bool, error)
+
+// Drain calls claim from concurrency goroutines until nothing is due, ctx is
+// done or a call fails. It returns how many rows were handled and the first
+// error.
+func Drain(ctx context.Context, concurrency int, claim ClaimFunc) (int, error) {
+    var (
+        mu       sync.Mutex
+        total    int
+        firstErr error
+        wg       sync.WaitGroup
+    )
+    for i := 0; i < concurrency; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for ctx.Err() == nil {
+                ok, err := claim(ctx)
+                mu.Lock()
+                if ok {
+                    total++
+                }
+                if err != nil && firstErr == nil {
+                    firstErr = err
+                }
+                mu.Unlock()
+                if !ok || err != nil {
+                    return
+                }
+            }
+        }()
+    }
+    wg.Wait()
+    return total, firstErr
+}
+
+// Poll runs drain every tick and prune hourly until ctx is done, logging
+// their errors under name. It backs the table-backed loops that, like
+// Worker, claim with SKIP LOCKED so every replica can run one.
+func Poll(ctx context.Context, name string, tick time.Duration, drain func(context.Context) (int, error), prune func(context.Context) error) {
+    ticker := time.NewTicker(tick)
+    defer ticker.Stop()
+    var lastPrune time.Time
+    for {
+        if _, err := drain(ctx); err != nil && ctx.Err() == nil {
+            log.Printf("%s: %v", name, err)
+        }
+        if time.Since(lastPrune) >= pruneInterval {
+            if err := prune(ctx); err != nil && ctx.Err() == nil {
+                log.Printf("%s: prune error: %v", name, err)
+            }
+            lastPrune = time.Now()
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Backoff doubles the retry delay per attempt from base up to max. With
+// jitter, the delay is picked uniformly from its upper half so retries of
+// many rows spread out; a nil jitter returns it as is.
+func Backoff(attempt int, base, max time.Duration, jitter func(n int64) int64) time.Duration {
+    d := base
+    for i := 1; i < attempt && d < max; i++ {
+        d *= 2
+    }
+    if d > max {
+        d = max
+    }
+    if jitter == nil {
+        return d
+    }
+    half := d / 2
+    return half + time.Duration(jitter(int64(half)+1))
+}
@@ -0,0 +1,54 @@
+package jobs
+
+import (
+    "context"
+    "errors"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestBackoff(t *testing.T) {
+    base, max := 5*time.Second, time.Hour
+    noJitter := func(int64) int64 { return 0 }
+    full := func(n int64) int64 { return n - 1 }
+    cases := map[int]time.Duration{
+        1:  5 * time.Second,
+        2:  10 * time.Second,
+        5:  80 * time.Second,
+        10: 2560 * time.Second,
+        11: time.Hour,
+        40: time.Hour,
+    }
+    for attempt, d := range cases {
+        if got := Backoff(attempt, base, max, nil); got != d {
+            t.Errorf("Backoff(%d) = %v, want %v", attempt, got, d)
+        }
+        if got, want := Backoff(attempt, base, max, noJitter), d/2; got != want {
+            t.Errorf("Backoff(%d) without jitter = %v, want %v", attempt, got, want)
+        }
+        if got := Backoff(attempt, base, max, full); got != d {
+            t.Errorf("Backoff(%d) with full jitter = %v, want %v", attempt, got, d)
+        }
+    }
+}
+
+func TestDrain(t *testing.T) {
+    var due atomic.Int64
+    due.Store(10)
+    n, err := Drain(context.Background(), 3, func(context.Context) (bool, error) {
+        return due.Add(-1) >= 0, nil
+    })
+    if err != nil || n != 10 {
+        t.Fatalf("Drain = %d, %v; want 10, nil", n, err)
+    }
+
+    // A failed claim stops its goroutine and is reported; handled rows count
+    boom := errors.New("boom")
+    n, err = Drain(context.Background(), 1, func(context.Context) (bool, error) {
+        return true, boom
+    })
+    if !errors.Is(err, boom) || n != 1 {
+        t.Fatalf("Drain = %d, %v; want 1, boom", n, err)
+    }
+}
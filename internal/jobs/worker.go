@@ -0,0 +1,343 @@
+package jobs
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "math/rand"
+    "os"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// storeTimeout bounds the bookkeeping after a job ran, which must happen even
+// when the job's context was cancelled.
+const storeTimeout = 5 * time.Second
+
+// Config controls a Worker. Zero values use the defaults.
+type Config struct {
+    // ID identifies the worker in locked_by (default host-pid-random).
+    ID string
+    // Concurrency is the number of jobs run at once (default 4).
+    Concurrency int
+    // PollInterval is how often due jobs are looked for while idle
+    // (default 1s).
+    PollInterval time.Duration
+    // Lease is how long a claimed job stays locked without a heartbeat;
+    // running jobs renew it every third of it (default 1m).
+    Lease time.Duration
+    // JobTimeout bounds each run (default 10m).
+    JobTimeout time.Duration
+    // DrainTimeout is how long Run waits for running jobs after its context
+    // is done; jobs still running then are cancelled and released to other
+    // workers (default 30s).
+    DrainTimeout time.Duration
+    // BackoffBase and BackoffMax bound the retry delay, which doubles per
+    // attempt with jitter (defaults 5s and 1h).
+    BackoffBase time.Duration
+    BackoffMax  time.Duration
+    // Retention is how long succeeded jobs are kept (default 7 days).
+    Retention time.Duration
+}
+
+type handlerFunc func(ctx context.Context, job Job) error
+
+// Worker runs jobs of the kinds it has handlers for.
+type Worker struct {
+    db        *pgxpool.Pool
+    cfg       Config
+    handlers  map[string]handlerFunc
+    now       func() time.Time
+    jitter    func(n int64) int64
+    lastPrune time.Time
+}
+
+// NewWorker creates a worker; register handlers with Handle before Run.
+func NewWorker(db *pgxpool.Pool, cfg Config) *Worker {
+    if cfg.ID == "" {
+        host, _ := os.Hostname()
+        cfg.ID = fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.NewString()[:8])
+    }
+    if cfg.Concurrency <= 0 {
+        cfg.Concurrency = 4
+    }
+    if cfg.PollInterval <= 0 {
+        cfg.PollInterval = time.Second
+    }
+    if cfg.Lease <= 0 {
+        cfg.Lease = time.Minute
+    }
+    if cfg.JobTimeout <= 0 {
+        cfg.JobTimeout = 10 * time.Minute
+    }
+    if cfg.DrainTimeout <= 0 {
+        cfg.DrainTimeout = 30 * time.Second
+    }
+    if cfg.BackoffBase <= 0 {
+        cfg.BackoffBase = 5 * time.Second
+    }
+    if cfg.BackoffMax <= 0 {
+        cfg.BackoffMax = time.Hour
+    }
+    if cfg.Retention <= 0 {
+        cfg.Retention = 7 * 24 * time.Hour
+    }
+    return &Worker{
+        db:       db,
+        cfg:      cfg,
+        handlers: map[string]handlerFunc{},
+        now:      time.Now,
+        jitter:   rand.Int63n,
+    }
+}
+
+// Handle registers fn for jobs of kind k, decoding each payload into T.
+// Payloads that do not decode are dead-lettered.
+func Handle[T any](w *Worker, k Kind[T], fn func(ctx context.Context, job Job, args T) error) {
+    w.handlers[k.Name] = func(ctx context.Context, job Job) error {
+        var args T
+        if err := json.Unmarshal(job.Payload, &args); err != nil {
+            return Permanent(fmt.Errorf("decode payload: %w", err))
+        }
+        return fn(ctx, job, args)
+    }
+}
+
+// Kinds returns the registered kinds, sorted.
+func (w *Worker) Kinds() []string {
+    kinds := make([]string, 0, len(w.handlers))
+    for k := range w.handlers {
+        kinds = append(kinds, k)
+    }
+    sort.Strings(kinds)
+    return kinds
+}
+
+// Run claims and runs jobs until ctx is done, then drains: no new jobs are
+// claimed and running ones get DrainTimeout to finish.
+func (w *Worker) Run(ctx context.Context) error {
+    kinds := w.Kinds()
+    if len(kinds) == 0 {
+        return errors.New("jobs: no handlers registered")
+    }
+    // Jobs run under their own context so shutdown lets them finish.
+    runCtx, cancelRun := context.WithCancel(context.Background())
+    defer cancelRun()
+    var wg sync.WaitGroup
+    slots := make(chan struct{}, w.cfg.Concurrency)
+    finished := make(chan struct{}, w.cfg.Concurrency)
+    ticker := time.NewTicker(w.cfg.PollInterval)
+    defer ticker.Stop()
+    for {
+        if free := cap(slots) - len(slots); free > 0 {
+            jobs, err := w.claim(ctx, kinds, free)
+            if err != nil && ctx.Err() == nil {
+                log.Println("jobs: claim error:", err)
+            }
+            for _, j := range jobs {
+                slots <- struct{}{}
+                wg.Add(1)
+                go func(j Job) {
+                    defer wg.Done()
+                    w.run(runCtx, j)
+                    <-slots
+                    select {
+                    case finished <- struct{}{}:
+                    default:
+                    }
+                }(j)
+            }
+        }
+        if w.now().Sub(w.lastPrune) >= pruneInterval {
+            if err := w.prune(ctx); err != nil && ctx.Err() == nil {
+                log.Println("jobs: prune error:", err)
+            }
+            w.lastPrune = w.now()
+        }
+        select {
+        case <-ctx.Done():
+            return w.drain(&wg, cancelRun)
+        case <-ticker.C:
+        case <-finished:
+        }
+    }
+}
+
+func (w *Worker) drain(wg *sync.WaitGroup, cancelRun context.CancelFunc) error {
+    done := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+        return nil
+    case <-time.After(w.cfg.DrainTimeout):
+        cancelRun()
+        <-done
+        return errors.New("jobs: drain timed out; unfinished jobs were released")
+    }
+}
+
+// claim leases up to n due jobs: pending ones whose run_at has come and
+// running ones whose lease lapsed (their worker died).
+func (w *Worker) claim(ctx context.Context, kinds []string, n int) ([]Job, error) {
+    rows, err := w.db.Query(ctx, `
+        UPDATE jobs
+        SET status = 'running', attempts = attempts + 1, locked_by = $1,
+            locked_until = NOW() + $2 * INTERVAL '1 millisecond', updated_at = NOW()
+        WHERE id IN (
+            SELECT id FROM jobs
+            WHERE kind = ANY($3)
+              AND ((status = 'pending' AND run_at <= NOW())
+                   OR (status = 'running' AND locked_until < NOW()))
+            ORDER BY run_at, created_at
+            LIMIT $4
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, kind, payload, attempts, max_attempts, COALESCE(unique_key, ''), run_at, created_at
+    `, w.cfg.ID, w.cfg.Lease.Milliseconds(), kinds, n)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var jobs []Job
+    for rows.Next() {
+        var j Job
+        if err := rows.Scan(&j.ID, &j.Kind, &j.Payload, &j.Attempt, &j.MaxAttempts, &j.UniqueKey, &j.RunAt, &j.CreatedAt); err != nil {
+            return nil, err
+        }
+        jobs = append(jobs, j)
+    }
+    return jobs, rows.Err()
+}
+
+// run executes one claimed job and records the outcome. Jobs cancelled by a
+// drain timeout are released without using up an attempt.
+func (w *Worker) run(parent context.Context, j Job) {
+    if j.Attempt > j.MaxAttempts {
+        // Only reachable when leases lapsed on every attempt
+        w.fail(j, Permanent(errors.New("lease expired on every attempt")))
+        return
+    }
+    ctx, cancel := context.WithTimeout(parent, w.cfg.JobTimeout)
+    defer cancel()
+    stop := w.keepLease(ctx, j.ID)
+    err := call(ctx, w.handlers[j.Kind], j)
+    stop()
+    switch {
+    case err == nil:
+        w.complete(j)
+    case parent.Err() != nil:
+        w.release(j)
+    default:
+        w.fail(j, err)
+    }
+}
+
+// call runs the handler, turning a panic into a failure.
+func call(ctx context.Context, h handlerFunc, j Job) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("panic: %v", r)
+        }
+    }()
+    return h(ctx, j)
+}
+
+// keepLease renews the job's lease until the returned stop is called.
+func (w *Worker) keepLease(ctx context.Context, id uuid.UUID) (stop func()) {
+    ctx, cancel := context.WithCancel(ctx)
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        ticker := time.NewTicker(w.cfg.Lease / 3)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+                _, err := w.db.Exec(ctx, `
+                    UPDATE jobs SET locked_until = NOW() + $3 * INTERVAL '1 millisecond'
+                    WHERE id = $1 AND locked_by = $2 AND status = 'running'
+                `, id, w.cfg.ID, w.cfg.Lease.Milliseconds())
+                if err != nil && ctx.Err() == nil {
+                    log.Printf("jobs: renew lease of %s: %v", id, err)
+                }
+            }
+        }
+    }()
+    return func() {
+        cancel()
+        <-done
+    }
+}
+
+func (w *Worker) complete(j Job) {
+    ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+    defer cancel()
+    _, err := w.db.Exec(ctx, `
+        UPDATE jobs
+        SET status = 'succeeded', last_error = NULL, locked_by = NULL, locked_until = NULL,
+            finished_at = NOW(), updated_at = NOW()
+        WHERE id = $1 AND locked_by = $2 AND status = 'running'
+    `, j.ID, w.cfg.ID)
+    if err != nil {
+        log.Printf("jobs: complete %s %s: %v", j.Kind, j.ID, err)
+    }
+}
+
+// fail schedules a retry, or dead-letters the job when the error is
+// permanent or attempts are used up.
+func (w *Worker) fail(j Job, jobErr error) {
+    ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+    defer cancel()
+    status, runAt := StatusPending, w.now().Add(Backoff(j.Attempt, w.cfg.BackoffBase, w.cfg.BackoffMax, w.jitter))
+    var finishedAt *time.Time
+    if IsPermanent(jobErr) || j.Attempt >= j.MaxAttempts {
+        status = StatusDead
+        now := w.now()
+        finishedAt = &now
+        log.Printf("jobs: %s %s dead-lettered after %d attempts: %v", j.Kind, j.ID, j.Attempt, jobErr)
+    }
+    _, err := w.db.Exec(ctx, `
+        UPDATE jobs
+        SET status = $3, run_at = $4, last_error = $5, finished_at = $6,
+            locked_by = NULL, locked_until = NULL, updated_at = NOW()
+        WHERE id = $1 AND locked_by = $2 AND status = 'running'
+    `, j.ID, w.cfg.ID, status, runAt, jobErr.Error(), finishedAt)
+    if err != nil {
+        log.Printf("jobs: record failure of %s %s: %v", j.Kind, j.ID, err)
+    }
+}
+
+// release hands a job interrupted by shutdown back to the queue.
+func (w *Worker) release(j Job) {
+    ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+    defer cancel()
+    _, err := w.db.Exec(ctx, `
+        UPDATE jobs
+        SET status = 'pending', attempts = attempts - 1, run_at = NOW(),
+            locked_by = NULL, locked_until = NULL, updated_at = NOW()
+        WHERE id = $1 AND locked_by = $2 AND status = 'running'
+    `, j.ID, w.cfg.ID)
+    if err != nil {
+        log.Printf("jobs: release %s %s: %v", j.Kind, j.ID, err)
+    }
+}
+
+// prune deletes succeeded jobs past retention; dead jobs are kept until
+// retried or removed by an operator.
+func (w *Worker) prune(ctx context.Context) error {
+    _, err := w.db.Exec(ctx, `
+        DELETE FROM jobs WHERE status = 'succeeded' AND finished_at < $1
+    `, w.now().Add(-w.cfg.Retention))
+    return err
+}
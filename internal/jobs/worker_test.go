@@ -0,0 +1,55 @@
+package jobs
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "testing"
+)
+
+func TestPermanent(t *testing.T) {
+    if Permanent(nil) != nil {
+        t.Fatalf("Permanent(nil) must be nil")
+    }
+    base := errors.New("bad payload")
+    err := Permanent(base)
+    if !IsPermanent(err) || !errors.Is(err, base) || err.Error() != "bad payload" {
+        t.Fatalf("unexpected permanent error %v", err)
+    }
+    if IsPermanent(base) {
+        t.Fatalf("plain errors are retried")
+    }
+    if !IsPermanent(errors.Join(errors.New("ctx"), err)) {
+        t.Fatalf("wrapped permanent errors stay permanent")
+    }
+}
+
+type greetArgs struct {
+    Name string `json:"name"`
+}
+
+func TestHandle_DecodesPayload(t *testing.T) {
+    w := NewWorker(nil, Config{})
+    kind := Kind[greetArgs]{Name: "greet"}
+    var got string
+    Handle(w, kind, func(ctx context.Context, job Job, args greetArgs) error {
+        got = args.Name
+        return nil
+    })
+    if kinds := w.Kinds(); len(kinds) != 1 || kinds[0] != "greet" {
+        t.Fatalf("kinds = %v", kinds)
+    }
+    if err := call(context.Background(), w.handlers["greet"], Job{Payload: json.RawMessage(`{"name":"ana"}`)}); err != nil || got != "ana" {
+        t.Fatalf("got %q, %v", got, err)
+    }
+    if err := call(context.Background(), w.handlers["greet"], Job{Payload: json.RawMessage(`[1]`)}); !IsPermanent(err) {
+        t.Fatalf("undecodable payload should be permanent, got %v", err)
+    }
+}
+
+func TestCall_RecoversPanic(t *testing.T) {
+    err := call(context.Background(), func(context.Context, Job) error { panic("boom") }, Job{})
+    if err == nil || err.Error() != "panic: boom" {
+        t.Fatalf("got %v", err)
+    }
+}
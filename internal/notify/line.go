@@ -0,0 +1,67 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+
+    "github.com/google/uuid"
+)
+
+// LINE sends text push messages with the LINE Messaging API. Message ids that
+// are UUIDs are passed as X-Line-Retry-Key, so LINE drops retried pushes it
+// already accepted.
+type LINE struct {
+    // BaseURL defaults to https://api.line.me.
+    BaseURL      string
+    ChannelToken string
+    Client       *http.Client
+}
+
+func (l *LINE) Kind() string     { return KindLINE }
+func (l *LINE) Provider() string { return "line" }
+
+func (l *LINE) Send(ctx context.Context, m Message) (string, error) {
+    base := strings.TrimRight(l.BaseURL, "/")
+    if base == "" {
+        base = "https://api.line.me"
+    }
+    payload, err := json.Marshal(map[string]any{
+        "to":       m.To,
+        "messages": []map[string]string{{"type": "text", "text": m.Body}},
+    })
+    if err != nil {
+        return "", err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/v2/bot/message/push", bytes.NewReader(payload))
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Authorization", "Bearer "+l.ChannelToken)
+    req.Header.Set("Content-Type", "application/json")
+    if _, err := uuid.Parse(m.ID); err == nil {
+        req.Header.Set("X-Line-Retry-Key", m.ID)
+    }
+    raw, err := doHTTP(l.Client, req)
+    if err != nil {
+        // 409: a push with this retry key was already accepted.
+        var herr *httpError
+        if errors.As(err, &herr) && herr.Status == http.StatusConflict {
+            return herr.Header.Get("X-Line-Accepted-Request-Id"), nil
+        }
+        return "", fmt.Errorf("line: %w", err)
+    }
+    var resp struct {
+        SentMessages []struct {
+            ID string `json:"id"`
+        } `json:"sentMessages"`
+    }
+    if err := json.Unmarshal(raw, &resp); err != nil || len(resp.SentMessages) == 0 {
+        return "", fmt.Errorf("line: unexpected response %q", raw)
+    }
+    return resp.SentMessages[0].ID, nil
+}
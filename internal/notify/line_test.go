@@ -0,0 +1,63 @@
+package notify
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestLINE_Send(t *testing.T) {
+    const id = "6f1c1f4e-2a55-4a53-9d0b-1b7f3f0b2c11"
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/v2/bot/message/push" || r.Header.Get("Authorization") != "Bearer tok" {
+            t.Errorf("request = %s %s", r.URL.Path, r.Header.Get("Authorization"))
+        }
+        if r.Header.Get("X-Line-Retry-Key") != id {
+            t.Errorf("retry key = %q", r.Header.Get("X-Line-Retry-Key"))
+        }
+        var body struct {
+            To       string `json:"to"`
+            Messages []struct {
+                Type string `json:"type"`
+                Text string `json:"text"`
+            } `json:"messages"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.To != "U1234" ||
+            len(body.Messages) != 1 || body.Messages[0].Text != "お届けしました" {
+            t.Errorf("body = %+v, %v", body, err)
+        }
+        w.Write([]byte(`{"sentMessages":[{"id":"461230966842064897","quoteToken":"q"}]}`))
+    }))
+    defer srv.Close()
+
+    ch := &LINE{BaseURL: srv.URL, ChannelToken: "tok"}
+    got, err := ch.Send(context.Background(), Message{ID: id, To: "U1234", Body: "お届けしました"})
+    if err != nil || got != "461230966842064897" {
+        t.Fatalf("send = %q, %v", got, err)
+    }
+}
+
+func TestLINE_RetryAccepted(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("X-Line-Retry-Key") == "" {
+            w.WriteHeader(http.StatusBadRequest)
+            return
+        }
+        w.Header().Set("X-Line-Accepted-Request-Id", "req-1")
+        w.WriteHeader(http.StatusConflict)
+        w.Write([]byte(`{"message":"The retry key is already accepted"}`))
+    }))
+    defer srv.Close()
+
+    ch := &LINE{BaseURL: srv.URL, ChannelToken: "tok"}
+    got, err := ch.Send(context.Background(), Message{ID: "6f1c1f4e-2a55-4a53-9d0b-1b7f3f0b2c11", To: "U1234", Body: "x"})
+    if err != nil || got != "req-1" {
+        t.Fatalf("an accepted retry is a success, got %q, %v", got, err)
+    }
+    if _, err := ch.Send(context.Background(), Message{ID: "n/a", To: "U1234", Body: "x"}); !errors.Is(err, ErrRejected) {
+        t.Fatalf("400 should be a rejection, got %v", err)
+    }
+}
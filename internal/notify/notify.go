@@ -0,0 +1,141 @@
+// Package notify sends customer notifications over pluggable channels:
+// email over SMTP, SMS through an HTTP provider and LINE push messages.
+// Every channel talks to a configurable endpoint, so tests and local setups
+// can point it at a stand-in.
+package notify
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// Channel kinds, as stored in notifications.kind.
+const (
+    KindEmail = "email"
+    KindSMS   = "sms"
+    KindLINE  = "line"
+)
+
+// responseLimit caps provider response bodies read for ids and errors.
+const responseLimit = 64 << 10
+
+// ErrRejected marks a send the provider refused for good (invalid recipient,
+// bad credentials, ...); retrying it cannot succeed.
+var ErrRejected = errors.New("notify: rejected")
+
+// Message is one notification to one recipient.
+type Message struct {
+    // ID identifies the notification; channels that support it pass it on as
+    // an idempotency key so a retried send is not delivered twice.
+    ID string
+    // To is the address in the channel's format: an email address, an E.164
+    // phone number or a LINE user id.
+    To      string
+    Subject string
+    Body    string
+}
+
+// Channel delivers messages of one kind.
+type Channel interface {
+    // Kind is the channel kind (KindEmail, KindSMS, KindLINE).
+    Kind() string
+    // Provider names the service behind the channel, e.g. "smtp" or "twilio".
+    Provider() string
+    // Send delivers m and returns the provider's message id. Errors wrapping
+    // ErrRejected are permanent; others may be retried.
+    Send(ctx context.Context, m Message) (string, error)
+}
+
+// Config selects and configures the channels. A channel is enabled when its
+// required settings are present.
+type Config struct {
+    // SMTP email; enabled by SMTPHost and SMTPFrom.
+    SMTPHost     string
+    SMTPPort     int
+    SMTPUsername string
+    SMTPPassword string
+    SMTPFrom     string
+
+    // Twilio SMS; enabled by TwilioAccountSID, TwilioAuthToken and TwilioFrom.
+    // TwilioBaseURL overrides the API host.
+    TwilioAccountSID string
+    TwilioAuthToken  string
+    TwilioFrom       string
+    TwilioBaseURL    string
+
+    // LINE Messaging API push; enabled by LINEChannelToken. LINEBaseURL
+    // overrides the API host.
+    LINEChannelToken string
+    LINEBaseURL      string
+}
+
+// NewChannels returns the channels enabled by cfg.
+func NewChannels(cfg Config) []Channel {
+    var out []Channel
+    if cfg.SMTPHost != "" && cfg.SMTPFrom != "" {
+        out = append(out, &SMTP{
+            Host:     cfg.SMTPHost,
+            Port:     cfg.SMTPPort,
+            Username: cfg.SMTPUsername,
+            Password: cfg.SMTPPassword,
+            From:     cfg.SMTPFrom,
+        })
+    }
+    if cfg.TwilioAccountSID != "" && cfg.TwilioAuthToken != "" && cfg.TwilioFrom != "" {
+        out = append(out, NewSMS(&Twilio{
+            BaseURL:    cfg.TwilioBaseURL,
+            AccountSID: cfg.TwilioAccountSID,
+            AuthToken:  cfg.TwilioAuthToken,
+            From:       cfg.TwilioFrom,
+        }))
+    }
+    if cfg.LINEChannelToken != "" {
+        out = append(out, &LINE{BaseURL: cfg.LINEBaseURL, ChannelToken: cfg.LINEChannelToken})
+    }
+    return out
+}
+
+// defaultClient is used by HTTP channels without their own client.
+var defaultClient = &http.Client{Timeout: 15 * time.Second}
+
+// httpError is a non-2xx provider response.
+type httpError struct {
+    Status int
+    Body   string
+    Header http.Header
+}
+
+func (e *httpError) Error() string {
+    return fmt.Sprintf("status %d: %s", e.Status, e.Body)
+}
+
+// doHTTP sends req and returns the response body of a 2xx response. 4xx
+// responses other than 408 and 429 are rejections.
+func doHTTP(client *http.Client, req *http.Request) ([]byte, error) {
+    if client == nil {
+        client = defaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    body, err := io.ReadAll(io.LimitReader(resp.Body, responseLimit))
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+        return body, nil
+    }
+    herr := &httpError{Status: resp.StatusCode, Body: strings.TrimSpace(string(body)), Header: resp.Header}
+    if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+        resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+        return nil, fmt.Errorf("%w: %w", ErrRejected, herr)
+    }
+    return nil, herr
+}
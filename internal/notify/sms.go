@@ -0,0 +1,82 @@
+package notify
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// SMSProvider sends text messages through an SMS gateway.
+type SMSProvider interface {
+    Name() string
+    // SendSMS sends body to an E.164 number and returns the provider's
+    // message id. Errors wrapping ErrRejected are permanent.
+    SendSMS(ctx context.Context, to, body string) (string, error)
+}
+
+// SMS is the SMS channel over an SMSProvider.
+type SMS struct {
+    provider SMSProvider
+}
+
+// NewSMS returns an SMS channel sending through p.
+func NewSMS(p SMSProvider) *SMS {
+    return &SMS{provider: p}
+}
+
+func (s *SMS) Kind() string     { return KindSMS }
+func (s *SMS) Provider() string { return s.provider.Name() }
+
+func (s *SMS) Send(ctx context.Context, m Message) (string, error) {
+    if !strings.HasPrefix(m.To, "+") {
+        return "", fmt.Errorf("%w: phone number %q is not in E.164 format", ErrRejected, m.To)
+    }
+    return s.provider.SendSMS(ctx, m.To, m.Body)
+}
+
+// Twilio sends SMS with the Twilio Messages API.
+type Twilio struct {
+    // BaseURL defaults to https://api.twilio.com.
+    BaseURL    string
+    AccountSID string
+    AuthToken  string
+    // From is the sending number or messaging service id.
+    From   string
+    Client *http.Client
+}
+
+func (t *Twilio) Name() string { return "twilio" }
+
+func (t *Twilio) SendSMS(ctx context.Context, to, body string) (string, error) {
+    base := strings.TrimRight(t.BaseURL, "/")
+    if base == "" {
+        base = "https://api.twilio.com"
+    }
+    form := url.Values{"To": {to}, "Body": {body}}
+    if strings.HasPrefix(t.From, "MG") {
+        form.Set("MessagingServiceSid", t.From)
+    } else {
+        form.Set("From", t.From)
+    }
+    endpoint := base + "/2010-04-01/Accounts/" + url.PathEscape(t.AccountSID) + "/Messages.json"
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+    if err != nil {
+        return "", err
+    }
+    req.SetBasicAuth(t.AccountSID, t.AuthToken)
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    raw, err := doHTTP(t.Client, req)
+    if err != nil {
+        return "", fmt.Errorf("twilio: %w", err)
+    }
+    var resp struct {
+        SID string `json:"sid"`
+    }
+    if err := json.Unmarshal(raw, &resp); err != nil || resp.SID == "" {
+        return "", fmt.Errorf("twilio: unexpected response %q", raw)
+    }
+    return resp.SID, nil
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestTwilio_SendSMS(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+            t.Errorf("path = %s", r.URL.Path)
+        }
+        if user, pass, ok := r.BasicAuth(); !ok || user != "AC123" || pass != "token" {
+            t.Errorf("basic auth = %q %q", user, pass)
+        }
+        if err := r.ParseForm(); err != nil {
+            t.Errorf("parse form: %v", err)
+        }
+        if r.PostForm.Get("To") != "+819012345678" || r.PostForm.Get("From") != "+15005550006" || r.PostForm.Get("Body") != "配達中です" {
+            t.Errorf("form = %v", r.PostForm)
+        }
+        w.WriteHeader(http.StatusCreated)
+        w.Write([]byte(`{"sid":"SM42","status":"queued"}`))
+    }))
+    defer srv.Close()
+
+    ch := NewSMS(&Twilio{BaseURL: srv.URL, AccountSID: "AC123", AuthToken: "token", From: "+15005550006"})
+    id, err := ch.Send(context.Background(), Message{To: "+819012345678", Body: "配達中です"})
+    if err != nil || id != "SM42" {
+        t.Fatalf("send = %q, %v", id, err)
+    }
+    if ch.Kind() != KindSMS || ch.Provider() != "twilio" {
+        t.Fatalf("kind/provider = %s/%s", ch.Kind(), ch.Provider())
+    }
+}
+
+func TestTwilio_Errors(t *testing.T) {
+    status := http.StatusBadRequest
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(status)
+        w.Write([]byte(`{"code":21211,"message":"invalid To"}`))
+    }))
+    defer srv.Close()
+    ch := NewSMS(&Twilio{BaseURL: srv.URL, AccountSID: "AC123", AuthToken: "token", From: "+15005550006"})
+
+    if _, err := ch.Send(context.Background(), Message{To: "+819012345678", Body: "x"}); !errors.Is(err, ErrRejected) {
+        t.Fatalf("400 should be a rejection, got %v", err)
+    }
+    for _, status = range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+        if _, err := ch.Send(context.Background(), Message{To: "+819012345678", Body: "x"}); err == nil || errors.Is(err, ErrRejected) {
+            t.Fatalf("%d should be retryable, got %v", status, err)
+        }
+    }
+    if _, err := ch.Send(context.Background(), Message{To: "090-1234-5678", Body: "x"}); !errors.Is(err, ErrRejected) {
+        t.Fatalf("non-E.164 numbers are rejected before sending, got %v", err)
+    }
+}
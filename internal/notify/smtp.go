@@ -0,0 +1,161 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "mime"
+    "net"
+    "net/mail"
+    "net/smtp"
+    "net/textproto"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// smtpTimeout bounds a send when ctx has no earlier deadline.
+const smtpTimeout = 30 * time.Second
+
+// SMTP sends plain-text UTF-8 email. It upgrades with STARTTLS when the
+// server offers it, uses implicit TLS on port 465, and authenticates with
+// PLAIN when Username is set.
+type SMTP struct {
+    Host string
+    // Port defaults to 587.
+    Port     int
+    Username string
+    Password string
+    // From is the sender, e.g. "Shop <noreply@example.com>".
+    From string
+}
+
+func (s *SMTP) Kind() string     { return KindEmail }
+func (s *SMTP) Provider() string { return "smtp" }
+
+// Send delivers m and returns the Message-ID it was sent with.
+func (s *SMTP) Send(ctx context.Context, m Message) (string, error) {
+    from, err := mail.ParseAddress(s.From)
+    if err != nil {
+        return "", fmt.Errorf("smtp: invalid sender: %w", err)
+    }
+    to, err := mail.ParseAddress(m.To)
+    if err != nil {
+        return "", fmt.Errorf("%w: invalid recipient %q", ErrRejected, m.To)
+    }
+    messageID := messageID(m.ID, from.Address)
+    msg := buildMail(from, to, m.Subject, m.Body, messageID, time.Now())
+
+    port := s.Port
+    if port == 0 {
+        port = 587
+    }
+    addr := net.JoinHostPort(s.Host, strconv.Itoa(port))
+    deadline, ok := ctx.Deadline()
+    if !ok {
+        deadline = time.Now().Add(smtpTimeout)
+    }
+    d := net.Dialer{Deadline: deadline}
+    var conn net.Conn
+    if port == 465 {
+        conn, err = (&tls.Dialer{NetDialer: &d, Config: &tls.Config{ServerName: s.Host}}).DialContext(ctx, "tcp", addr)
+    } else {
+        conn, err = d.DialContext(ctx, "tcp", addr)
+    }
+    if err != nil {
+        return "", fmt.Errorf("smtp: %w", err)
+    }
+    _ = conn.SetDeadline(deadline)
+    c, err := smtp.NewClient(conn, s.Host)
+    if err != nil {
+        conn.Close()
+        return "", fmt.Errorf("smtp: %w", err)
+    }
+    defer c.Close()
+    if err := s.deliver(c, port, from.Address, to.Address, msg); err != nil {
+        return "", fmt.Errorf("smtp: %w", classifySMTP(err))
+    }
+    return messageID, nil
+}
+
+func (s *SMTP) deliver(c *smtp.Client, port int, from, to string, msg []byte) error {
+    if port != 465 {
+        if ok, _ := c.Extension("STARTTLS"); ok {
+            if err := c.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+                return err
+            }
+        }
+    }
+    if s.Username != "" {
+        if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+            return err
+        }
+    }
+    if err := c.Mail(from); err != nil {
+        return err
+    }
+    if err := c.Rcpt(to); err != nil {
+        return err
+    }
+    w, err := c.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := w.Write(msg); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+    return c.Quit()
+}
+
+// classifySMTP marks permanent (5xx) replies as rejections.
+func classifySMTP(err error) error {
+    var perr *textproto.Error
+    if errors.As(err, &perr) && perr.Code >= 500 {
+        return fmt.Errorf("%w: %w", ErrRejected, err)
+    }
+    return err
+}
+
+// messageID derives the Message-ID from the notification id, so a retried
+// send carries the same one.
+func messageID(id, from string) string {
+    if id == "" {
+        id = uuid.NewString()
+    }
+    domain := "localhost"
+    if i := strings.LastIndex(from, "@"); i >= 0 {
+        domain = from[i+1:]
+    }
+    return "<" + id + "@" + domain + ">"
+}
+
+// buildMail renders a text/plain message with base64 body, which keeps
+// non-ASCII text intact over any relay.
+func buildMail(from, to *mail.Address, subject, body, messageID string, date time.Time) []byte {
+    var b bytes.Buffer
+    header := func(k, v string) { fmt.Fprintf(&b, "%s: %s\r\n", k, v) }
+    header("From", from.String())
+    header("To", to.String())
+    header("Subject", mime.QEncoding.Encode("utf-8", subject))
+    header("Date", date.Format(time.RFC1123Z))
+    header("Message-ID", messageID)
+    header("MIME-Version", "1.0")
+    header("Content-Type", `text/plain; charset="utf-8"`)
+    header("Content-Transfer-Encoding", "base64")
+    b.WriteString("\r\n")
+    enc := base64.StdEncoding.EncodeToString([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+    for len(enc) > 76 {
+        b.WriteString(enc[:76] + "\r\n")
+        enc = enc[76:]
+    }
+    b.WriteString(enc + "\r\n")
+    return b.Bytes()
+}
@@ -0,0 +1,152 @@
+package notify
+
+import (
+    "bufio"
+    "context"
+    "encoding/base64"
+    "errors"
+    "io"
+    "mime"
+    "net"
+    "net/mail"
+    "strings"
+    "testing"
+)
+
+// fakeSMTP is a minimal SMTP server that records one message and rejects
+// recipients at rejectDomain.
+type fakeSMTP struct {
+    ln           net.Listener
+    rejectDomain string
+    rcpts        chan string
+    data         chan string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+    t.Helper()
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("listen: %v", err)
+    }
+    f := &fakeSMTP{ln: ln, rejectDomain: "invalid.example", rcpts: make(chan string, 4), data: make(chan string, 4)}
+    t.Cleanup(func() { ln.Close() })
+    go func() {
+        for {
+            conn, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            go f.serve(conn)
+        }
+    }()
+    return f
+}
+
+func (f *fakeSMTP) port() int { return f.ln.Addr().(*net.TCPAddr).Port }
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+    defer conn.Close()
+    r := bufio.NewReader(conn)
+    reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+    reply("220 fake ESMTP")
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return
+        }
+        cmd := strings.ToUpper(strings.TrimSpace(line))
+        switch {
+        case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+            reply("250 fake")
+        case strings.HasPrefix(cmd, "MAIL FROM"):
+            reply("250 ok")
+        case strings.HasPrefix(cmd, "RCPT TO"):
+            if strings.Contains(strings.ToLower(line), f.rejectDomain) {
+                reply("550 no such user")
+                continue
+            }
+            f.rcpts <- strings.TrimSpace(line[len("RCPT TO:"):])
+            reply("250 ok")
+        case cmd == "DATA":
+            reply("354 go ahead")
+            var b strings.Builder
+            for {
+                l, err := r.ReadString('\n')
+                if err != nil {
+                    return
+                }
+                if l == ".\r\n" {
+                    break
+                }
+                b.WriteString(l)
+            }
+            f.data <- b.String()
+            reply("250 queued")
+        case cmd == "QUIT":
+            reply("221 bye")
+            return
+        default:
+            reply("502 not implemented")
+        }
+    }
+}
+
+func TestSMTP_Send(t *testing.T) {
+    srv := newFakeSMTP(t)
+    ch := &SMTP{Host: "127.0.0.1", Port: srv.port(), From: "Shop <noreply@shop.example>"}
+    id, err := ch.Send(context.Background(), Message{
+        ID:      "6f1c1f4e-2a55-4a53-9d0b-1b7f3f0b2c11",
+        To:      "hana@example.com",
+        Subject: "発送のお知らせ",
+        Body:    "ご注文の商品を発送しました。\n追跡番号: 1234",
+    })
+    if err != nil {
+        t.Fatalf("send: %v", err)
+    }
+    if id != "<6f1c1f4e-2a55-4a53-9d0b-1b7f3f0b2c11@shop.example>" {
+        t.Fatalf("message id = %q", id)
+    }
+    if rcpt := <-srv.rcpts; rcpt != "<hana@example.com>" {
+        t.Fatalf("rcpt = %q", rcpt)
+    }
+    msg, err := mail.ReadMessage(strings.NewReader(<-srv.data))
+    if err != nil {
+        t.Fatalf("parse message: %v", err)
+    }
+    subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+    if err != nil || subject != "発送のお知らせ" {
+        t.Fatalf("subject = %q, %v", subject, err)
+    }
+    if msg.Header.Get("Message-ID") != id {
+        t.Fatalf("Message-ID header = %q", msg.Header.Get("Message-ID"))
+    }
+    raw, _ := io.ReadAll(msg.Body)
+    body, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(raw), "\r\n", ""))
+    if err != nil || string(body) != "ご注文の商品を発送しました。\r\n追跡番号: 1234" {
+        t.Fatalf("body = %q, %v", body, err)
+    }
+}
+
+func TestSMTP_RejectedRecipient(t *testing.T) {
+    srv := newFakeSMTP(t)
+    ch := &SMTP{Host: "127.0.0.1", Port: srv.port(), From: "noreply@shop.example"}
+    _, err := ch.Send(context.Background(), Message{To: "nobody@invalid.example", Subject: "x", Body: "x"})
+    if !errors.Is(err, ErrRejected) {
+        t.Fatalf("5xx reply should be a rejection, got %v", err)
+    }
+    _, err = ch.Send(context.Background(), Message{To: "not an address", Subject: "x", Body: "x"})
+    if !errors.Is(err, ErrRejected) {
+        t.Fatalf("invalid address should be a rejection, got %v", err)
+    }
+}
+
+func TestSMTP_Unreachable(t *testing.T) {
+    ln, _ := net.Listen("tcp", "127.0.0.1:0")
+    port := ln.Addr().(*net.TCPAddr).Port
+    ln.Close()
+    ch := &SMTP{Host: "127.0.0.1", Port: port, From: "noreply@shop.example"}
+    _, err := ch.Send(context.Background(), Message{To: "hana@example.com", Subject: "x", Body: "x"})
+    if err == nil || errors.Is(err, ErrRejected) {
+        t.Fatalf("connection errors are retryable, got %v", err)
+    }
+}
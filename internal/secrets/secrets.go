@@ -0,0 +1,115 @@
+// Package secrets encrypts credentials at rest with AES-256-GCM under
+// versioned keys, so keys can be rotated while older ciphertexts stay
+// readable.
+package secrets
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// ErrNoKeys is returned by NewKeyring for an empty key list.
+var ErrNoKeys = errors.New("secrets: no keys configured")
+
+// ErrDecrypt is returned when a ciphertext is malformed, was sealed under an
+// unknown key version, or fails authentication (including a wrong aad).
+var ErrDecrypt = errors.New("secrets: cannot decrypt")
+
+// Keyring holds the AES-GCM keys by version. New ciphertexts use the active
+// version; any configured version can open.
+type Keyring struct {
+    keys   map[byte]cipher.AEAD
+    active byte
+}
+
+// NewKeyring parses "version:base64key" pairs separated by commas, e.g.
+// "1:<32 bytes base64>,2:<32 bytes base64>". Versions are 1-255 and keys must
+// be 32 bytes. The highest version is active.
+func NewKeyring(spec string) (*Keyring, error) {
+    k := &Keyring{keys: map[byte]cipher.AEAD{}}
+    for _, pair := range strings.Split(spec, ",") {
+        pair = strings.TrimSpace(pair)
+        if pair == "" {
+            continue
+        }
+        v, b64, ok := strings.Cut(pair, ":")
+        if !ok {
+            return nil, fmt.Errorf("secrets: key %q must be version:base64", pair)
+        }
+        n, err := strconv.Atoi(strings.TrimSpace(v))
+        if err != nil || n < 1 || n > 255 {
+            return nil, fmt.Errorf("secrets: invalid key version %q", v)
+        }
+        raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+        if err != nil || len(raw) != 32 {
+            return nil, fmt.Errorf("secrets: key %d must be 32 bytes, base64 encoded", n)
+        }
+        block, err := aes.NewCipher(raw)
+        if err != nil {
+            return nil, err
+        }
+        aead, err := cipher.NewGCM(block)
+        if err != nil {
+            return nil, err
+        }
+        if _, dup := k.keys[byte(n)]; dup {
+            return nil, fmt.Errorf("secrets: duplicate key version %d", n)
+        }
+        k.keys[byte(n)] = aead
+        if byte(n) > k.active {
+            k.active = byte(n)
+        }
+    }
+    if len(k.keys) == 0 {
+        return nil, ErrNoKeys
+    }
+    return k, nil
+}
+
+// Seal encrypts plaintext as version || nonce || ciphertext. aad binds the
+// ciphertext to its context (such as the owning row's ID) so it cannot be
+// moved elsewhere.
+func (k *Keyring) Seal(plaintext, aad []byte) ([]byte, error) {
+    aead := k.keys[k.active]
+    out := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(plaintext)+aead.Overhead())
+    out[0] = k.active
+    if _, err := rand.Read(out[1:]); err != nil {
+        return nil, err
+    }
+    return aead.Seal(out, out[1:], plaintext, aad), nil
+}
+
+// Open decrypts a Seal result with the same aad.
+func (k *Keyring) Open(sealed, aad []byte) ([]byte, error) {
+    if len(sealed) < 1 {
+        return nil, ErrDecrypt
+    }
+    aead, ok := k.keys[sealed[0]]
+    if !ok || len(sealed) < 1+aead.NonceSize() {
+        return nil, ErrDecrypt
+    }
+    nonce := sealed[1 : 1+aead.NonceSize()]
+    plain, err := aead.Open(nil, nonce, sealed[1+aead.NonceSize():], aad)
+    if err != nil {
+        return nil, ErrDecrypt
+    }
+    return plain, nil
+}
+
+// Version reports the key version a ciphertext was sealed under; 0 when
+// malformed.
+func Version(sealed []byte) int {
+    if len(sealed) == 0 {
+        return 0
+    }
+    return int(sealed[0])
+}
+
+// ActiveVersion is the version new ciphertexts are sealed under.
+func (k *Keyring) ActiveVersion() int { return int(k.active) }
@@ -0,0 +1,71 @@
+package secrets
+
+import (
+    "bytes"
+    "encoding/base64"
+    "testing"
+)
+
+func key(b byte) string { return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32)) }
+
+func TestSealOpen(t *testing.T) {
+    k, err := NewKeyring("1:" + key(1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    sealed, err := k.Seal([]byte("whsec_abc"), []byte("endpoint-1"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if bytes.Contains(sealed, []byte("whsec_abc")) || Version(sealed) != 1 {
+        t.Fatalf("unexpected ciphertext %x", sealed)
+    }
+    plain, err := k.Open(sealed, []byte("endpoint-1"))
+    if err != nil || string(plain) != "whsec_abc" {
+        t.Fatalf("Open = %q, %v", plain, err)
+    }
+    if _, err := k.Open(sealed, []byte("endpoint-2")); err != ErrDecrypt {
+        t.Errorf("wrong aad: err = %v, want ErrDecrypt", err)
+    }
+    sealed[len(sealed)-1] ^= 1
+    if _, err := k.Open(sealed, []byte("endpoint-1")); err != ErrDecrypt {
+        t.Errorf("tampered: err = %v, want ErrDecrypt", err)
+    }
+    if _, err := k.Open(nil, nil); err != ErrDecrypt {
+        t.Errorf("empty: err = %v, want ErrDecrypt", err)
+    }
+}
+
+func TestKeyRotation(t *testing.T) {
+    old, _ := NewKeyring("1:" + key(1))
+    sealed, _ := old.Seal([]byte("s"), nil)
+
+    rotated, err := NewKeyring("2:" + key(2) + ", 1:" + key(1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rotated.ActiveVersion() != 2 {
+        t.Fatalf("active = %d, want 2", rotated.ActiveVersion())
+    }
+    if plain, err := rotated.Open(sealed, nil); err != nil || string(plain) != "s" {
+        t.Fatalf("old ciphertext: %q %v", plain, err)
+    }
+    resealed, _ := rotated.Seal([]byte("s"), nil)
+    if Version(resealed) != 2 {
+        t.Errorf("new ciphertext version = %d", Version(resealed))
+    }
+    if _, err := old.Open(resealed, nil); err != ErrDecrypt {
+        t.Errorf("retired keyring opened newer version: %v", err)
+    }
+}
+
+func TestNewKeyring_Invalid(t *testing.T) {
+    for _, spec := range []string{"", " , ", "nokey", "0:" + key(1), "1:short", "1:" + key(1) + ",1:" + key(2)} {
+        if _, err := NewKeyring(spec); err == nil {
+            t.Errorf("NewKeyring(%q) should fail", spec)
+        }
+    }
+    if _, err := NewKeyring(""); err != ErrNoKeys {
+        t.Errorf("empty: err = %v", err)
+    }
+}
@@ -5,7 +5,11 @@ import (
     "net/http"
     "net/http/httptest"
     "os"
+    "strings"
     "testing"
+    "time"
+
+    "github.com/google/uuid"
 )
 
 // helper to parse standardized error
@@ -52,6 +56,67 @@ func TestWebhook_InvalidSignatureFormat_ErrorJSON(t *testing.T) {
     }
 }
 
+func TestWebhook_StaleTimestamp_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    os.Setenv("DUMMY_WEBHOOK_SECRET", "dummysecret")
+    body := `{"code":"X","status":"in_transit"}`
+    req := httptest.NewRequest(http.MethodPost, "/webhooks/dummy", strings.NewReader(body))
+    req.Header.Set("X-Signature", signTimestamped("dummysecret", time.Now().Add(-time.Hour).Unix(), []byte(body)))
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusUnauthorized {
+        t.Fatalf("expected 401, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var e stdError
+    if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+        t.Fatalf("unmarshal error: %v", err)
+    }
+    if e.Error.Code != "timestamp_out_of_window" {
+        t.Fatalf("unexpected error code: %s", e.Error.Code)
+    }
+}
+
+func TestWebhook_TimestampRequired_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    os.Setenv("DUMMY_WEBHOOK_SECRET", "dummysecret")
+    os.Setenv("WEBHOOK_REQUIRE_TIMESTAMP", "true")
+    defer os.Unsetenv("WEBHOOK_REQUIRE_TIMESTAMP")
+    req := httptest.NewRequest(http.MethodPost, "/webhooks/dummy", strings.NewReader(`{}`))
+    req.Header.Set("X-Signature", "sha256=00")
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusUnauthorized {
+        t.Fatalf("expected 401, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var e stdError
+    if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+        t.Fatalf("unmarshal error: %v", err)
+    }
+    if e.Error.Code != "timestamp_required" {
+        t.Fatalf("unexpected error code: %s", e.Error.Code)
+    }
+}
+
+func TestWebhook_BodyTooLarge_ErrorJSON(t *testing.T) {
+    h := NewWithOptions(nil, Options{WebhookMaxBody: 64})
+    os.Setenv("DUMMY_WEBHOOK_SECRET", "dummysecret")
+    body := `{"code":"X","status":"in_transit","description":"` + strings.Repeat("x", 64) + `"}`
+    req := httptest.NewRequest(http.MethodPost, "/webhooks/dummy", strings.NewReader(body))
+    req.Header.Set("X-Signature", signTimestamped("dummysecret", time.Now().Unix(), []byte(body)))
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusRequestEntityTooLarge {
+        t.Fatalf("expected 413, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var e stdError
+    if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+        t.Fatalf("unmarshal error: %v", err)
+    }
+    if e.Error.Code != "payload_too_large" {
+        t.Fatalf("unexpected error code: %s", e.Error.Code)
+    }
+}
+
 func TestGetTracker_MissingCode_ErrorJSON(t *testing.T) {
     h := New(nil)
     // space decodes to empty after trim
@@ -68,4 +133,120 @@ func TestGetTracker_MissingCode_ErrorJSON(t *testing.T) {
     if e.Error.Code != "invalid_request" {
         t.Fatalf("unexpected error code: %s", e.Error.Code)
     }
-}
\ No newline at end of file
+}
+
+func TestGetTrackerEvents_InvalidOrder_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    req := httptest.NewRequest(http.MethodGet, "/trackers/TRACK123/events?order=sideways", nil)
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var e stdError
+    if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+        t.Fatalf("unmarshal error: %v", err)
+    }
+    if e.Error.Code != "invalid_request" {
+        t.Fatalf("unexpected error code: %s", e.Error.Code)
+    }
+}
+
+func TestGetTrackerEvents_InvalidLimit_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    req := httptest.NewRequest(http.MethodGet, "/trackers/TRACK123/events?limit=-1", nil)
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+}
+
+func TestCreateShipmentTracker_MissingCode_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    req := httptest.NewRequest(http.MethodPost, "/shipments/"+uuid.NewString()+"/trackers", strings.NewReader(`{"carrier_code":"dhl"}`))
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var e stdError
+    if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+        t.Fatalf("unmarshal error: %v", err)
+    }
+    if e.Error.Code != "invalid_request" {
+        t.Fatalf("unexpected error code: %s", e.Error.Code)
+    }
+}
+
+func TestGetShipment_InvalidID_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    req := httptest.NewRequest(http.MethodGet, "/shipments/not-a-uuid", nil)
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+}
+
+func TestCreateTracker_InvalidCheckDigit_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    req := httptest.NewRequest(http.MethodPost, "/trackers", strings.NewReader(`{"tracking_number":"1Z999AA10123456785","carrier_code":"ups"}`))
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var e stdError
+    if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+        t.Fatalf("unmarshal error: %v", err)
+    }
+    if e.Error.Code != "invalid_tracking_number" {
+        t.Fatalf("unexpected error code: %s", e.Error.Code)
+    }
+}
+
+func TestBulkCreateTrackers_Empty_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    req := httptest.NewRequest(http.MethodPost, "/trackers/bulk", strings.NewReader(`{"trackers":[]}`))
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+}
+
+func TestEndpointWebhook_SecretsNotConfigured_ErrorJSON(t *testing.T) {
+    t.Setenv("SECRET_KEYS", "")
+    h := New(nil)
+    req := httptest.NewRequest(http.MethodPost, "/webhooks/dummy/"+uuid.NewString(), strings.NewReader(`{}`))
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusServiceUnavailable {
+        t.Fatalf("expected 503, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var e stdError
+    if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+        t.Fatalf("unmarshal error: %v", err)
+    }
+    if e.Error.Code != "secrets_not_configured" {
+        t.Fatalf("unexpected error code: %s", e.Error.Code)
+    }
+}
+
+func TestCreateWebhookEndpoint_UnsupportedSource_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    req := httptest.NewRequest(http.MethodPost, "/webhook-endpoints", strings.NewReader(`{"org_slug":"demo","source":"nope"}`))
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var e stdError
+    if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+        t.Fatalf("unmarshal error: %v", err)
+    }
+    if e.Error.Code != "unsupported_source" {
+        t.Fatalf("unexpected error code: %s", e.Error.Code)
+    }
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+
+    "deliveryinfra/internal/tracking"
+)
+
+// etaSampleLimit caps the lane history used per prediction to recent deliveries.
+const etaSampleLimit = 500
+
+// TrackerETA is the predicted delivery on GET /trackers/{code}.
+type TrackerETA struct {
+    EstimatedAt string  `json:"estimated_at"`
+    Earliest    string  `json:"earliest"`
+    Latest      string  `json:"latest"`
+    Confidence  float64 `json:"confidence"`
+    Samples     int     `json:"samples"`
+    // Basis is the history used: lane, lane_any_service, country_pair, or
+    // delivered once the parcel has arrived.
+    Basis     string `json:"basis"`
+    UpdatedAt string `json:"updated_at"`
+}
+
+// transitLane identifies comparable shipments for transit-time history.
+type transitLane struct {
+    Carrier            string
+    Service            string
+    OriginCountry      string
+    OriginRegion       string
+    DestinationCountry string
+    DestinationRegion  string
+}
+
+// etaLevels are tried from most to least specific until one has enough history.
+var etaLevels = []struct {
+    basis            string
+    service, regions bool
+}{
+    {"lane", true, true},
+    {"lane_any_service", false, true},
+    {"country_pair", false, false},
+}
+
+// laneRegion reduces an address to its country and state/prefecture, the
+// granularity of a lane. It matches the lane_transit_times backfill.
+func laneRegion(raw json.RawMessage) (country, region string) {
+    loc := publicLocation(raw)
+    if loc == nil {
+        return "", ""
+    }
+    return strings.ToUpper(loc.Country), strings.ToLower(loc.State)
+}
+
+// updateTrackerETA refreshes a tracker's predicted delivery after its state
+// changed. A delivery is also recorded as lane history for future predictions.
+func updateTrackerETA(ctx context.Context, q dbtx, trackerID uuid.UUID, st tracking.State) error {
+    var (
+        carrier, service string
+        shipFrom, shipTo *string
+        shipmentID       *uuid.UUID
+    )
+    err := q.QueryRow(ctx, `
+        SELECT COALESCE(t.carrier_code, ''), COALESCE(s.service_code, ''), s.id, s.ship_from, s.ship_to
+        FROM trackers t LEFT JOIN shipments s ON s.id = t.shipment_id
+        WHERE t.id = $1
+    `, trackerID).Scan(&carrier, &service, &shipmentID, &shipFrom, &shipTo)
+    if err != nil {
+        return err
+    }
+    lane := transitLane{Carrier: strings.ToLower(carrier), Service: strings.ToLower(strings.TrimSpace(service))}
+    if shipFrom != nil {
+        lane.OriginCountry, lane.OriginRegion = laneRegion(json.RawMessage(*shipFrom))
+    }
+    if shipTo != nil {
+        lane.DestinationCountry, lane.DestinationRegion = laneRegion(json.RawMessage(*shipTo))
+    }
+
+    switch st.Status {
+    case tracking.StatusDelivered:
+        if lane.Carrier != "" && shipmentID != nil && !st.PickedUpAt.IsZero() && st.DeliveredAt.Sub(st.PickedUpAt) >= time.Second {
+            if err := recordLaneTransit(ctx, q, trackerID, lane, st.PickedUpAt, st.DeliveredAt); err != nil {
+                return err
+            }
+        }
+        at := st.DeliveredAt
+        return setTrackerETA(ctx, q, trackerID, &tracking.Prediction{EstimatedAt: at, Earliest: at, Latest: at}, "delivered")
+    case tracking.StatusReturnToSender:
+        return setTrackerETA(ctx, q, trackerID, nil, "")
+    }
+    if lane.Carrier == "" {
+        return setTrackerETA(ctx, q, trackerID, nil, "")
+    }
+    now := time.Now()
+    for _, level := range etaLevels {
+        samples, err := laneTransitSamples(ctx, q, lane, level.service, level.regions)
+        if err != nil {
+            return err
+        }
+        if p, ok := tracking.PredictETA(samples, st.PickedUpAt, now); ok {
+            return setTrackerETA(ctx, q, trackerID, &p, level.basis)
+        }
+    }
+    return setTrackerETA(ctx, q, trackerID, nil, "")
+}
+
+func recordLaneTransit(ctx context.Context, q dbtx, trackerID uuid.UUID, lane transitLane, pickedUp, delivered time.Time) error {
+    _, err := q.Exec(ctx, `
+        INSERT INTO lane_transit_times (
+            tracker_id, carrier_code, service_code, origin_country, origin_region,
+            destination_country, destination_region, picked_up_at, delivered_at, transit_seconds
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        ON CONFLICT (tracker_id) DO UPDATE SET
+            carrier_code = EXCLUDED.carrier_code, service_code = EXCLUDED.service_code,
+            origin_country = EXCLUDED.origin_country, origin_region = EXCLUDED.origin_region,
+            destination_country = EXCLUDED.destination_country, destination_region = EXCLUDED.destination_region,
+            picked_up_at = EXCLUDED.picked_up_at, delivered_at = EXCLUDED.delivered_at,
+            transit_seconds = EXCLUDED.transit_seconds
+    `, trackerID, lane.Carrier, lane.Service, lane.OriginCountry, lane.OriginRegion,
+        lane.DestinationCountry, lane.DestinationRegion, pickedUp, delivered, int64(delivered.Sub(pickedUp)/time.Second))
+    return err
+}
+
+func laneTransitSamples(ctx context.Context, q dbtx, lane transitLane, byService, byRegion bool) ([]time.Duration, error) {
+    rows, err := q.Query(ctx, `
+        SELECT transit_seconds FROM lane_transit_times
+        WHERE carrier_code = $1 AND origin_country = $2 AND destination_country = $3
+          AND (NOT $4 OR (origin_region = $5 AND destination_region = $6))
+          AND (NOT $7 OR service_code = $8)
+        ORDER BY delivered_at DESC
+        LIMIT $9
+    `, lane.Carrier, lane.OriginCountry, lane.DestinationCountry, byRegion, lane.OriginRegion, lane.DestinationRegion,
+        byService, lane.Service, etaSampleLimit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []time.Duration
+    for rows.Next() {
+        var secs int64
+        if err := rows.Scan(&secs); err != nil {
+            return nil, err
+        }
+        out = append(out, time.Duration(secs)*time.Second)
+    }
+    return out, rows.Err()
+}
+
+// setTrackerETA stores p, or clears the prediction when p is nil.
+func setTrackerETA(ctx context.Context, q dbtx, trackerID uuid.UUID, p *tracking.Prediction, basis string) error {
+    var est, earliest, latest *time.Time
+    var samples *int
+    if p != nil {
+        est, earliest, latest, samples = &p.EstimatedAt, &p.Earliest, &p.Latest, &p.Samples
+    }
+    _, err := q.Exec(ctx, `
+        UPDATE trackers
+        SET eta_estimated_at = $2, eta_earliest = $3, eta_latest = $4, eta_samples = $5, eta_basis = $6,
+            eta_updated_at = now()
+        WHERE id = $1
+    `, trackerID, est, earliest, latest, samples, nullIfEmpty(basis))
+    return err
+}
+
+// trackerETA builds the response from the stored columns; nil without a prediction.
+func trackerETA(est, earliest, latest, updated *time.Time, samples *int, basis *string) *TrackerETA {
+    if est == nil || earliest == nil || latest == nil {
+        return nil
+    }
+    eta := &TrackerETA{
+        EstimatedAt: est.UTC().Format(time.RFC3339),
+        Earliest:    earliest.UTC().Format(time.RFC3339),
+        Latest:      latest.UTC().Format(time.RFC3339),
+        Confidence:  tracking.ETAConfidence,
+    }
+    if samples != nil {
+        eta.Samples = *samples
+    }
+    if basis != nil {
+        eta.Basis = *basis
+        if *basis == "delivered" {
+            eta.Confidence = 1
+        }
+    }
+    if updated != nil {
+        eta.UpdatedAt = updated.UTC().Format(time.RFC3339)
+    }
+    return eta
+}
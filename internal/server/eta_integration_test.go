@@ -0,0 +1,115 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "testing"
+    "time"
+
+    "deliveryinfra/internal/db"
+)
+
+func TestTrackerETAIntegration(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    _, _ = pool.Exec(t.Context(), `
+        INSERT INTO orgs (slug, name)
+        SELECT 'demo', 'Demo Org'
+        WHERE NOT EXISTS (SELECT 1 FROM orgs WHERE slug = 'demo')
+    `)
+    carrier := fmt.Sprintf("itesteta%d", time.Now().UnixNano())
+
+    // Lane history: Tokyo -> Osaka deliveries took 1..12 days
+    for i := 1; i <= 12; i++ {
+        delivered := time.Now().Add(-time.Duration(i) * time.Hour)
+        _, err := pool.Exec(t.Context(), `
+            WITH t AS (
+                INSERT INTO trackers (carrier_tracking_code, carrier_code, status)
+                VALUES ($1, $2, 'delivered') RETURNING id
+            )
+            INSERT INTO lane_transit_times (tracker_id, carrier_code, service_code, origin_country, origin_region,
+                destination_country, destination_region, picked_up_at, delivered_at, transit_seconds)
+            SELECT id, $2, 'express', 'JP', 'tokyo', 'JP', 'osaka', $3::timestamptz - make_interval(days => $4), $3, $4 * 86400
+            FROM t
+        `, fmt.Sprintf("%s-H%d", carrier, i), carrier, delivered, i)
+        if err != nil {
+            t.Fatalf("seed lane history: %v", err)
+        }
+    }
+
+    h := New(pool)
+    code := fmt.Sprintf("%s-NEW", carrier)
+    body, _ := json.Marshal(map[string]any{
+        "org_slug":        "demo",
+        "carrier_code":    carrier,
+        "service_code":    "express",
+        "tracking_number": code,
+        "ship_from":       map[string]any{"country": "JP", "prefecture": "Tokyo"},
+        "ship_to":         map[string]any{"country": "JP", "prefecture": "Osaka"},
+        "package":         map[string]any{"weight_oz": 5},
+    })
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/shipments", bytes.NewReader(body)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("create shipment: %d %s", rr.Code, rr.Body.String())
+    }
+    pickup := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+    post := func(status string, at time.Time) {
+        t.Helper()
+        ev, _ := json.Marshal(map[string]any{"status": status, "occurred_at": at.Format(time.RFC3339)})
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trackers/"+code+"/events", bytes.NewReader(ev)))
+        if rr.Code != http.StatusOK {
+            t.Fatalf("post event: %d %s", rr.Code, rr.Body.String())
+        }
+    }
+    get := func() TrackerResponse {
+        t.Helper()
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/trackers/"+code, nil))
+        var res TrackerResponse
+        if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+            t.Fatalf("unmarshal: %v", err)
+        }
+        return res
+    }
+
+    post("in_transit", pickup)
+    eta := get().PredictedDelivery
+    if eta == nil || eta.Basis != "lane" || eta.Confidence != 0.8 {
+        t.Fatalf("expected lane prediction, got %+v", eta)
+    }
+    est, _ := time.Parse(time.RFC3339, eta.EstimatedAt)
+    earliest, _ := time.Parse(time.RFC3339, eta.Earliest)
+    latest, _ := time.Parse(time.RFC3339, eta.Latest)
+    if earliest.After(est) || est.After(latest) || est.Before(pickup.Add(24*time.Hour)) {
+        t.Fatalf("unexpected interval: %+v", eta)
+    }
+
+    delivered := pickup.Add(48 * time.Hour)
+    post("delivered", delivered)
+    if eta := get().PredictedDelivery; eta == nil || eta.Basis != "delivered" || eta.EstimatedAt != delivered.Format(time.RFC3339) {
+        t.Fatalf("expected delivered time, got %+v", eta)
+    }
+    var secs int64
+    if err := pool.QueryRow(t.Context(), `
+        SELECT l.transit_seconds FROM lane_transit_times l JOIN trackers t ON t.id = l.tracker_id
+        WHERE t.carrier_tracking_code = $1 AND l.origin_region = 'tokyo' AND l.destination_region = 'osaka'
+    `, code).Scan(&secs); err != nil || secs != 48*3600 {
+        t.Fatalf("expected recorded transit of 48h, got %d %v", secs, err)
+    }
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "deliveryinfra/internal/tracking"
+)
+
+// exceptionDetectorLockName identifies the advisory lock held by the leading detector.
+const exceptionDetectorLockName = "deliveryinfra.exception_detector"
+
+type ShipmentException struct {
+    ID           string          `json:"id"`
+    TrackingCode string          `json:"tracking_code"`
+    Reason       string          `json:"reason"`
+    Detail       json.RawMessage `json:"detail"`
+    DetectedAt   string          `json:"detected_at"`
+}
+
+// ExceptionDetectorConfig controls the exception detector. Zero values use the defaults.
+type ExceptionDetectorConfig struct {
+    // Tick is how often trackers are scanned (default 15m).
+    Tick time.Duration
+    // BatchSize is the number of trackers loaded per query (default 500).
+    BatchSize int
+    // Rules holds thresholds per carrier and service.
+    Rules tracking.ExceptionRules
+}
+
+// ExceptionDetector scans active trackers for stalled parcels, repeated
+// delivery failures, returns and ETA breaches. It records exceptions, moves
+// the shipment to "exception" and resolves exceptions that no longer apply.
+// Like the poller, only the advisory-lock holder across replicas scans.
+type ExceptionDetector struct {
+    db     *pgxpool.Pool
+    cfg    ExceptionDetectorConfig
+    leader *advisoryLeader
+    now    func() time.Time
+}
+
+func NewExceptionDetector(db *pgxpool.Pool, cfg ExceptionDetectorConfig) *ExceptionDetector {
+    if cfg.Tick <= 0 {
+        cfg.Tick = 15 * time.Minute
+    }
+    if cfg.BatchSize <= 0 {
+        cfg.BatchSize = 500
+    }
+    return &ExceptionDetector{
+        db:     db,
+        cfg:    cfg,
+        leader: newAdvisoryLeader(db, exceptionDetectorLockName),
+        now:    time.Now,
+    }
+}
+
+// Run scans until ctx is done, taking over leadership whenever it is free.
+func (d *ExceptionDetector) Run(ctx context.Context) {
+    ticker := time.NewTicker(d.cfg.Tick)
+    defer ticker.Stop()
+    defer d.leader.resign()
+    for {
+        leading, err := d.leader.ensure(ctx)
+        if err != nil {
+            log.Println("exception detector: leader election error:", err)
+        } else if leading {
+            if err := d.scanOnce(ctx); err != nil {
+                log.Println("exception detector: scan error:", err)
+            }
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+type exceptionCandidate struct {
+    TrackerID  uuid.UUID
+    ShipmentID *uuid.UUID
+    Code       string
+    Carrier    string
+    Service    string
+    Snapshot   tracking.TrackerSnapshot
+    Open       []string
+}
+
+// scanOnce checks every tracker that is undelivered or still has open
+// exceptions, in id order.
+func (d *ExceptionDetector) scanOnce(ctx context.Context) error {
+    var after uuid.UUID
+    for {
+        batch, err := d.candidates(ctx, after)
+        if err != nil {
+            return err
+        }
+        for _, c := range batch {
+            if err := d.checkTracker(ctx, c); err != nil {
+                log.Printf("exception detector: %s: %v", c.Code, err)
+            }
+        }
+        if len(batch) < d.cfg.BatchSize {
+            return nil
+        }
+        after = batch[len(batch)-1].TrackerID
+    }
+}
+
+func (d *ExceptionDetector) candidates(ctx context.Context, after uuid.UUID) ([]exceptionCandidate, error) {
+    rows, err := d.db.Query(ctx, `
+        SELECT t.id, t.shipment_id, t.carrier_tracking_code, COALESCE(t.carrier_code, ''),
+               COALESCE(s.service_code, ''), COALESCE(t.status, 'unknown'), t.last_event_at, t.created_at,
+               (SELECT COUNT(*) FROM tracking_events e
+                 WHERE e.tracker_id = t.id AND (e.status = 'failure' OR e.substatus = 'delivery_attempted')),
+               COALESCE(t.metadata->>'estimated_delivery', s.metadata->>'estimated_delivery', ''), t.eta_latest,
+               ARRAY(SELECT x.reason FROM tracking_exceptions x WHERE x.tracker_id = t.id AND x.resolved_at IS NULL)
+        FROM trackers t
+        LEFT JOIN shipments s ON s.id = t.shipment_id
+        WHERE t.id > $1
+          AND (t.status IS DISTINCT FROM 'delivered'
+               OR EXISTS (SELECT 1 FROM tracking_exceptions x WHERE x.tracker_id = t.id AND x.resolved_at IS NULL))
+        ORDER BY t.id
+        LIMIT $2
+    `, after, d.cfg.BatchSize)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []exceptionCandidate
+    for rows.Next() {
+        var (
+            c           exceptionCandidate
+            status, eta string
+            lastEventAt *time.Time
+            predicted   *time.Time
+        )
+        if err := rows.Scan(&c.TrackerID, &c.ShipmentID, &c.Code, &c.Carrier, &c.Service, &status, &lastEventAt,
+            &c.Snapshot.CreatedAt, &c.Snapshot.FailedAttempts, &eta, &predicted, &c.Open); err != nil {
+            return nil, err
+        }
+        c.Snapshot.Status = tracking.Status(status)
+        if lastEventAt != nil {
+            c.Snapshot.LastEventAt = *lastEventAt
+        }
+        // A promised date wins; otherwise the upper bound of the predicted delivery.
+        if t, ok := tracking.ParseETA(eta); ok {
+            c.Snapshot.EstimatedDelivery = t
+        } else if predicted != nil {
+            c.Snapshot.EstimatedDelivery = *predicted
+        }
+        out = append(out, c)
+    }
+    return out, rows.Err()
+}
+
+// checkTracker reconciles a tracker's open exceptions with what the rules
+// detect now. Nothing is written when they already agree.
+func (d *ExceptionDetector) checkTracker(ctx context.Context, c exceptionCandidate) error {
+    found := tracking.DetectExceptions(c.Snapshot, d.cfg.Rules.For(c.Carrier, c.Service), d.now())
+    open := map[string]bool{}
+    for _, r := range c.Open {
+        open[r] = true
+    }
+    var raise []tracking.Exception
+    keep := []string{}
+    for _, ex := range found {
+        keep = append(keep, string(ex.Reason))
+        if !open[string(ex.Reason)] {
+            raise = append(raise, ex)
+        }
+    }
+    if len(raise) == 0 && len(keep) == len(c.Open) {
+        return nil
+    }
+
+    tx, err := d.db.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+
+    raised := 0
+    for _, ex := range raise {
+        detail, _ := json.Marshal(ex.Detail)
+        var id uuid.UUID
+        err := tx.QueryRow(ctx, `
+            INSERT INTO tracking_exceptions (tracker_id, shipment_id, reason, detail)
+            VALUES ($1, $2, $3, $4::jsonb)
+            ON CONFLICT (tracker_id, reason) WHERE resolved_at IS NULL DO NOTHING
+            RETURNING id
+        `, c.TrackerID, c.ShipmentID, string(ex.Reason), jsonOrEmpty(detail)).Scan(&id)
+        if err != nil {
+            if errors.Is(err, pgx.ErrNoRows) {
+                continue
+            }
+            return err
+        }
+        if c.ShipmentID != nil {
+            if err := outboxExceptionRaised(ctx, tx, *c.ShipmentID, id); err != nil {
+                return err
+            }
+        }
+        raised++
+    }
+
+    _, err = tx.Exec(ctx, `
+        UPDATE tracking_exceptions SET resolved_at = now()
+        WHERE tracker_id = $1 AND resolved_at IS NULL AND NOT (reason = ANY($2))
+    `, c.TrackerID, keep)
+    if err != nil {
+        return err
+    }
+
+    if c.ShipmentID != nil {
+        switch {
+        case raised > 0:
+            if err := markShipmentException(ctx, tx, *c.ShipmentID); err != nil {
+                return err
+            }
+        case len(keep) == 0:
+            // Everything resolved: the shipment follows its tracker again.
+            if err := syncShipmentStatus(ctx, tx, c.TrackerID); err != nil {
+                return err
+            }
+        }
+    }
+    return tx.Commit(ctx)
+}
+
+// markShipmentException moves an undelivered shipment to "exception" and
+// refreshes its order.
+func markShipmentException(ctx context.Context, q dbtx, shipmentID uuid.UUID) error {
+    var orderID *uuid.UUID
+    err := q.QueryRow(ctx, `
+        UPDATE shipments SET status = 'exception', updated_at = now()
+        WHERE id = $1 AND status NOT IN ('exception', 'delivered')
+        RETURNING order_id
+    `, shipmentID).Scan(&orderID)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return nil
+        }
+        return err
+    }
+    if orderID == nil {
+        return nil
+    }
+    _, err = refreshOrderStatus(ctx, q, *orderID)
+    return err
+}
+
+func openShipmentExceptions(ctx context.Context, q dbtx, shipmentID uuid.UUID) ([]ShipmentException, error) {
+    rows, err := q.Query(ctx, `
+        SELECT x.id, t.carrier_tracking_code, x.reason, x.detail, x.detected_at
+        FROM tracking_exceptions x
+        JOIN trackers t ON t.id = x.tracker_id
+        WHERE x.shipment_id = $1 AND x.resolved_at IS NULL
+        ORDER BY x.detected_at, x.reason
+    `, shipmentID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := []ShipmentException{}
+    for rows.Next() {
+        var (
+            ex         ShipmentException
+            id         uuid.UUID
+            detail     string
+            detectedAt time.Time
+        )
+        if err := rows.Scan(&id, &ex.TrackingCode, &ex.Reason, &detail, &detectedAt); err != nil {
+            return nil, err
+        }
+        ex.ID = id.String()
+        ex.Detail = json.RawMessage(detail)
+        ex.DetectedAt = detectedAt.UTC().Format(time.RFC3339)
+        out = append(out, ex)
+    }
+    return out, rows.Err()
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "testing"
+    "time"
+
+    "deliveryinfra/internal/db"
+    "deliveryinfra/internal/tracking"
+)
+
+func TestExceptionDetectorIntegration(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    _, _ = pool.Exec(t.Context(), `
+        INSERT INTO orgs (slug, name)
+        SELECT 'demo', 'Demo Org'
+        WHERE NOT EXISTS (SELECT 1 FROM orgs WHERE slug = 'demo')
+    `)
+
+    h := New(pool)
+    code := fmt.Sprintf("ITESTSTALL%d", time.Now().UnixNano())
+    body, _ := json.Marshal(map[string]any{
+        "org_slug":        "demo",
+        "carrier_code":    "itestexc",
+        "service_code":    "express",
+        "tracking_number": code,
+        "ship_to":         map[string]any{"country": "JP"},
+        "package":         map[string]any{"weight_oz": 5},
+    })
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/shipments", bytes.NewReader(body)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("create shipment: %d %s", rr.Code, rr.Body.String())
+    }
+    var created ShipmentCreateResponse
+    _ = json.Unmarshal(rr.Body.Bytes(), &created)
+
+    postEvent := func(occurred time.Time) {
+        t.Helper()
+        ev, _ := json.Marshal(map[string]any{"status": "in_transit", "occurred_at": occurred.UTC().Format(time.RFC3339)})
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trackers/"+code+"/events", bytes.NewReader(ev)))
+        if rr.Code != http.StatusOK {
+            t.Fatalf("post event: %d %s", rr.Code, rr.Body.String())
+        }
+    }
+    getShipment := func() ShipmentResponse {
+        t.Helper()
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/shipments/"+created.ShipmentID, nil))
+        if rr.Code != http.StatusOK {
+            t.Fatalf("get shipment: %d %s", rr.Code, rr.Body.String())
+        }
+        var sh ShipmentResponse
+        if err := json.Unmarshal(rr.Body.Bytes(), &sh); err != nil {
+            t.Fatalf("unmarshal: %v", err)
+        }
+        return sh
+    }
+
+    // Per-service threshold: express parcels stall after three days
+    rules, err := tracking.ParseExceptionRules(`{"carriers": {"itestexc/express": {"stall_after": "72h"}}}`)
+    if err != nil {
+        t.Fatalf("rules: %v", err)
+    }
+    d := NewExceptionDetector(pool, ExceptionDetectorConfig{Rules: rules})
+
+    postEvent(time.Now().Add(-4 * 24 * time.Hour))
+    if err := d.scanOnce(t.Context()); err != nil {
+        t.Fatalf("scan: %v", err)
+    }
+    sh := getShipment()
+    if sh.Status != "exception" || sh.ServiceCode != "express" || len(sh.Exceptions) != 1 || sh.Exceptions[0].Reason != "stalled" {
+        t.Fatalf("expected stalled exception, got status=%s exceptions=%+v", sh.Status, sh.Exceptions)
+    }
+
+    // Scanning again raises nothing new
+    if err := d.scanOnce(t.Context()); err != nil {
+        t.Fatalf("scan: %v", err)
+    }
+    var open int
+    if err := pool.QueryRow(t.Context(), `
+        SELECT COUNT(*) FROM tracking_exceptions x JOIN trackers t ON t.id = x.tracker_id
+        WHERE t.carrier_tracking_code = $1
+    `, code).Scan(&open); err != nil || open != 1 {
+        t.Fatalf("expected a single exception record, got %d %v", open, err)
+    }
+
+    // A fresh scan keeps the shipment in exception until the detector resolves it
+    postEvent(time.Now())
+    if sh := getShipment(); sh.Status != "exception" {
+        t.Fatalf("expected exception until resolved, got %s", sh.Status)
+    }
+    if err := d.scanOnce(t.Context()); err != nil {
+        t.Fatalf("scan: %v", err)
+    }
+    sh = getShipment()
+    if sh.Status != "in_transit" || len(sh.Exceptions) != 0 {
+        t.Fatalf("expected resolved in_transit shipment, got status=%s exceptions=%+v", sh.Status, sh.Exceptions)
+    }
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "deliveryinfra/internal/jobs"
+    "deliveryinfra/internal/notify"
+    "deliveryinfra/internal/tracking"
+)
+
+// TrackingUpdateArgs identifies the tracker a tracking.update job refreshes.
+type TrackingUpdateArgs struct {
+    Carrier string `json:"carrier"`
+    Code    string `json:"code"`
+}
+
+// TrackingUpdateJob fetches a tracker's events from the tracking provider
+// and ingests them, outside the poller's schedule. It is enqueued for every
+// new tracker with a carrier (enqueueTrackingUpdate): registered with POST
+// /trackers or created for a shipment.
+var TrackingUpdateJob = jobs.Kind[TrackingUpdateArgs]{Name: "tracking.update"}
+
+// enqueueTrackingUpdate asks the worker to fetch a tracker's events now
+// rather than at the poller's next pass. The tracking code is the UniqueKey,
+// so concurrent requests collapse into one fetch. Nothing is enqueued without
+// a carrier, which the provider needs.
+func enqueueTrackingUpdate(ctx context.Context, q dbtx, carrier, code string) error {
+    if carrier == "" {
+        return nil
+    }
+    _, _, err := TrackingUpdateJob.Enqueue(ctx, q, TrackingUpdateArgs{Carrier: carrier, Code: code}, jobs.Options{UniqueKey: code})
+    return err
+}
+
+// RegisterJobHandlers registers the handlers of the server's job kinds:
+// tracking updates from provider, and notifications sent over channels.
+// Without a provider, tracking updates complete without fetching anything.
+func RegisterJobHandlers(w *jobs.Worker, db *pgxpool.Pool, provider tracking.Provider, channels []notify.Channel) {
+    s := &Server{db: db}
+    jobs.Handle(w, TrackingUpdateJob, func(ctx context.Context, _ jobs.Job, args TrackingUpdateArgs) error {
+        if provider == nil {
+            return nil
+        }
+        return s.runTrackingUpdate(ctx, provider, args)
+    })
+    sender := &notificationSender{db: db, channels: map[string]notify.Channel{}, now: time.Now}
+    for _, ch := range channels {
+        sender.channels[ch.Kind()] = ch
+    }
+    for event := range notifyEvents {
+        jobs.Handle(w, notifyJob(event), sender.send)
+    }
+}
+
+func (s *Server) runTrackingUpdate(ctx context.Context, provider tracking.Provider, args TrackingUpdateArgs) error {
+    carrier := strings.ToLower(strings.TrimSpace(args.Carrier))
+    code := strings.TrimSpace(args.Code)
+    if carrier == "" || code == "" {
+        return jobs.Permanent(errors.New("carrier and code required"))
+    }
+    events, err := provider.Track(ctx, carrier, code)
+    if err != nil {
+        return err
+    }
+    return s.ingestProviderEvents(ctx, carrier, code, events)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+    "context"
+    "log"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// advisoryLeader elects one replica for a background job by holding a Postgres
+// advisory lock. The lock is session-scoped, so it lives on a dedicated
+// connection and is released if that connection dies.
+type advisoryLeader struct {
+    db   *pgxpool.Pool
+    name string
+    conn *pgxpool.Conn
+}
+
+func newAdvisoryLeader(db *pgxpool.Pool, name string) *advisoryLeader {
+    return &advisoryLeader{db: db, name: name}
+}
+
+// ensure reports whether this replica holds the lock, trying to take it when not.
+func (l *advisoryLeader) ensure(ctx context.Context) (bool, error) {
+    if l.conn != nil {
+        if err := l.conn.Ping(ctx); err == nil {
+            return true, nil
+        }
+        log.Printf("%s: lost leader connection", l.name)
+        l.resign()
+    }
+    conn, err := l.db.Acquire(ctx)
+    if err != nil {
+        return false, err
+    }
+    var locked bool
+    if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, l.name).Scan(&locked); err != nil {
+        conn.Release()
+        return false, err
+    }
+    if !locked {
+        conn.Release()
+        return false, nil
+    }
+    l.conn = conn
+    log.Printf("%s: acquired leadership", l.name)
+    return true, nil
+}
+
+// resign closes the leader connection, which ends the session and releases
+// the advisory lock even if an unlock call would fail.
+func (l *advisoryLeader) resign() {
+    if l.conn == nil {
+        return
+    }
+    conn := l.conn.Hijack()
+    l.conn = nil
+    _ = conn.Close(context.Background())
+}
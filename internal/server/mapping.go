@@ -0,0 +1,338 @@
+package server
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// MappingSpec declares how to normalize a webhook source's payloads without
+// Go code. Field expressions are getPaths paths. A relative path is looked up
+// in the event, then its tracker, then the payload root; a "$." path only in
+// the root.
+//
+//	{"source": "aftership", "trackers": "msg", "events": "checkpoints[*]",
+//	 "code": "tracking_number", "status": "tag", "description": "message",
+//	 "occurred_at": "checkpoint_time", "time_formats": ["2006-01-02T15:04:05"],
+//	 "location": {"city": "city", "state": "state", "country": "country_iso3"},
+//	 "status_map": {"AttemptFail": "failure"}}
+type MappingSpec struct {
+    Source string `json:"source"`
+    // SecretEnv names the webhook signing secret's environment variable;
+    // defaults to <SOURCE>_WEBHOOK_SECRET.
+    SecretEnv string `json:"secret_env,omitempty"`
+    // Trackers selects the trackers in a batched payload (e.g. "data[*]");
+    // empty means the root is the only tracker.
+    Trackers string `json:"trackers,omitempty"`
+    // Events selects each tracker's events (e.g. "events[*]"); empty means
+    // the tracker itself is the only event.
+    Events      string          `json:"events,omitempty"`
+    Code        string          `json:"code"`
+    Status      string          `json:"status,omitempty"`
+    Description string          `json:"description,omitempty"`
+    OccurredAt  string          `json:"occurred_at,omitempty"`
+    Location    MappingLocation `json:"location"`
+    // TimeFormats are Go layouts tried after RFC3339, or "unix" / "unix_ms".
+    TimeFormats []string `json:"time_formats,omitempty"`
+    // Timezone is the IANA zone of timestamps without an offset (default UTC).
+    Timezone string `json:"timezone,omitempty"`
+    // StatusMap translates the provider's status values before the carrier
+    // mapping tables apply; matched exactly, then case-insensitively.
+    StatusMap map[string]string `json:"status_map,omitempty"`
+    // Verifier selects the signature scheme; default DefaultVerifier.
+    Verifier *VerifierSpec `json:"verifier,omitempty"`
+
+    loc      *time.Location
+    verifier WebhookVerifier
+}
+
+// MappingLocation is either a path to a location object or string, or an
+// object of location fields ("city", "country", ...) to paths.
+type MappingLocation struct {
+    Path   string
+    Fields map[string]string
+}
+
+func (l *MappingLocation) UnmarshalJSON(b []byte) error {
+    b = bytes.TrimSpace(b)
+    if len(b) > 0 && b[0] == '{' {
+        return json.Unmarshal(b, &l.Fields)
+    }
+    return json.Unmarshal(b, &l.Path)
+}
+
+func (l MappingLocation) MarshalJSON() ([]byte, error) {
+    if l.Fields != nil {
+        return json.Marshal(l.Fields)
+    }
+    return json.Marshal(l.Path)
+}
+
+var mappingSourceRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ParseMappingSpecs parses one spec object or a list of them and validates
+// each.
+func ParseMappingSpecs(data []byte) ([]MappingSpec, error) {
+    var specs []MappingSpec
+    if err := unmarshalOneOrMany(data, &specs); err != nil {
+        return nil, err
+    }
+    for i := range specs {
+        if err := specs[i].compile(); err != nil {
+            return nil, err
+        }
+    }
+    return specs, nil
+}
+
+// compile validates the spec and resolves its timezone.
+func (sp *MappingSpec) compile() error {
+    sp.Source = strings.ToLower(strings.TrimSpace(sp.Source))
+    if !mappingSourceRe.MatchString(sp.Source) {
+        return fmt.Errorf("mapping: invalid source %q", sp.Source)
+    }
+    if strings.TrimSpace(sp.Code) == "" {
+        return fmt.Errorf("mapping %s: code path required", sp.Source)
+    }
+    paths := []string{sp.Trackers, sp.Events, sp.Code, sp.Status, sp.Description, sp.OccurredAt, sp.Location.Path}
+    for _, p := range sp.Location.Fields {
+        paths = append(paths, p)
+    }
+    for _, p := range paths {
+        if _, err := parsePath(p); err != nil {
+            return fmt.Errorf("mapping %s: %w", sp.Source, err)
+        }
+    }
+    if sp.Verifier != nil {
+        v, err := NewVerifier(*sp.Verifier)
+        if err != nil {
+            return fmt.Errorf("mapping %s: %w", sp.Source, err)
+        }
+        sp.verifier = v
+    }
+    sp.loc = time.UTC
+    if tz := strings.TrimSpace(sp.Timezone); tz != "" {
+        loc, err := time.LoadLocation(tz)
+        if err != nil {
+            return fmt.Errorf("mapping %s: timezone: %w", sp.Source, err)
+        }
+        sp.loc = loc
+    }
+    return nil
+}
+
+func (sp *MappingSpec) secretEnv() string {
+    if sp.SecretEnv != "" {
+        return sp.SecretEnv
+    }
+    return strings.ToUpper(strings.ReplaceAll(sp.Source, "-", "_")) + "_WEBHOOK_SECRET"
+}
+
+var mappings = struct {
+    sync.RWMutex
+    bySource map[string]*MappingSpec
+}{bySource: map[string]*MappingSpec{}}
+
+// RegisterMappings makes the specs available to NewNormalizer and the webhook
+// endpoint, replacing earlier specs (and dedicated normalizers) of the same
+// source.
+func RegisterMappings(specs ...MappingSpec) {
+    mappings.Lock()
+    defer mappings.Unlock()
+    for i := range specs {
+        sp := specs[i]
+        mappings.bySource[sp.Source] = &sp
+    }
+}
+
+func mappingFor(source string) (*MappingSpec, bool) {
+    mappings.RLock()
+    defer mappings.RUnlock()
+    sp, ok := mappings.bySource[strings.ToLower(strings.TrimSpace(source))]
+    return sp, ok
+}
+
+// LoadMappingSpecs reads the enabled specs from webhook_mappings. The row's
+// source column names the spec.
+func LoadMappingSpecs(ctx context.Context, q dbtx) ([]MappingSpec, error) {
+    rows, err := q.Query(ctx, `SELECT source, spec FROM webhook_mappings WHERE enabled ORDER BY source`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var specs []MappingSpec
+    for rows.Next() {
+        var source string
+        var raw []byte
+        if err := rows.Scan(&source, &raw); err != nil {
+            return nil, err
+        }
+        var sp MappingSpec
+        if err := json.Unmarshal(raw, &sp); err != nil {
+            return nil, fmt.Errorf("mapping %s: %w", source, err)
+        }
+        sp.Source = source
+        if err := sp.compile(); err != nil {
+            return nil, err
+        }
+        specs = append(specs, sp)
+    }
+    return specs, rows.Err()
+}
+
+// MappingNormalizer normalizes payloads as declared by a MappingSpec.
+type MappingNormalizer struct {
+    Spec *MappingSpec
+}
+
+func (n *MappingNormalizer) Normalize(source string, body []byte) ([]WebhookEvent, error) {
+    sp := n.Spec
+    dec := json.NewDecoder(bytes.NewReader(body))
+    dec.UseNumber()
+    var root any
+    if err := dec.Decode(&root); err != nil {
+        return nil, err
+    }
+    trackers := []any{root}
+    if sp.Trackers != "" {
+        trackers = getPaths(root, sp.Trackers)
+    }
+    var out []WebhookEvent
+    for _, tr := range trackers {
+        events := []any{tr}
+        if sp.Events != "" {
+            events = getPaths(tr, sp.Events)
+        }
+        for _, ev := range events {
+            scopes := []any{ev, tr, root}
+            code := strings.TrimSpace(mappingString(scopes, sp.Code))
+            if code == "" {
+                return nil, ErrMissingCode
+            }
+            status := mappingString(scopes, sp.Status)
+            carrierStatus := status
+            if mapped, ok := sp.mapStatus(status); ok {
+                status = mapped
+            }
+            raw, err := json.Marshal(ev)
+            if err != nil {
+                return nil, err
+            }
+            out = append(out, WebhookEvent{Code: code, Event: TrackerEventRequest{
+                Status:        status,
+                CarrierStatus: carrierStatus,
+                Description:   mappingString(scopes, sp.Description),
+                Location:      sp.location(scopes),
+                OccurredAt:    sp.parseTime(mappingString(scopes, sp.OccurredAt)),
+                Raw:           raw,
+            }})
+        }
+    }
+    return out, nil
+}
+
+func (sp *MappingSpec) mapStatus(status string) (string, bool) {
+    if v, ok := sp.StatusMap[status]; ok {
+        return v, true
+    }
+    for k, v := range sp.StatusMap {
+        if strings.EqualFold(k, status) {
+            return v, true
+        }
+    }
+    return "", false
+}
+
+func (sp *MappingSpec) location(scopes []any) json.RawMessage {
+    loc := map[string]any{}
+    switch {
+    case sp.Location.Fields != nil:
+        for k, p := range sp.Location.Fields {
+            if s := strings.TrimSpace(mappingString(scopes, p)); s != "" {
+                loc[k] = s
+            }
+        }
+    case sp.Location.Path != "":
+        switch v := mappingValue(scopes, sp.Location.Path).(type) {
+        case map[string]any:
+            loc = v
+        case string:
+            if s := strings.TrimSpace(v); s != "" {
+                loc["name"] = s
+            }
+        }
+    }
+    return marshalLocation(loc)
+}
+
+// parseTime converts a timestamp to RFC3339 using the spec's formats and
+// timezone. Unrecognised values are returned as-is, like webhookTime.
+func (sp *MappingSpec) parseTime(s string) string {
+    s = strings.TrimSpace(s)
+    if s == "" {
+        return ""
+    }
+    if t, err := time.Parse(time.RFC3339, s); err == nil {
+        return t.UTC().Format(time.RFC3339)
+    }
+    loc := sp.loc
+    if loc == nil {
+        loc = time.UTC
+    }
+    for _, f := range sp.TimeFormats {
+        switch f {
+        case "unix", "unix_ms":
+            n, err := strconv.ParseInt(s, 10, 64)
+            if err != nil {
+                continue
+            }
+            t := time.Unix(n, 0)
+            if f == "unix_ms" {
+                t = time.UnixMilli(n)
+            }
+            return t.UTC().Format(time.RFC3339)
+        default:
+            if t, err := time.ParseInLocation(f, s, loc); err == nil {
+                return t.UTC().Format(time.RFC3339)
+            }
+        }
+    }
+    return s
+}
+
+// mappingValue resolves path in the first scope that has it; "$." paths only
+// in the root, the last scope.
+func mappingValue(scopes []any, path string) any {
+    if strings.TrimSpace(path) == "" {
+        return nil
+    }
+    if strings.HasPrefix(strings.TrimSpace(path), "$") {
+        scopes = scopes[len(scopes)-1:]
+    }
+    for _, sc := range scopes {
+        if vs := getPaths(sc, path); len(vs) > 0 {
+            return vs[0]
+        }
+    }
+    return nil
+}
+
+// mappingString resolves path to a string; numbers and booleans are
+// formatted, objects and arrays are ignored.
+func mappingString(scopes []any, path string) string {
+    switch v := mappingValue(scopes, path).(type) {
+    case string:
+        return v
+    case json.Number:
+        return v.String()
+    case bool:
+        return strconv.FormatBool(v)
+    }
+    return ""
+}
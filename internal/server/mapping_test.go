@@ -0,0 +1,124 @@
+package server
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+)
+
+func TestGetPaths(t *testing.T) {
+    var doc any
+    _ = json.Unmarshal([]byte(`{
+        "data": [
+            {"number": "A1", "events": [{"s": "a"}, {"s": "b"}]},
+            {"number": "B2", "events": [{"s": "c"}]}
+        ],
+        "by_id": {"y": {"n": 2}, "x": {"n": 1}}
+    }`), &doc)
+    cases := []struct {
+        path string
+        want []any
+    }{
+        {"data[0].number", []any{"A1"}},
+        {"$.data[-1].number", []any{"B2"}},
+        {"data[*].number", []any{"A1", "B2"}},
+        {"data[*].events[*].s", []any{"a", "b", "c"}},
+        {"data[0].events[1].s", []any{"b"}},
+        {"by_id.*.n", []any{float64(1), float64(2)}},
+        {"data[5].number", nil},
+        {"data.number", nil},
+        {"data[x]", nil},
+        {"data[0", nil},
+    }
+    for _, c := range cases {
+        if got := getPaths(doc, c.path); !reflect.DeepEqual(got, c.want) {
+            t.Errorf("getPaths(%q) = %v, want %v", c.path, got, c.want)
+        }
+    }
+    // getPath keeps returning the single value for plain dot paths
+    m := doc.(map[string]any)
+    if got := getPath(m, "data[1].events[0].s"); got != "c" {
+        t.Errorf("getPath = %v, want c", got)
+    }
+}
+
+func TestParseMappingSpecs_Validates(t *testing.T) {
+    for _, bad := range []string{
+        `{"source": "Bad Source", "code": "id"}`,
+        `{"source": "acme"}`,
+        `[{"source": "acme", "code": "id", "events": "items[*"}]`,
+        `{"source": "acme", "code": "id", "timezone": "Mars/Olympus"}`,
+    } {
+        if _, err := ParseMappingSpecs([]byte(bad)); err == nil {
+            t.Errorf("expected error for %s", bad)
+        }
+    }
+    specs, err := ParseMappingSpecs([]byte(`[{"source": " ACME ", "code": "id"}, {"source": "acme-post", "code": "id"}]`))
+    if err != nil || len(specs) != 2 || specs[0].Source != "acme" {
+        t.Fatalf("unexpected specs %+v %v", specs, err)
+    }
+    if env := specs[1].secretEnv(); env != "ACME_POST_WEBHOOK_SECRET" {
+        t.Errorf("secretEnv = %q", env)
+    }
+}
+
+func TestMappingNormalizer(t *testing.T) {
+    specs, err := ParseMappingSpecs([]byte(`{
+        "source": "acme",
+        "trackers": "parcels[*]",
+        "events": "history[*]",
+        "code": "ref",
+        "status": "state",
+        "description": "$.note",
+        "occurred_at": "ts",
+        "location": "place",
+        "time_formats": ["unix"],
+        "status_map": {"DLV": "delivered"}
+    }`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    n := &MappingNormalizer{Spec: &specs[0]}
+    events, err := n.Normalize("acme", []byte(`{
+        "note": "batch",
+        "parcels": [
+            {"ref": 12345678901234567890, "history": [{"state": "dlv", "ts": "1741000000", "place": "Osaka"}]},
+            {"ref": "X2", "history": [{"state": "moving", "ts": "2025-03-04T01:00:00+09:00", "place": {"city": "Kobe"}}]},
+            {"ref": "X3", "history": []}
+        ]
+    }`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(events) != 2 {
+        t.Fatalf("expected 2 events, got %+v", events)
+    }
+    first, second := events[0], events[1]
+    if first.Code != "12345678901234567890" || first.Event.Status != "delivered" || first.Event.CarrierStatus != "dlv" ||
+        first.Event.OccurredAt != "2025-03-03T11:06:40Z" || first.Event.Description != "batch" ||
+        string(first.Event.Location) != `{"name":"Osaka"}` {
+        t.Errorf("unexpected first event %+v", first)
+    }
+    if second.Code != "X2" || second.Event.Status != "moving" || second.Event.OccurredAt != "2025-03-03T16:00:00Z" ||
+        string(second.Event.Location) != `{"city":"Kobe"}` {
+        t.Errorf("unexpected second event %+v", second)
+    }
+
+    if _, err := n.Normalize("acme", []byte(`{"parcels": [{"history": [{"state": "x"}]}]}`)); err != ErrMissingCode {
+        t.Errorf("err = %v, want ErrMissingCode", err)
+    }
+}
+
+func TestNewNormalizer_PrefersRegisteredMapping(t *testing.T) {
+    specs, err := ParseMappingSpecs([]byte(`{"source": "itest-mapped", "code": "id"}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := NewNormalizer("itest-mapped").(*DefaultNormalizer); !ok {
+        t.Fatal("expected DefaultNormalizer before registration")
+    }
+    RegisterMappings(specs...)
+    if _, ok := NewNormalizer("ITEST-MAPPED").(*MappingNormalizer); !ok {
+        t.Fatal("expected MappingNormalizer after registration")
+    }
+}
@@ -3,33 +3,63 @@ package server
 import (
     "encoding/json"
     "errors"
+    "fmt"
+    "sort"
+    "strconv"
     "strings"
+    "time"
+
+    "deliveryinfra/internal/tracking"
 )
 
-// Normalizer maps provider-specific webhook payloads into TrackerEventRequest.
+// WebhookEvent is one tracker event extracted from a webhook payload.
+type WebhookEvent struct {
+    Code  string              `json:"code"`
+    Event TrackerEventRequest `json:"event"`
+}
+
+// Normalizer maps provider-specific webhook payloads into tracker events.
+// Providers batch several events, and sometimes several trackers, into one
+// delivery, so a payload yields zero or more events.
 type Normalizer interface {
-    Normalize(source string, body []byte) (code string, req TrackerEventRequest, err error)
+    Normalize(source string, body []byte) ([]WebhookEvent, error)
 }
 
 // ErrMissingCode is returned when a payload cannot produce a tracker code.
 var ErrMissingCode = errors.New("missing tracker code")
 
-// NewNormalizer selects a normalizer for the given source.
-// Currently returns DefaultNormalizer for all sources.
-func NewNormalizer(source string) Normalizer { return &DefaultNormalizer{} }
+// NewNormalizer selects a normalizer for the given source: a registered
+// MappingSpec, then a dedicated normalizer, then DefaultNormalizer.
+func NewNormalizer(source string) Normalizer {
+    if sp, ok := mappingFor(source); ok {
+        return &MappingNormalizer{Spec: sp}
+    }
+    switch strings.ToLower(strings.TrimSpace(source)) {
+    case "karrio":
+        return &KarrioNormalizer{}
+    case "17track":
+        return &Track17Normalizer{}
+    case "dhl":
+        return &DHLNormalizer{}
+    case "yamato":
+        return &YamatoNormalizer{}
+    default:
+        return &DefaultNormalizer{}
+    }
+}
 
 // DefaultNormalizer attempts to extract common fields from diverse payloads.
 type DefaultNormalizer struct{}
 
-func (n *DefaultNormalizer) Normalize(source string, body []byte) (string, TrackerEventRequest, error) {
+func (n *DefaultNormalizer) Normalize(source string, body []byte) ([]WebhookEvent, error) {
     var payload map[string]any
     if err := json.Unmarshal(body, &payload); err != nil {
-        return "", TrackerEventRequest{}, err
+        return nil, err
     }
     code := getString(payload, []string{"code", "tracking_number", "tracker_code", "tracking_code", "id"})
     code = strings.TrimSpace(code)
     if code == "" {
-        return "", TrackerEventRequest{}, ErrMissingCode
+        return nil, ErrMissingCode
     }
 
     status := getString(payload, []string{"status", "event.status", "tracking_status"})
@@ -51,7 +81,356 @@ func (n *DefaultNormalizer) Normalize(source string, body []byte) (string, Track
         OccurredAt:  occurredAt,
         Raw:         json.RawMessage(body),
     }
-    return code, req, nil
+    return []WebhookEvent{{Code: code, Event: req}}, nil
+}
+
+// KarrioNormalizer handles Karrio tracker webhooks (tracker.created and
+// tracker.updated). data is a tracker with its full event history, newest
+// first; a list of trackers is accepted as well. Payloads without data fall
+// back to DefaultNormalizer.
+type KarrioNormalizer struct{}
+
+type karrioTracker struct {
+    TrackingNumber string            `json:"tracking_number"`
+    Status         string            `json:"status"`
+    Events         []json.RawMessage `json:"events"`
+}
+
+type karrioEvent struct {
+    Code        string   `json:"code"`
+    Date        string   `json:"date"`
+    Time        string   `json:"time"`
+    Timestamp   string   `json:"timestamp"`
+    Description string   `json:"description"`
+    Location    string   `json:"location"`
+    Status      string   `json:"status"`
+    Latitude    *float64 `json:"latitude"`
+    Longitude   *float64 `json:"longitude"`
+}
+
+func (n *KarrioNormalizer) Normalize(source string, body []byte) ([]WebhookEvent, error) {
+    var payload struct {
+        Data json.RawMessage `json:"data"`
+    }
+    if err := json.Unmarshal(body, &payload); err != nil {
+        return nil, err
+    }
+    // Flat payloads from custom Karrio hooks lack the data envelope.
+    if len(payload.Data) == 0 {
+        return (&DefaultNormalizer{}).Normalize(source, body)
+    }
+    var trackers []karrioTracker
+    if err := unmarshalOneOrMany(payload.Data, &trackers); err != nil {
+        return nil, err
+    }
+    var out []WebhookEvent
+    for _, t := range trackers {
+        code := strings.TrimSpace(t.TrackingNumber)
+        if code == "" {
+            return nil, ErrMissingCode
+        }
+        for i, raw := range t.Events {
+            var ev karrioEvent
+            if err := json.Unmarshal(raw, &ev); err != nil {
+                return nil, err
+            }
+            // Older Karrio versions only report the tracker status, which
+            // describes the latest event.
+            status := ev.Status
+            if status == "" && i == 0 {
+                status = t.Status
+            }
+            occurred := ev.Timestamp
+            if occurred == "" && ev.Date != "" {
+                occurred = strings.TrimSpace(ev.Date + " " + ev.Time)
+            }
+            loc := map[string]any{}
+            if s := strings.TrimSpace(ev.Location); s != "" {
+                loc["name"] = s
+            }
+            if ev.Latitude != nil && ev.Longitude != nil {
+                loc["latitude"], loc["longitude"] = *ev.Latitude, *ev.Longitude
+            }
+            out = append(out, WebhookEvent{Code: code, Event: TrackerEventRequest{
+                Status:      status,
+                Description: ev.Description,
+                Location:    marshalLocation(loc),
+                OccurredAt:  webhookTime(occurred, time.UTC, "2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02 03:04 PM", "2006-01-02"),
+                Raw:         raw,
+            }})
+        }
+    }
+    return out, nil
+}
+
+// Track17Normalizer handles 17TRACK push notifications (TRACKING_UPDATED).
+// Events are collected from every provider of the number, since international
+// parcels are handed between carriers. data may also be a list of numbers.
+type Track17Normalizer struct{}
+
+type track17Number struct {
+    Number    string `json:"number"`
+    TrackInfo struct {
+        Tracking struct {
+            Providers []struct {
+                Events []json.RawMessage `json:"events"`
+            } `json:"providers"`
+        } `json:"tracking"`
+    } `json:"track_info"`
+}
+
+type track17Event struct {
+    TimeISO     string `json:"time_iso"`
+    TimeUTC     string `json:"time_utc"`
+    Description string `json:"description"`
+    Location    string `json:"location"`
+    Stage       string `json:"stage"`
+    SubStatus   string `json:"sub_status"`
+    Address     struct {
+        Country    string `json:"country"`
+        State      string `json:"state"`
+        City       string `json:"city"`
+        PostalCode string `json:"postal_code"`
+    } `json:"address"`
+}
+
+// track17Substatus maps the detail half of a 17TRACK sub_status to a substatus.
+var track17Substatus = map[string]string{
+    "PickedUp":                    "picked_up",
+    "Departure":                   "departed_facility",
+    "Arrival":                     "arrived_at_facility",
+    "CustomsProcessing":           "customs",
+    "CustomsRequiringInformation": "customs",
+    "NoBody":                      "delivery_attempted",
+    "Security":                    "delivery_attempted",
+}
+
+func (n *Track17Normalizer) Normalize(source string, body []byte) ([]WebhookEvent, error) {
+    var payload struct {
+        Event string          `json:"event"`
+        Data  json.RawMessage `json:"data"`
+    }
+    if err := json.Unmarshal(body, &payload); err != nil {
+        return nil, err
+    }
+    // TRACKING_STOPPED and other notices carry no events.
+    if payload.Event != "" && payload.Event != "TRACKING_UPDATED" {
+        return nil, nil
+    }
+    var numbers []track17Number
+    if err := unmarshalOneOrMany(payload.Data, &numbers); err != nil {
+        return nil, err
+    }
+    var out []WebhookEvent
+    for _, num := range numbers {
+        code := strings.TrimSpace(num.Number)
+        if code == "" {
+            return nil, ErrMissingCode
+        }
+        for _, p := range num.TrackInfo.Tracking.Providers {
+            for _, raw := range p.Events {
+                var ev track17Event
+                if err := json.Unmarshal(raw, &ev); err != nil {
+                    return nil, err
+                }
+                // sub_status is "<main status>_<detail>", e.g. "InTransit_PickedUp";
+                // the mapping table is keyed by the main status.
+                status, detail, _ := strings.Cut(ev.SubStatus, "_")
+                if status == "" {
+                    status = ev.Stage
+                }
+                loc := map[string]any{}
+                for k, v := range map[string]string{
+                    "country": ev.Address.Country, "state": ev.Address.State,
+                    "city": ev.Address.City, "postal_code": ev.Address.PostalCode,
+                } {
+                    if s := strings.TrimSpace(v); s != "" {
+                        loc[k] = s
+                    }
+                }
+                if s := strings.TrimSpace(ev.Location); s != "" {
+                    loc["name"] = s
+                }
+                occurred := ev.TimeUTC
+                if occurred == "" {
+                    occurred = ev.TimeISO
+                }
+                out = append(out, WebhookEvent{Code: code, Event: TrackerEventRequest{
+                    Status:        status,
+                    Substatus:     track17Substatus[detail],
+                    CarrierStatus: orDefault(ev.SubStatus, status),
+                    Description:   ev.Description,
+                    Location:      marshalLocation(loc),
+                    OccurredAt:    webhookTime(occurred, time.UTC, "2006-01-02T15:04:05", "2006-01-02 15:04:05"),
+                    Raw:           raw,
+                }})
+            }
+        }
+    }
+    return out, nil
+}
+
+// DHLNormalizer handles DHL Shipment Tracking - Unified push notifications,
+// which reuse the API's {"shipments": [...]} response shape.
+type DHLNormalizer struct{}
+
+type dhlShipment struct {
+    ID     string            `json:"id"`
+    Events []json.RawMessage `json:"events"`
+}
+
+type dhlEvent struct {
+    Timestamp   string `json:"timestamp"`
+    StatusCode  string `json:"statusCode"`
+    Status      string `json:"status"`
+    Description string `json:"description"`
+    Location    struct {
+        Address struct {
+            CountryCode     string `json:"countryCode"`
+            PostalCode      string `json:"postalCode"`
+            AddressLocality string `json:"addressLocality"`
+        } `json:"address"`
+    } `json:"location"`
+}
+
+func (n *DHLNormalizer) Normalize(source string, body []byte) ([]WebhookEvent, error) {
+    var payload struct {
+        Shipments []dhlShipment `json:"shipments"`
+    }
+    if err := json.Unmarshal(body, &payload); err != nil {
+        return nil, err
+    }
+    var out []WebhookEvent
+    for _, sh := range payload.Shipments {
+        code := strings.TrimSpace(sh.ID)
+        if code == "" {
+            return nil, ErrMissingCode
+        }
+        for _, raw := range sh.Events {
+            var ev dhlEvent
+            if err := json.Unmarshal(raw, &ev); err != nil {
+                return nil, err
+            }
+            loc := map[string]any{}
+            for k, v := range map[string]string{
+                "country": ev.Location.Address.CountryCode, "postal_code": ev.Location.Address.PostalCode,
+                "city": ev.Location.Address.AddressLocality,
+            } {
+                if s := strings.TrimSpace(v); s != "" {
+                    loc[k] = s
+                }
+            }
+            out = append(out, WebhookEvent{Code: code, Event: TrackerEventRequest{
+                Status:      ev.StatusCode,
+                Description: orDefault(ev.Description, ev.Status),
+                Location:    marshalLocation(loc),
+                OccurredAt:  webhookTime(ev.Timestamp, time.UTC, "2006-01-02T15:04:05"),
+                Raw:         raw,
+            }})
+        }
+    }
+    return out, nil
+}
+
+// YamatoNormalizer handles Yamato Transport status notifications: a list of
+// slips (送り状), each with its status history in Japanese and JST local time.
+type YamatoNormalizer struct{}
+
+type yamatoSlip struct {
+    SlipNo   string            `json:"slip_no"`
+    Statuses []json.RawMessage `json:"statuses"`
+}
+
+type yamatoStatus struct {
+    Status     string `json:"status"`
+    Date       string `json:"date"`
+    Time       string `json:"time"`
+    Branch     string `json:"branch"`
+    BranchCode string `json:"branch_code"`
+}
+
+func (n *YamatoNormalizer) Normalize(source string, body []byte) ([]WebhookEvent, error) {
+    var payload struct {
+        Notifications []yamatoSlip `json:"notifications"`
+    }
+    if err := json.Unmarshal(body, &payload); err != nil {
+        return nil, err
+    }
+    var out []WebhookEvent
+    for _, slip := range payload.Notifications {
+        // Slip numbers are printed as 1234-5678-9012.
+        code := tracking.NormalizeNumber(slip.SlipNo)
+        if code == "" {
+            return nil, ErrMissingCode
+        }
+        for _, raw := range slip.Statuses {
+            var ev yamatoStatus
+            if err := json.Unmarshal(raw, &ev); err != nil {
+                return nil, err
+            }
+            loc := map[string]any{"country": "JP"}
+            if s := strings.TrimSpace(ev.Branch); s != "" {
+                loc["name"] = s
+            }
+            if s := strings.TrimSpace(ev.BranchCode); s != "" {
+                loc["branch_code"] = s
+            }
+            out = append(out, WebhookEvent{Code: code, Event: TrackerEventRequest{
+                Status:      ev.Status,
+                Description: ev.Status,
+                Location:    marshalLocation(loc),
+                OccurredAt:  webhookTime(strings.TrimSpace(ev.Date+" "+ev.Time), jst, "2006/01/02 15:04", "2006/01/02 15:04:05", "2006/01/02"),
+                Raw:         raw,
+            }})
+        }
+    }
+    return out, nil
+}
+
+// unmarshalOneOrMany decodes raw as a list, or as a single object into a
+// one-element list.
+func unmarshalOneOrMany[T any](raw json.RawMessage, out *[]T) error {
+    raw = json.RawMessage(strings.TrimSpace(string(raw)))
+    if len(raw) == 0 || string(raw) == "null" {
+        return nil
+    }
+    if raw[0] == '[' {
+        return json.Unmarshal(raw, out)
+    }
+    var v T
+    if err := json.Unmarshal(raw, &v); err != nil {
+        return err
+    }
+    *out = append(*out, v)
+    return nil
+}
+
+// webhookTime converts a provider timestamp to RFC3339. Values already in
+// RFC3339 pass through; the layouts are tried in loc for carriers that send
+// local time. Unrecognised values are returned as-is so the handler rejects
+// them as an invalid occurred_at.
+func webhookTime(s string, loc *time.Location, layouts ...string) string {
+    s = strings.TrimSpace(s)
+    if s == "" {
+        return ""
+    }
+    if t, err := time.Parse(time.RFC3339, s); err == nil {
+        return t.UTC().Format(time.RFC3339)
+    }
+    for _, layout := range layouts {
+        if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+            return t.UTC().Format(time.RFC3339)
+        }
+    }
+    return s
+}
+
+func marshalLocation(loc map[string]any) json.RawMessage {
+    b, err := json.Marshal(loc)
+    if err != nil {
+        return json.RawMessage("{}")
+    }
+    return b
 }
 
 // getString returns the first non-empty string from the candidate keys.
@@ -77,20 +456,123 @@ func getAny(m map[string]any, keys []string) any {
     return nil
 }
 
-// getPath navigates a dot-separated key into nested maps.
+// getPath returns the first value matched by path (see getPaths), or nil.
 func getPath(m map[string]any, path string) any {
-    parts := strings.Split(path, ".")
-    var cur any = m
-    for _, p := range parts {
-        mm, ok := cur.(map[string]any)
-        if !ok {
-            return nil
+    if vs := getPaths(m, path); len(vs) > 0 {
+        return vs[0]
+    }
+    return nil
+}
+
+// getPaths navigates a dot-separated path into nested maps and arrays and
+// returns every value it matches. Segments may carry array indices
+// ("events[0]", "events[-1]" for the last) and wildcards: "[*]" iterates an
+// array and a "*" segment iterates a map's values in key order. A leading
+// "$." is ignored. Missing keys and malformed paths match nothing.
+func getPaths(v any, path string) []any {
+    steps, err := parsePath(path)
+    if err != nil {
+        return nil
+    }
+    cur := []any{v}
+    for _, st := range steps {
+        var next []any
+        for _, c := range cur {
+            next = append(next, st.apply(c)...)
         }
-        v, ok := mm[p]
-        if !ok {
+        if len(next) == 0 {
             return nil
         }
-        cur = v
+        cur = next
     }
     return cur
-}
\ No newline at end of file
+}
+
+type pathStep struct {
+    key      string
+    index    int
+    isIndex  bool
+    wildcard bool
+}
+
+func (st pathStep) apply(v any) []any {
+    switch {
+    case st.wildcard:
+        switch c := v.(type) {
+        case []any:
+            return c
+        case map[string]any:
+            keys := make([]string, 0, len(c))
+            for k := range c {
+                keys = append(keys, k)
+            }
+            sort.Strings(keys)
+            out := make([]any, 0, len(keys))
+            for _, k := range keys {
+                out = append(out, c[k])
+            }
+            return out
+        }
+    case st.isIndex:
+        if a, ok := v.([]any); ok {
+            i := st.index
+            if i < 0 {
+                i += len(a)
+            }
+            if i >= 0 && i < len(a) {
+                return []any{a[i]}
+            }
+        }
+    default:
+        if m, ok := v.(map[string]any); ok {
+            if x, ok := m[st.key]; ok && x != nil {
+                return []any{x}
+            }
+        }
+    }
+    return nil
+}
+
+// parsePath splits a getPaths expression into steps.
+func parsePath(path string) ([]pathStep, error) {
+    path = strings.TrimSpace(path)
+    path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+    if path == "" {
+        return nil, nil
+    }
+    var steps []pathStep
+    for _, seg := range strings.Split(path, ".") {
+        key, rest, _ := strings.Cut(seg, "[")
+        switch {
+        case key == "*":
+            steps = append(steps, pathStep{wildcard: true})
+        case key != "":
+            steps = append(steps, pathStep{key: key})
+        case rest == "":
+            return nil, fmt.Errorf("empty segment in path %q", path)
+        }
+        for rest != "" {
+            idx, after, ok := strings.Cut(rest, "]")
+            if !ok {
+                return nil, fmt.Errorf("unclosed [ in path %q", path)
+            }
+            if idx == "*" {
+                steps = append(steps, pathStep{wildcard: true})
+            } else {
+                n, err := strconv.Atoi(idx)
+                if err != nil {
+                    return nil, fmt.Errorf("invalid index %q in path %q", idx, path)
+                }
+                steps = append(steps, pathStep{index: n, isIndex: true})
+            }
+            if after == "" {
+                break
+            }
+            if after[0] != '[' {
+                return nil, fmt.Errorf("unexpected %q after ] in path %q", after, path)
+            }
+            rest = after[1:]
+        }
+    }
+    return steps, nil
+}
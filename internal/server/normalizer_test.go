@@ -0,0 +1,109 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "flag"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// TestNormalizersGolden runs each testdata/normalizers/<source>_<name>.json
+// payload through the source's normalizer and the status mapping, and compares
+// the events with <source>_<name>.golden. Run with -update to regenerate.
+func TestNormalizersGolden(t *testing.T) {
+    // Sources without a dedicated normalizer are declared in testdata/mappings
+    specFiles, _ := filepath.Glob(filepath.Join("testdata", "mappings", "*.json"))
+    for _, path := range specFiles {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        specs, err := ParseMappingSpecs(data)
+        if err != nil {
+            t.Fatalf("%s: %v", path, err)
+        }
+        RegisterMappings(specs...)
+    }
+    payloads, err := filepath.Glob(filepath.Join("testdata", "normalizers", "*.json"))
+    if err != nil || len(payloads) == 0 {
+        t.Fatalf("no payload samples: %v", err)
+    }
+    for _, path := range payloads {
+        name := strings.TrimSuffix(filepath.Base(path), ".json")
+        source, _, _ := strings.Cut(name, "_")
+        t.Run(name, func(t *testing.T) {
+            body, err := os.ReadFile(path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            events, err := NewNormalizer(source).Normalize(source, body)
+            if err != nil {
+                t.Fatalf("Normalize: %v", err)
+            }
+            for i := range events {
+                events[i].Event = normalizeTrackerEvent(source, events[i].Event)
+            }
+            got, err := json.MarshalIndent(events, "", "  ")
+            if err != nil {
+                t.Fatal(err)
+            }
+            got = append(got, '\n')
+            golden := strings.TrimSuffix(path, ".json") + ".golden"
+            if *updateGolden {
+                if err := os.WriteFile(golden, got, 0o644); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            want, err := os.ReadFile(golden)
+            if err != nil {
+                t.Fatalf("read golden (run with -update to create): %v", err)
+            }
+            if !bytes.Equal(got, want) {
+                t.Errorf("events differ from %s:\n%s", golden, got)
+            }
+        })
+    }
+}
+
+func TestNormalizersRejectMissingCode(t *testing.T) {
+    cases := map[string]string{
+        "karrio":  `{"data": {"status": "in_transit", "events": []}}`,
+        "17track": `{"event": "TRACKING_UPDATED", "data": {"track_info": {}}}`,
+        "dhl":     `{"shipments": [{"events": []}]}`,
+        "yamato":  `{"notifications": [{"slip_no": " ", "statuses": []}]}`,
+        "dummy":   `{"status": "in_transit"}`,
+    }
+    for source, body := range cases {
+        if _, err := NewNormalizer(source).Normalize(source, []byte(body)); err != ErrMissingCode {
+            t.Errorf("%s: err = %v, want ErrMissingCode", source, err)
+        }
+    }
+}
+
+func TestTrack17IgnoresStoppedNotice(t *testing.T) {
+    events, err := NewNormalizer("17track").Normalize("17track", []byte(`{"event": "TRACKING_STOPPED", "data": {"number": "RR123456785CN"}}`))
+    if err != nil || len(events) != 0 {
+        t.Fatalf("expected no events, got %+v %v", events, err)
+    }
+}
+
+func TestWebhookTime(t *testing.T) {
+    cases := []struct {
+        in, want string
+    }{
+        {"2025-03-04T09:15:00+01:00", "2025-03-04T08:15:00Z"},
+        {"2025/03/04 08:40", "2025-03-03T23:40:00Z"},
+        {"", ""},
+        {"yesterday", "yesterday"},
+    }
+    for _, c := range cases {
+        if got := webhookTime(c.in, jst, "2006/01/02 15:04"); got != c.want {
+            t.Errorf("webhookTime(%q) = %q, want %q", c.in, got, c.want)
+        }
+    }
+}
@@ -0,0 +1,434 @@
+package server
+
+import (
+    "context"
+    "embed"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/mail"
+    "net/url"
+    "strings"
+    "text/template"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "deliveryinfra/internal/jobs"
+    "deliveryinfra/internal/notify"
+    "deliveryinfra/internal/tracking"
+)
+
+//go:embed templates/notifications.txt
+var notificationTemplateFS embed.FS
+
+var notificationTemplates = template.Must(template.ParseFS(notificationTemplateFS, "templates/notifications.txt"))
+
+// Notification statuses. Pending notifications have a notify job queued;
+// failed ones were rejected or ran out of attempts.
+const (
+    notificationStatusPending = "pending"
+    notificationStatusSent    = "sent"
+    notificationStatusFailed  = "failed"
+)
+
+// notifyEvents are the outbox types customers are notified of.
+var notifyEvents = map[string]bool{
+    eventShipmentCreated:         true,
+    outboxShipmentOutForDelivery: true,
+    eventShipmentDelivered:       true,
+    outboxShipmentException:      true,
+}
+
+var carrierDisplayNames = map[string]string{
+    "yamato":    "ヤマト運輸",
+    "japanpost": "日本郵便",
+    "sagawa":    "佐川急便",
+    "dhl":       "DHL",
+}
+
+var exceptionReasonTexts = map[tracking.ExceptionReason]string{
+    tracking.ReasonStalled:          "配送状況の更新が止まっています。",
+    tracking.ReasonDeliveryFailures: "ご不在などによりお届けできませんでした。",
+    tracking.ReasonReturnToSender:   "荷物が差出人へ返送されています。",
+    tracking.ReasonETABreached:      "お届け予定日を過ぎています。",
+}
+
+// NotifyArgs identifies the notification a notify job sends.
+type NotifyArgs struct {
+    NotificationID uuid.UUID `json:"notification_id"`
+}
+
+// notifyJob is the job kind sending notifications of an event, e.g.
+// "notify:shipment.created".
+func notifyJob(event string) jobs.Kind[NotifyArgs] {
+    return jobs.Kind[NotifyArgs]{Name: "notify:" + event}
+}
+
+type Notification struct {
+    ID                string `json:"id"`
+    Event             string `json:"event"`
+    Channel           string `json:"channel"`
+    Recipient         string `json:"recipient"`
+    Status            string `json:"status"`
+    Provider          string `json:"provider,omitempty"`
+    ProviderMessageID string `json:"provider_message_id,omitempty"`
+    Attempts          int    `json:"attempts"`
+    LastError         string `json:"last_error,omitempty"`
+    CreatedAt         string `json:"created_at"`
+    SentAt            string `json:"sent_at,omitempty"`
+}
+
+// notificationData fills the notification templates.
+type notificationData struct {
+    OrgName      string
+    Name         string
+    TrackingCode string
+    Carrier      string
+    TrackingURL  string
+    Reason       string
+}
+
+// NotificationConsumer turns shipment events into notifications: for every
+// enabled channel with a recipient on the shipment it renders the message,
+// records it in notifications and queues a notify job in the relay's
+// transaction. Recipients come from ship_to ("email", "phone", "line_user_id")
+// with the order's customer email as fallback; shipments with metadata
+// "notifications": false are skipped.
+type NotificationConsumer struct {
+    channels      map[string]bool
+    publicBaseURL string
+}
+
+// NewNotificationConsumer creates a consumer for the given channel kinds.
+// With publicBaseURL set, messages link to the public tracking page.
+func NewNotificationConsumer(kinds []string, publicBaseURL string) *NotificationConsumer {
+    c := &NotificationConsumer{channels: map[string]bool{}, publicBaseURL: strings.TrimRight(publicBaseURL, "/")}
+    for _, k := range kinds {
+        c.channels[k] = true
+    }
+    return c
+}
+
+func (c *NotificationConsumer) Name() string { return "notifications" }
+
+func (c *NotificationConsumer) Handle(ctx context.Context, tx pgx.Tx, m OutboxMessage) error {
+    if m.OrgID == nil || !notifyEvents[m.Type] || len(c.channels) == 0 {
+        return nil
+    }
+    var payload struct {
+        Shipment  ShipmentResponse   `json:"shipment"`
+        Exception *ShipmentException `json:"exception"`
+    }
+    if err := json.Unmarshal(m.Payload, &payload); err != nil {
+        return err
+    }
+    sh := payload.Shipment
+    var meta struct {
+        Notifications *bool `json:"notifications"`
+    }
+    _ = json.Unmarshal(sh.Metadata, &meta)
+    if meta.Notifications != nil && !*meta.Notifications {
+        return nil
+    }
+
+    var orgName, orgSlug, customerEmail string
+    err := tx.QueryRow(ctx, `
+        SELECT o.name, o.slug::text, COALESCE(ord.customer_email::text, '')
+        FROM shipments s
+        JOIN orgs o ON o.id = s.org_id
+        LEFT JOIN orders ord ON ord.id = s.order_id
+        WHERE s.id = $1
+    `, m.AggregateID).Scan(&orgName, &orgSlug, &customerEmail)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            // shipment deleted since
+            return nil
+        }
+        return err
+    }
+
+    data := notificationData{OrgName: orgName}
+    if len(sh.Trackers) > 0 {
+        tr := sh.Trackers[0]
+        data.TrackingCode = tr.Code
+        data.Carrier = carrierDisplayName(tr.CarrierCode)
+        if c.publicBaseURL != "" {
+            data.TrackingURL = c.publicBaseURL + "/track/" + url.PathEscape(orgSlug) + "/" + url.PathEscape(tr.Code)
+        }
+    }
+    if payload.Exception != nil {
+        data.Reason = exceptionReasonText(payload.Exception.Reason)
+    }
+    recipients := notificationRecipients(sh.ShipTo, customerEmail)
+    data.Name = recipients.Name
+
+    for _, kind := range []string{notify.KindEmail, notify.KindSMS, notify.KindLINE} {
+        to := recipients.address(kind)
+        if !c.channels[kind] || to == "" {
+            continue
+        }
+        subject, body, err := renderNotification(m.Type, kind, data)
+        if err != nil {
+            return err
+        }
+        var id uuid.UUID
+        err = tx.QueryRow(ctx, `
+            INSERT INTO notifications (org_id, shipment_id, kind, endpoint, event, event_id, subject, body, status)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'pending')
+            ON CONFLICT (event_id, kind, endpoint) WHERE event_id IS NOT NULL DO NOTHING
+            RETURNING id
+        `, m.OrgID, m.AggregateID, kind, to, m.Type, m.EventID, nullIfEmpty(subject), body).Scan(&id)
+        if errors.Is(err, pgx.ErrNoRows) {
+            // already recorded by an earlier relay of this message
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        if _, _, err := notifyJob(m.Type).Enqueue(ctx, tx, NotifyArgs{NotificationID: id}, jobs.Options{}); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type shipmentRecipients struct {
+    Name, Email, Phone, LINEUserID string
+}
+
+func (r shipmentRecipients) address(kind string) string {
+    switch kind {
+    case notify.KindEmail:
+        return r.Email
+    case notify.KindSMS:
+        return r.Phone
+    case notify.KindLINE:
+        return r.LINEUserID
+    }
+    return ""
+}
+
+// notificationRecipients reads the recipient's addresses from ship_to.
+// Invalid emails and phone numbers are dropped.
+func notificationRecipients(shipTo json.RawMessage, customerEmail string) shipmentRecipients {
+    var to struct {
+        Name       string `json:"name"`
+        Email      string `json:"email"`
+        Phone      string `json:"phone"`
+        Country    string `json:"country"`
+        LINEUserID string `json:"line_user_id"`
+    }
+    _ = json.Unmarshal(shipTo, &to)
+    r := shipmentRecipients{
+        Name:       strings.TrimSpace(to.Name),
+        Phone:      e164Phone(to.Phone, to.Country),
+        LINEUserID: strings.TrimSpace(to.LINEUserID),
+    }
+    for _, email := range []string{to.Email, customerEmail} {
+        if a, err := mail.ParseAddress(strings.TrimSpace(email)); err == nil {
+            r.Email = a.Address
+            break
+        }
+    }
+    return r
+}
+
+// e164Phone normalizes a phone number to E.164. National numbers ("090-1234-
+// 5678") are only converted for Japan, the default country; anything else
+// unrecognised yields "".
+func e164Phone(phone, country string) string {
+    var digits strings.Builder
+    for i, r := range strings.TrimSpace(phone) {
+        switch {
+        case r >= '0' && r <= '9':
+            digits.WriteRune(r)
+        case r >= '０' && r <= '９':
+            digits.WriteRune('0' + (r - '０'))
+        case r == '+' && i == 0:
+            digits.WriteRune(r)
+        case r == '-' || r == ' ' || r == '(' || r == ')' || r == '.' || r == 'ー' || r == '－':
+        default:
+            return ""
+        }
+    }
+    n := digits.String()
+    switch {
+    case strings.HasPrefix(n, "+"):
+    case strings.HasPrefix(n, "00"):
+        n = "+" + n[2:]
+    case strings.HasPrefix(n, "0") && (country == "" || strings.EqualFold(country, "JP")):
+        n = "+81" + n[1:]
+    default:
+        return ""
+    }
+    if len(n) < 9 || len(n) > 16 {
+        return ""
+    }
+    return n
+}
+
+func carrierDisplayName(code string) string {
+    if name, ok := carrierDisplayNames[code]; ok {
+        return name
+    }
+    return strings.ToUpper(code)
+}
+
+func exceptionReasonText(reason string) string {
+    if text, ok := exceptionReasonTexts[tracking.ExceptionReason(reason)]; ok {
+        return text
+    }
+    return "配送に問題が発生しています。"
+}
+
+// renderNotification renders the message of an event for a channel: subject
+// and body for email, the short text for SMS and LINE.
+func renderNotification(event, kind string, data notificationData) (subject, body string, err error) {
+    render := func(name string) (string, error) {
+        var b strings.Builder
+        if err := notificationTemplates.ExecuteTemplate(&b, event+"."+name, data); err != nil {
+            return "", err
+        }
+        return strings.TrimSpace(b.String()), nil
+    }
+    if kind != notify.KindEmail {
+        body, err = render("short")
+        return "", body, err
+    }
+    if subject, err = render("subject"); err != nil {
+        return "", "", err
+    }
+    body, err = render("body")
+    return subject, body, err
+}
+
+// notificationSender sends queued notifications over the configured channels.
+type notificationSender struct {
+    db       *pgxpool.Pool
+    channels map[string]notify.Channel
+    now      func() time.Time
+}
+
+// send delivers one notification. Sent and failed notifications are left
+// alone, so a repeated job does not send twice; a notification whose channel
+// is not configured on this worker fails for good.
+func (s *notificationSender) send(ctx context.Context, job jobs.Job, args NotifyArgs) error {
+    var (
+        kind, to, status string
+        subject          *string
+        body             string
+    )
+    err := s.db.QueryRow(ctx, `
+        SELECT kind, endpoint, status, subject, COALESCE(body, '') FROM notifications WHERE id = $1
+    `, args.NotificationID).Scan(&kind, &to, &status, &subject, &body)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return jobs.Permanent(fmt.Errorf("notification %s not found", args.NotificationID))
+        }
+        return err
+    }
+    if status != notificationStatusPending {
+        return nil
+    }
+    ch, ok := s.channels[kind]
+    var (
+        messageID string
+        sendErr   error
+    )
+    if !ok {
+        sendErr = jobs.Permanent(fmt.Errorf("channel %q not configured", kind))
+    } else {
+        m := notify.Message{ID: args.NotificationID.String(), To: to, Body: body}
+        if subject != nil {
+            m.Subject = *subject
+        }
+        messageID, sendErr = ch.Send(ctx, m)
+        if errors.Is(sendErr, notify.ErrRejected) {
+            sendErr = jobs.Permanent(sendErr)
+        }
+    }
+
+    var provider *string
+    if ok {
+        p := ch.Provider()
+        provider = &p
+    }
+    if sendErr == nil {
+        _, err = s.db.Exec(ctx, `
+            UPDATE notifications
+            SET status = 'sent', provider = $2, provider_message_id = $3, attempts = attempts + 1,
+                last_error = NULL, sent_at = $4, updated_at = NOW()
+            WHERE id = $1
+        `, args.NotificationID, provider, nullIfEmpty(messageID), s.now())
+        return err
+    }
+    status = notificationStatusPending
+    if jobs.IsPermanent(sendErr) || job.Attempt >= job.MaxAttempts {
+        status = notificationStatusFailed
+    }
+    _, err = s.db.Exec(ctx, `
+        UPDATE notifications
+        SET status = $2, provider = $3, attempts = attempts + 1, last_error = $4, updated_at = NOW()
+        WHERE id = $1
+    `, args.NotificationID, status, provider, sendErr.Error())
+    if err != nil {
+        return errors.Join(sendErr, err)
+    }
+    return sendErr
+}
+
+func (s *Server) handleListShipmentNotifications(w http.ResponseWriter, r *http.Request) {
+    shipmentID, ok := parseShipmentID(w, r)
+    if !ok {
+        return
+    }
+    ctx := r.Context()
+    var exists bool
+    if err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM shipments WHERE id = $1)`, shipmentID).Scan(&exists); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if !exists {
+        writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "shipment not found")
+        return
+    }
+    rows, err := s.db.Query(ctx, `
+        SELECT id, COALESCE(event, ''), kind, endpoint, status, COALESCE(provider, ''),
+               COALESCE(provider_message_id, ''), attempts, COALESCE(last_error, ''), created_at, sent_at
+        FROM notifications
+        WHERE shipment_id = $1
+        ORDER BY created_at, kind
+    `, shipmentID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer rows.Close()
+    out := []Notification{}
+    for rows.Next() {
+        var (
+            n         Notification
+            id        uuid.UUID
+            createdAt time.Time
+            sentAt    *time.Time
+        )
+        if err := rows.Scan(&id, &n.Event, &n.Channel, &n.Recipient, &n.Status, &n.Provider,
+            &n.ProviderMessageID, &n.Attempts, &n.LastError, &createdAt, &sentAt); err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+            return
+        }
+        n.ID = id.String()
+        n.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+        n.SentAt = formatOptionalTime(sentAt)
+        out = append(out, n)
+    }
+    if err := rows.Err(); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]any{"notifications": out})
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+
+    "deliveryinfra/internal/db"
+    "deliveryinfra/internal/jobs"
+    "deliveryinfra/internal/notify"
+)
+
+// recordingChannel is a notify.Channel stand-in that records messages and
+// fails with err when set.
+type recordingChannel struct {
+    kind string
+    mu   sync.Mutex
+    sent []notify.Message
+    err  error
+}
+
+func (c *recordingChannel) Kind() string     { return c.kind }
+func (c *recordingChannel) Provider() string { return "test-" + c.kind }
+
+func (c *recordingChannel) Send(ctx context.Context, m notify.Message) (string, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.err != nil {
+        return "", c.err
+    }
+    c.sent = append(c.sent, m)
+    return fmt.Sprintf("%s-msg-%d", c.kind, len(c.sent)), nil
+}
+
+func TestNotifications_ShipmentCreated(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+    ctx := t.Context()
+
+    slug := fmt.Sprintf("ntf-%d", time.Now().UnixNano())
+    if _, err := pool.Exec(ctx, `INSERT INTO orgs (slug, name) VALUES ($1, 'ネコ商店')`, slug); err != nil {
+        t.Fatalf("create org: %v", err)
+    }
+    code := fmt.Sprintf("NTF%d", time.Now().UnixNano())
+    b, _ := json.Marshal(map[string]any{
+        "org_slug":        slug,
+        "carrier_code":    "yamato",
+        "tracking_number": code,
+        "ship_to": map[string]any{
+            "name": "山田 花子", "email": "hana@example.com", "phone": "090-1234-5678", "country": "JP", "line_user_id": "U1234",
+        },
+        "ship_from": map[string]any{"country": "JP"},
+        "package":   map[string]any{"weight_oz": 5},
+    })
+    rr := httptest.NewRecorder()
+    h := New(pool)
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/shipments", bytes.NewReader(b)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("create shipment: %d %s", rr.Code, rr.Body.String())
+    }
+    var created ShipmentCreateResponse
+    _ = json.Unmarshal(rr.Body.Bytes(), &created)
+
+    // Email and LINE are enabled; SMS is not, so no SMS is recorded
+    consumer := NewNotificationConsumer([]string{notify.KindEmail, notify.KindLINE}, "https://track.example")
+    relay := NewOutboxRelay(pool, OutboxRelayConfig{}, consumer)
+    if _, err := relay.PublishPending(ctx); err != nil {
+        t.Fatalf("publish outbox: %v", err)
+    }
+
+    rows, err := pool.Query(ctx, `
+        SELECT n.id, n.kind, n.endpoint, n.status, j.id
+        FROM notifications n
+        JOIN jobs j ON j.kind = 'notify:shipment.created' AND j.payload->>'notification_id' = n.id::text
+        WHERE n.shipment_id = $1 AND n.event = 'shipment.created'
+        ORDER BY n.kind
+    `, created.ShipmentID)
+    if err != nil {
+        t.Fatalf("load notifications: %v", err)
+    }
+    type queued struct {
+        ID, JobID        uuid.UUID
+        Kind, To, Status string
+    }
+    var got []queued
+    for rows.Next() {
+        var q queued
+        if err := rows.Scan(&q.ID, &q.Kind, &q.To, &q.Status, &q.JobID); err != nil {
+            t.Fatalf("scan: %v", err)
+        }
+        got = append(got, q)
+    }
+    rows.Close()
+    if len(got) != 2 || got[0].Kind != "email" || got[0].To != "hana@example.com" || got[1].Kind != "line" || got[1].To != "U1234" {
+        t.Fatalf("unexpected notifications %+v", got)
+    }
+
+    email := &recordingChannel{kind: notify.KindEmail}
+    line := &recordingChannel{kind: notify.KindLINE, err: fmt.Errorf("%w: invalid user", notify.ErrRejected)}
+    sender := &notificationSender{db: pool, channels: map[string]notify.Channel{"email": email, "line": line}, now: time.Now}
+    job := jobs.Job{Attempt: 1, MaxAttempts: jobs.DefaultMaxAttempts}
+    if err := sender.send(ctx, job, NotifyArgs{NotificationID: got[0].ID}); err != nil {
+        t.Fatalf("send email: %v", err)
+    }
+    if err := sender.send(ctx, job, NotifyArgs{NotificationID: got[1].ID}); !jobs.IsPermanent(err) {
+        t.Fatalf("rejected LINE push should be permanent, got %v", err)
+    }
+    // A repeated job does not send again
+    if err := sender.send(ctx, job, NotifyArgs{NotificationID: got[0].ID}); err != nil || len(email.sent) != 1 {
+        t.Fatalf("resend: %v, %d sent", err, len(email.sent))
+    }
+    m := email.sent[0]
+    if m.ID != got[0].ID.String() || m.Subject != "【ネコ商店】商品を発送しました" {
+        t.Fatalf("unexpected email %+v", m)
+    }
+
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/shipments/"+created.ShipmentID+"/notifications", nil))
+    var list struct {
+        Notifications []Notification `json:"notifications"`
+    }
+    if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || rr.Code != http.StatusOK || len(list.Notifications) != 2 {
+        t.Fatalf("list notifications: %d %s", rr.Code, rr.Body.String())
+    }
+    for _, n := range list.Notifications {
+        switch n.Channel {
+        case "email":
+            if n.Status != "sent" || n.Provider != "test-email" || n.ProviderMessageID != "email-msg-1" || n.SentAt == "" || n.Attempts != 1 {
+                t.Errorf("email notification %+v", n)
+            }
+        case "line":
+            if n.Status != "failed" || n.LastError == "" || n.Attempts != 1 {
+                t.Errorf("line notification %+v", n)
+            }
+        }
+    }
+
+    // Relaying the same event again records nothing new
+    if _, err := pool.Exec(ctx, `UPDATE outbox SET status = 'pending' WHERE aggregate_id = $1`, created.ShipmentID); err != nil {
+        t.Fatalf("reset outbox: %v", err)
+    }
+    if _, err := relay.PublishPending(ctx); err != nil {
+        t.Fatalf("publish outbox: %v", err)
+    }
+    var count int
+    _ = pool.QueryRow(ctx, `SELECT COUNT(*) FROM notifications WHERE shipment_id = $1`, created.ShipmentID).Scan(&count)
+    if count != 2 {
+        t.Fatalf("expected 2 notifications after redelivery, got %d", count)
+    }
+}
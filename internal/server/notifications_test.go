@@ -0,0 +1,74 @@
+package server
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+
+    "deliveryinfra/internal/notify"
+)
+
+func TestE164Phone(t *testing.T) {
+    cases := []struct{ phone, country, want string }{
+        {"090-1234-5678", "JP", "+819012345678"},
+        {"０９０ー１２３４ー５６７８", "", "+819012345678"},
+        {"(03) 1234-5678", "jp", "+81312345678"},
+        {"+1 415 555 0100", "US", "+14155550100"},
+        {"0044 20 7946 0958", "GB", "+442079460958"},
+        {"020 7946 0958", "GB", ""},
+        {"090-1234-5678 ext 2", "JP", ""},
+        {"123", "JP", ""},
+        {"", "JP", ""},
+    }
+    for _, c := range cases {
+        if got := e164Phone(c.phone, c.country); got != c.want {
+            t.Errorf("e164Phone(%q, %q) = %q, want %q", c.phone, c.country, got, c.want)
+        }
+    }
+}
+
+func TestNotificationRecipients(t *testing.T) {
+    r := notificationRecipients(json.RawMessage(`{"name":"山田 花子","email":"not-an-email","phone":"090-1234-5678","country":"JP","line_user_id":" U1234 "}`), "Hana <hana@example.com>")
+    if r.Name != "山田 花子" || r.Email != "hana@example.com" || r.Phone != "+819012345678" || r.LINEUserID != "U1234" {
+        t.Fatalf("unexpected recipients %+v", r)
+    }
+    if r.address(notify.KindEmail) != r.Email || r.address(notify.KindSMS) != r.Phone || r.address(notify.KindLINE) != r.LINEUserID {
+        t.Fatalf("address by kind mismatch")
+    }
+    if r := notificationRecipients(json.RawMessage(`{}`), ""); r != (shipmentRecipients{}) {
+        t.Fatalf("expected no recipients, got %+v", r)
+    }
+}
+
+func TestRenderNotification(t *testing.T) {
+    data := notificationData{
+        OrgName:      "ネコ商店",
+        Name:         "山田 花子",
+        TrackingCode: "123456789012",
+        Carrier:      carrierDisplayName("yamato"),
+        TrackingURL:  "https://track.example/track/neko/123456789012",
+        Reason:       exceptionReasonText("delivery_failures"),
+    }
+    for event := range notifyEvents {
+        subject, body, err := renderNotification(event, notify.KindEmail, data)
+        if err != nil {
+            t.Fatalf("%s email: %v", event, err)
+        }
+        if !strings.HasPrefix(subject, "【ネコ商店】") || !strings.HasPrefix(body, "山田 花子 様") ||
+            !strings.Contains(body, "追跡番号：123456789012（ヤマト運輸）") || !strings.Contains(body, data.TrackingURL) {
+            t.Errorf("%s email:\n%s\n%s", event, subject, body)
+        }
+        subject, short, err := renderNotification(event, notify.KindSMS, data)
+        if err != nil || subject != "" || strings.Contains(short, "\n") || !strings.HasSuffix(short, data.TrackingURL) {
+            t.Errorf("%s short: %q %q %v", event, subject, short, err)
+        }
+    }
+    _, body, _ := renderNotification(outboxShipmentException, notify.KindEmail, data)
+    if !strings.Contains(body, "ご不在などによりお届けできませんでした。") {
+        t.Errorf("exception reason missing:\n%s", body)
+    }
+    _, short, _ := renderNotification(eventShipmentCreated, notify.KindLINE, notificationData{OrgName: "ネコ商店", TrackingCode: "X1"})
+    if short != "【ネコ商店】商品を発送しました。 追跡番号：X1" {
+        t.Errorf("short without link = %q", short)
+    }
+}
@@ -0,0 +1,807 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+)
+
+// Order statuses. new/canceled are set by callers; the rest are derived from
+// the order's shipments by refreshOrderStatus.
+const (
+    orderStatusNew                = "new"
+    orderStatusPartiallyFulfilled = "partially_fulfilled"
+    orderStatusFulfilled          = "fulfilled"
+    orderStatusShipped            = "shipped"
+    orderStatusDelivered          = "delivered"
+    orderStatusCanceled           = "canceled"
+)
+
+// Orders
+type OrderLineItem struct {
+    ID        string          `json:"id,omitempty"`
+    SKU       string          `json:"sku"`
+    Name      string          `json:"name,omitempty"`
+    Quantity  int             `json:"quantity"`
+    WeightOz  float64         `json:"weight_oz,omitempty"`
+    UnitPrice float64         `json:"unit_price,omitempty"`
+    Currency  string          `json:"currency,omitempty"`
+    Metadata  json.RawMessage `json:"metadata,omitempty"`
+    // Read-only: quantities already allocated to shipments and still to ship.
+    FulfilledQuantity int `json:"fulfilled_quantity"`
+    RemainingQuantity int `json:"remaining_quantity"`
+}
+
+type OrderCreateRequest struct {
+    OrgSlug         string          `json:"org_slug"`
+    ExternalOrderID string          `json:"external_order_id"`
+    CustomerEmail   string          `json:"customer_email"`
+    ShippingAddress json.RawMessage `json:"shipping_address"`
+    BillingAddress  json.RawMessage `json:"billing_address"`
+    Metadata        json.RawMessage `json:"metadata"`
+    LineItems       []OrderLineItem `json:"line_items"`
+}
+
+// OrderUpdateRequest carries a partial update; nil fields are left unchanged.
+type OrderUpdateRequest struct {
+    CustomerEmail   *string          `json:"customer_email"`
+    ShippingAddress json.RawMessage  `json:"shipping_address"`
+    BillingAddress  json.RawMessage  `json:"billing_address"`
+    Status          *string          `json:"status"`
+    Metadata        json.RawMessage  `json:"metadata"`
+    LineItems       *[]OrderLineItem `json:"line_items"`
+}
+
+type OrderResponse struct {
+    ID              string          `json:"id"`
+    ExternalOrderID string          `json:"external_order_id"`
+    CustomerEmail   string          `json:"customer_email,omitempty"`
+    ShippingAddress json.RawMessage `json:"shipping_address"`
+    BillingAddress  json.RawMessage `json:"billing_address"`
+    Status          string          `json:"status"`
+    Metadata        json.RawMessage `json:"metadata"`
+    LineItems       []OrderLineItem `json:"line_items,omitempty"`
+    ShipmentIDs     []string        `json:"shipment_ids,omitempty"`
+    CreatedAt       string          `json:"created_at"`
+    UpdatedAt       string          `json:"updated_at"`
+}
+
+func (s *Server) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
+    var req OrderCreateRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    if strings.TrimSpace(req.OrgSlug) == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "org_slug required")
+        return
+    }
+    if strings.TrimSpace(req.ExternalOrderID) == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "external_order_id required")
+        return
+    }
+    if err := validateLineItems(req.LineItems); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    ctx := r.Context()
+    orgID, err := resolveOrgID(ctx, s.db, req.OrgSlug)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "org not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+
+    tx, err := s.db.Begin(ctx)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+
+    orderID, err := insertOrder(ctx, tx, orgID, req)
+    if err != nil {
+        if isUniqueViolation(err) {
+            writeErrorJSON(w, http.StatusConflict, "conflict", "order already exists")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create order")
+        return
+    }
+    if err := tx.Commit(ctx); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create order")
+        return
+    }
+
+    resp, err := loadOrder(ctx, s.db, orderID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+    orderID, ok := parseOrderID(w, r)
+    if !ok {
+        return
+    }
+    resp, err := loadOrder(r.Context(), s.db, orderID)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "order not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// handleListOrders lists an org's orders (without line items), newest first.
+func (s *Server) handleListOrders(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    slug := strings.TrimSpace(q.Get("org_slug"))
+    if slug == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "org_slug required")
+        return
+    }
+    limit, offset, ok := parsePagination(w, r, 50, 200)
+    if !ok {
+        return
+    }
+    ctx := r.Context()
+    orgID, err := resolveOrgID(ctx, s.db, slug)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "org not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    rows, err := s.db.Query(ctx, `
+        SELECT id, external_order_id, COALESCE(customer_email::text, ''), shipping_address, billing_address,
+               status, metadata, created_at, updated_at
+        FROM orders
+        WHERE org_id = $1 AND ($2::text IS NULL OR status = $2)
+        ORDER BY created_at DESC, id
+        LIMIT $3 OFFSET $4
+    `, orgID, nullIfEmpty(q.Get("status")), limit, offset)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer rows.Close()
+    orders := []OrderResponse{}
+    for rows.Next() {
+        o, err := scanOrder(rows)
+        if err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+            return
+        }
+        orders = append(orders, o)
+    }
+    if err := rows.Err(); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]any{"orders": orders, "limit": limit, "offset": offset})
+}
+
+func (s *Server) handleUpdateOrder(w http.ResponseWriter, r *http.Request) {
+    orderID, ok := parseOrderID(w, r)
+    if !ok {
+        return
+    }
+    var req OrderUpdateRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    if req.Status != nil && *req.Status != orderStatusNew && *req.Status != orderStatusCanceled {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "status must be new or canceled")
+        return
+    }
+    if req.LineItems != nil {
+        if err := validateLineItems(*req.LineItems); err != nil {
+            writeErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+            return
+        }
+    }
+
+    ctx := r.Context()
+    tx, err := s.db.Begin(ctx)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+
+    var shipmentCount int
+    err = tx.QueryRow(ctx, `
+        SELECT (SELECT COUNT(*) FROM shipments s WHERE s.order_id = o.id)
+        FROM orders o WHERE o.id = $1 FOR UPDATE
+    `, orderID).Scan(&shipmentCount)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "order not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if shipmentCount > 0 && (req.LineItems != nil || req.Status != nil) {
+        writeErrorJSON(w, http.StatusConflict, "order_already_fulfilled", "order already has shipments")
+        return
+    }
+
+    _, err = tx.Exec(ctx, `
+        UPDATE orders SET
+            customer_email = COALESCE($2, customer_email),
+            shipping_address = COALESCE($3::jsonb, shipping_address),
+            billing_address = COALESCE($4::jsonb, billing_address),
+            status = COALESCE($5, status),
+            metadata = COALESCE($6::jsonb, metadata),
+            updated_at = NOW()
+        WHERE id = $1
+    `, orderID, req.CustomerEmail, rawOrNil(req.ShippingAddress), rawOrNil(req.BillingAddress), req.Status, rawOrNil(req.Metadata))
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to update order")
+        return
+    }
+    if req.LineItems != nil {
+        if _, err := tx.Exec(ctx, `DELETE FROM order_items WHERE order_id = $1`, orderID); err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to update order")
+            return
+        }
+        if err := insertOrderItems(ctx, tx, orderID, *req.LineItems); err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to update order")
+            return
+        }
+    }
+    if err := tx.Commit(ctx); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to update order")
+        return
+    }
+
+    resp, err := loadOrder(ctx, s.db, orderID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// Fulfilment
+type FulfillItem struct {
+    SKU      string `json:"sku"`
+    Quantity int    `json:"quantity"`
+}
+
+// FulfillParcel describes one shipment. ShipFrom overrides the request-level
+// ship_from so parcels can leave from different warehouses.
+type FulfillParcel struct {
+    Items          []FulfillItem   `json:"items"`
+    ShipFrom       json.RawMessage `json:"ship_from"`
+    Package        json.RawMessage `json:"package"`
+    Metadata       json.RawMessage `json:"metadata"`
+    TrackingNumber string          `json:"tracking_number"`
+}
+
+type OrderFulfillRequest struct {
+    CarrierCode  string          `json:"carrier_code"`
+    ServiceCode  string          `json:"service_code"`
+    RateCurrency string          `json:"rate_currency"`
+    ShipFrom     json.RawMessage `json:"ship_from"`
+    Parcels      []FulfillParcel `json:"parcels"`
+}
+
+type FulfilledShipment struct {
+    ShipmentID   string          `json:"shipment_id"`
+    LabelURL     string          `json:"label_url"`
+    TrackingCode string          `json:"tracking_code,omitempty"`
+    Status       string          `json:"status"`
+    CreatedAt    string          `json:"created_at"`
+    ShipFrom     json.RawMessage `json:"ship_from"`
+    Items        []FulfillItem   `json:"items"`
+}
+
+type OrderFulfillResponse struct {
+    OrderID   string              `json:"order_id"`
+    Status    string              `json:"status"`
+    Shipments []FulfilledShipment `json:"shipments"`
+    Remaining []FulfillItem       `json:"remaining"`
+}
+
+// handleFulfillOrder creates one shipment per requested parcel from the order's
+// shipping address and line items. Parcels may cover a subset of the remaining
+// quantities, leaving the order partially_fulfilled until the rest ships.
+// Without parcels, everything still remaining ships together.
+func (s *Server) handleFulfillOrder(w http.ResponseWriter, r *http.Request) {
+    orderID, ok := parseOrderID(w, r)
+    if !ok {
+        return
+    }
+    var req OrderFulfillRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+
+    ctx := r.Context()
+    tx, err := s.db.Begin(ctx)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+
+    var (
+        orgID    uuid.UUID
+        status   string
+        shipTo   string
+    )
+    err = tx.QueryRow(ctx, `
+        SELECT org_id, status, shipping_address FROM orders WHERE id = $1 FOR UPDATE
+    `, orderID).Scan(&orgID, &status, &shipTo)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "order not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if status != orderStatusNew && status != orderStatusPartiallyFulfilled {
+        writeErrorJSON(w, http.StatusConflict, "order_not_fulfillable", "order status is "+status)
+        return
+    }
+
+    items, err := loadOrderItems(ctx, tx, orderID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    plans, err := planParcels(items, req.Parcels)
+    if err != nil {
+        if errors.Is(err, ErrNothingToFulfill) {
+            writeErrorJSON(w, http.StatusConflict, "order_not_fulfillable", err.Error())
+            return
+        }
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    carrierAccountID, err := resolveCarrierAccountID(ctx, tx, orgID, req.CarrierCode)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+
+    resp := OrderFulfillResponse{OrderID: orderID.String(), Shipments: []FulfilledShipment{}}
+    for _, p := range plans {
+        shipFrom := req.ShipFrom
+        if len(p.ShipFrom) > 0 && string(p.ShipFrom) != "null" {
+            shipFrom = p.ShipFrom
+        }
+        created, err := insertShipment(ctx, tx, shipmentParams{
+            OrgID:            orgID,
+            OrderID:          &orderID,
+            CarrierAccountID: carrierAccountID,
+            CarrierCode:      req.CarrierCode,
+            TrackingCode:     p.TrackingNumber,
+            ServiceCode:      req.ServiceCode,
+            RateCurrency:     req.RateCurrency,
+            ShipTo:           json.RawMessage(shipTo),
+            ShipFrom:         shipFrom,
+            Package:          p.Package,
+            Metadata:         p.Metadata,
+        })
+        if err != nil {
+            if errors.Is(err, errTrackerLinked) {
+                writeErrorJSON(w, http.StatusConflict, "conflict", err.Error())
+                return
+            }
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create shipment")
+            return
+        }
+        out := FulfilledShipment{
+            ShipmentID:   created.ID.String(),
+            LabelURL:     created.LabelURL,
+            TrackingCode: created.TrackingCode,
+            Status:       created.Status,
+            CreatedAt:    created.CreatedAt.Format(time.RFC3339),
+            ShipFrom:     json.RawMessage(jsonOrEmpty(shipFrom)),
+            Items:        []FulfillItem{},
+        }
+        for _, a := range p.Allocations {
+            _, err := tx.Exec(ctx, `
+                INSERT INTO shipment_items (shipment_id, order_item_id, quantity) VALUES ($1, $2, $3)
+            `, created.ID, a.Item.ID, a.Quantity)
+            if err != nil {
+                writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create shipment")
+                return
+            }
+            out.Items = append(out.Items, FulfillItem{SKU: a.Item.SKU, Quantity: a.Quantity})
+        }
+        resp.Shipments = append(resp.Shipments, out)
+    }
+
+    resp.Remaining = remainingAfter(items, plans)
+    resp.Status, err = refreshOrderStatus(ctx, tx, orderID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if err := tx.Commit(ctx); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to fulfill order")
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// orderItemRow is an order line item as stored, used for fulfilment planning.
+// Fulfilled is the quantity already allocated to shipments.
+type orderItemRow struct {
+    ID        uuid.UUID
+    SKU       string
+    Quantity  int
+    Fulfilled int
+    WeightOz  float64
+}
+
+func (it orderItemRow) remaining() int { return it.Quantity - it.Fulfilled }
+
+type parcelAllocation struct {
+    Item     orderItemRow
+    Quantity int
+}
+
+type parcelPlan struct {
+    Allocations    []parcelAllocation
+    ShipFrom       json.RawMessage
+    Package        json.RawMessage
+    Metadata       json.RawMessage
+    TrackingNumber string
+}
+
+// ErrNothingToFulfill is returned when an order has no remaining quantity to ship.
+var ErrNothingToFulfill = errors.New("nothing left to fulfill")
+
+// planParcels resolves requested parcels against the order's line items.
+// Allocations may not exceed each item's remaining quantity; an item listed
+// without a quantity takes whatever remains. An empty request ships all
+// remaining items in a single parcel. A SKU listed more than once in a parcel
+// is merged into one allocation. Packages without an explicit weight get the
+// summed item weight.
+func planParcels(items []orderItemRow, parcels []FulfillParcel) ([]parcelPlan, error) {
+    if len(parcels) == 0 {
+        all := FulfillParcel{}
+        for _, it := range items {
+            if it.remaining() > 0 {
+                all.Items = append(all.Items, FulfillItem{SKU: it.SKU, Quantity: it.remaining()})
+            }
+        }
+        if len(all.Items) == 0 && len(items) > 0 {
+            return nil, ErrNothingToFulfill
+        }
+        parcels = []FulfillParcel{all}
+    }
+    bySKU := make(map[string]orderItemRow, len(items))
+    for _, it := range items {
+        bySKU[it.SKU] = it
+    }
+    allocated := make(map[string]int, len(items))
+    plans := make([]parcelPlan, 0, len(parcels))
+    for i, p := range parcels {
+        plan := parcelPlan{ShipFrom: p.ShipFrom, Metadata: p.Metadata, TrackingNumber: p.TrackingNumber}
+        inParcel := map[string]int{}
+        var weight float64
+        for _, fi := range p.Items {
+            it, ok := bySKU[fi.SKU]
+            if !ok {
+                return nil, fmt.Errorf("parcel %d: unknown sku %q", i, fi.SKU)
+            }
+            qty := fi.Quantity
+            if qty == 0 {
+                qty = it.remaining() - allocated[fi.SKU]
+            }
+            if qty <= 0 {
+                return nil, fmt.Errorf("parcel %d: no remaining quantity for %q", i, fi.SKU)
+            }
+            allocated[fi.SKU] += qty
+            if allocated[fi.SKU] > it.remaining() {
+                return nil, fmt.Errorf("sku %q allocated %d but only %d remaining", fi.SKU, allocated[fi.SKU], it.remaining())
+            }
+            if j, ok := inParcel[fi.SKU]; ok {
+                plan.Allocations[j].Quantity += qty
+            } else {
+                inParcel[fi.SKU] = len(plan.Allocations)
+                plan.Allocations = append(plan.Allocations, parcelAllocation{Item: it, Quantity: qty})
+            }
+            weight += it.WeightOz * float64(qty)
+        }
+        if len(plan.Allocations) == 0 && len(items) > 0 {
+            return nil, fmt.Errorf("parcel %d: items required", i)
+        }
+        plan.Package = p.Package
+        if len(p.Package) == 0 || string(p.Package) == "null" {
+            b, _ := json.Marshal(map[string]any{"weight_oz": weight})
+            plan.Package = b
+        }
+        plans = append(plans, plan)
+    }
+    return plans, nil
+}
+
+// remainingAfter lists items that still have quantity left once plans ship.
+func remainingAfter(items []orderItemRow, plans []parcelPlan) []FulfillItem {
+    allocated := map[string]int{}
+    for _, p := range plans {
+        for _, a := range p.Allocations {
+            allocated[a.Item.SKU] += a.Quantity
+        }
+    }
+    out := []FulfillItem{}
+    for _, it := range items {
+        if left := it.remaining() - allocated[it.SKU]; left > 0 {
+            out = append(out, FulfillItem{SKU: it.SKU, Quantity: left})
+        }
+    }
+    return out
+}
+
+// deriveOrderStatus computes an order's status from its shipment counts and
+// the item quantity not yet allocated to a shipment. Orders without shipments
+// and canceled orders keep their current status.
+func deriveOrderStatus(current string, total, shipped, delivered, remaining int) string {
+    if current == orderStatusCanceled || total == 0 {
+        return current
+    }
+    switch {
+    case remaining > 0:
+        return orderStatusPartiallyFulfilled
+    case delivered == total:
+        return orderStatusDelivered
+    case shipped > 0:
+        return orderStatusShipped
+    default:
+        return orderStatusFulfilled
+    }
+}
+
+// refreshOrderStatus recomputes orders.status from its shipments and persists it.
+func refreshOrderStatus(ctx context.Context, q dbtx, orderID uuid.UUID) (string, error) {
+    var (
+        current                              string
+        total, shipped, delivered, remaining int
+    )
+    err := q.QueryRow(ctx, `
+        SELECT o.status,
+               COUNT(s.id),
+               COUNT(s.id) FILTER (WHERE s.status IN ('in_transit', 'out_for_delivery', 'delivered', 'available_for_pickup',
+                                                       'failure', 'exception', 'return_to_sender')),
+               COUNT(s.id) FILTER (WHERE s.status = 'delivered'),
+               (SELECT COALESCE(SUM(oi.quantity), 0) FROM order_items oi WHERE oi.order_id = o.id)
+                 - (SELECT COALESCE(SUM(si.quantity), 0)
+                      FROM shipment_items si JOIN order_items oi ON oi.id = si.order_item_id
+                     WHERE oi.order_id = o.id)
+        FROM orders o
+        LEFT JOIN shipments s ON s.order_id = o.id
+        WHERE o.id = $1
+        GROUP BY o.id
+    `, orderID).Scan(&current, &total, &shipped, &delivered, &remaining)
+    if err != nil {
+        return "", err
+    }
+    next := deriveOrderStatus(current, total, shipped, delivered, remaining)
+    if next != current {
+        if _, err := q.Exec(ctx, `UPDATE orders SET status = $2, updated_at = NOW() WHERE id = $1`, orderID, next); err != nil {
+            return "", err
+        }
+    }
+    return next, nil
+}
+
+func insertOrder(ctx context.Context, q dbtx, orgID uuid.UUID, req OrderCreateRequest) (uuid.UUID, error) {
+    orderID := uuid.New()
+    _, err := q.Exec(ctx, `
+        INSERT INTO orders (
+            id, org_id, external_order_id, customer_email, shipping_address, billing_address, status, metadata
+        ) VALUES ($1, $2, $3, $4, $5::jsonb, $6::jsonb, 'new', $7::jsonb)
+    `, orderID, orgID, req.ExternalOrderID, nullIfEmpty(req.CustomerEmail),
+        jsonOrEmpty(req.ShippingAddress), jsonOrEmpty(req.BillingAddress), jsonOrEmpty(req.Metadata))
+    if err != nil {
+        return uuid.Nil, err
+    }
+    if err := insertOrderItems(ctx, q, orderID, req.LineItems); err != nil {
+        return uuid.Nil, err
+    }
+    return orderID, nil
+}
+
+func insertOrderItems(ctx context.Context, q dbtx, orderID uuid.UUID, items []OrderLineItem) error {
+    for _, it := range items {
+        _, err := q.Exec(ctx, `
+            INSERT INTO order_items (order_id, sku, name, quantity, weight_oz, unit_price, currency, metadata)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8::jsonb)
+        `, orderID, it.SKU, nullIfEmpty(it.Name), it.Quantity, it.WeightOz, it.UnitPrice,
+            nullIfEmpty(it.Currency), jsonOrEmpty(it.Metadata))
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// validateLineItems checks that SKUs are present and unique and quantities positive.
+func validateLineItems(items []OrderLineItem) error {
+    seen := make(map[string]bool, len(items))
+    for i, it := range items {
+        if strings.TrimSpace(it.SKU) == "" {
+            return fmt.Errorf("line_items[%d].sku required", i)
+        }
+        if seen[it.SKU] {
+            return fmt.Errorf("line_items[%d].sku %q duplicated", i, it.SKU)
+        }
+        seen[it.SKU] = true
+        if it.Quantity <= 0 {
+            return fmt.Errorf("line_items[%d].quantity must be positive", i)
+        }
+    }
+    return nil
+}
+
+func loadOrder(ctx context.Context, q dbtx, orderID uuid.UUID) (OrderResponse, error) {
+    row := q.QueryRow(ctx, `
+        SELECT id, external_order_id, COALESCE(customer_email::text, ''), shipping_address, billing_address,
+               status, metadata, created_at, updated_at
+        FROM orders WHERE id = $1
+    `, orderID)
+    o, err := scanOrder(row)
+    if err != nil {
+        return OrderResponse{}, err
+    }
+
+    rows, err := q.Query(ctx, `
+        SELECT oi.id, oi.sku, COALESCE(oi.name, ''), oi.quantity, COALESCE(oi.weight_oz, 0)::float8,
+               COALESCE(oi.unit_price, 0)::float8, COALESCE(oi.currency, ''), oi.metadata,
+               (SELECT COALESCE(SUM(si.quantity), 0)::int FROM shipment_items si WHERE si.order_item_id = oi.id)
+        FROM order_items oi WHERE oi.order_id = $1 ORDER BY oi.created_at, oi.sku
+    `, orderID)
+    if err != nil {
+        return OrderResponse{}, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var (
+            id   uuid.UUID
+            it   OrderLineItem
+            meta string
+        )
+        if err := rows.Scan(&id, &it.SKU, &it.Name, &it.Quantity, &it.WeightOz, &it.UnitPrice, &it.Currency, &meta, &it.FulfilledQuantity); err != nil {
+            return OrderResponse{}, err
+        }
+        it.ID = id.String()
+        it.RemainingQuantity = it.Quantity - it.FulfilledQuantity
+        it.Metadata = json.RawMessage(meta)
+        o.LineItems = append(o.LineItems, it)
+    }
+    if err := rows.Err(); err != nil {
+        return OrderResponse{}, err
+    }
+
+    srows, err := q.Query(ctx, `SELECT id FROM shipments WHERE order_id = $1 ORDER BY created_at, id`, orderID)
+    if err != nil {
+        return OrderResponse{}, err
+    }
+    defer srows.Close()
+    for srows.Next() {
+        var sid uuid.UUID
+        if err := srows.Scan(&sid); err != nil {
+            return OrderResponse{}, err
+        }
+        o.ShipmentIDs = append(o.ShipmentIDs, sid.String())
+    }
+    return o, srows.Err()
+}
+
+func loadOrderItems(ctx context.Context, q dbtx, orderID uuid.UUID) ([]orderItemRow, error) {
+    rows, err := q.Query(ctx, `
+        SELECT oi.id, oi.sku, oi.quantity,
+               (SELECT COALESCE(SUM(si.quantity), 0)::int FROM shipment_items si WHERE si.order_item_id = oi.id),
+               COALESCE(oi.weight_oz, 0)::float8
+        FROM order_items oi WHERE oi.order_id = $1 ORDER BY oi.created_at, oi.sku
+    `, orderID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var items []orderItemRow
+    for rows.Next() {
+        var it orderItemRow
+        if err := rows.Scan(&it.ID, &it.SKU, &it.Quantity, &it.Fulfilled, &it.WeightOz); err != nil {
+            return nil, err
+        }
+        items = append(items, it)
+    }
+    return items, rows.Err()
+}
+
+func scanOrder(row pgx.Row) (OrderResponse, error) {
+    var (
+        o                        OrderResponse
+        id                       uuid.UUID
+        shipTo, billTo, meta     string
+        createdAt, updatedAt     time.Time
+    )
+    if err := row.Scan(&id, &o.ExternalOrderID, &o.CustomerEmail, &shipTo, &billTo, &o.Status, &meta, &createdAt, &updatedAt); err != nil {
+        return OrderResponse{}, err
+    }
+    o.ID = id.String()
+    o.ShippingAddress = json.RawMessage(shipTo)
+    o.BillingAddress = json.RawMessage(billTo)
+    o.Metadata = json.RawMessage(meta)
+    o.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+    o.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
+    return o, nil
+}
+
+// parseOrderID reads and validates the {id} URL parameter, writing an error response on failure.
+func parseOrderID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+    id, err := uuid.Parse(strings.TrimSpace(chi.URLParam(r, "id")))
+    if err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid order id")
+        return uuid.Nil, false
+    }
+    return id, true
+}
+
+// parsePagination reads limit/offset query parameters, applying a default and maximum limit.
+func parsePagination(w http.ResponseWriter, r *http.Request, def, max int) (int, int, bool) {
+    q := r.URL.Query()
+    limit, offset := def, 0
+    if v := q.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n <= 0 {
+            writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid limit")
+            return 0, 0, false
+        }
+        limit = n
+    }
+    if limit > max {
+        limit = max
+    }
+    if v := q.Get("offset"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 0 {
+            writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid offset")
+            return 0, 0, false
+        }
+        offset = n
+    }
+    return limit, offset, true
+}
+
+// rawOrNil returns raw as a string pointer, or nil when unset, for COALESCE updates.
+func rawOrNil(raw json.RawMessage) *string {
+    if len(raw) == 0 || string(raw) == "null" {
+        return nil
+    }
+    s := string(raw)
+    return &s
+}
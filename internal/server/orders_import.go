@@ -0,0 +1,289 @@
+package server
+
+import (
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+)
+
+// maxImportBytes bounds the size of a CSV order import body. Larger bodies
+// are rejected whole rather than imported up to a cut-off row.
+const maxImportBytes = 10 << 20
+
+// CSV columns prefixed with these map into the order's address JSON, e.g.
+// ship_to_postal -> shipping_address.postal.
+const (
+    csvShipToPrefix = "ship_to_"
+    csvBillToPrefix = "bill_to_"
+)
+
+type OrderImportResult struct {
+    ExternalOrderID string `json:"external_order_id"`
+    Lines           []int  `json:"lines"`
+    Result          string `json:"result"` // created|updated|error
+    OrderID         string `json:"order_id,omitempty"`
+    Error           string `json:"error,omitempty"`
+}
+
+type OrderImportResponse struct {
+    Created int                 `json:"created"`
+    Updated int                 `json:"updated"`
+    Failed  int                 `json:"failed"`
+    Results []OrderImportResult `json:"results"`
+}
+
+// importedOrder is one order assembled from one or more CSV rows.
+type importedOrder struct {
+    Order OrderCreateRequest
+    Lines []int
+    Err   string
+}
+
+// handleImportOrders bulk creates or replaces orders from a CSV body.
+// Rows sharing an external_order_id become line items of the same order.
+// Each order is written in its own transaction so one bad order does not
+// block the rest; orders that already have shipments are not modified.
+func (s *Server) handleImportOrders(w http.ResponseWriter, r *http.Request) {
+    slug := strings.TrimSpace(r.URL.Query().Get("org_slug"))
+    if slug == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "org_slug required")
+        return
+    }
+    orders, err := parseOrdersCSV(http.MaxBytesReader(w, r.Body, maxImportBytes))
+    if err != nil {
+        var tooLarge *http.MaxBytesError
+        if errors.As(err, &tooLarge) {
+            writeErrorJSON(w, http.StatusRequestEntityTooLarge, "payload_too_large", fmt.Sprintf("csv exceeds %d bytes", tooLarge.Limit))
+            return
+        }
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_csv", err.Error())
+        return
+    }
+
+    ctx := r.Context()
+    orgID, err := resolveOrgID(ctx, s.db, slug)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "org not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+
+    resp := OrderImportResponse{Results: []OrderImportResult{}}
+    for _, o := range orders {
+        res := OrderImportResult{ExternalOrderID: o.Order.ExternalOrderID, Lines: o.Lines}
+        if o.Err != "" {
+            res.Result, res.Error = "error", o.Err
+            resp.Failed++
+            resp.Results = append(resp.Results, res)
+            continue
+        }
+        orderID, created, err := s.upsertImportedOrder(ctx, orgID, o.Order)
+        switch {
+        case err != nil:
+            res.Result, res.Error = "error", err.Error()
+            resp.Failed++
+        case created:
+            res.Result, res.OrderID = "created", orderID
+            resp.Created++
+        default:
+            res.Result, res.OrderID = "updated", orderID
+            resp.Updated++
+        }
+        resp.Results = append(resp.Results, res)
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// errImportFulfilled is reported for imported rows targeting an order that already shipped.
+var errImportFulfilled = errors.New("order already has shipments")
+
+func (s *Server) upsertImportedOrder(ctx context.Context, orgID uuid.UUID, o OrderCreateRequest) (string, bool, error) {
+    tx, err := s.db.Begin(ctx)
+    if err != nil {
+        return "", false, errors.New("db error")
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+
+    var (
+        existing      uuid.UUID
+        shipmentCount int
+    )
+    err = tx.QueryRow(ctx, `
+        SELECT o.id, (SELECT COUNT(*) FROM shipments s WHERE s.order_id = o.id)
+        FROM orders o WHERE o.org_id = $1 AND o.external_order_id = $2 FOR UPDATE
+    `, orgID, o.ExternalOrderID).Scan(&existing, &shipmentCount)
+    switch {
+    case errors.Is(err, pgx.ErrNoRows):
+        id, err := insertOrder(ctx, tx, orgID, o)
+        if err != nil {
+            return "", false, errors.New("failed to create order")
+        }
+        if err := tx.Commit(ctx); err != nil {
+            return "", false, errors.New("failed to create order")
+        }
+        return id.String(), true, nil
+    case err != nil:
+        return "", false, errors.New("db error")
+    }
+    if shipmentCount > 0 {
+        return "", false, errImportFulfilled
+    }
+    _, err = tx.Exec(ctx, `
+        UPDATE orders SET customer_email = $2, shipping_address = $3::jsonb, billing_address = $4::jsonb, updated_at = NOW()
+        WHERE id = $1
+    `, existing, nullIfEmpty(o.CustomerEmail), jsonOrEmpty(o.ShippingAddress), jsonOrEmpty(o.BillingAddress))
+    if err != nil {
+        return "", false, errors.New("failed to update order")
+    }
+    if _, err := tx.Exec(ctx, `DELETE FROM order_items WHERE order_id = $1`, existing); err != nil {
+        return "", false, errors.New("failed to update order")
+    }
+    if err := insertOrderItems(ctx, tx, existing, o.LineItems); err != nil {
+        return "", false, errors.New("failed to update order")
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return "", false, errors.New("failed to update order")
+    }
+    return existing.String(), false, nil
+}
+
+// parseOrdersCSV groups CSV rows into orders by external_order_id, preserving
+// first-seen order. Row-level problems are recorded on the affected order
+// rather than failing the whole import; only malformed CSV is fatal.
+//
+// Recognised columns: external_order_id (required), customer_email, sku,
+// item_name, quantity, weight_oz, unit_price, currency, and any ship_to_* /
+// bill_to_* address fields.
+func parseOrdersCSV(r io.Reader) ([]importedOrder, error) {
+    cr := csv.NewReader(r)
+    cr.FieldsPerRecord = -1
+    cr.TrimLeadingSpace = true
+    header, err := cr.Read()
+    if err != nil {
+        if errors.Is(err, io.EOF) {
+            return nil, errors.New("empty csv")
+        }
+        return nil, err
+    }
+    cols := make(map[string]int, len(header))
+    for i, h := range header {
+        cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+    }
+    if _, ok := cols["external_order_id"]; !ok {
+        return nil, errors.New("external_order_id column required")
+    }
+
+    var (
+        orders []importedOrder
+        index  = map[string]int{}
+        line   = 1
+    )
+    for {
+        rec, err := cr.Read()
+        if errors.Is(err, io.EOF) {
+            break
+        }
+        line++
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %w", line, err)
+        }
+        get := func(name string) string {
+            if i, ok := cols[name]; ok && i < len(rec) {
+                return strings.TrimSpace(rec[i])
+            }
+            return ""
+        }
+        ext := get("external_order_id")
+        if ext == "" {
+            orders = append(orders, importedOrder{Lines: []int{line}, Err: "external_order_id required"})
+            continue
+        }
+        idx, ok := index[ext]
+        if !ok {
+            idx = len(orders)
+            index[ext] = idx
+            orders = append(orders, importedOrder{Order: OrderCreateRequest{ExternalOrderID: ext}})
+        }
+        o := &orders[idx]
+        o.Lines = append(o.Lines, line)
+        if o.Err != "" {
+            continue
+        }
+        if o.Order.CustomerEmail == "" {
+            o.Order.CustomerEmail = get("customer_email")
+        }
+        if o.Order.ShippingAddress == nil {
+            o.Order.ShippingAddress = csvAddress(header, rec, csvShipToPrefix)
+        }
+        if o.Order.BillingAddress == nil {
+            o.Order.BillingAddress = csvAddress(header, rec, csvBillToPrefix)
+        }
+
+        sku := get("sku")
+        if sku == "" {
+            continue
+        }
+        item := OrderLineItem{SKU: sku, Name: get("item_name"), Quantity: 1, Currency: get("currency")}
+        if v := get("quantity"); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil || n <= 0 {
+                o.Err = fmt.Sprintf("line %d: invalid quantity", line)
+                continue
+            }
+            item.Quantity = n
+        }
+        if v := get("weight_oz"); v != "" {
+            f, err := strconv.ParseFloat(v, 64)
+            if err != nil || f < 0 {
+                o.Err = fmt.Sprintf("line %d: invalid weight_oz", line)
+                continue
+            }
+            item.WeightOz = f
+        }
+        if v := get("unit_price"); v != "" {
+            f, err := strconv.ParseFloat(v, 64)
+            if err != nil || f < 0 {
+                o.Err = fmt.Sprintf("line %d: invalid unit_price", line)
+                continue
+            }
+            item.UnitPrice = f
+        }
+        o.Order.LineItems = append(o.Order.LineItems, item)
+        if err := validateLineItems(o.Order.LineItems); err != nil {
+            o.Err = fmt.Sprintf("line %d: %v", line, err)
+        }
+    }
+    return orders, nil
+}
+
+// csvAddress collects non-empty prefixed columns into an address object.
+// Returns nil when the row carries no such columns so later rows can fill it.
+func csvAddress(header, rec []string, prefix string) json.RawMessage {
+    addr := map[string]string{}
+    for i, h := range header {
+        h = strings.ToLower(strings.TrimSpace(h))
+        if !strings.HasPrefix(h, prefix) || i >= len(rec) {
+            continue
+        }
+        if v := strings.TrimSpace(rec[i]); v != "" {
+            addr[strings.TrimPrefix(h, prefix)] = v
+        }
+    }
+    if len(addr) == 0 {
+        return nil
+    }
+    b, _ := json.Marshal(addr)
+    return b
+}
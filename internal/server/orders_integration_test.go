@@ -0,0 +1,162 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "testing"
+
+    "deliveryinfra/internal/db"
+)
+
+func TestOrdersCreateImportFulfill(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    // Ensure demo org exists and clear previous runs
+    _, _ = pool.Exec(t.Context(), `
+        INSERT INTO orgs (slug, name)
+        SELECT 'demo', 'Demo Org'
+        WHERE NOT EXISTS (SELECT 1 FROM orgs WHERE slug = 'demo')
+    `)
+    _, _ = pool.Exec(t.Context(), `
+        DELETE FROM shipments WHERE order_id IN (
+            SELECT id FROM orders WHERE external_order_id IN ('ITEST-ORD-1', 'ITEST-ORD-CSV'))
+    `)
+    _, _ = pool.Exec(t.Context(), `DELETE FROM orders WHERE external_order_id IN ('ITEST-ORD-1', 'ITEST-ORD-CSV')`)
+
+    h := New(pool)
+
+    // Create
+    payload := map[string]any{
+        "org_slug":          "demo",
+        "external_order_id": "ITEST-ORD-1",
+        "customer_email":    "buyer@example.com",
+        "shipping_address":  map[string]any{"country": "JP", "postal": "1500001"},
+        "line_items": []map[string]any{
+            {"sku": "TEA", "quantity": 2, "weight_oz": 4},
+            {"sku": "CUP", "quantity": 1, "weight_oz": 8},
+        },
+    }
+    body, _ := json.Marshal(payload)
+    req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewReader(body))
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var order OrderResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &order); err != nil {
+        t.Fatalf("unmarshal: %v", err)
+    }
+    if order.ID == "" || order.Status != "new" || len(order.LineItems) != 2 {
+        t.Fatalf("unexpected order: %+v", order)
+    }
+
+    // Duplicate external ID conflicts
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/orders", bytes.NewReader(body)))
+    if rr.Code != http.StatusConflict {
+        t.Fatalf("expected 409 on duplicate, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+
+    // Patch
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPatch, "/orders/"+order.ID, strings.NewReader(`{"metadata":{"channel":"shopify"}}`)))
+    if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "shopify") {
+        t.Fatalf("unexpected patch response %d: %s", rr.Code, rr.Body.String())
+    }
+
+    // Partially fulfil from one warehouse
+    fulfill := `{"carrier_code":"","ship_from":{"country":"JP","warehouse":"tokyo"},"parcels":[
+        {"items":[{"sku":"TEA","quantity":1}]}
+    ]}`
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/orders/"+order.ID+"/fulfill", strings.NewReader(fulfill)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200 on fulfill, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var fres OrderFulfillResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &fres); err != nil {
+        t.Fatalf("unmarshal: %v", err)
+    }
+    if fres.Status != "partially_fulfilled" || len(fres.Shipments) != 1 || len(fres.Remaining) != 2 {
+        t.Fatalf("unexpected partial fulfill response: %+v", fres)
+    }
+
+    // Ship the rest split across two warehouses
+    fulfill = `{"ship_from":{"country":"JP","warehouse":"tokyo"},"parcels":[
+        {"items":[{"sku":"TEA"}]},
+        {"items":[{"sku":"CUP","quantity":1}],"ship_from":{"country":"JP","warehouse":"osaka"}}
+    ]}`
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/orders/"+order.ID+"/fulfill", strings.NewReader(fulfill)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200 on second fulfill, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    fres = OrderFulfillResponse{}
+    if err := json.Unmarshal(rr.Body.Bytes(), &fres); err != nil {
+        t.Fatalf("unmarshal: %v", err)
+    }
+    if fres.Status != "fulfilled" || len(fres.Shipments) != 2 || len(fres.Remaining) != 0 {
+        t.Fatalf("unexpected fulfill response: %+v", fres)
+    }
+    if !strings.Contains(string(fres.Shipments[1].ShipFrom), "osaka") {
+        t.Fatalf("expected parcel ship_from override, got %s", fres.Shipments[1].ShipFrom)
+    }
+
+    // Line items report shipped vs remaining quantities
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/orders/"+order.ID, nil))
+    var got OrderResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+        t.Fatalf("unmarshal: %v", err)
+    }
+    for _, it := range got.LineItems {
+        if it.FulfilledQuantity != it.Quantity || it.RemainingQuantity != 0 {
+            t.Fatalf("unexpected line item quantities: %+v", it)
+        }
+    }
+    if len(got.ShipmentIDs) != 3 {
+        t.Fatalf("expected 3 shipments, got %d", len(got.ShipmentIDs))
+    }
+
+    // Fulfilling again is rejected
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/orders/"+order.ID+"/fulfill", strings.NewReader(`{}`)))
+    if rr.Code != http.StatusConflict {
+        t.Fatalf("expected 409 on extra fulfill, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+
+    // CSV import: one new order, one row for the fulfilled order which must be refused
+    csvBody := "external_order_id,customer_email,ship_to_country,sku,quantity\n" +
+        "ITEST-ORD-CSV,csv@example.com,US,MUG,3\n" +
+        "ITEST-ORD-1,buyer@example.com,JP,TEA,5\n"
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/orders/import?org_slug=demo", strings.NewReader(csvBody)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200 on import, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var ires OrderImportResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &ires); err != nil {
+        t.Fatalf("unmarshal: %v", err)
+    }
+    if ires.Created != 1 || ires.Failed != 1 {
+        t.Fatalf("unexpected import summary: %+v", ires)
+    }
+
+    _, _ = pool.Exec(t.Context(), `DELETE FROM shipments WHERE order_id = $1`, order.ID)
+    _, _ = pool.Exec(t.Context(), `DELETE FROM orders WHERE external_order_id IN ('ITEST-ORD-1', 'ITEST-ORD-CSV')`)
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/google/uuid"
+)
+
+func TestCreateOrder_MissingExternalID_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"org_slug":"demo"}`))
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var e stdError
+    if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+        t.Fatalf("unmarshal error: %v", err)
+    }
+    if e.Error.Code != "invalid_request" {
+        t.Fatalf("unexpected error code: %s", e.Error.Code)
+    }
+}
+
+func TestGetOrder_InvalidID_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    req := httptest.NewRequest(http.MethodGet, "/orders/not-a-uuid", nil)
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+}
+
+func TestImportOrders_TooLarge(t *testing.T) {
+    h := New(nil)
+    body := "external_order_id,sku,quantity\n" + strings.Repeat("ORD-1,SKU-1,12\n", maxImportBytes/15+1)
+    req := httptest.NewRequest(http.MethodPost, "/orders/import?org_slug=demo", strings.NewReader(body))
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusRequestEntityTooLarge {
+        t.Fatalf("expected 413, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var e stdError
+    if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil || e.Error.Code != "payload_too_large" {
+        t.Fatalf("unexpected error body: %s", rr.Body.String())
+    }
+}
+
+func TestParseOrdersCSV_GroupsRowsByOrder(t *testing.T) {
+    in := "external_order_id,customer_email,ship_to_country,ship_to_postal,sku,item_name,quantity,weight_oz\n" +
+        "A-1,a@example.com,JP,1500001,SKU-1,Tea,2,4\n" +
+        "A-1,,,,SKU-2,Cup,1,8\n" +
+        "B-1,b@example.com,US,94043,SKU-1,Tea,,\n" +
+        "C-1,c@example.com,US,94043,SKU-1,Tea,zero,\n"
+    orders, err := parseOrdersCSV(strings.NewReader(in))
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+    if len(orders) != 3 {
+        t.Fatalf("expected 3 orders, got %d", len(orders))
+    }
+    a := orders[0]
+    if a.Err != "" || a.Order.ExternalOrderID != "A-1" || len(a.Order.LineItems) != 2 {
+        t.Fatalf("unexpected first order: %+v", a)
+    }
+    if a.Order.LineItems[0].Quantity != 2 || a.Order.LineItems[1].WeightOz != 8 {
+        t.Fatalf("unexpected line items: %+v", a.Order.LineItems)
+    }
+    var addr map[string]string
+    _ = json.Unmarshal(a.Order.ShippingAddress, &addr)
+    if addr["country"] != "JP" || addr["postal"] != "1500001" {
+        t.Fatalf("unexpected shipping address: %s", a.Order.ShippingAddress)
+    }
+    if len(a.Lines) != 2 || a.Lines[0] != 2 || a.Lines[1] != 3 {
+        t.Fatalf("unexpected lines: %v", a.Lines)
+    }
+    if orders[1].Order.LineItems[0].Quantity != 1 {
+        t.Fatalf("expected default quantity 1, got %d", orders[1].Order.LineItems[0].Quantity)
+    }
+    if orders[2].Err == "" {
+        t.Fatalf("expected invalid quantity error for C-1")
+    }
+}
+
+func TestParseOrdersCSV_RequiresExternalOrderIDColumn(t *testing.T) {
+    if _, err := parseOrdersCSV(strings.NewReader("sku,quantity\nX,1\n")); err == nil {
+        t.Fatalf("expected error for missing external_order_id column")
+    }
+}
+
+func TestPlanParcels(t *testing.T) {
+    items := []orderItemRow{
+        {ID: uuid.New(), SKU: "A", Quantity: 2, WeightOz: 4},
+        {ID: uuid.New(), SKU: "B", Quantity: 1, WeightOz: 10},
+    }
+
+    plans, err := planParcels(items, nil)
+    if err != nil {
+        t.Fatalf("default plan: %v", err)
+    }
+    if len(plans) != 1 || len(plans[0].Allocations) != 2 {
+        t.Fatalf("expected one parcel with all items, got %+v", plans)
+    }
+    var pkg map[string]float64
+    _ = json.Unmarshal(plans[0].Package, &pkg)
+    if pkg["weight_oz"] != 18 {
+        t.Fatalf("expected summed weight 18, got %v", pkg["weight_oz"])
+    }
+
+    plans, err = planParcels(items, []FulfillParcel{
+        {Items: []FulfillItem{{SKU: "A", Quantity: 1}}, ShipFrom: json.RawMessage(`{"warehouse":"osaka"}`)},
+        {Items: []FulfillItem{{SKU: "A", Quantity: 1}, {SKU: "B"}}, Package: json.RawMessage(`{"weight_oz":20}`)},
+    })
+    if err != nil {
+        t.Fatalf("split plan: %v", err)
+    }
+    if len(plans) != 2 || string(plans[1].Package) != `{"weight_oz":20}` || string(plans[0].ShipFrom) != `{"warehouse":"osaka"}` {
+        t.Fatalf("unexpected split plans: %+v", plans)
+    }
+
+    if _, err := planParcels(items, []FulfillParcel{{Items: []FulfillItem{{SKU: "A", Quantity: 3}}}}); err == nil {
+        t.Fatalf("expected over-allocation error")
+    }
+    if _, err := planParcels(items, []FulfillParcel{{Items: []FulfillItem{{SKU: "Z"}}}}); err == nil {
+        t.Fatalf("expected unknown sku error")
+    }
+
+    // A SKU repeated within a parcel becomes one allocation
+    plans, err = planParcels(items, []FulfillParcel{{Items: []FulfillItem{{SKU: "A", Quantity: 1}, {SKU: "A", Quantity: 1}}}})
+    if err != nil {
+        t.Fatalf("repeated sku plan: %v", err)
+    }
+    if len(plans[0].Allocations) != 1 || plans[0].Allocations[0].Quantity != 2 {
+        t.Fatalf("expected merged allocation of 2, got %+v", plans[0].Allocations)
+    }
+    if _, err := planParcels(items, []FulfillParcel{{Items: []FulfillItem{{SKU: "A", Quantity: 2}, {SKU: "A", Quantity: 1}}}}); err == nil {
+        t.Fatalf("expected over-allocation error for repeated sku")
+    }
+}
+
+func TestPlanParcels_PartialAgainstRemaining(t *testing.T) {
+    items := []orderItemRow{
+        {ID: uuid.New(), SKU: "A", Quantity: 3, Fulfilled: 2},
+        {ID: uuid.New(), SKU: "B", Quantity: 1, Fulfilled: 1},
+    }
+
+    // Partial allocation is allowed and leaves the remainder
+    plans, err := planParcels(items, nil)
+    if err != nil {
+        t.Fatalf("default plan: %v", err)
+    }
+    if len(plans[0].Allocations) != 1 || plans[0].Allocations[0].Quantity != 1 {
+        t.Fatalf("expected only remaining A, got %+v", plans[0].Allocations)
+    }
+    if left := remainingAfter(items, plans); len(left) != 0 {
+        t.Fatalf("expected nothing remaining, got %+v", left)
+    }
+
+    // Fully fulfilled items cannot be allocated again
+    if _, err := planParcels(items, []FulfillParcel{{Items: []FulfillItem{{SKU: "B"}}}}); err == nil {
+        t.Fatalf("expected no remaining quantity error")
+    }
+    if _, err := planParcels(items, []FulfillParcel{{Items: []FulfillItem{{SKU: "A", Quantity: 2}}}}); err == nil {
+        t.Fatalf("expected over-allocation error")
+    }
+
+    done := []orderItemRow{{ID: uuid.New(), SKU: "A", Quantity: 1, Fulfilled: 1}}
+    if _, err := planParcels(done, nil); !errors.Is(err, ErrNothingToFulfill) {
+        t.Fatalf("expected ErrNothingToFulfill, got %v", err)
+    }
+}
+
+func TestRemainingAfter(t *testing.T) {
+    items := []orderItemRow{
+        {ID: uuid.New(), SKU: "A", Quantity: 5},
+        {ID: uuid.New(), SKU: "B", Quantity: 1},
+    }
+    plans, err := planParcels(items, []FulfillParcel{{Items: []FulfillItem{{SKU: "A", Quantity: 2}}}})
+    if err != nil {
+        t.Fatalf("plan: %v", err)
+    }
+    left := remainingAfter(items, plans)
+    if len(left) != 2 || left[0].Quantity != 3 || left[1].Quantity != 1 {
+        t.Fatalf("unexpected remaining: %+v", left)
+    }
+}
+
+func TestDeriveOrderStatus(t *testing.T) {
+    cases := []struct {
+        current                              string
+        total, shipped, delivered, remaining int
+        want                                 string
+    }{
+        {orderStatusNew, 0, 0, 0, 3, orderStatusNew},
+        {orderStatusNew, 1, 0, 0, 2, orderStatusPartiallyFulfilled},
+        {orderStatusPartiallyFulfilled, 2, 2, 2, 1, orderStatusPartiallyFulfilled},
+        {orderStatusNew, 2, 0, 0, 0, orderStatusFulfilled},
+        {orderStatusFulfilled, 2, 1, 0, 0, orderStatusShipped},
+        {orderStatusShipped, 2, 2, 1, 0, orderStatusShipped},
+        {orderStatusShipped, 2, 2, 2, 0, orderStatusDelivered},
+        {orderStatusCanceled, 1, 1, 1, 0, orderStatusCanceled},
+    }
+    for _, c := range cases {
+        if got := deriveOrderStatus(c.current, c.total, c.shipped, c.delivered, c.remaining); got != c.want {
+            t.Errorf("deriveOrderStatus(%q, %d, %d, %d, %d) = %q, want %q", c.current, c.total, c.shipped, c.delivered, c.remaining, got, c.want)
+        }
+    }
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "deliveryinfra/internal/jobs"
+)
+
+// outboxTrackingEventCreated announces an ingested tracking event to live
+// streams; the other outbox types are the outbound webhook event types.
+const outboxTrackingEventCreated = "tracking_event.created"
+
+// Shipment milestones that only customer notifications consume; they are not
+// outbound webhook event types.
+const (
+    outboxShipmentOutForDelivery = "shipment.out_for_delivery"
+    outboxShipmentException      = "shipment.exception"
+)
+
+// Outbox aggregates: messages of one aggregate are published in order.
+const (
+    outboxAggregateShipment = "shipment"
+    outboxAggregateTracker  = "tracker"
+)
+
+// Outbox statuses. Pending messages are due at next_attempt_at; dead ones ran
+// out of attempts and no longer hold back their aggregate.
+const (
+    outboxStatusPending   = "pending"
+    outboxStatusPublished = "published"
+    outboxStatusDead      = "dead"
+)
+
+// Retry delays double per attempt; they are capped at five minutes since a
+// failing message holds back the rest of its aggregate.
+const (
+    outboxBackoffBase = time.Second
+    outboxBackoffMax  = 5 * time.Minute
+)
+
+// OutboxMessage is a domain event written in the transaction of its change.
+type OutboxMessage struct {
+    // ID orders messages; EventID identifies the event to consumers.
+    ID            int64
+    EventID       uuid.UUID
+    OrgID         *uuid.UUID
+    AggregateType string
+    AggregateID   uuid.UUID
+    Type          string
+    Payload       json.RawMessage
+    CreatedAt     time.Time
+}
+
+// OutboxConsumer receives every outbox message. Handle runs in the relay's
+// transaction, which also marks the message published, so database writes
+// are applied exactly once; side effects outside the database may repeat
+// when a later consumer fails, so Handle must tolerate redelivery.
+type OutboxConsumer interface {
+    Name() string
+    Handle(ctx context.Context, tx pgx.Tx, m OutboxMessage) error
+}
+
+// writeOutbox records a message in the caller's transaction, so it exists
+// exactly when the change commits.
+func writeOutbox(ctx context.Context, q dbtx, orgID *uuid.UUID, aggregateType string, aggregateID uuid.UUID, eventType string, payload any) error {
+    raw, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+    _, err = q.Exec(ctx, `
+        INSERT INTO outbox (org_id, aggregate_type, aggregate_id, type, payload)
+        VALUES ($1, $2, $3, $4, $5::jsonb)
+    `, orgID, aggregateType, aggregateID, eventType, string(raw))
+    return err
+}
+
+// outboxShipmentEvent records a shipment event carrying the shipment as
+// served by GET /shipments/{id}.
+func outboxShipmentEvent(ctx context.Context, q dbtx, orgID, shipmentID uuid.UUID, eventType string) error {
+    sh, err := loadShipment(ctx, q, shipmentID)
+    if err != nil {
+        return err
+    }
+    return writeOutbox(ctx, q, &orgID, outboxAggregateShipment, shipmentID, eventType, map[string]any{"shipment": sh})
+}
+
+// outboxExceptionRaised records a raised exception of a shipment with the
+// shipment and the exception.
+func outboxExceptionRaised(ctx context.Context, q dbtx, shipmentID, exceptionID uuid.UUID) error {
+    var (
+        orgID      uuid.UUID
+        ex         ShipmentException
+        detail     string
+        detectedAt time.Time
+    )
+    err := q.QueryRow(ctx, `
+        SELECT s.org_id, t.carrier_tracking_code, x.reason, x.detail, x.detected_at
+        FROM tracking_exceptions x
+        JOIN trackers t ON t.id = x.tracker_id
+        JOIN shipments s ON s.id = x.shipment_id
+        WHERE x.id = $1
+    `, exceptionID).Scan(&orgID, &ex.TrackingCode, &ex.Reason, &detail, &detectedAt)
+    if err != nil {
+        return err
+    }
+    ex.ID = exceptionID.String()
+    ex.Detail = json.RawMessage(detail)
+    ex.DetectedAt = detectedAt.UTC().Format(time.RFC3339)
+    sh, err := loadShipment(ctx, q, shipmentID)
+    if err != nil {
+        return err
+    }
+    return writeOutbox(ctx, q, &orgID, outboxAggregateShipment, shipmentID, outboxShipmentException,
+        map[string]any{"shipment": sh, "exception": ex})
+}
+
+// outboxTrackerUpdated records tracker.updated with the tracker as served by
+// GET /trackers/{code}.
+func outboxTrackerUpdated(ctx context.Context, q dbtx, trackerID uuid.UUID) error {
+    var (
+        code  string
+        orgID *uuid.UUID
+    )
+    err := q.QueryRow(ctx, `
+        SELECT t.carrier_tracking_code, s.org_id
+        FROM trackers t LEFT JOIN shipments s ON s.id = t.shipment_id
+        WHERE t.id = $1
+    `, trackerID).Scan(&code, &orgID)
+    if err != nil {
+        return err
+    }
+    tr, err := loadTracker(ctx, q, code)
+    if err != nil {
+        return err
+    }
+    return writeOutbox(ctx, q, orgID, outboxAggregateTracker, trackerID, eventTrackerUpdated, map[string]any{"tracker": tr})
+}
+
+// outboxTrackingEvent records an inserted tracking event for live streams.
+func outboxTrackingEvent(ctx context.Context, q dbtx, trackerID uuid.UUID, seq int64) error {
+    orgID, err := trackerOrgID(ctx, q, trackerID)
+    if err != nil {
+        return err
+    }
+    return writeOutbox(ctx, q, orgID, outboxAggregateTracker, trackerID, outboxTrackingEventCreated,
+        streamNotice{Seq: seq, TrackerID: trackerID, OrgID: orgID})
+}
+
+// webhookOutboxConsumer turns org events into outbound webhook deliveries.
+type webhookOutboxConsumer struct{}
+
+func (webhookOutboxConsumer) Name() string { return "webhooks" }
+
+func (webhookOutboxConsumer) Handle(ctx context.Context, tx pgx.Tx, m OutboxMessage) error {
+    if m.OrgID == nil || !isWebhookEventType(m.Type) {
+        return nil
+    }
+    return emitEvent(ctx, tx, m.EventID, *m.OrgID, m.Type, m.CreatedAt, m.Payload)
+}
+
+// streamOutboxConsumer announces tracking events to the SSE streams of every
+// replica; the notification is sent when the relay commits. It first gives
+// the event its stream position from tracking_stream_counter, whose row lock
+// is held until the relay commits, so positions are visible in commit order
+// even with concurrent relays.
+type streamOutboxConsumer struct{}
+
+func (streamOutboxConsumer) Name() string { return "streams" }
+
+func (streamOutboxConsumer) Handle(ctx context.Context, tx pgx.Tx, m OutboxMessage) error {
+    if m.Type != outboxTrackingEventCreated {
+        return nil
+    }
+    var notice streamNotice
+    if err := json.Unmarshal(m.Payload, &notice); err != nil {
+        return err
+    }
+    // Only take the counter lock for an event that still needs a position: a
+    // redelivered message or a deleted event must not bump the counter.
+    var pos *int64
+    err := tx.QueryRow(ctx, `SELECT stream_seq FROM tracking_events WHERE seq = $1 FOR UPDATE`, notice.Seq).Scan(&pos)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if pos == nil {
+        if _, err := tx.Exec(ctx, `
+            WITH pos AS (
+                UPDATE tracking_stream_counter SET last_seq = last_seq + 1 RETURNING last_seq
+            )
+            UPDATE tracking_events SET stream_seq = (SELECT last_seq FROM pos) WHERE seq = $1
+        `, notice.Seq); err != nil {
+            return err
+        }
+    }
+    _, err = tx.Exec(ctx, `SELECT pg_notify($1, $2)`, trackingEventsChannel, string(m.Payload))
+    return err
+}
+
+// OutboxRelayConfig controls the outbox relay. Zero values use the defaults.
+type OutboxRelayConfig struct {
+    // Tick is how often due messages are looked for (default 250ms).
+    Tick time.Duration
+    // Concurrency is the number of messages published at once (default 4).
+    // Tracking events all take the single tracking_stream_counter row lock
+    // until their relay transaction commits, so across every replica they
+    // are published one at a time: their throughput is capped at one commit
+    // round trip per event however high this is set.
+    Concurrency int
+    // MaxAttempts dead-letters a message after this many failures (default 15).
+    MaxAttempts int
+    // Retention is how long published messages are kept (default 7 days).
+    Retention time.Duration
+}
+
+// OutboxRelay publishes outbox messages to its consumers, at least once.
+// Messages are claimed with SKIP LOCKED, so every replica can run one; a
+// message is only claimed once no earlier message of its aggregate is
+// pending, so each aggregate's messages are published in order and a failing
+// message holds back only its own aggregate.
+type OutboxRelay struct {
+    db        *pgxpool.Pool
+    consumers []OutboxConsumer
+    cfg       OutboxRelayConfig
+    now       func() time.Time
+}
+
+// NewOutboxRelay creates a relay publishing to the outbound webhook and
+// stream consumers, then to any extra consumers in order.
+func NewOutboxRelay(db *pgxpool.Pool, cfg OutboxRelayConfig, extra ...OutboxConsumer) *OutboxRelay {
+    if cfg.Tick <= 0 {
+        cfg.Tick = 250 * time.Millisecond
+    }
+    if cfg.Concurrency <= 0 {
+        cfg.Concurrency = 4
+    }
+    if cfg.MaxAttempts <= 0 {
+        cfg.MaxAttempts = 15
+    }
+    if cfg.Retention <= 0 {
+        cfg.Retention = 7 * 24 * time.Hour
+    }
+    consumers := append([]OutboxConsumer{webhookOutboxConsumer{}, streamOutboxConsumer{}}, extra...)
+    return &OutboxRelay{db: db, consumers: consumers, cfg: cfg, now: time.Now}
+}
+
+// Run publishes due messages until ctx is done.
+func (r *OutboxRelay) Run(ctx context.Context) {
+    jobs.Poll(ctx, "outbox relay", r.cfg.Tick, r.PublishPending, r.prune)
+}
+
+// PublishPending publishes due messages until none are left and returns how
+// many it handled.
+func (r *OutboxRelay) PublishPending(ctx context.Context) (int, error) {
+    return jobs.Drain(ctx, r.cfg.Concurrency, r.publishNext)
+}
+
+// publishNext claims the next publishable message and hands it to every
+// consumer. Consumers run in a savepoint so their failure can be recorded on
+// the claimed message. It reports false when nothing was due.
+func (r *OutboxRelay) publishNext(ctx context.Context) (bool, error) {
+    tx, err := r.db.Begin(ctx)
+    if err != nil {
+        return false, err
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+    var (
+        m        OutboxMessage
+        attempts int
+    )
+    err = tx.QueryRow(ctx, `
+        SELECT o.id, o.event_id, o.org_id, o.aggregate_type, o.aggregate_id, o.type, o.payload, o.created_at, o.attempts
+        FROM outbox o
+        WHERE o.status = 'pending' AND o.next_attempt_at <= NOW()
+          AND NOT EXISTS (
+              SELECT 1 FROM outbox p
+              WHERE p.aggregate_id = o.aggregate_id AND p.status = 'pending' AND p.id < o.id)
+        ORDER BY o.id
+        LIMIT 1
+        FOR UPDATE OF o SKIP LOCKED
+    `).Scan(&m.ID, &m.EventID, &m.OrgID, &m.AggregateType, &m.AggregateID, &m.Type, &m.Payload, &m.CreatedAt, &attempts)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return false, nil
+        }
+        return false, err
+    }
+
+    perr := r.publish(ctx, tx, m)
+    attempts++
+    if perr == nil {
+        _, err = tx.Exec(ctx, `
+            UPDATE outbox
+            SET status = 'published', attempts = $2, last_error = NULL, published_at = NOW()
+            WHERE id = $1
+        `, m.ID, attempts)
+    } else {
+        status, next := outboxStatusPending, r.now().Add(jobs.Backoff(attempts, outboxBackoffBase, outboxBackoffMax, nil))
+        if attempts >= r.cfg.MaxAttempts {
+            status = outboxStatusDead
+            log.Printf("outbox relay: message %d (%s) dead-lettered after %d attempts: %v", m.ID, m.Type, attempts, perr)
+        }
+        _, err = tx.Exec(ctx, `
+            UPDATE outbox
+            SET status = $2, attempts = $3, next_attempt_at = $4, last_error = $5
+            WHERE id = $1
+        `, m.ID, status, attempts, next, perr.Error())
+    }
+    if err != nil {
+        return true, err
+    }
+    return true, tx.Commit(ctx)
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, tx pgx.Tx, m OutboxMessage) error {
+    sp, err := tx.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    for _, c := range r.consumers {
+        if err := c.Handle(ctx, sp, m); err != nil {
+            _ = sp.Rollback(ctx)
+            return fmt.Errorf("%s: %w", c.Name(), err)
+        }
+    }
+    return sp.Commit(ctx)
+}
+
+// prune deletes published messages past retention; dead messages are kept
+// for inspection.
+func (r *OutboxRelay) prune(ctx context.Context) error {
+    _, err := r.db.Exec(ctx, `
+        DELETE FROM outbox WHERE status = 'published' AND published_at < $1
+    `, r.now().Add(-r.cfg.Retention))
+    return err
+}
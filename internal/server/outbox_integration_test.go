@@ -0,0 +1,116 @@
+package server
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "sync"
+    "testing"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+
+    "deliveryinfra/internal/db"
+)
+
+// recordingConsumer records the messages of the given aggregates and fails
+// while failures[aggregate] > 0.
+type recordingConsumer struct {
+    mu       sync.Mutex
+    watch    map[uuid.UUID]bool
+    failures map[uuid.UUID]int
+    got      []string
+}
+
+func (c *recordingConsumer) Name() string { return "recording" }
+
+func (c *recordingConsumer) Handle(ctx context.Context, tx pgx.Tx, m OutboxMessage) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if !c.watch[m.AggregateID] {
+        return nil
+    }
+    if c.failures[m.AggregateID] > 0 {
+        c.failures[m.AggregateID]--
+        return errors.New("consumer down")
+    }
+    c.got = append(c.got, m.Type)
+    return nil
+}
+
+func TestOutboxRelay_OrderPerAggregate(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+    ctx := t.Context()
+
+    a, b, dead := uuid.New(), uuid.New(), uuid.New()
+    write := func(agg uuid.UUID, typ string) {
+        t.Helper()
+        if err := writeOutbox(ctx, pool, nil, "test", agg, typ, map[string]any{}); err != nil {
+            t.Fatalf("write outbox: %v", err)
+        }
+    }
+
+    // Nothing is written when the domain transaction rolls back
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := writeOutbox(ctx, tx, nil, "test", a, "a.rolled_back", map[string]any{}); err != nil {
+        t.Fatal(err)
+    }
+    _ = tx.Rollback(ctx)
+
+    write(a, "a.1")
+    write(a, "a.2")
+    write(b, "b.1")
+    write(dead, "dead.1")
+    write(dead, "dead.2")
+
+    c := &recordingConsumer{
+        watch:    map[uuid.UUID]bool{a: true, b: true, dead: true},
+        failures: map[uuid.UUID]int{a: 1, dead: 100},
+    }
+    relay := NewOutboxRelay(pool, OutboxRelayConfig{Concurrency: 2, MaxAttempts: 2}, c)
+    due := func() {
+        t.Helper()
+        if _, err := pool.Exec(ctx, `UPDATE outbox SET next_attempt_at = NOW() WHERE aggregate_id = ANY($1) AND status = 'pending'`, []uuid.UUID{a, b, dead}); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := relay.PublishPending(ctx); err != nil {
+            t.Fatalf("publish: %v", err)
+        }
+    }
+
+    // a.1 fails and holds back a.2, but not b.1
+    due()
+    if fmt.Sprint(c.got) != "[b.1]" {
+        t.Fatalf("after first pass got %v", c.got)
+    }
+    // a resumes in order; dead.1 runs out of attempts and releases dead.2
+    due()
+    due()
+    if fmt.Sprint(c.got) != "[b.1 a.1 a.2]" {
+        t.Fatalf("after retries got %v", c.got)
+    }
+    var status string
+    _ = pool.QueryRow(ctx, `SELECT status FROM outbox WHERE aggregate_id = $1 AND type = 'dead.1'`, dead).Scan(&status)
+    if status != outboxStatusDead {
+        t.Fatalf("dead.1 status = %q", status)
+    }
+    var rolledBack int
+    _ = pool.QueryRow(ctx, `SELECT COUNT(*) FROM outbox WHERE type = 'a.rolled_back' AND aggregate_id = $1`, a).Scan(&rolledBack)
+    if rolledBack != 0 {
+        t.Fatalf("rolled back message was written")
+    }
+    _, _ = pool.Exec(context.Background(), `DELETE FROM outbox WHERE aggregate_id = ANY($1)`, []uuid.UUID{a, b, dead})
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+    "testing"
+    "time"
+
+    "deliveryinfra/internal/jobs"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+    cases := map[int]time.Duration{
+        1:  time.Second,
+        2:  2 * time.Second,
+        5:  16 * time.Second,
+        9:  256 * time.Second,
+        10: 5 * time.Minute,
+        50: 5 * time.Minute,
+    }
+    for attempts, want := range cases {
+        if got := jobs.Backoff(attempts, outboxBackoffBase, outboxBackoffMax, nil); got != want {
+            t.Errorf("outbox backoff(%d) = %v, want %v", attempts, got, want)
+        }
+    }
+}
+
+func TestIsWebhookEventType(t *testing.T) {
+    if !isWebhookEventType(eventShipmentCreated) || !isWebhookEventType(eventTrackerUpdated) {
+        t.Fatalf("expected webhook event types")
+    }
+    if isWebhookEventType(outboxTrackingEventCreated) || isWebhookEventType(eventWebhookTest) {
+        t.Fatalf("internal and test events must not fan out to subscriptions")
+    }
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "deliveryinfra/internal/tracking"
+)
+
+// pollerLockName identifies the advisory lock held by the leading poller.
+const pollerLockName = "deliveryinfra.tracking_poller"
+
+// PollerConfig controls the tracking poller. Zero values use the defaults.
+type PollerConfig struct {
+    // Tick is how often due trackers are looked up (default 30s).
+    Tick time.Duration
+    // BatchSize caps trackers polled per tick (default 200).
+    BatchSize int
+    // Concurrency is the default number of in-flight requests per carrier (default 4).
+    Concurrency int
+    // CarrierConcurrency overrides Concurrency for individual carriers.
+    CarrierConcurrency map[string]int
+    // Carriers restricts polling to these carriers, e.g. those without webhooks.
+    // Empty polls every tracker with a carrier.
+    Carriers []string
+}
+
+// Poller periodically fetches tracking events for active trackers from a
+// Provider and ingests them like webhook events. Every replica may run one;
+// only the holder of a Postgres advisory lock polls.
+type Poller struct {
+    db       *pgxpool.Pool
+    srv      *Server
+    provider tracking.Provider
+    cfg      PollerConfig
+    limiter  *carrierLimiter
+    leader   *advisoryLeader
+}
+
+func NewPoller(db *pgxpool.Pool, provider tracking.Provider, cfg PollerConfig) *Poller {
+    if cfg.Tick <= 0 {
+        cfg.Tick = 30 * time.Second
+    }
+    if cfg.BatchSize <= 0 {
+        cfg.BatchSize = 200
+    }
+    if cfg.Concurrency <= 0 {
+        cfg.Concurrency = 4
+    }
+    carriers := make([]string, 0, len(cfg.Carriers))
+    for _, c := range cfg.Carriers {
+        if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+            carriers = append(carriers, c)
+        }
+    }
+    cfg.Carriers = carriers
+    if provider == nil {
+        provider = tracking.NewNoop()
+    }
+    return &Poller{
+        db:       db,
+        srv:      &Server{db: db},
+        provider: provider,
+        cfg:      cfg,
+        limiter:  newCarrierLimiter(cfg.Concurrency, cfg.CarrierConcurrency),
+        leader:   newAdvisoryLeader(db, pollerLockName),
+    }
+}
+
+// Run polls until ctx is done, taking over leadership whenever it is free.
+func (p *Poller) Run(ctx context.Context) {
+    ticker := time.NewTicker(p.cfg.Tick)
+    defer ticker.Stop()
+    defer p.resign()
+    for {
+        leading, err := p.ensureLeader(ctx)
+        if err != nil {
+            log.Println("tracking poller: leader election error:", err)
+        } else if leading {
+            if err := p.pollOnce(ctx); err != nil {
+                log.Println("tracking poller: poll error:", err)
+            }
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// ensureLeader reports whether this poller holds the advisory lock, trying to
+// take it when not.
+func (p *Poller) ensureLeader(ctx context.Context) (bool, error) {
+    return p.leader.ensure(ctx)
+}
+
+func (p *Poller) resign() {
+    p.leader.resign()
+}
+
+type dueTracker struct {
+    ID       uuid.UUID
+    Code     string
+    Carrier  string
+    Failures int
+}
+
+// pollOnce polls every due tracker, at most the configured number per carrier
+// at a time.
+func (p *Poller) pollOnce(ctx context.Context) error {
+    due, err := p.dueTrackers(ctx)
+    if err != nil {
+        return err
+    }
+    var wg sync.WaitGroup
+    for _, tr := range due {
+        wg.Add(1)
+        go func(tr dueTracker) {
+            defer wg.Done()
+            if !p.limiter.acquire(ctx, tr.Carrier) {
+                return
+            }
+            defer p.limiter.release(tr.Carrier)
+            p.pollTracker(ctx, tr)
+        }(tr)
+    }
+    wg.Wait()
+    return nil
+}
+
+func (p *Poller) dueTrackers(ctx context.Context) ([]dueTracker, error) {
+    var carriers []string
+    if len(p.cfg.Carriers) > 0 {
+        carriers = p.cfg.Carriers
+    }
+    rows, err := p.db.Query(ctx, `
+        SELECT id, carrier_tracking_code, carrier_code, poll_failures
+        FROM trackers
+        WHERE carrier_code IS NOT NULL
+          AND status IS DISTINCT FROM 'delivered' AND status IS DISTINCT FROM 'return_to_sender'
+          AND (next_poll_at IS NULL OR next_poll_at <= now())
+          AND ($1::text[] IS NULL OR carrier_code = ANY($1))
+        ORDER BY next_poll_at NULLS FIRST
+        LIMIT $2
+    `, carriers, p.cfg.BatchSize)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var due []dueTracker
+    for rows.Next() {
+        var tr dueTracker
+        if err := rows.Scan(&tr.ID, &tr.Code, &tr.Carrier, &tr.Failures); err != nil {
+            return nil, err
+        }
+        due = append(due, tr)
+    }
+    return due, rows.Err()
+}
+
+// pollTracker fetches and ingests one tracker's events, then schedules its
+// next poll from the resulting status. Failures back off.
+func (p *Poller) pollTracker(ctx context.Context, tr dueTracker) {
+    events, err := p.provider.Track(ctx, tr.Carrier, tr.Code)
+    if err != nil {
+        log.Printf("tracking poller: %s %s: %v", tr.Carrier, tr.Code, err)
+        p.schedule(ctx, tr, tr.Failures+1)
+        return
+    }
+    if err := p.srv.ingestProviderEvents(ctx, tr.Carrier, tr.Code, events); err != nil {
+        log.Printf("tracking poller: ingest %s %s: %v", tr.Carrier, tr.Code, err)
+        p.schedule(ctx, tr, tr.Failures+1)
+        return
+    }
+    p.schedule(ctx, tr, 0)
+}
+
+// ingestProviderEvents normalizes events fetched from a tracking provider and
+// ingests them like webhook events, stopping at the first failure. Events
+// without a timestamp are skipped: providers return the full history on every
+// fetch, so stamping them with the fetch time would add a new row each poll.
+func (s *Server) ingestProviderEvents(ctx context.Context, carrier, code string, events []tracking.ProviderEvent) error {
+    for _, ev := range events {
+        if ev.OccurredAt.IsZero() {
+            log.Printf("tracking provider: skipping %s %s event without occurred_at", carrier, code)
+            continue
+        }
+        occurred := ev.OccurredAt.UTC()
+        req := normalizeTrackerEvent(carrier, TrackerEventRequest{
+            Status:      ev.Status,
+            Substatus:   ev.Substatus,
+            Description: ev.Description,
+            Location:    json.RawMessage(jsonOrEmpty(ev.Location)),
+            Raw:         json.RawMessage(jsonOrEmpty(ev.Raw)),
+        })
+        if err := s.insertTrackerEvent(ctx, code, req, occurred); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (p *Poller) schedule(ctx context.Context, tr dueTracker, failures int) {
+    var status string
+    if err := p.db.QueryRow(ctx, `SELECT COALESCE(status, 'unknown') FROM trackers WHERE id = $1`, tr.ID).Scan(&status); err != nil {
+        log.Printf("tracking poller: load %s: %v", tr.Code, err)
+        return
+    }
+    var next *time.Time
+    if d, ok := tracking.PollInterval(tracking.Status(status), failures); ok {
+        t := time.Now().Add(d)
+        next = &t
+    }
+    _, err := p.db.Exec(ctx, `
+        UPDATE trackers SET last_polled_at = now(), next_poll_at = $2, poll_failures = $3 WHERE id = $1
+    `, tr.ID, next, failures)
+    if err != nil {
+        log.Printf("tracking poller: schedule %s: %v", tr.Code, err)
+    }
+}
+
+// carrierLimiter bounds in-flight provider calls per carrier so one slow or
+// rate-limited carrier cannot starve the others.
+type carrierLimiter struct {
+    mu     sync.Mutex
+    def    int
+    limits map[string]int
+    sems   map[string]chan struct{}
+}
+
+func newCarrierLimiter(def int, limits map[string]int) *carrierLimiter {
+    l := &carrierLimiter{def: def, limits: map[string]int{}, sems: map[string]chan struct{}{}}
+    for c, n := range limits {
+        l.limits[strings.ToLower(strings.TrimSpace(c))] = n
+    }
+    return l
+}
+
+func (l *carrierLimiter) sem(carrier string) chan struct{} {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    s, ok := l.sems[carrier]
+    if !ok {
+        n := l.def
+        if v, ok := l.limits[carrier]; ok && v > 0 {
+            n = v
+        }
+        s = make(chan struct{}, n)
+        l.sems[carrier] = s
+    }
+    return s
+}
+
+// acquire blocks until a slot for carrier is free; false means ctx ended first.
+func (l *carrierLimiter) acquire(ctx context.Context, carrier string) bool {
+    select {
+    case l.sem(carrier) <- struct{}{}:
+        return true
+    case <-ctx.Done():
+        return false
+    }
+}
+
+func (l *carrierLimiter) release(carrier string) {
+    <-l.sem(carrier)
+}
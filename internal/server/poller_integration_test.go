@@ -0,0 +1,167 @@
+package server
+
+import (
+    "context"
+    "os"
+    "sync"
+    "testing"
+    "time"
+
+    "deliveryinfra/internal/db"
+    "deliveryinfra/internal/tracking"
+)
+
+type fakeProvider struct {
+    mu     sync.Mutex
+    events map[string][]tracking.ProviderEvent
+    calls  int
+}
+
+func (f *fakeProvider) Track(ctx context.Context, carrier, number string) ([]tracking.ProviderEvent, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.calls++
+    return f.events[number], nil
+}
+
+func TestPollerIngestsAndSchedules(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    codes := []string{"ITESTPOLL001", "ITESTPOLL002"}
+    _, _ = pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = ANY($1)`, codes)
+    defer pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = ANY($1)`, codes)
+    for _, code := range codes {
+        if _, err := registerTracker(t.Context(), pool, code, "itestpoll", nil); err != nil {
+            t.Fatalf("register: %v", err)
+        }
+    }
+
+    base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+    provider := &fakeProvider{events: map[string][]tracking.ProviderEvent{
+        codes[0]: {
+            {Status: "in_transit", OccurredAt: base},
+            {Status: "out_for_delivery", OccurredAt: base.Add(time.Hour)},
+        },
+        codes[1]: {
+            {Status: "delivered", OccurredAt: base},
+        },
+    }}
+    p := NewPoller(pool, provider, PollerConfig{Carriers: []string{"itestpoll"}})
+    defer p.resign()
+
+    // Only one poller can lead at a time
+    leading, err := p.ensureLeader(t.Context())
+    if err != nil || !leading {
+        t.Fatalf("expected leadership, got %v %v", leading, err)
+    }
+    other := NewPoller(pool, provider, PollerConfig{Carriers: []string{"itestpoll"}})
+    if leading, err := other.ensureLeader(t.Context()); err != nil || leading {
+        t.Fatalf("second poller must not lead, got %v %v", leading, err)
+    }
+
+    if err := p.pollOnce(t.Context()); err != nil {
+        t.Fatalf("poll: %v", err)
+    }
+    if provider.calls != 2 {
+        t.Fatalf("expected 2 provider calls, got %d", provider.calls)
+    }
+
+    var (
+        status string
+        next   *time.Time
+    )
+    if err := pool.QueryRow(t.Context(), `SELECT status, next_poll_at FROM trackers WHERE carrier_tracking_code = $1`, codes[0]).Scan(&status, &next); err != nil {
+        t.Fatalf("load: %v", err)
+    }
+    if status != "out_for_delivery" || next == nil || time.Until(*next) > 31*time.Minute {
+        t.Fatalf("expected out_for_delivery polled again within 30m, got %s %v", status, next)
+    }
+    if err := pool.QueryRow(t.Context(), `SELECT status, next_poll_at FROM trackers WHERE carrier_tracking_code = $1`, codes[1]).Scan(&status, &next); err != nil {
+        t.Fatalf("load: %v", err)
+    }
+    if status != "delivered" || next != nil {
+        t.Fatalf("expected delivered tracker to stop polling, got %s %v", status, next)
+    }
+
+    // Nothing is due on the next tick
+    if err := p.pollOnce(t.Context()); err != nil {
+        t.Fatalf("poll: %v", err)
+    }
+    if provider.calls != 2 {
+        t.Fatalf("expected no further provider calls, got %d", provider.calls)
+    }
+
+    // Leadership passes on once the leader resigns
+    p.resign()
+    if leading, err := other.ensureLeader(t.Context()); err != nil || !leading {
+        t.Fatalf("expected takeover after resign, got %v %v", leading, err)
+    }
+    other.resign()
+}
+
+func TestPollerRepeatedResponseDeduplicates(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    code := "ITESTPOLLDUP001"
+    _, _ = pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = $1`, code)
+    defer pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = $1`, code)
+    if _, err := registerTracker(t.Context(), pool, code, "itestpoll", nil); err != nil {
+        t.Fatalf("register: %v", err)
+    }
+
+    // The provider returns the same history on every fetch, one event undated
+    provider := &fakeProvider{events: map[string][]tracking.ProviderEvent{
+        code: {
+            {Status: "in_transit", OccurredAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+            {Status: "in_transit", Description: "Arrived at hub"},
+        },
+    }}
+    p := NewPoller(pool, provider, PollerConfig{Carriers: []string{"itestpoll"}})
+    defer p.resign()
+    if leading, err := p.ensureLeader(t.Context()); err != nil || !leading {
+        t.Fatalf("expected leadership, got %v %v", leading, err)
+    }
+
+    for i := 0; i < 2; i++ {
+        if _, err := pool.Exec(t.Context(), `UPDATE trackers SET next_poll_at = now() WHERE carrier_tracking_code = $1`, code); err != nil {
+            t.Fatalf("make due: %v", err)
+        }
+        if err := p.pollOnce(t.Context()); err != nil {
+            t.Fatalf("poll: %v", err)
+        }
+    }
+    if provider.calls != 2 {
+        t.Fatalf("expected 2 provider calls, got %d", provider.calls)
+    }
+
+    var n int
+    if err := pool.QueryRow(t.Context(), `
+        SELECT COUNT(*) FROM tracking_events e JOIN trackers t ON t.id = e.tracker_id
+        WHERE t.carrier_tracking_code = $1
+    `, code).Scan(&n); err != nil {
+        t.Fatalf("count events: %v", err)
+    }
+    if n != 1 {
+        t.Fatalf("expected the dated event once and the undated one skipped, got %d events", n)
+    }
+}
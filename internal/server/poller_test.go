@@ -0,0 +1,58 @@
+package server
+
+import (
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestCarrierLimiter_BoundsPerCarrier(t *testing.T) {
+    l := newCarrierLimiter(3, map[string]int{"DHL": 1})
+    var (
+        wg            sync.WaitGroup
+        inFlight, max = map[string]*int32{"dhl": new(int32), "ups": new(int32)}, map[string]*int32{"dhl": new(int32), "ups": new(int32)}
+    )
+    for i := 0; i < 20; i++ {
+        for _, c := range []string{"dhl", "ups"} {
+            wg.Add(1)
+            go func(c string) {
+                defer wg.Done()
+                if !l.acquire(t.Context(), c) {
+                    t.Error("acquire failed")
+                    return
+                }
+                defer l.release(c)
+                n := atomic.AddInt32(inFlight[c], 1)
+                for {
+                    m := atomic.LoadInt32(max[c])
+                    if n <= m || atomic.CompareAndSwapInt32(max[c], m, n) {
+                        break
+                    }
+                }
+                time.Sleep(time.Millisecond)
+                atomic.AddInt32(inFlight[c], -1)
+            }(c)
+        }
+    }
+    wg.Wait()
+    if got := atomic.LoadInt32(max["dhl"]); got != 1 {
+        t.Fatalf("dhl override should allow 1 in flight, saw %d", got)
+    }
+    if got := atomic.LoadInt32(max["ups"]); got < 1 || got > 3 {
+        t.Fatalf("ups default should allow at most 3 in flight, saw %d", got)
+    }
+}
+
+func TestNewPoller_Defaults(t *testing.T) {
+    p := NewPoller(nil, nil, PollerConfig{Carriers: []string{" DHL ", ""}})
+    if p.cfg.Tick != 30*time.Second || p.cfg.BatchSize != 200 || p.cfg.Concurrency != 4 {
+        t.Fatalf("unexpected defaults: %+v", p.cfg)
+    }
+    if len(p.cfg.Carriers) != 1 || p.cfg.Carriers[0] != "dhl" {
+        t.Fatalf("unexpected carriers: %v", p.cfg.Carriers)
+    }
+    if p.provider == nil {
+        t.Fatalf("expected noop provider")
+    }
+}
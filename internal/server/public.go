@@ -0,0 +1,484 @@
+package server
+
+import (
+    "context"
+    "embed"
+    "encoding/json"
+    "errors"
+    "html/template"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+
+    "deliveryinfra/internal/tracking"
+)
+
+//go:embed templates/track.html
+var publicTemplates embed.FS
+
+var trackPage = template.Must(template.ParseFS(publicTemplates, "templates/track.html"))
+
+const (
+    defaultBrandColor = "#1a73e8"
+    publicEventLimit  = 100
+)
+
+var brandColorRe = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// errPublicNotFound covers unknown orgs, unknown numbers and numbers of other
+// orgs alike, so responses do not reveal which one it was.
+var errPublicNotFound = errors.New("tracking number not found")
+
+// Public tracking (no auth). Only PII-minimized fields are exposed: no
+// addresses beyond city/state/country, no names, and no carrier event
+// descriptions, which often include signer names.
+type PublicLocation struct {
+    City    string `json:"city,omitempty"`
+    State   string `json:"state,omitempty"`
+    Country string `json:"country,omitempty"`
+}
+
+type PublicBranding struct {
+    Name         string `json:"name"`
+    LogoURL      string `json:"logo_url,omitempty"`
+    PrimaryColor string `json:"primary_color"`
+    SupportURL   string `json:"support_url,omitempty"`
+}
+
+type PublicTrackingEvent struct {
+    OccurredAt  string          `json:"occurred_at"`
+    Status      string          `json:"status"`
+    StatusLabel string          `json:"status_label"`
+    Location    *PublicLocation `json:"location,omitempty"`
+}
+
+type PublicTrackingResponse struct {
+    Code              string                `json:"code"`
+    CarrierCode       string                `json:"carrier_code,omitempty"`
+    Status            string                `json:"status"`
+    StatusLabel       string                `json:"status_label"`
+    Substatus         string                `json:"substatus,omitempty"`
+    EstimatedDelivery string                `json:"estimated_delivery,omitempty"`
+    LastEventAt       string                `json:"last_event_at,omitempty"`
+    Destination       *PublicLocation       `json:"destination,omitempty"`
+    Org               PublicBranding        `json:"org"`
+    Events            []PublicTrackingEvent `json:"events"`
+}
+
+// handlePublicTrackingJSON serves GET /public/trackers/{org_slug}/{code}.
+func (s *Server) handlePublicTrackingJSON(w http.ResponseWriter, r *http.Request) {
+    lang := publicLang(r)
+    resp, status := s.lookupPublicTracking(r, lang)
+    w.Header().Set("Cache-Control", publicCacheControl(status))
+    switch status {
+    case http.StatusOK:
+        writeJSON(w, http.StatusOK, resp)
+    case http.StatusNotFound:
+        writeErrorJSON(w, http.StatusNotFound, "resource_not_found", errPublicNotFound.Error())
+    case http.StatusTooManyRequests:
+        writeErrorJSON(w, http.StatusTooManyRequests, "rate_limited", "too many lookups; try again later")
+    default:
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+    }
+}
+
+// handlePublicTrackingPage serves the server-rendered page at /track/{org_slug}/{code}.
+func (s *Server) handlePublicTrackingPage(w http.ResponseWriter, r *http.Request) {
+    lang := publicLang(r)
+    resp, status := s.lookupPublicTracking(r, lang)
+    // Misses render the default branding: the org's own look would tell a
+    // valid slug from an unknown one.
+    page := trackingPageData{Lang: lang, Msg: publicMessages[lang], Org: PublicBranding{PrimaryColor: defaultBrandColor}}
+    if status == http.StatusOK {
+        page.Org = resp.Org
+        page.Tracking = &resp
+        page.LastUpdate = formatPublicTime(resp.LastEventAt, lang)
+        for _, ev := range resp.Events {
+            page.Events = append(page.Events, pageEvent{
+                StatusLabel: ev.StatusLabel,
+                When:        formatPublicTime(ev.OccurredAt, lang),
+                Where:       ev.Location.String(),
+            })
+        }
+    }
+    h := w.Header()
+    h.Set("Content-Type", "text/html; charset=utf-8")
+    h.Set("Cache-Control", publicCacheControl(status))
+    h.Set("Referrer-Policy", "no-referrer")
+    h.Set("X-Robots-Tag", "noindex")
+    w.WriteHeader(status)
+    if err := trackPage.Execute(w, page); err != nil {
+        log.Println("render tracking page:", err)
+    }
+}
+
+// publicCacheControl lets shared caches keep found trackers briefly; misses,
+// rate limits and errors must not be cached.
+func publicCacheControl(status int) string {
+    if status == http.StatusOK {
+        return "public, max-age=60"
+    }
+    return "no-store"
+}
+
+// lookupPublicTracking resolves the org-scoped tracker and charges the
+// client's lookup budget.
+func (s *Server) lookupPublicTracking(r *http.Request, lang string) (PublicTrackingResponse, int) {
+    client := clientIP(r)
+    if !s.public.allow(client) {
+        return PublicTrackingResponse{}, http.StatusTooManyRequests
+    }
+    slug := strings.TrimSpace(chi.URLParam(r, "org_slug"))
+    code := strings.TrimSpace(chi.URLParam(r, "code"))
+    if slug == "" || code == "" {
+        s.public.charge(client, lookupMissCost)
+        return PublicTrackingResponse{}, http.StatusNotFound
+    }
+    resp, err := loadPublicTracking(r.Context(), s.db, slug, code, lang)
+    switch {
+    case errors.Is(err, errPublicNotFound):
+        s.public.charge(client, lookupMissCost)
+        return PublicTrackingResponse{}, http.StatusNotFound
+    case err != nil:
+        return PublicTrackingResponse{}, http.StatusInternalServerError
+    }
+    s.public.charge(client, lookupHitCost)
+    return resp, http.StatusOK
+}
+
+func loadPublicTracking(ctx context.Context, q dbtx, slug, code, lang string) (PublicTrackingResponse, error) {
+    var (
+        resp    PublicTrackingResponse
+        orgID   uuid.UUID
+        orgName string
+        orgMeta string
+    )
+    err := q.QueryRow(ctx, `SELECT id, name, metadata FROM orgs WHERE slug = $1`, slug).Scan(&orgID, &orgName, &orgMeta)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return resp, errPublicNotFound
+        }
+        return resp, err
+    }
+    resp.Org = orgBranding(orgName, json.RawMessage(orgMeta))
+
+    var (
+        trackerID   uuid.UUID
+        lastEventAt *time.Time
+        predicted   *time.Time
+        shipTo      string
+    )
+    err = q.QueryRow(ctx, `
+        SELECT t.id, t.carrier_tracking_code, COALESCE(t.carrier_code, ''), COALESCE(t.status, 'unknown'),
+               COALESCE(t.substatus, ''), t.last_event_at,
+               COALESCE(t.metadata->>'estimated_delivery', s.metadata->>'estimated_delivery', ''),
+               t.eta_estimated_at, s.ship_to
+        FROM trackers t
+        JOIN shipments s ON s.id = t.shipment_id
+        WHERE s.org_id = $1 AND t.carrier_tracking_code IN ($2, $3)
+        LIMIT 1
+    `, orgID, code, tracking.NormalizeNumber(code)).Scan(&trackerID, &resp.Code, &resp.CarrierCode, &resp.Status,
+        &resp.Substatus, &lastEventAt, &resp.EstimatedDelivery, &predicted, &shipTo)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return resp, errPublicNotFound
+        }
+        return resp, err
+    }
+    resp.StatusLabel = statusLabel(resp.Status, lang)
+    // The merchant's promised date wins; otherwise show the predicted day.
+    if resp.EstimatedDelivery == "" && predicted != nil && resp.Status != string(tracking.StatusDelivered) {
+        resp.EstimatedDelivery = predicted.In(jst).Format("2006-01-02")
+    }
+    if lastEventAt != nil {
+        resp.LastEventAt = lastEventAt.UTC().Format(time.RFC3339)
+    }
+    resp.Destination = publicLocation(json.RawMessage(shipTo))
+
+    rows, err := q.Query(ctx, `
+        SELECT occurred_at, COALESCE(status, 'unknown'), location
+        FROM tracking_events
+        WHERE tracker_id = $1
+        ORDER BY occurred_at DESC, seq DESC
+        LIMIT $2
+    `, trackerID, publicEventLimit)
+    if err != nil {
+        return resp, err
+    }
+    defer rows.Close()
+    resp.Events = []PublicTrackingEvent{}
+    for rows.Next() {
+        var (
+            ev       PublicTrackingEvent
+            occurred time.Time
+            location string
+        )
+        if err := rows.Scan(&occurred, &ev.Status, &location); err != nil {
+            return resp, err
+        }
+        ev.OccurredAt = occurred.UTC().Format(time.RFC3339)
+        ev.StatusLabel = statusLabel(ev.Status, lang)
+        ev.Location = publicLocation(json.RawMessage(location))
+        resp.Events = append(resp.Events, ev)
+    }
+    return resp, rows.Err()
+}
+
+// orgBranding reads orgs.metadata.branding ({"display_name","logo_url",
+// "primary_color","support_url"}), dropping values that are not plain
+// https URLs or hex colours since they end up in HTML and CSS.
+func orgBranding(name string, metadata json.RawMessage) PublicBranding {
+    var meta struct {
+        Branding struct {
+            DisplayName  string `json:"display_name"`
+            LogoURL      string `json:"logo_url"`
+            PrimaryColor string `json:"primary_color"`
+            SupportURL   string `json:"support_url"`
+        } `json:"branding"`
+    }
+    _ = json.Unmarshal(metadata, &meta)
+    b := PublicBranding{Name: name, PrimaryColor: defaultBrandColor}
+    if v := strings.TrimSpace(meta.Branding.DisplayName); v != "" {
+        b.Name = v
+    }
+    if brandColorRe.MatchString(meta.Branding.PrimaryColor) {
+        b.PrimaryColor = meta.Branding.PrimaryColor
+    }
+    b.LogoURL = httpsURL(meta.Branding.LogoURL)
+    b.SupportURL = httpsURL(meta.Branding.SupportURL)
+    return b
+}
+
+func httpsURL(raw string) string {
+    u, err := url.Parse(strings.TrimSpace(raw))
+    if err != nil || u.Scheme != "https" || u.Host == "" {
+        return ""
+    }
+    return u.String()
+}
+
+// publicLocation keeps only city/state/country from a location or address.
+func publicLocation(raw json.RawMessage) *PublicLocation {
+    var m map[string]any
+    if err := json.Unmarshal(raw, &m); err != nil {
+        return nil
+    }
+    str := func(keys ...string) string {
+        for _, k := range keys {
+            if v, ok := m[k].(string); ok && strings.TrimSpace(v) != "" {
+                return strings.TrimSpace(v)
+            }
+        }
+        return ""
+    }
+    loc := PublicLocation{
+        City:    str("city"),
+        State:   str("state", "prefecture"),
+        Country: str("country"),
+    }
+    if loc == (PublicLocation{}) {
+        return nil
+    }
+    return &loc
+}
+
+func (l *PublicLocation) String() string {
+    if l == nil {
+        return ""
+    }
+    var parts []string
+    for _, p := range []string{l.City, l.State, l.Country} {
+        if p != "" {
+            parts = append(parts, p)
+        }
+    }
+    return strings.Join(parts, ", ")
+}
+
+// Page rendering
+type trackingPageData struct {
+    Lang       string
+    Msg        pageMessages
+    Org        PublicBranding
+    Tracking   *PublicTrackingResponse
+    LastUpdate string
+    Events     []pageEvent
+}
+
+type pageEvent struct {
+    StatusLabel string
+    When        string
+    Where       string
+}
+
+type pageMessages struct {
+    Title             string
+    TrackingNumber    string
+    EstimatedDelivery string
+    LastUpdate        string
+    Timeline          string
+    NoEvents          string
+    NotFound          string
+    NotFoundHint      string
+    Support           string
+}
+
+var publicMessages = map[string]pageMessages{
+    "ja": {
+        Title:             "配送状況",
+        TrackingNumber:    "追跡番号",
+        EstimatedDelivery: "お届け予定",
+        LastUpdate:        "最終更新",
+        Timeline:          "配送履歴",
+        NoEvents:          "まだ配送情報がありません。発送後に更新されます。",
+        NotFound:          "追跡番号が見つかりません",
+        NotFoundHint:      "番号をご確認のうえ、しばらくしてから再度お試しください。",
+        Support:           "お問い合わせ",
+    },
+    "en": {
+        Title:             "Delivery status",
+        TrackingNumber:    "Tracking number",
+        EstimatedDelivery: "Estimated delivery",
+        LastUpdate:        "Last updated",
+        Timeline:          "Tracking history",
+        NoEvents:          "No tracking updates yet. This page updates once the parcel ships.",
+        NotFound:          "Tracking number not found",
+        NotFoundHint:      "Please check the number and try again later.",
+        Support:           "Contact support",
+    },
+}
+
+var statusLabels = map[string]map[tracking.Status]string{
+    "ja": {
+        tracking.StatusPreTransit:         "発送準備中",
+        tracking.StatusInTransit:          "輸送中",
+        tracking.StatusOutForDelivery:     "配達中",
+        tracking.StatusDelivered:          "配達完了",
+        tracking.StatusAvailableForPickup: "受け取り可能",
+        tracking.StatusReturnToSender:     "返送",
+        tracking.StatusFailure:            "配達できませんでした",
+        tracking.StatusException:          "配送に問題が発生しています",
+        tracking.StatusUnknown:            "確認中",
+    },
+    "en": {
+        tracking.StatusPreTransit:         "Label created",
+        tracking.StatusInTransit:          "In transit",
+        tracking.StatusOutForDelivery:     "Out for delivery",
+        tracking.StatusDelivered:          "Delivered",
+        tracking.StatusAvailableForPickup: "Ready for pickup",
+        tracking.StatusReturnToSender:     "Returned to sender",
+        tracking.StatusFailure:            "Delivery attempt failed",
+        tracking.StatusException:          "Delivery exception",
+        tracking.StatusUnknown:            "Checking status",
+    },
+}
+
+func statusLabel(status, lang string) string {
+    labels := statusLabels[lang]
+    if l, ok := labels[tracking.Status(status)]; ok {
+        return l
+    }
+    return labels[tracking.StatusUnknown]
+}
+
+// publicLang picks ja or en from ?lang=, then Accept-Language, defaulting to ja.
+func publicLang(r *http.Request) string {
+    if l := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("lang"))); l == "ja" || l == "en" {
+        return l
+    }
+    for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+        tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+        switch base, _, _ := strings.Cut(strings.ToLower(tag), "-"); base {
+        case "ja", "en":
+            return base
+        }
+    }
+    return "ja"
+}
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+func formatPublicTime(rfc3339, lang string) string {
+    t, err := time.Parse(time.RFC3339, rfc3339)
+    if err != nil {
+        return ""
+    }
+    if lang == "ja" {
+        return t.In(jst).Format("2006年1月2日 15:04")
+    }
+    return t.UTC().Format("Jan 2, 2006 15:04 UTC")
+}
+
+// Lookup throttling. Each client gets a budget per window; misses cost far
+// more than hits, so guessing numbers runs dry after a handful of attempts
+// while a customer refreshing their own page is unaffected.
+const (
+    lookupWindow   = 10 * time.Minute
+    lookupBudget   = 60
+    lookupHitCost  = 1
+    lookupMissCost = 10
+    lookupMaxKeys  = 10000
+)
+
+type lookupLimiter struct {
+    mu      sync.Mutex
+    now     func() time.Time
+    clients map[string]*lookupUsage
+}
+
+type lookupUsage struct {
+    start time.Time
+    spent int
+}
+
+func newLookupLimiter() *lookupLimiter {
+    return &lookupLimiter{now: time.Now, clients: map[string]*lookupUsage{}}
+}
+
+func (l *lookupLimiter) usage(client string) *lookupUsage {
+    now := l.now()
+    u, ok := l.clients[client]
+    if !ok || now.Sub(u.start) >= lookupWindow {
+        if len(l.clients) >= lookupMaxKeys {
+            for k, v := range l.clients {
+                if now.Sub(v.start) >= lookupWindow {
+                    delete(l.clients, k)
+                }
+            }
+        }
+        u = &lookupUsage{start: now}
+        l.clients[client] = u
+    }
+    return u
+}
+
+func (l *lookupLimiter) allow(client string) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.usage(client).spent < lookupBudget
+}
+
+func (l *lookupLimiter) charge(client string, cost int) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.usage(client).spent += cost
+}
+
+// clientIP is the connection's remote address. Deployments behind a proxy
+// should install a trusted real-IP middleware in front of the router.
+func clientIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "testing"
+    "time"
+
+    "deliveryinfra/internal/db"
+)
+
+func TestPublicTrackingIntegration(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    _, _ = pool.Exec(t.Context(), `
+        INSERT INTO orgs (slug, name, metadata)
+        VALUES ('public-acme', 'Acme', '{"branding":{"display_name":"Acme Store","primary_color":"#ff6600"}}'),
+               ('public-other', 'Other', '{}')
+        ON CONFLICT (slug) DO NOTHING
+    `)
+
+    h := New(pool)
+    code := fmt.Sprintf("PUB%d", time.Now().UnixNano())
+    body, _ := json.Marshal(map[string]any{
+        "org_slug":        "public-acme",
+        "tracking_number": code,
+        "rate_currency":   "JPY",
+        "ship_to":         map[string]any{"name": "Taro Yamada", "line1": "1-2-3 Shibuya", "city": "Shibuya", "country": "JP"},
+        "ship_from":       map[string]any{"country": "JP"},
+        "package":         map[string]any{"weight_oz": 5},
+    })
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/shipments", bytes.NewReader(body)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("create shipment: %d %s", rr.Code, rr.Body.String())
+    }
+    ev, _ := json.Marshal(map[string]any{"status": "in_transit", "location": map[string]any{"city": "Tokyo", "line1": "secret", "country": "JP"}})
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trackers/"+code+"/events", bytes.NewReader(ev)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("post event: %d %s", rr.Code, rr.Body.String())
+    }
+
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/public/trackers/public-acme/"+code+"?lang=en", nil))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("public json: %d %s", rr.Code, rr.Body.String())
+    }
+    raw := rr.Body.String()
+    for _, leaked := range []string{"Taro", "secret", "1-2-3"} {
+        if strings.Contains(raw, leaked) {
+            t.Fatalf("public response leaks %q: %s", leaked, raw)
+        }
+    }
+    var res PublicTrackingResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+        t.Fatalf("unmarshal: %v", err)
+    }
+    if res.Status != "in_transit" || res.StatusLabel != "In transit" || res.Org.Name != "Acme Store" || len(res.Events) != 1 {
+        t.Fatalf("unexpected response: %+v", res)
+    }
+
+    // Another org's slug must look exactly like an unknown number.
+    other := httptest.NewRecorder()
+    h.ServeHTTP(other, httptest.NewRequest(http.MethodGet, "/public/trackers/public-other/"+code, nil))
+    missing := httptest.NewRecorder()
+    h.ServeHTTP(missing, httptest.NewRequest(http.MethodGet, "/public/trackers/no-such-org/"+code, nil))
+    if other.Code != http.StatusNotFound || missing.Code != http.StatusNotFound || other.Body.String() != missing.Body.String() {
+        t.Fatalf("expected identical 404s, got %d %s / %d %s", other.Code, other.Body.String(), missing.Code, missing.Body.String())
+    }
+
+    // The not-found page carries no org branding either.
+    ownPage := httptest.NewRecorder()
+    h.ServeHTTP(ownPage, httptest.NewRequest(http.MethodGet, "/track/public-acme/NOSUCHCODE", nil))
+    unknownPage := httptest.NewRecorder()
+    h.ServeHTTP(unknownPage, httptest.NewRequest(http.MethodGet, "/track/no-such-org/NOSUCHCODE", nil))
+    if ownPage.Code != http.StatusNotFound || unknownPage.Code != http.StatusNotFound ||
+        ownPage.Body.String() != unknownPage.Body.String() || strings.Contains(ownPage.Body.String(), "Acme Store") {
+        t.Fatalf("expected identical unbranded 404 pages, got %d / %d", ownPage.Code, unknownPage.Code)
+    }
+
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/track/public-acme/"+code, nil))
+    if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "輸送中") {
+        t.Fatalf("page: %d %s", rr.Code, rr.Body.String())
+    }
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestPublicLang(t *testing.T) {
+    cases := []struct {
+        query, accept, want string
+    }{
+        {"", "", "ja"},
+        {"lang=en", "ja", "en"},
+        {"lang=fr", "en-US,en;q=0.9", "en"},
+        {"", "fr-FR, ja;q=0.8", "ja"},
+        {"", "de", "ja"},
+    }
+    for _, c := range cases {
+        r := httptest.NewRequest(http.MethodGet, "/track/acme/X?"+c.query, nil)
+        if c.accept != "" {
+            r.Header.Set("Accept-Language", c.accept)
+        }
+        if got := publicLang(r); got != c.want {
+            t.Errorf("publicLang(%q, %q) = %q, want %q", c.query, c.accept, got, c.want)
+        }
+    }
+}
+
+func TestPublicLocation_DropsPII(t *testing.T) {
+    raw := json.RawMessage(`{"name":"Taro Yamada","line1":"1-2-3 Shibuya","postal_code":"150-0002","phone":"090","city":"Shibuya","prefecture":"Tokyo","country":"JP"}`)
+    loc := publicLocation(raw)
+    if loc == nil || *loc != (PublicLocation{City: "Shibuya", State: "Tokyo", Country: "JP"}) {
+        t.Fatalf("unexpected location: %+v", loc)
+    }
+    if publicLocation(json.RawMessage(`{"line1":"secret"}`)) != nil {
+        t.Fatalf("expected nil for location without city/state/country")
+    }
+}
+
+func TestOrgBranding_Sanitizes(t *testing.T) {
+    b := orgBranding("Acme", json.RawMessage(`{"branding":{"display_name":"Acme Store","logo_url":"javascript:alert(1)","primary_color":"red;}","support_url":"https://acme.example/help"}}`))
+    if b.Name != "Acme Store" || b.LogoURL != "" || b.PrimaryColor != defaultBrandColor || b.SupportURL != "https://acme.example/help" {
+        t.Fatalf("unexpected branding: %+v", b)
+    }
+    b = orgBranding("Acme", json.RawMessage(`{}`))
+    if b.Name != "Acme" || b.PrimaryColor != defaultBrandColor {
+        t.Fatalf("unexpected default branding: %+v", b)
+    }
+}
+
+func TestLookupLimiter_MissesCostMore(t *testing.T) {
+    now := time.Unix(0, 0)
+    l := newLookupLimiter()
+    l.now = func() time.Time { return now }
+    for i := 0; i < lookupBudget/lookupMissCost; i++ {
+        if !l.allow("a") {
+            t.Fatalf("miss %d rejected early", i)
+        }
+        l.charge("a", lookupMissCost)
+    }
+    if l.allow("a") {
+        t.Fatalf("expected client a to be throttled")
+    }
+    if !l.allow("b") {
+        t.Fatalf("other clients must not be affected")
+    }
+    now = now.Add(lookupWindow)
+    if !l.allow("a") {
+        t.Fatalf("expected budget to reset after the window")
+    }
+}
+
+func TestTrackPage_RendersEscaped(t *testing.T) {
+    var buf bytes.Buffer
+    page := trackingPageData{
+        Lang: "en",
+        Msg:  publicMessages["en"],
+        Org:  PublicBranding{Name: "<b>Acme</b>", PrimaryColor: "#123456"},
+        Tracking: &PublicTrackingResponse{
+            Code:        "1Z999AA10123456784",
+            StatusLabel: statusLabel("in_transit", "en"),
+        },
+        Events: []pageEvent{{StatusLabel: "In transit", When: "Jan 2, 2026 03:04 UTC", Where: "Tokyo, JP"}},
+    }
+    if err := trackPage.Execute(&buf, page); err != nil {
+        t.Fatalf("execute: %v", err)
+    }
+    out := buf.String()
+    for _, want := range []string{"&lt;b&gt;Acme&lt;/b&gt;", "#123456", "1Z999AA10123456784", "Tokyo, JP", "Tracking history"} {
+        if !strings.Contains(out, want) {
+            t.Errorf("page missing %q", want)
+        }
+    }
+}
+
+func TestFormatPublicTime(t *testing.T) {
+    if got := formatPublicTime("2026-01-02T15:04:00Z", "ja"); got != "2026年1月3日 00:04" {
+        t.Fatalf("ja: %q", got)
+    }
+    if got := formatPublicTime("2026-01-02T15:04:00Z", "en"); got != "Jan 2, 2026 15:04 UTC" {
+        t.Fatalf("en: %q", got)
+    }
+}
+
+func TestPublicCacheControl(t *testing.T) {
+    if got := publicCacheControl(http.StatusOK); got != "public, max-age=60" {
+        t.Fatalf("200: got %q", got)
+    }
+    for _, status := range []int{http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError} {
+        if got := publicCacheControl(status); got != "no-store" {
+            t.Fatalf("%d: got %q, want no-store", status, got)
+        }
+    }
+}
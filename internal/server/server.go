@@ -4,13 +4,12 @@ import (
     "context"
     "encoding/json"
     "errors"
-    "crypto/hmac"
-    "crypto/sha256"
-    "encoding/hex"
+    "fmt"
+    "io"
     "log"
     "net/http"
-    "io"
     "os"
+    "sort"
     "strings"
     "time"
 
@@ -18,47 +17,138 @@ import (
     "github.com/go-chi/chi/v5/middleware"
     "github.com/google/uuid"
     "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgconn"
     "github.com/jackc/pgx/v5/pgxpool"
-    "github.com/jackc/pgconn"
     "deliveryinfra/internal/rate"
+    "deliveryinfra/internal/secrets"
+    "deliveryinfra/internal/tracking"
 )
 
 type Server struct {
     db *pgxpool.Pool
     est rate.Estimator
+    streams *streamBroker
+    public *lookupLimiter
+    // keys encrypts webhook endpoint secrets; nil when SECRET_KEYS is unset.
+    keys *secrets.Keyring
+    // webhookMaxBody caps inbound webhook bodies.
+    webhookMaxBody int64
+}
+
+// defaultWebhookMaxBody is the inbound webhook body limit when Options
+// leaves it unset.
+const defaultWebhookMaxBody = 1 << 20
+
+// Options configures a Server. Zero values use the defaults.
+type Options struct {
+    // Estimator quotes rates (default rate.NewDummy()).
+    Estimator rate.Estimator
+    // WebhookMaxBody caps inbound webhook bodies in bytes; larger deliveries
+    // are refused with 413 (default 1 MiB).
+    WebhookMaxBody int64
+}
+
+// dbtx is satisfied by both *pgxpool.Pool and pgx.Tx so helpers can run
+// inside or outside a transaction.
+type dbtx interface {
+    Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+    Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+    QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
 func New(db *pgxpool.Pool) http.Handler {
-    s := &Server{db: db, est: rate.NewDummy()}
-    r := chi.NewRouter()
-    // Observability: Request ID and basic logger
-    r.Use(requestIDMiddleware)
-    r.Use(middleware.Logger)
-    r.Get("/healthz", s.handleHealth)
-    r.Post("/shipments", s.handleCreateShipment)
-    r.Get("/rates", s.handleGetRates)
-    r.Get("/trackers/{code}", s.handleGetTracker)
-    r.Post("/trackers/{code}/events", s.handlePostTrackerEvent)
-    r.Post("/webhooks/{source}", s.handleWebhook)
-    return r
+    return NewWithOptions(db, Options{})
 }
 
 // NewWithEstimator allows injecting a custom Estimator implementation.
 func NewWithEstimator(db *pgxpool.Pool, est rate.Estimator) http.Handler {
-    if est == nil {
-        est = rate.NewDummy()
+    return NewWithOptions(db, Options{Estimator: est})
+}
+
+// NewWithOptions creates the API handler configured by opts.
+func NewWithOptions(db *pgxpool.Pool, opts Options) http.Handler {
+    if opts.Estimator == nil {
+        opts.Estimator = rate.NewDummy()
+    }
+    if opts.WebhookMaxBody <= 0 {
+        opts.WebhookMaxBody = defaultWebhookMaxBody
+    }
+    s := &Server{
+        db:             db,
+        est:            opts.Estimator,
+        streams:        newStreamBroker(db),
+        public:         newLookupLimiter(),
+        keys:           loadKeyring(),
+        webhookMaxBody: opts.WebhookMaxBody,
+    }
+    return s.routes()
+}
+
+// loadKeyring reads SECRET_KEYS; without valid keys, features storing
+// secrets are unavailable.
+func loadKeyring() *secrets.Keyring {
+    spec := strings.TrimSpace(os.Getenv("SECRET_KEYS"))
+    if spec == "" {
+        return nil
+    }
+    k, err := secrets.NewKeyring(spec)
+    if err != nil {
+        log.Printf("invalid SECRET_KEYS: %v", err)
+        return nil
     }
-    s := &Server{db: db, est: est}
+    return k
+}
+
+func (s *Server) routes() http.Handler {
     r := chi.NewRouter()
     // Observability: Request ID and basic logger
     r.Use(requestIDMiddleware)
     r.Use(middleware.Logger)
     r.Get("/healthz", s.handleHealth)
     r.Post("/shipments", s.handleCreateShipment)
+    r.Get("/shipments/{id}", s.handleGetShipment)
+    r.Post("/shipments/{id}/trackers", s.handleCreateShipmentTracker)
+    r.Get("/shipments/{id}/notifications", s.handleListShipmentNotifications)
     r.Get("/rates", s.handleGetRates)
+    r.Post("/orders", s.handleCreateOrder)
+    r.Get("/orders", s.handleListOrders)
+    r.Post("/orders/import", s.handleImportOrders)
+    r.Get("/orders/{id}", s.handleGetOrder)
+    r.Patch("/orders/{id}", s.handleUpdateOrder)
+    r.Post("/orders/{id}/fulfill", s.handleFulfillOrder)
+    r.Post("/trackers", s.handleCreateTracker)
+    r.Post("/trackers/bulk", s.handleBulkCreateTrackers)
+    r.Get("/trackers/stream", s.handleOrgTrackerStream)
     r.Get("/trackers/{code}", s.handleGetTracker)
+    r.Get("/trackers/{code}/events", s.handleGetTrackerEvents)
+    r.Get("/trackers/{code}/stream", s.handleTrackerStream)
     r.Post("/trackers/{code}/events", s.handlePostTrackerEvent)
     r.Post("/webhooks/{source}", s.handleWebhook)
+    r.Post("/webhooks/{source}/{endpoint_id}", s.handleEndpointWebhook)
+    r.Post("/webhook-endpoints", s.handleCreateWebhookEndpoint)
+    r.Get("/webhook-endpoints", s.handleListWebhookEndpoints)
+    r.Get("/webhook-endpoints/{id}", s.handleGetWebhookEndpoint)
+    r.Patch("/webhook-endpoints/{id}", s.handleUpdateWebhookEndpoint)
+    r.Delete("/webhook-endpoints/{id}", s.handleDeleteWebhookEndpoint)
+    r.Get("/webhook-inbox", s.handleListWebhookInbox)
+    r.Get("/webhook-inbox/{id}", s.handleGetWebhookInbox)
+    r.Post("/webhook-inbox/{id}/replay", s.handleReplayWebhookInbox)
+    r.Get("/webhook-endpoints/{id}/secrets", s.handleListWebhookEndpointSecrets)
+    r.Post("/webhook-endpoints/{id}/secrets", s.handleStageWebhookEndpointSecret)
+    r.Post("/webhook-endpoints/{id}/secrets/{secret_id}/promote", s.handlePromoteWebhookEndpointSecret)
+    r.Post("/webhook-endpoints/{id}/secrets/{secret_id}/retire", s.handleRetireWebhookEndpointSecret)
+    r.Post("/webhook-subscriptions", s.handleCreateWebhookSubscription)
+    r.Get("/webhook-subscriptions", s.handleListWebhookSubscriptions)
+    r.Get("/webhook-subscriptions/{id}", s.handleGetWebhookSubscription)
+    r.Patch("/webhook-subscriptions/{id}", s.handleUpdateWebhookSubscription)
+    r.Delete("/webhook-subscriptions/{id}", s.handleDeleteWebhookSubscription)
+    r.Post("/webhook-subscriptions/{id}/rotate-secret", s.handleRotateWebhookSubscriptionSecret)
+    r.Post("/webhook-subscriptions/{id}/test", s.handleTestWebhookSubscription)
+    r.Get("/webhook-subscriptions/{id}/deliveries", s.handleListWebhookDeliveries)
+    r.Get("/webhook-subscriptions/{id}/deliveries/{delivery_id}", s.handleGetWebhookDelivery)
+    r.Post("/webhook-subscriptions/{id}/deliveries/{delivery_id}/redeliver", s.handleRedeliverWebhookDelivery)
+    r.Get("/track/{org_slug}/{code}", s.handlePublicTrackingPage)
+    r.Get("/public/trackers/{org_slug}/{code}", s.handlePublicTrackingJSON)
     return r
 }
 
@@ -105,6 +195,8 @@ type ShipmentCreateRequest struct {
     OrgSlug          string          `json:"org_slug"`
     OrderExternalID  string          `json:"order_external_id"`
     CarrierCode      string          `json:"carrier_code"`
+    ServiceCode      string          `json:"service_code"`
+    TrackingNumber   string          `json:"tracking_number"`
     RateCurrency     string          `json:"rate_currency"`
     ShipTo           json.RawMessage `json:"ship_to"`
     ShipFrom         json.RawMessage `json:"ship_from"`
@@ -113,10 +205,11 @@ type ShipmentCreateRequest struct {
 }
 
 type ShipmentCreateResponse struct {
-    ShipmentID  string `json:"shipment_id"`
-    LabelURL    string `json:"label_url"`
-    Status      string `json:"status"`
-    CreatedAt   string `json:"created_at"`
+    ShipmentID   string `json:"shipment_id"`
+    LabelURL     string `json:"label_url"`
+    TrackingCode string `json:"tracking_code,omitempty"`
+    Status       string `json:"status"`
+    CreatedAt    string `json:"created_at"`
 }
 
 func (s *Server) handleCreateShipment(w http.ResponseWriter, r *http.Request) {
@@ -141,8 +234,7 @@ func (s *Server) handleCreateShipment(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
 
     // Resolve org
-    var orgID uuid.UUID
-    err := s.db.QueryRow(ctx, "SELECT id FROM orgs WHERE slug = $1", req.OrgSlug).Scan(&orgID)
+    orgID, err := resolveOrgID(ctx, s.db, req.OrgSlug)
     if err != nil {
         if errors.Is(err, pgx.ErrNoRows) {
             writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "org not found")
@@ -166,26 +258,91 @@ func (s *Server) handleCreateShipment(w http.ResponseWriter, r *http.Request) {
     }
 
     // Resolve carrier account (optional by carrier_code)
-    var carrierAccountID *uuid.UUID
-    if req.CarrierCode != "" {
-        var caid uuid.UUID
-        err = s.db.QueryRow(ctx, `
-            SELECT ca.id
-            FROM carrier_accounts ca
-            JOIN carriers c ON c.id = ca.carrier_id
-            WHERE ca.org_id = $1 AND c.code = $2
-            LIMIT 1`, orgID, req.CarrierCode).Scan(&caid)
-        if err == nil {
-            carrierAccountID = &caid
-        } else if !errors.Is(err, pgx.ErrNoRows) {
-            http.Error(w, "db error", http.StatusInternalServerError)
+    carrierAccountID, err := resolveCarrierAccountID(ctx, s.db, orgID, req.CarrierCode)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+
+    tx, err := s.db.Begin(ctx)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+
+    created, err := insertShipment(ctx, tx, shipmentParams{
+        OrgID:            orgID,
+        OrderID:          orderID,
+        CarrierAccountID: carrierAccountID,
+        CarrierCode:      req.CarrierCode,
+        TrackingCode:     req.TrackingNumber,
+        ServiceCode:      req.ServiceCode,
+        RateCurrency:     req.RateCurrency,
+        ShipTo:           req.ShipTo,
+        ShipFrom:         req.ShipFrom,
+        Package:          req.Package,
+        Metadata:         req.Metadata,
+    })
+    if err != nil {
+        if errors.Is(err, errTrackerLinked) {
+            writeErrorJSON(w, http.StatusConflict, "conflict", err.Error())
             return
         }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create shipment")
+        return
+    }
+    if err := tx.Commit(ctx); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create shipment")
+        return
     }
 
+    res := ShipmentCreateResponse{
+        ShipmentID:   created.ID.String(),
+        LabelURL:     created.LabelURL,
+        TrackingCode: created.TrackingCode,
+        Status:       created.Status,
+        CreatedAt:    created.CreatedAt.Format(time.RFC3339),
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(res)
+}
+
+// shipmentParams holds the resolved inputs for insertShipment.
+type shipmentParams struct {
+    OrgID            uuid.UUID
+    OrderID          *uuid.UUID
+    CarrierAccountID *uuid.UUID
+    // CarrierCode and TrackingCode describe the tracker created with the label.
+    // Without a TrackingCode no tracker is created; one is registered once the
+    // carrier assigns a number (POST /shipments/{id}/trackers).
+    CarrierCode      string
+    TrackingCode     string
+    ServiceCode      string
+    RateCurrency     string
+    ShipTo           json.RawMessage
+    ShipFrom         json.RawMessage
+    Package          json.RawMessage
+    Metadata         json.RawMessage
+}
+
+type createdShipment struct {
+    ID           uuid.UUID
+    LabelURL     string
+    TrackingCode string
+    Status       string
+    CreatedAt    time.Time
+}
+
+// insertShipment creates a shipment row together with its placeholder label and
+// the tracker for the label's tracking code.
+func insertShipment(ctx context.Context, q dbtx, p shipmentParams) (createdShipment, error) {
+    if p.RateCurrency == "" {
+        p.RateCurrency = "USD"
+    }
     // Naive rate using weight_oz from package
     var pkgMap map[string]any
-    _ = json.Unmarshal(req.Package, &pkgMap)
+    _ = json.Unmarshal(p.Package, &pkgMap)
     weightOz, _ := toFloat(pkgMap["weight_oz"]) // default 0
     rateAmount := 5.0 + weightOz*0.5
 
@@ -193,39 +350,39 @@ func (s *Server) handleCreateShipment(w http.ResponseWriter, r *http.Request) {
     now := time.Now().UTC()
 
     // Insert shipment
-    _, err = s.db.Exec(ctx, `
+    _, err := q.Exec(ctx, `
         INSERT INTO shipments (
-            id, org_id, order_id, carrier_account_id, status,
+            id, org_id, order_id, carrier_account_id, service_code, status,
             rate_currency, rate_amount, ship_to, ship_from, package, metadata,
             created_at, updated_at
         ) VALUES (
-            $1, $2, $3, $4, 'created',
+            $1, $2, $3, $4, $12, 'created',
             $5, $6, $7::jsonb, $8::jsonb, $9::jsonb, $10::jsonb,
             $11, $11
         )
     `,
         shipmentID,
-        orgID,
-        orderID,
-        carrierAccountID,
-        req.RateCurrency,
+        p.OrgID,
+        p.OrderID,
+        p.CarrierAccountID,
+        p.RateCurrency,
         rateAmount,
-        string(req.ShipTo),
-        string(req.ShipFrom),
-        string(req.Package),
-        string(req.Metadata),
+        jsonOrEmpty(p.ShipTo),
+        jsonOrEmpty(p.ShipFrom),
+        jsonOrEmpty(p.Package),
+        jsonOrEmpty(p.Metadata),
         now,
+        nullIfEmpty(strings.ToLower(strings.TrimSpace(p.ServiceCode))),
     )
     if err != nil {
         log.Println("insert shipment error:", err)
-        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create shipment")
-        return
+        return createdShipment{}, err
     }
 
     // Insert a placeholder label
     labelID := uuid.New()
     labelURL := "https://example.com/label/" + shipmentID.String() + ".pdf"
-    _, err = s.db.Exec(ctx, `
+    _, err = q.Exec(ctx, `
         INSERT INTO labels (
             id, shipment_id, document_url, format, size, cost, currency, metadata, created_at
         ) VALUES (
@@ -235,32 +392,67 @@ func (s *Server) handleCreateShipment(w http.ResponseWriter, r *http.Request) {
         labelID,
         shipmentID,
         labelURL,
-        req.RateCurrency,
+        p.RateCurrency,
         "{}",
         now,
     )
     if err != nil {
         log.Println("insert label error:", err)
-        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create label")
-        return
+        return createdShipment{}, err
     }
 
-    res := ShipmentCreateResponse{
-        ShipmentID: shipmentID.String(),
-        LabelURL:   labelURL,
-        Status:     "created",
-        CreatedAt:  now.Format(time.RFC3339),
+    trackingCode := strings.TrimSpace(p.TrackingCode)
+    if trackingCode != "" {
+        if _, err := linkTracker(ctx, q, shipmentID, p.CarrierCode, trackingCode); err != nil {
+            return createdShipment{}, err
+        }
     }
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(res)
+    if err := outboxShipmentEvent(ctx, q, p.OrgID, shipmentID, eventShipmentCreated); err != nil {
+        return createdShipment{}, err
+    }
+    return createdShipment{ID: shipmentID, LabelURL: labelURL, TrackingCode: trackingCode, Status: "created", CreatedAt: now}, nil
+}
+
+// resolveOrgID looks up an org by slug. Returns pgx.ErrNoRows when absent.
+func resolveOrgID(ctx context.Context, q dbtx, slug string) (uuid.UUID, error) {
+    var orgID uuid.UUID
+    err := q.QueryRow(ctx, "SELECT id FROM orgs WHERE slug = $1", slug).Scan(&orgID)
+    return orgID, err
+}
+
+// resolveCarrierAccountID returns the org's first account for the carrier code,
+// or nil when the code is empty or no account is configured.
+func resolveCarrierAccountID(ctx context.Context, q dbtx, orgID uuid.UUID, carrierCode string) (*uuid.UUID, error) {
+    if strings.TrimSpace(carrierCode) == "" {
+        return nil, nil
+    }
+    var caid uuid.UUID
+    err := q.QueryRow(ctx, `
+        SELECT ca.id
+        FROM carrier_accounts ca
+        JOIN carriers c ON c.id = ca.carrier_id
+        WHERE ca.org_id = $1 AND c.code = $2
+        LIMIT 1`, orgID, carrierCode).Scan(&caid)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return &caid, nil
 }
 
 // Tracker detail
 type TrackerResponse struct {
     Code        string          `json:"code"`
     Status      string          `json:"status"`
+    Substatus   string          `json:"substatus,omitempty"`
+    CarrierCode string          `json:"carrier_code,omitempty"`
+    ShipmentID  string          `json:"shipment_id,omitempty"`
     LastEventAt string          `json:"last_event_at,omitempty"`
     LastEvent   json.RawMessage `json:"last_event,omitempty"`
+    // PredictedDelivery comes from lane history and is refreshed on every event.
+    PredictedDelivery *TrackerETA `json:"predicted_delivery,omitempty"`
 }
 
 func (s *Server) handleGetTracker(w http.ResponseWriter, r *http.Request) {
@@ -269,59 +461,145 @@ func (s *Server) handleGetTracker(w http.ResponseWriter, r *http.Request) {
         writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "code required")
         return
     }
-    ctx := r.Context()
+    resp, err := loadTracker(r.Context(), s.db, code)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(resp)
+}
+
+// loadTracker returns a tracker's current state as served by GET
+// /trackers/{code}. Returns pgx.ErrNoRows when absent.
+func loadTracker(ctx context.Context, q dbtx, code string) (TrackerResponse, error) {
     var (
         status       *string
+        substatus    *string
+        carrierCode  *string
+        shipmentID   *uuid.UUID
         lastEventAt  *time.Time
         lastEventRaw *string
+        etaAt        *time.Time
+        etaEarliest  *time.Time
+        etaLatest    *time.Time
+        etaUpdatedAt *time.Time
+        etaSamples   *int
+        etaBasis     *string
     )
-    err := s.db.QueryRow(ctx, `
+    err := q.QueryRow(ctx, `
         SELECT t.status,
+               t.substatus,
+               t.carrier_code,
+               t.shipment_id,
                t.last_event_at,
                (SELECT to_jsonb(e) FROM tracking_events e
                  WHERE e.tracker_id = t.id
                  ORDER BY e.occurred_at DESC
-                 LIMIT 1) AS last_event
+                 LIMIT 1) AS last_event,
+               t.eta_estimated_at, t.eta_earliest, t.eta_latest, t.eta_updated_at, t.eta_samples, t.eta_basis
         FROM trackers t
         WHERE t.carrier_tracking_code = $1
-    `, code).Scan(&status, &lastEventAt, &lastEventRaw)
+    `, code).Scan(&status, &substatus, &carrierCode, &shipmentID, &lastEventAt, &lastEventRaw,
+        &etaAt, &etaEarliest, &etaLatest, &etaUpdatedAt, &etaSamples, &etaBasis)
     if err != nil {
-        if errors.Is(err, pgx.ErrNoRows) {
-            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "not found")
-            return
-        }
-        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
-        return
+        return TrackerResponse{}, err
     }
     resp := TrackerResponse{Code: code}
     if status != nil {
         resp.Status = *status
     }
+    if substatus != nil {
+        resp.Substatus = *substatus
+    }
+    if carrierCode != nil {
+        resp.CarrierCode = *carrierCode
+    }
+    if shipmentID != nil {
+        resp.ShipmentID = shipmentID.String()
+    }
     if lastEventAt != nil {
         resp.LastEventAt = lastEventAt.UTC().Format(time.RFC3339)
     }
     if lastEventRaw != nil {
         resp.LastEvent = json.RawMessage(*lastEventRaw)
     }
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(resp)
+    resp.PredictedDelivery = trackerETA(etaAt, etaEarliest, etaLatest, etaUpdatedAt, etaSamples, etaBasis)
+    return resp, nil
 }
 
 // Tracker event ingestion
 type TrackerEventRequest struct {
     Status      string          `json:"status"`
+    Substatus   string          `json:"substatus,omitempty"`
     Description string          `json:"description"`
     Location    json.RawMessage `json:"location"`
     OccurredAt  string          `json:"occurred_at"`
     Raw         json.RawMessage `json:"raw"`
+    // Source selects the carrier mapping table for Status (e.g. "yamato").
+    // Webhooks use the source from the URL.
+    Source string `json:"source,omitempty"`
+    // CarrierStatus keeps the status as the carrier reported it. Filled from
+    // Status by normalizeTrackerEvent when not provided.
+    CarrierStatus string `json:"carrier_status,omitempty"`
 }
 
 type TrackerEventResponse struct {
     Code        string          `json:"code"`
     Status      string          `json:"status"`
+    Substatus   string          `json:"substatus,omitempty"`
     OccurredAt  string          `json:"occurred_at"`
 }
 
+// WebhookAcceptedResponse acknowledges a verified delivery stored in the
+// inbox.
+type WebhookAcceptedResponse struct {
+    ID     string `json:"id"`
+    Status string `json:"status"`
+}
+
+// WebhookResponse summarizes one processed webhook delivery: how many events
+// it held, how many were new, already stored or skipped. It is kept as the
+// inbox entry's result.
+type WebhookResponse struct {
+    Received   int                  `json:"received"`
+    Created    int                  `json:"created"`
+    Duplicates int                  `json:"duplicates"`
+    Skipped    int                  `json:"skipped,omitempty"`
+    Events     []WebhookEventResult `json:"events"`
+}
+
+// WebhookEventResult is the outcome of one event, by its position in the
+// normalized payload. Result is "created", "duplicate" or, for registered
+// endpoints, "skipped" when the tracker belongs to another org.
+type WebhookEventResult struct {
+    Index int `json:"index"`
+    TrackerEventResponse
+    Result string `json:"result"`
+}
+
+// normalizeTrackerEvent maps req.Status into the canonical taxonomy using the
+// source's mapping table, preserving the original value in CarrierStatus.
+// An explicit Substatus from the caller wins over the mapped one.
+func normalizeTrackerEvent(source string, req TrackerEventRequest) TrackerEventRequest {
+    if strings.TrimSpace(req.Source) != "" {
+        source = req.Source
+    }
+    if strings.TrimSpace(req.CarrierStatus) == "" {
+        req.CarrierStatus = req.Status
+    }
+    m := tracking.Normalize(source, req.Status)
+    req.Status = string(m.Status)
+    if strings.TrimSpace(req.Substatus) == "" {
+        req.Substatus = m.Substatus
+    }
+    return req
+}
+
 func (s *Server) handlePostTrackerEvent(w http.ResponseWriter, r *http.Request) {
     code := chi.URLParam(r, "code")
     if strings.TrimSpace(code) == "" {
@@ -352,6 +630,7 @@ func (s *Server) handlePostTrackerEvent(w http.ResponseWriter, r *http.Request)
         }
     }
 
+    req = normalizeTrackerEvent("", req)
     if err := s.insertTrackerEvent(r.Context(), code, req, occurred); err != nil {
         writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
         return
@@ -360,6 +639,7 @@ func (s *Server) handlePostTrackerEvent(w http.ResponseWriter, r *http.Request)
     resp := TrackerEventResponse{
         Code:       code,
         Status:     orDefault(req.Status, "unknown"),
+        Substatus:  req.Substatus,
         OccurredAt: occurred.Format(time.RFC3339),
     }
     w.Header().Set("Content-Type", "application/json")
@@ -367,6 +647,7 @@ func (s *Server) handlePostTrackerEvent(w http.ResponseWriter, r *http.Request)
 }
 
 // insertTrackerEvent ensures tracker exists, inserts event, and updates tracker status/last_event_at.
+// req.Status is expected to be canonical already (see normalizeTrackerEvent).
 func (s *Server) insertTrackerEvent(ctx context.Context, code string, req TrackerEventRequest, occurred time.Time) error {
     tx, err := s.db.Begin(ctx)
     if err != nil {
@@ -374,138 +655,325 @@ func (s *Server) insertTrackerEvent(ctx context.Context, code string, req Tracke
     }
     defer func() { _ = tx.Rollback(ctx) }()
 
-    var trackerID uuid.UUID
-    err = tx.QueryRow(ctx, `SELECT id FROM trackers WHERE carrier_tracking_code = $1`, code).Scan(&trackerID)
+    trackerID, err := lockTracker(ctx, tx, code, nil)
     if err != nil {
-        if errors.Is(err, pgx.ErrNoRows) {
-            trackerID = uuid.New()
-            _, err = tx.Exec(ctx, `
-                INSERT INTO trackers (id, carrier_tracking_code, status, last_event_at, metadata)
-                VALUES ($1, $2, COALESCE($3, 'unknown'), $4, '{}'::jsonb)
-            `, trackerID, code, nullIfEmpty(req.Status), occurred)
-            if err != nil {
-                return err
-            }
-        } else {
-            return err
-        }
+        return err
     }
+    if _, err := insertTrackingEvent(ctx, tx, trackerID, req, occurred); err != nil {
+        return err
+    }
+    if err := refreshTrackerState(ctx, tx, trackerID); err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
+}
 
-    // Idempotency: skip insert if same tracker_id + occurred_at + status + description already exists
-    var exists bool
-    err = tx.QueryRow(ctx, `
-        SELECT EXISTS (
-            SELECT 1 FROM tracking_events
-            WHERE tracker_id = $1
-              AND occurred_at = $2
-              AND (status IS NOT DISTINCT FROM $3)
-              AND (description IS NOT DISTINCT FROM $4)
-        )
-    `, trackerID, occurred, nullIfEmpty(req.Status), req.Description).Scan(&exists)
+// lockTracker creates the tracker if needed, recording creatorOrgID as the
+// org it belongs to until a shipment claims it, then locks it so concurrent
+// events for the same code derive state one at a time. Callers locking
+// several trackers in one transaction must do so in code order.
+func lockTracker(ctx context.Context, q dbtx, code string, creatorOrgID *uuid.UUID) (uuid.UUID, error) {
+    _, err := q.Exec(ctx, `
+        INSERT INTO trackers (id, carrier_tracking_code, status, metadata, created_by_org_id)
+        VALUES ($1, $2, 'unknown', '{}'::jsonb, $3)
+        ON CONFLICT (carrier_tracking_code) DO NOTHING
+    `, uuid.New(), code, creatorOrgID)
+    if err != nil {
+        return uuid.Nil, err
+    }
+    var trackerID uuid.UUID
+    err = q.QueryRow(ctx, `SELECT id FROM trackers WHERE carrier_tracking_code = $1 FOR UPDATE`, code).Scan(&trackerID)
+    return trackerID, err
+}
+
+// insertTrackingEvent stores one event of a locked tracker; created is false
+// for a duplicate.
+func insertTrackingEvent(ctx context.Context, q dbtx, trackerID uuid.UUID, req TrackerEventRequest, occurred time.Time) (created bool, err error) {
+    // Idempotency: the dedupe index on tracker_id + occurred_at + status + description
+    // turns replays into no-ops without aborting the transaction.
+    var seq int64
+    err = q.QueryRow(ctx, `
+        INSERT INTO tracking_events (tracker_id, occurred_at, status, substatus, carrier_status, description, location, raw)
+        VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8::jsonb)
+        ON CONFLICT DO NOTHING
+        RETURNING seq
+    `, trackerID, occurred, nullIfEmpty(req.Status), nullIfEmpty(req.Substatus), nullIfEmpty(req.CarrierStatus),
+        req.Description, string(req.Location), string(req.Raw)).Scan(&seq)
+    switch {
+    case errors.Is(err, pgx.ErrNoRows):
+        // duplicate: nothing new to stream
+        return false, nil
+    case err != nil:
+        return false, err
+    }
+    return true, outboxTrackingEvent(ctx, q, trackerID, seq)
+}
+
+// refreshTrackerState recomputes a locked tracker's status, ETA and shipment
+// status after new events.
+func refreshTrackerState(ctx context.Context, q dbtx, trackerID uuid.UUID) error {
+    // Events may arrive out of order, so derive tracker state from all of them
+    // rather than from the one just inserted.
+    st, err := deriveTrackerState(ctx, q, trackerID)
     if err != nil {
         return err
     }
-    if !exists {
-        _, err = tx.Exec(ctx, `
-            INSERT INTO tracking_events (tracker_id, occurred_at, status, description, location, raw)
-            VALUES ($1, $2, $3, $4, $5::jsonb, $6::jsonb)
-        `, trackerID, occurred, nullIfEmpty(req.Status), req.Description, string(req.Location), string(req.Raw))
-        if err != nil {
-            // If unique violation occurred due to race, treat as idempotent success
-            var pgErr *pgconn.PgError
-            if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
-                // continue without error
-            } else {
-                return err
-            }
+    var changed bool
+    err = q.QueryRow(ctx, `
+        UPDATE trackers t
+        SET status = $2, substatus = $3, last_event_at = $4
+        FROM (SELECT status, substatus, last_event_at FROM trackers WHERE id = $1) old
+        WHERE t.id = $1
+        RETURNING old.status IS DISTINCT FROM $2
+               OR old.substatus IS DISTINCT FROM $3
+               OR old.last_event_at IS DISTINCT FROM $4
+    `, trackerID, string(st.Status), nullIfEmpty(st.Substatus), st.LastEventAt).Scan(&changed)
+    if err != nil {
+        return err
+    }
+    if err := updateTrackerETA(ctx, q, trackerID, st); err != nil {
+        return err
+    }
+    if changed {
+        if err := outboxTrackerUpdated(ctx, q, trackerID); err != nil {
+            return err
         }
     }
+    return syncShipmentStatus(ctx, q, trackerID)
+}
 
-    _, err = tx.Exec(ctx, `UPDATE trackers SET status = COALESCE($2, status), last_event_at = $3 WHERE id = $1`, trackerID, nullIfEmpty(req.Status), occurred)
+// deriveTrackerState loads a tracker's events and applies tracking.Derive.
+func deriveTrackerState(ctx context.Context, q dbtx, trackerID uuid.UUID) (tracking.State, error) {
+    rows, err := q.Query(ctx, `
+        SELECT COALESCE(status, ''), COALESCE(substatus, ''), occurred_at
+        FROM tracking_events
+        WHERE tracker_id = $1
+    `, trackerID)
     if err != nil {
-        return err
+        return tracking.State{}, err
     }
-    return tx.Commit(ctx)
+    defer rows.Close()
+    var events []tracking.Event
+    for rows.Next() {
+        var (
+            ev     tracking.Event
+            status string
+        )
+        if err := rows.Scan(&status, &ev.Substatus, &ev.OccurredAt); err != nil {
+            return tracking.State{}, err
+        }
+        ev.Status = tracking.Status(status)
+        events = append(events, ev)
+    }
+    if err := rows.Err(); err != nil {
+        return tracking.State{}, err
+    }
+    return tracking.Derive(events), nil
 }
 
-// handleWebhook ingests provider-specific webhook events. For now supports "dummy" and reads HMAC secret from env.
+// handleWebhook accepts provider-specific webhook events for the built-in and
+// mapped sources, with secrets from the environment.
 func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
     source := chi.URLParam(r, "source")
     if strings.TrimSpace(source) == "" {
         writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "source required")
         return
     }
-    var secretEnv string
-    switch strings.ToLower(strings.TrimSpace(source)) {
-    case "dummy":
-        secretEnv = "DUMMY_WEBHOOK_SECRET"
-    case "karrio":
-        secretEnv = "KARRIO_WEBHOOK_SECRET"
-    default:
-        writeErrorJSON(w, http.StatusNotFound, "unsupported_source", "unsupported source")
-        return
+    source = strings.ToLower(strings.TrimSpace(source))
+    verifier := webhookVerifierFor(source)
+    secretEnv, ok := builtinWebhookSources[source]
+    if !ok {
+        sp, ok := mappingFor(source)
+        if !ok {
+            writeErrorJSON(w, http.StatusNotFound, "unsupported_source", "unsupported source")
+            return
+        }
+        secretEnv = sp.secretEnv()
+        if sp.verifier != nil {
+            verifier = sp.verifier
+        }
     }
     secret := os.Getenv(secretEnv)
     if strings.TrimSpace(secret) == "" {
         writeErrorJSON(w, http.StatusUnauthorized, "secret_not_configured", "webhook secret not configured")
         return
     }
+    s.serveWebhook(w, r, webhookTarget{source: source, normalizer: source, scope: source, secrets: []webhookSecret{{value: secret}}, verifier: verifier})
+}
+
+// builtinWebhookSources are the sources with dedicated handling and the
+// environment variable holding each one's signing secret.
+var builtinWebhookSources = map[string]string{
+    "dummy":   "DUMMY_WEBHOOK_SECRET",
+    "karrio":  "KARRIO_WEBHOOK_SECRET",
+    "17track": "TRACK17_WEBHOOK_SECRET",
+    "dhl":     "DHL_WEBHOOK_SECRET",
+    "yamato":  "YAMATO_WEBHOOK_SECRET",
+}
 
-    // Read raw body for signature verification
-    body, err := io.ReadAll(r.Body)
+// webhookTarget is a resolved inbound webhook: how to verify its signature,
+// which normalizer reads it and where its events may go.
+type webhookTarget struct {
+    // source selects the status mapping table.
+    source     string
+    normalizer string
+    // scope namespaces delivery IDs for replay protection.
+    scope string
+    // secrets are all currently valid secrets; a match with any verifies.
+    secrets  []webhookSecret
+    verifier WebhookVerifier
+    // orgID, when set, keeps events away from other orgs' trackers.
+    orgID      *uuid.UUID
+    endpointID *uuid.UUID
+}
+
+// serveWebhook verifies one webhook delivery and stores it in the inbox; the
+// InboxProcessor normalizes and ingests it later, so a slow database never
+// makes the provider time out.
+func (s *Server) serveWebhook(w http.ResponseWriter, r *http.Request, t webhookTarget) {
+    // Read raw body for signature verification; it is stored as is, so
+    // oversized deliveries are refused before anything else.
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.webhookMaxBody))
     if err != nil {
+        var tooLarge *http.MaxBytesError
+        if errors.As(err, &tooLarge) {
+            writeErrorJSON(w, http.StatusRequestEntityTooLarge, "payload_too_large", fmt.Sprintf("body exceeds %d bytes", tooLarge.Limit))
+            return
+        }
         writeErrorJSON(w, http.StatusBadRequest, "read_error", "read error")
         return
     }
-    sigHeader := r.Header.Get("X-Signature")
-    sigHeader = strings.TrimSpace(sigHeader)
-    sigHeader = strings.TrimPrefix(sigHeader, "sha256=")
-    if sigHeader == "" {
-        writeErrorJSON(w, http.StatusUnauthorized, "missing_signature", "missing signature")
+    sig, matched, err := verifyAny(t.verifier, r, body, t.secrets, time.Now())
+    if err != nil {
+        writeWebhookError(w, err)
         return
     }
-    mac := hmac.New(sha256.New, []byte(secret))
-    mac.Write(body)
-    expected := hex.EncodeToString(mac.Sum(nil))
-    provided, err := hex.DecodeString(sigHeader)
+    deliveryKeys := webhookDeliveryKeys(r, sig)
+
+    id, err := enqueueWebhook(r.Context(), s.db, t, deliveryKeys, body)
     if err != nil {
-        writeErrorJSON(w, http.StatusUnauthorized, "invalid_signature_format", "invalid signature format")
+        writeWebhookError(w, err)
         return
     }
-    if !hmac.Equal([]byte(expected), []byte(hex.EncodeToString(provided))) {
-        writeErrorJSON(w, http.StatusUnauthorized, "signature_mismatch", "signature mismatch")
-        return
+    if matched.id != uuid.Nil {
+        if err := touchWebhookEndpointSecret(r.Context(), s.db, matched.id); err != nil {
+            log.Printf("webhook: record matched secret: %v", err)
+        }
     }
+    writeJSON(w, http.StatusAccepted, WebhookAcceptedResponse{ID: id.String(), Status: inboxStatusPending})
+}
 
-    // Normalize provider payload into tracker event
-    code, req, nerr := NewNormalizer(source).Normalize(source, body)
-    if nerr != nil {
-        if errors.Is(nerr, ErrMissingCode) {
-            writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "code required")
-        } else {
-            writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+// buildWebhookItems normalizes a payload and validates the whole batch
+// before any of it is ingested. Errors are permanent: the payload will never
+// ingest as is.
+func buildWebhookItems(source, normalizer string, body []byte, now time.Time) ([]webhookItem, error) {
+    // Normalize provider payload into tracker events
+    events, err := NewNormalizer(normalizer).Normalize(normalizer, body)
+    if err != nil {
+        if errors.Is(err, ErrMissingCode) {
+            return nil, errors.New("code required")
         }
-        return
+        return nil, fmt.Errorf("invalid json: %w", err)
+    }
+    items := make([]webhookItem, len(events))
+    for i, ev := range events {
+        req := normalizeTrackerEvent(source, ev.Event)
+        if req.Location == nil {
+            req.Location = json.RawMessage("{}")
+        }
+        if req.Raw == nil {
+            req.Raw = json.RawMessage("{}")
+        }
+        items[i] = webhookItem{index: i, code: ev.Code, req: req, occurred: now}
+        if strings.TrimSpace(req.OccurredAt) == "" {
+            continue
+        }
+        t, err := time.Parse(time.RFC3339, req.OccurredAt)
+        if err != nil {
+            return nil, fmt.Errorf("invalid occurred_at (event %d)", i)
+        }
+        items[i].occurred = t.UTC()
     }
+    return items, nil
+}
 
-    var occurred time.Time
-    if strings.TrimSpace(req.OccurredAt) == "" {
-        occurred = time.Now().UTC()
-    } else {
-        if t, err := time.Parse(time.RFC3339, req.OccurredAt); err == nil {
-            occurred = t.UTC()
-        } else {
-            writeErrorJSON(w, http.StatusBadRequest, "invalid_occurred_at", "invalid occurred_at")
-            return
+type webhookItem struct {
+    index    int
+    code     string
+    req      TrackerEventRequest
+    occurred time.Time
+}
+
+const (
+    webhookResultCreated   = "created"
+    webhookResultDuplicate = "duplicate"
+    // webhookResultSkipped marks events for another org's tracker.
+    webhookResultSkipped = "skipped"
+)
+
+// ingestWebhookItems stores a webhook batch in the caller's transaction, so
+// either every event is ingested or none is. With orgID set, trackers it
+// creates belong to that org, and events for trackers the org does not own
+// (see webhookTrackerOwner) are skipped.
+// Trackers are locked in code order so concurrent batches touching the same
+// trackers cannot deadlock, and each tracker's state is derived once after
+// all of its events. Results follow the payload order.
+func ingestWebhookItems(ctx context.Context, tx dbtx, orgID *uuid.UUID, items []webhookItem) ([]WebhookEventResult, error) {
+    results := make([]WebhookEventResult, len(items))
+    byCode := map[string][]webhookItem{}
+    var codes []string
+    for _, it := range items {
+        if _, ok := byCode[it.code]; !ok {
+            codes = append(codes, it.code)
         }
+        byCode[it.code] = append(byCode[it.code], it)
     }
-    if err := s.insertTrackerEvent(r.Context(), code, req, occurred); err != nil {
-        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
-        return
+    sort.Strings(codes)
+
+    for _, code := range codes {
+        trackerID, err := lockTracker(ctx, tx, code, orgID)
+        if err != nil {
+            return nil, err
+        }
+        foreign := false
+        if orgID != nil {
+            owner, err := webhookTrackerOwner(ctx, tx, trackerID)
+            if err != nil {
+                return nil, err
+            }
+            foreign = owner == nil || *owner != *orgID
+        }
+        for _, it := range byCode[code] {
+            created := false
+            if !foreign {
+                if created, err = insertTrackingEvent(ctx, tx, trackerID, it.req, it.occurred); err != nil {
+                    return nil, err
+                }
+            }
+            res := WebhookEventResult{
+                Index: it.index,
+                TrackerEventResponse: TrackerEventResponse{
+                    Code: code, Status: orDefault(it.req.Status, "unknown"), Substatus: it.req.Substatus,
+                    OccurredAt: it.occurred.Format(time.RFC3339),
+                },
+                Result: webhookResultDuplicate,
+            }
+            switch {
+            case foreign:
+                res.Result = webhookResultSkipped
+            case created:
+                res.Result = webhookResultCreated
+            }
+            results[it.index] = res
+        }
+        if foreign {
+            continue
+        }
+        if err := refreshTrackerState(ctx, tx, trackerID); err != nil {
+            return nil, err
+        }
     }
-    resp := TrackerEventResponse{Code: code, Status: orDefault(req.Status, "unknown"), OccurredAt: occurred.Format(time.RFC3339)}
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(resp)
+    return results, nil
 }
 
 // writeErrorJSON writes a standardized JSON error response:
@@ -521,6 +989,19 @@ func writeErrorJSON(w http.ResponseWriter, status int, code string, message stri
     })
 }
 
+// writeJSON writes v as a JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    _ = json.NewEncoder(w).Encode(v)
+}
+
+// isUniqueViolation reports whether err is a Postgres unique_violation.
+func isUniqueViolation(err error) bool {
+    var pgErr *pgconn.PgError
+    return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
 // requestIDMiddleware ensures X-Request-ID is set on the response.
 // If provided in the request header, it is propagated; otherwise a UUID is generated.
 func requestIDMiddleware(next http.Handler) http.Handler {
@@ -541,6 +1022,14 @@ func nullIfEmpty(s string) *string {
     return &s
 }
 
+// jsonOrEmpty returns raw as a string, substituting an empty object when unset.
+func jsonOrEmpty(raw json.RawMessage) string {
+    if len(raw) == 0 || string(raw) == "null" {
+        return "{}"
+    }
+    return string(raw)
+}
+
 func orDefault(s, d string) string {
     if strings.TrimSpace(s) == "" {
         return d
@@ -56,4 +56,57 @@ func TestRequestIDHeaderPresent(t *testing.T) {
     if rid := rr.Header().Get("X-Request-ID"); rid == "" {
         t.Fatalf("expected X-Request-ID header to be set")
     }
-}
\ No newline at end of file
+}
+
+func TestNormalizeTrackerEvent(t *testing.T) {
+    req := normalizeTrackerEvent("yamato", TrackerEventRequest{Status: "持戻（ご不在）"})
+    if req.Status != "failure" || req.Substatus != "delivery_attempted" || req.CarrierStatus != "持戻（ご不在）" {
+        t.Fatalf("unexpected normalized event: %+v", req)
+    }
+
+    // Explicit source in the body overrides the endpoint source; explicit substatus wins
+    req = normalizeTrackerEvent("", TrackerEventRequest{Status: "DeliveryFailure", Source: "17track", Substatus: "address_issue"})
+    if req.Status != "failure" || req.Substatus != "address_issue" || req.CarrierStatus != "DeliveryFailure" {
+        t.Fatalf("unexpected normalized event: %+v", req)
+    }
+
+    req = normalizeTrackerEvent("", TrackerEventRequest{})
+    if req.Status != "" || req.CarrierStatus != "" {
+        t.Fatalf("empty status should stay empty: %+v", req)
+    }
+}
+
+func TestResolveTrackerCarrier(t *testing.T) {
+    number, carrier, candidates, err := resolveTrackerCarrier(" 1z999aa10123456784 ", "")
+    if err != nil || number != "1Z999AA10123456784" || carrier != "ups" || candidates != nil {
+        t.Fatalf("unexpected detection: %q %q %v %v", number, carrier, candidates, err)
+    }
+
+    // Ambiguous numbers are registered without a carrier
+    _, carrier, candidates, err = resolveTrackerCarrier("1234-5678-9013", "")
+    if err != nil || carrier != "" || len(candidates) != 3 {
+        t.Fatalf("unexpected ambiguous detection: %q %v %v", carrier, candidates, err)
+    }
+
+    // Explicit carrier wins but must accept the number
+    if _, carrier, _, err = resolveTrackerCarrier("123456789013", "Sagawa"); err != nil || carrier != "sagawa" {
+        t.Fatalf("unexpected explicit carrier: %q %v", carrier, err)
+    }
+    if _, _, _, err = resolveTrackerCarrier("123456789012", "yamato"); err == nil {
+        t.Fatalf("expected check digit error")
+    }
+    // Carriers without format rules are accepted as given
+    if _, carrier, _, err = resolveTrackerCarrier("ABC", "seino"); err != nil || carrier != "seino" {
+        t.Fatalf("unexpected unknown carrier handling: %q %v", carrier, err)
+    }
+}
+
+func TestLaneRegion(t *testing.T) {
+    country, region := laneRegion(json.RawMessage(`{"country":"jp","prefecture":" Tokyo ","line1":"Jinnan 1-1"}`))
+    if country != "JP" || region != "tokyo" {
+        t.Fatalf("unexpected lane region: %q %q", country, region)
+    }
+    if country, region := laneRegion(json.RawMessage(`{}`)); country != "" || region != "" {
+        t.Fatalf("expected empty lane region, got %q %q", country, region)
+    }
+}
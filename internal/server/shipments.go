@@ -0,0 +1,291 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+)
+
+// errTrackerLinked is returned when a tracking code already belongs to another shipment.
+var errTrackerLinked = errors.New("tracking code is linked to another shipment")
+
+// Shipment detail
+type ShipmentTracker struct {
+    Code        string `json:"code"`
+    CarrierCode string `json:"carrier_code,omitempty"`
+    Status      string `json:"status"`
+    Substatus   string `json:"substatus,omitempty"`
+    LastEventAt string `json:"last_event_at,omitempty"`
+}
+
+type ShipmentResponse struct {
+    ID           string              `json:"id"`
+    OrderID      string              `json:"order_id,omitempty"`
+    ServiceCode  string              `json:"service_code,omitempty"`
+    Status       string              `json:"status"`
+    RateCurrency string              `json:"rate_currency,omitempty"`
+    RateAmount   float64             `json:"rate_amount"`
+    ShipTo       json.RawMessage     `json:"ship_to"`
+    ShipFrom     json.RawMessage     `json:"ship_from"`
+    Package      json.RawMessage     `json:"package"`
+    Metadata     json.RawMessage     `json:"metadata"`
+    LabelURL     string              `json:"label_url,omitempty"`
+    Trackers     []ShipmentTracker   `json:"trackers"`
+    Exceptions   []ShipmentException `json:"exceptions"`
+    CreatedAt    string              `json:"created_at"`
+    UpdatedAt    string              `json:"updated_at"`
+}
+
+type ShipmentTrackerRequest struct {
+    TrackingCode string `json:"tracking_code"`
+    CarrierCode  string `json:"carrier_code"`
+}
+
+func (s *Server) handleGetShipment(w http.ResponseWriter, r *http.Request) {
+    shipmentID, ok := parseShipmentID(w, r)
+    if !ok {
+        return
+    }
+    sh, err := loadShipment(r.Context(), s.db, shipmentID)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "shipment not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, sh)
+}
+
+// handleCreateShipmentTracker registers a tracking code for an existing
+// shipment, e.g. when the label was bought outside this service. A tracker that
+// already exists for the code (from earlier events) is linked rather than
+// duplicated, and the shipment picks up its current status.
+func (s *Server) handleCreateShipmentTracker(w http.ResponseWriter, r *http.Request) {
+    shipmentID, ok := parseShipmentID(w, r)
+    if !ok {
+        return
+    }
+    var req ShipmentTrackerRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    if strings.TrimSpace(req.TrackingCode) == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "tracking_code required")
+        return
+    }
+    code, carrier, _, err := resolveTrackerCarrier(req.TrackingCode, req.CarrierCode)
+    if err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_tracking_number", err.Error())
+        return
+    }
+
+    ctx := r.Context()
+    tx, err := s.db.Begin(ctx)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+
+    var exists bool
+    if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM shipments WHERE id = $1)`, shipmentID).Scan(&exists); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if !exists {
+        writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "shipment not found")
+        return
+    }
+    trackerID, err := linkTracker(ctx, tx, shipmentID, carrier, code)
+    if err != nil {
+        if errors.Is(err, errTrackerLinked) {
+            writeErrorJSON(w, http.StatusConflict, "conflict", err.Error())
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if err := syncShipmentStatus(ctx, tx, trackerID); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    sh, err := loadShipment(ctx, tx, shipmentID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if err := tx.Commit(ctx); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, sh)
+}
+
+// linkTracker creates the tracker for code under the shipment, or attaches an
+// existing unlinked one unless another org's webhook endpoint created it. It
+// returns errTrackerLinked when the code belongs to a different shipment or
+// org. A new tracker's first fetch is enqueued in the same transaction.
+func linkTracker(ctx context.Context, q dbtx, shipmentID uuid.UUID, carrierCode, code string) (uuid.UUID, error) {
+    var (
+        trackerID uuid.UUID
+        linked    *uuid.UUID
+        created   bool
+        carrier   = strings.ToLower(strings.TrimSpace(carrierCode))
+    )
+    err := q.QueryRow(ctx, `
+        INSERT INTO trackers (id, shipment_id, carrier_tracking_code, carrier_code, status, metadata)
+        VALUES ($1, $2, $3, $4, 'unknown', '{}'::jsonb)
+        ON CONFLICT (carrier_tracking_code) DO UPDATE
+        SET shipment_id = CASE
+                WHEN trackers.created_by_org_id IS NULL
+                  OR trackers.created_by_org_id = (SELECT org_id FROM shipments WHERE id = EXCLUDED.shipment_id)
+                THEN COALESCE(trackers.shipment_id, EXCLUDED.shipment_id)
+                ELSE trackers.shipment_id
+            END,
+            carrier_code = COALESCE(trackers.carrier_code, EXCLUDED.carrier_code)
+        RETURNING id, shipment_id, (xmax = 0)
+    `, uuid.New(), shipmentID, code, nullIfEmpty(carrier)).Scan(&trackerID, &linked, &created)
+    if err != nil {
+        return uuid.Nil, err
+    }
+    if linked == nil || *linked != shipmentID {
+        return uuid.Nil, errTrackerLinked
+    }
+    if created {
+        if err := enqueueTrackingUpdate(ctx, q, carrier, code); err != nil {
+            return uuid.Nil, err
+        }
+    }
+    return trackerID, nil
+}
+
+// syncShipmentStatus copies a linked tracker's status onto its shipment and
+// refreshes the order, so shipped/delivered orders follow carrier events. A
+// shipment becoming delivered records shipment.delivered in the outbox.
+// Trackers that have not reported a known status yet leave the shipment alone,
+// as do undelivered trackers with open exceptions, which keep the shipment in
+// "exception" until the detector resolves them.
+func syncShipmentStatus(ctx context.Context, q dbtx, trackerID uuid.UUID) error {
+    var (
+        shipmentID, orgID uuid.UUID
+        orderID           *uuid.UUID
+        status            string
+    )
+    err := q.QueryRow(ctx, `
+        UPDATE shipments s
+        SET status = t.status, updated_at = now()
+        FROM trackers t
+        WHERE t.id = $1 AND s.id = t.shipment_id
+          AND t.status IS NOT NULL AND t.status <> 'unknown'
+          AND s.status IS DISTINCT FROM t.status
+          AND (t.status = 'delivered' OR NOT EXISTS (
+                SELECT 1 FROM tracking_exceptions x WHERE x.tracker_id = t.id AND x.resolved_at IS NULL))
+        RETURNING s.id, s.org_id, s.order_id, s.status
+    `, trackerID).Scan(&shipmentID, &orgID, &orderID, &status)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return nil
+        }
+        return err
+    }
+    switch status {
+    case "delivered":
+        if err := outboxShipmentEvent(ctx, q, orgID, shipmentID, eventShipmentDelivered); err != nil {
+            return err
+        }
+    case "out_for_delivery":
+        if err := outboxShipmentEvent(ctx, q, orgID, shipmentID, outboxShipmentOutForDelivery); err != nil {
+            return err
+        }
+    }
+    if orderID == nil {
+        return nil
+    }
+    _, err = refreshOrderStatus(ctx, q, *orderID)
+    return err
+}
+
+func loadShipment(ctx context.Context, q dbtx, shipmentID uuid.UUID) (ShipmentResponse, error) {
+    var (
+        sh                              ShipmentResponse
+        orderID                         *uuid.UUID
+        currency, labelURL              *string
+        shipTo, shipFrom, pkg, metadata string
+        createdAt, updatedAt            time.Time
+    )
+    err := q.QueryRow(ctx, `
+        SELECT s.order_id, COALESCE(s.service_code, ''), s.status, s.rate_currency, COALESCE(s.rate_amount, 0)::float8,
+               s.ship_to, s.ship_from, s.package, s.metadata,
+               (SELECT l.document_url FROM labels l WHERE l.shipment_id = s.id ORDER BY l.created_at DESC LIMIT 1),
+               s.created_at, s.updated_at
+        FROM shipments s WHERE s.id = $1
+    `, shipmentID).Scan(&orderID, &sh.ServiceCode, &sh.Status, &currency, &sh.RateAmount, &shipTo, &shipFrom, &pkg, &metadata,
+        &labelURL, &createdAt, &updatedAt)
+    if err != nil {
+        return ShipmentResponse{}, err
+    }
+    sh.ID = shipmentID.String()
+    if orderID != nil {
+        sh.OrderID = orderID.String()
+    }
+    if currency != nil {
+        sh.RateCurrency = *currency
+    }
+    if labelURL != nil {
+        sh.LabelURL = *labelURL
+    }
+    sh.ShipTo = json.RawMessage(shipTo)
+    sh.ShipFrom = json.RawMessage(shipFrom)
+    sh.Package = json.RawMessage(pkg)
+    sh.Metadata = json.RawMessage(metadata)
+    sh.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+    sh.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
+
+    rows, err := q.Query(ctx, `
+        SELECT carrier_tracking_code, COALESCE(carrier_code, ''), COALESCE(status, 'unknown'),
+               COALESCE(substatus, ''), last_event_at
+        FROM trackers WHERE shipment_id = $1 ORDER BY created_at, carrier_tracking_code
+    `, shipmentID)
+    if err != nil {
+        return ShipmentResponse{}, err
+    }
+    defer rows.Close()
+    sh.Trackers = []ShipmentTracker{}
+    for rows.Next() {
+        var (
+            t           ShipmentTracker
+            lastEventAt *time.Time
+        )
+        if err := rows.Scan(&t.Code, &t.CarrierCode, &t.Status, &t.Substatus, &lastEventAt); err != nil {
+            return ShipmentResponse{}, err
+        }
+        if lastEventAt != nil {
+            t.LastEventAt = lastEventAt.UTC().Format(time.RFC3339)
+        }
+        sh.Trackers = append(sh.Trackers, t)
+    }
+    if err := rows.Err(); err != nil {
+        return ShipmentResponse{}, err
+    }
+    sh.Exceptions, err = openShipmentExceptions(ctx, q, shipmentID)
+    return sh, err
+}
+
+func parseShipmentID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+    id, err := uuid.Parse(strings.TrimSpace(chi.URLParam(r, "id")))
+    if err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid shipment id")
+        return uuid.Nil, false
+    }
+    return id, true
+}
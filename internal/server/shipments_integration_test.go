@@ -64,4 +64,114 @@ func TestCreateShipmentIntegration(t *testing.T) {
     }
     // Clean up inserted shipment cascades labels
     _, _ = pool.Exec(t.Context(), `DELETE FROM shipments WHERE id = $1`, res.ShipmentID)
-}
\ No newline at end of file
+}
+func TestShipmentTrackerLinkIntegration(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    _, _ = pool.Exec(t.Context(), `
+        INSERT INTO orgs (slug, name)
+        SELECT 'demo', 'Demo Org'
+        WHERE NOT EXISTS (SELECT 1 FROM orgs WHERE slug = 'demo')
+    `)
+    codes := []string{"ITESTLINK001", "ITESTLINK002"}
+    _, _ = pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = ANY($1)`, codes)
+    _, _ = pool.Exec(t.Context(), `DELETE FROM jobs WHERE unique_key = ANY($1)`, codes)
+    defer pool.Exec(t.Context(), `DELETE FROM jobs WHERE unique_key = ANY($1)`, codes)
+
+    h := New(pool)
+
+    // Label purchase creates the tracker
+    body, _ := json.Marshal(map[string]any{
+        "org_slug":        "demo",
+        "carrier_code":    "DHL",
+        "tracking_number": codes[0],
+        "package":         map[string]any{"weight_oz": 5},
+    })
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/shipments", bytes.NewReader(body)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var created ShipmentCreateResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+        t.Fatalf("unmarshal: %v", err)
+    }
+    if created.TrackingCode != codes[0] {
+        t.Fatalf("unexpected tracking code: %+v", created)
+    }
+    defer pool.Exec(t.Context(), `DELETE FROM shipments WHERE id = $1`, created.ShipmentID)
+
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/trackers/"+codes[0], nil))
+    var tr TrackerResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &tr); err != nil {
+        t.Fatalf("unmarshal: %v", err)
+    }
+    if tr.ShipmentID != created.ShipmentID || tr.CarrierCode != "dhl" {
+        t.Fatalf("tracker not linked: %+v", tr)
+    }
+
+    // Events on the tracker move the shipment
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trackers/"+codes[0]+"/events",
+        bytes.NewReader([]byte(`{"status":"in_transit","occurred_at":"2025-01-01T00:00:00Z"}`))))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200 on event, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+
+    // Register a second code, e.g. a label bought elsewhere
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/shipments/"+created.ShipmentID+"/trackers",
+        bytes.NewReader([]byte(`{"tracking_code":"`+codes[1]+`","carrier_code":"yamato"}`))))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200 on register, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var sh ShipmentResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &sh); err != nil {
+        t.Fatalf("unmarshal: %v", err)
+    }
+    if sh.Status != "in_transit" || len(sh.Trackers) != 2 {
+        t.Fatalf("unexpected shipment: %+v", sh)
+    }
+
+    // Both new trackers had their first fetch queued
+    var queued int
+    if err := pool.QueryRow(t.Context(), `SELECT COUNT(*) FROM jobs WHERE kind = $1 AND unique_key = ANY($2)`,
+        TrackingUpdateJob.Name, codes).Scan(&queued); err != nil {
+        t.Fatalf("count jobs: %v", err)
+    }
+    if queued != 2 {
+        t.Fatalf("expected two tracking.update jobs, got %d", queued)
+    }
+
+    // A code linked to this shipment cannot be claimed by another
+    body, _ = json.Marshal(map[string]any{"org_slug": "demo", "tracking_number": codes[1]})
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/shipments", bytes.NewReader(body)))
+    if rr.Code != http.StatusConflict {
+        t.Fatalf("expected 409 for linked code, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+
+    // Without a tracking number no tracker is created until one is registered
+    body, _ = json.Marshal(map[string]any{"org_slug": "demo"})
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/shipments", bytes.NewReader(body)))
+    var bare ShipmentCreateResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &bare); err != nil || rr.Code != http.StatusOK || bare.TrackingCode != "" {
+        t.Fatalf("unexpected create without tracking number: %d %s", rr.Code, rr.Body.String())
+    }
+    var trackers int
+    if err := pool.QueryRow(t.Context(), `SELECT COUNT(*) FROM trackers WHERE shipment_id = $1`, bare.ShipmentID).Scan(&trackers); err != nil || trackers != 0 {
+        t.Fatalf("expected no tracker, got %d (%v)", trackers, err)
+    }
+}
@@ -0,0 +1,455 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+// trackingEventsChannel is the Postgres NOTIFY channel for ingested events.
+const trackingEventsChannel = "tracking_events"
+
+const (
+    maxStreams        = 1000
+    maxStreamsPerKey  = 20
+    streamBuffer      = 64
+    streamReplayBatch = 500
+)
+
+// streamHeartbeat is how often an idle stream sends a comment line so proxies
+// keep the connection open.
+var streamHeartbeat = 15 * time.Second
+
+var errTooManyStreams = errors.New("too many open streams")
+
+// TrackerStreamEvent is the data of one SSE message. The SSE id is the event's
+// stream position (tracking_events.stream_seq), which the outbox relay assigns
+// in commit order; ingestion seq is assigned at insert, so a concurrent
+// ingest could commit a lower seq after a higher one was streamed.
+type TrackerStreamEvent struct {
+    Seq           int64           `json:"-"`
+    ID            string          `json:"id"`
+    Code          string          `json:"code"`
+    ShipmentID    string          `json:"shipment_id,omitempty"`
+    OccurredAt    string          `json:"occurred_at"`
+    Status        string          `json:"status"`
+    Substatus     string          `json:"substatus,omitempty"`
+    CarrierStatus string          `json:"carrier_status,omitempty"`
+    Description   string          `json:"description,omitempty"`
+    Location      json.RawMessage `json:"location"`
+}
+
+// streamNotice is the NOTIFY payload, sent by the outbox relay for each
+// ingested event (outboxTrackingEvent). It stays small (Postgres caps payloads
+// at 8000 bytes); the listener loads the event itself.
+type streamNotice struct {
+    // Seq is the event's ingestion seq; the stream position is loaded with it.
+    Seq       int64      `json:"seq"`
+    TrackerID uuid.UUID  `json:"tracker_id"`
+    OrgID     *uuid.UUID `json:"org_id"`
+}
+
+// handleTrackerStream streams a tracker's events as Server-Sent Events.
+func (s *Server) handleTrackerStream(w http.ResponseWriter, r *http.Request) {
+    code := chi.URLParam(r, "code")
+    if strings.TrimSpace(code) == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "code required")
+        return
+    }
+    lastID, ok := parseLastEventID(w, r)
+    if !ok {
+        return
+    }
+    ctx := r.Context()
+    var trackerID uuid.UUID
+    if err := s.db.QueryRow(ctx, `SELECT id FROM trackers WHERE carrier_tracking_code = $1`, code).Scan(&trackerID); err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    sub := &streamSub{key: "tracker:" + trackerID.String(), trackerID: trackerID}
+    s.serveStream(w, r, sub, lastID, func(after int64) ([]TrackerStreamEvent, error) {
+        return queryStreamEvents(ctx, s.db, `WHERE e.tracker_id = $1 AND e.stream_seq > $2 ORDER BY e.stream_seq LIMIT $3`,
+            trackerID, after, streamReplayBatch)
+    })
+}
+
+// handleOrgTrackerStream streams events for every tracker linked to one of
+// the org's shipments.
+func (s *Server) handleOrgTrackerStream(w http.ResponseWriter, r *http.Request) {
+    slug := strings.TrimSpace(r.URL.Query().Get("org_slug"))
+    if slug == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "org_slug required")
+        return
+    }
+    lastID, ok := parseLastEventID(w, r)
+    if !ok {
+        return
+    }
+    ctx := r.Context()
+    orgID, err := resolveOrgID(ctx, s.db, slug)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "org not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    sub := &streamSub{key: "org:" + orgID.String(), orgID: orgID}
+    s.serveStream(w, r, sub, lastID, func(after int64) ([]TrackerStreamEvent, error) {
+        return queryStreamEvents(ctx, s.db, `JOIN shipments s ON s.id = t.shipment_id
+            WHERE s.org_id = $1 AND e.stream_seq > $2 ORDER BY e.stream_seq LIMIT $3`, orgID, after, streamReplayBatch)
+    })
+}
+
+// serveStream subscribes before replaying, so nothing ingested in between is
+// lost, then forwards live events, skipping any already replayed. lastID < 0
+// means the client sent no Last-Event-ID and only wants live events.
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request, sub *streamSub, lastID int64, replay func(after int64) ([]TrackerStreamEvent, error)) {
+    if _, ok := w.(http.Flusher); !ok {
+        writeErrorJSON(w, http.StatusInternalServerError, "streaming_unsupported", "streaming unsupported")
+        return
+    }
+    ready, err := s.streams.subscribe(sub)
+    if err != nil {
+        writeErrorJSON(w, http.StatusTooManyRequests, "too_many_streams", err.Error())
+        return
+    }
+    defer s.streams.unsubscribe(sub)
+    // Replay only once LISTEN is active; otherwise an event committed in
+    // between would be neither replayed nor delivered.
+    select {
+    case <-ready:
+    case <-sub.done:
+        writeErrorJSON(w, http.StatusServiceUnavailable, "stream_unavailable", "event listener unavailable")
+        return
+    case <-r.Context().Done():
+        return
+    }
+
+    var backlog []TrackerStreamEvent
+    for after := lastID; after >= 0; {
+        batch, err := replay(after)
+        if err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+            return
+        }
+        backlog = append(backlog, batch...)
+        if len(batch) < streamReplayBatch {
+            break
+        }
+        after = batch[len(batch)-1].Seq
+    }
+
+    rc := http.NewResponseController(w)
+    // Streams outlive the server's WriteTimeout.
+    _ = rc.SetWriteDeadline(time.Time{})
+    h := w.Header()
+    h.Set("Content-Type", "text/event-stream")
+    h.Set("Cache-Control", "no-cache")
+    h.Set("Connection", "keep-alive")
+    h.Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprint(w, "retry: 3000\n\n")
+
+    for _, ev := range backlog {
+        if err := writeSSE(w, ev); err != nil {
+            return
+        }
+        lastID = ev.Seq
+    }
+    if err := rc.Flush(); err != nil {
+        return
+    }
+
+    heartbeat := time.NewTicker(streamHeartbeat)
+    defer heartbeat.Stop()
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case <-heartbeat.C:
+            if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+                return
+            }
+        case ev, ok := <-sub.ch:
+            if !ok {
+                // Dropped by the broker (slow client or listener restart); the
+                // client reconnects with Last-Event-ID.
+                return
+            }
+            // Notifications arrive in commit order, which is stream
+            // position order, so anything at or below lastID was replayed.
+            if ev.Seq <= lastID {
+                continue
+            }
+            if err := writeSSE(w, ev); err != nil {
+                return
+            }
+            lastID = ev.Seq
+        }
+        if err := rc.Flush(); err != nil {
+            return
+        }
+    }
+}
+
+func writeSSE(w io.Writer, ev TrackerStreamEvent) error {
+    data, err := json.Marshal(ev)
+    if err != nil {
+        return err
+    }
+    _, err = fmt.Fprintf(w, "id: %d\nevent: tracking_event\ndata: %s\n\n", ev.Seq, data)
+    return err
+}
+
+// parseLastEventID reads the resume cursor from the Last-Event-ID header, or
+// the last_event_id query parameter for clients that cannot set headers.
+// It returns -1 when neither is present.
+func parseLastEventID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    v := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+    if v == "" {
+        v = strings.TrimSpace(r.URL.Query().Get("last_event_id"))
+    }
+    if v == "" {
+        return -1, true
+    }
+    n, err := strconv.ParseInt(v, 10, 64)
+    if err != nil || n < 0 {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid Last-Event-ID")
+        return 0, false
+    }
+    return n, true
+}
+
+// queryStreamEvents loads published events joined to their tracker; clause
+// supplies any further joins, the filter, ordering and limit, and must only
+// match events with a stream position.
+func queryStreamEvents(ctx context.Context, q dbtx, clause string, args ...any) ([]TrackerStreamEvent, error) {
+    rows, err := q.Query(ctx, `
+        SELECT e.stream_seq, e.id, t.carrier_tracking_code, t.shipment_id, e.occurred_at,
+               COALESCE(e.status, 'unknown'), COALESCE(e.substatus, ''), COALESCE(e.carrier_status, ''),
+               COALESCE(e.description, ''), e.location
+        FROM tracking_events e
+        JOIN trackers t ON t.id = e.tracker_id
+        `+clause, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []TrackerStreamEvent
+    for rows.Next() {
+        var (
+            ev         TrackerStreamEvent
+            id         uuid.UUID
+            shipmentID *uuid.UUID
+            occurred   time.Time
+            location   string
+        )
+        if err := rows.Scan(&ev.Seq, &id, &ev.Code, &shipmentID, &occurred, &ev.Status, &ev.Substatus,
+            &ev.CarrierStatus, &ev.Description, &location); err != nil {
+            return nil, err
+        }
+        ev.ID = id.String()
+        if shipmentID != nil {
+            ev.ShipmentID = shipmentID.String()
+        }
+        ev.OccurredAt = occurred.UTC().Format(time.RFC3339)
+        ev.Location = json.RawMessage(location)
+        out = append(out, ev)
+    }
+    return out, rows.Err()
+}
+
+// streamSub is one open stream, filtered to a tracker or an org.
+type streamSub struct {
+    key       string
+    trackerID uuid.UUID
+    orgID     uuid.UUID
+    ch        chan TrackerStreamEvent
+    // done is closed together with ch when the broker drops the stream.
+    done chan struct{}
+}
+
+func (s *streamSub) matches(n streamNotice) bool {
+    if s.trackerID != uuid.Nil {
+        return n.TrackerID == s.trackerID
+    }
+    return n.OrgID != nil && *n.OrgID == s.orgID
+}
+
+// streamBroker fans NOTIFY messages out to this replica's open streams. It
+// LISTENs on a dedicated connection only while streams are open.
+type streamBroker struct {
+    db        *pgxpool.Pool
+    maxTotal  int
+    maxPerKey int
+
+    mu     sync.Mutex
+    subs   map[*streamSub]struct{}
+    perKey map[string]int
+    gen    int
+    stop   context.CancelFunc
+    // ready is closed once the current listener is LISTENing.
+    ready chan struct{}
+}
+
+func newStreamBroker(db *pgxpool.Pool) *streamBroker {
+    return &streamBroker{
+        db:        db,
+        maxTotal:  maxStreams,
+        maxPerKey: maxStreamsPerKey,
+        subs:      map[*streamSub]struct{}{},
+        perKey:    map[string]int{},
+    }
+}
+
+// subscribe registers sub, starting the listener if needed. The returned
+// channel is closed once notifications are being received.
+func (b *streamBroker) subscribe(sub *streamSub) (<-chan struct{}, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if len(b.subs) >= b.maxTotal || b.perKey[sub.key] >= b.maxPerKey {
+        return nil, errTooManyStreams
+    }
+    sub.ch = make(chan TrackerStreamEvent, streamBuffer)
+    sub.done = make(chan struct{})
+    b.subs[sub] = struct{}{}
+    b.perKey[sub.key]++
+    if b.stop == nil {
+        b.ready = make(chan struct{})
+        if b.db == nil {
+            // No database (unit tests): nothing to listen to.
+            close(b.ready)
+        } else {
+            ctx, cancel := context.WithCancel(context.Background())
+            b.gen++
+            b.stop = cancel
+            go b.listen(ctx, b.gen, b.ready)
+        }
+    }
+    return b.ready, nil
+}
+
+func (b *streamBroker) unsubscribe(sub *streamSub) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.removeLocked(sub)
+    if len(b.subs) == 0 && b.stop != nil {
+        b.stop()
+        b.stop = nil
+    }
+}
+
+func (b *streamBroker) removeLocked(sub *streamSub) {
+    if _, ok := b.subs[sub]; !ok {
+        return
+    }
+    delete(b.subs, sub)
+    if b.perKey[sub.key]--; b.perKey[sub.key] <= 0 {
+        delete(b.perKey, sub.key)
+    }
+    close(sub.ch)
+    close(sub.done)
+}
+
+// listen relays notifications until ctx is cancelled. If the connection fails
+// the open streams are closed, since they may have missed events; clients
+// resume from Last-Event-ID and a new listener starts with them.
+func (b *streamBroker) listen(ctx context.Context, gen int, ready chan struct{}) {
+    err := b.listenConn(ctx, ready)
+    if ctx.Err() != nil {
+        return
+    }
+    log.Println("tracking stream: listener stopped:", err)
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.gen != gen {
+        return
+    }
+    for sub := range b.subs {
+        b.removeLocked(sub)
+    }
+    b.stop()
+    b.stop = nil
+}
+
+func (b *streamBroker) listenConn(ctx context.Context, ready chan struct{}) error {
+    pc, err := b.db.Acquire(ctx)
+    if err != nil {
+        return err
+    }
+    // LISTEN state must not leak back into the pool.
+    conn := pc.Hijack()
+    defer conn.Close(context.Background())
+    if _, err := conn.Exec(ctx, "LISTEN "+trackingEventsChannel); err != nil {
+        return err
+    }
+    close(ready)
+    for {
+        n, err := conn.WaitForNotification(ctx)
+        if err != nil {
+            return err
+        }
+        var notice streamNotice
+        if err := json.Unmarshal([]byte(n.Payload), &notice); err != nil {
+            continue
+        }
+        if !b.interested(notice) {
+            continue
+        }
+        events, err := queryStreamEvents(ctx, b.db, `WHERE e.seq = $1 AND e.stream_seq IS NOT NULL`, notice.Seq)
+        if err != nil {
+            log.Println("tracking stream: load event:", err)
+            continue
+        }
+        for _, ev := range events {
+            b.deliver(notice, ev)
+        }
+    }
+}
+
+func (b *streamBroker) interested(n streamNotice) bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for sub := range b.subs {
+        if sub.matches(n) {
+            return true
+        }
+    }
+    return false
+}
+
+// deliver hands ev to matching streams without blocking. A stream whose
+// buffer is full is closed rather than allowed to stall the others.
+func (b *streamBroker) deliver(n streamNotice, ev TrackerStreamEvent) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for sub := range b.subs {
+        if !sub.matches(n) {
+            continue
+        }
+        select {
+        case sub.ch <- ev:
+        default:
+            b.removeLocked(sub)
+        }
+    }
+}
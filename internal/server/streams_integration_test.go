@@ -0,0 +1,102 @@
+package server
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "testing"
+    "time"
+
+    "deliveryinfra/internal/db"
+)
+
+func TestTrackerStreamReplayAndLive(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    code := "ITESTSTREAM001"
+    _, _ = pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = $1`, code)
+    defer pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = $1`, code)
+
+    srv := httptest.NewServer(New(pool))
+    defer srv.Close()
+    // Live events are announced by the outbox relay
+    relayCtx, stopRelay := context.WithCancel(t.Context())
+    defer stopRelay()
+    go NewOutboxRelay(pool, OutboxRelayConfig{Tick: 50 * time.Millisecond}).Run(relayCtx)
+
+    post := func(body string) {
+        t.Helper()
+        res, err := http.Post(srv.URL+"/trackers/"+code+"/events", "application/json", bytes.NewReader([]byte(body)))
+        if err != nil {
+            t.Fatalf("post: %v", err)
+        }
+        res.Body.Close()
+        if res.StatusCode != http.StatusOK {
+            t.Fatalf("expected 200, got %d", res.StatusCode)
+        }
+    }
+    post(`{"status":"pre_transit","occurred_at":"2025-01-01T00:00:00Z"}`)
+
+    ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+    defer cancel()
+    req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/trackers/"+code+"/stream", nil)
+    req.Header.Set("Last-Event-ID", "0")
+    res, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatalf("stream: %v", err)
+    }
+    defer res.Body.Close()
+    if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+        t.Fatalf("unexpected stream response %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+    }
+
+    reader := bufio.NewReader(res.Body)
+    nextData := func() string {
+        t.Helper()
+        for {
+            line, err := reader.ReadString('\n')
+            if err != nil {
+                t.Fatalf("read stream: %v", err)
+            }
+            if strings.HasPrefix(line, "data: ") {
+                return line
+            }
+        }
+    }
+
+    // Replayed from Last-Event-ID
+    if data := nextData(); !strings.Contains(data, `"status":"pre_transit"`) {
+        t.Fatalf("unexpected replayed event: %s", data)
+    }
+
+    // Delivered live through the outbox relay and LISTEN/NOTIFY
+    post(`{"status":"in_transit","occurred_at":"2025-01-02T00:00:00Z"}`)
+    if data := nextData(); !strings.Contains(data, `"status":"in_transit"`) {
+        t.Fatalf("unexpected live event: %s", data)
+    }
+
+    // Both events were given a stream position when published
+    var unpublished int
+    if err := pool.QueryRow(t.Context(), `
+        SELECT COUNT(*) FROM tracking_events e JOIN trackers t ON t.id = e.tracker_id
+        WHERE t.carrier_tracking_code = $1 AND e.stream_seq IS NULL`, code).Scan(&unpublished); err != nil {
+        t.Fatalf("count: %v", err)
+    }
+    if unpublished != 0 {
+        t.Fatalf("expected every streamed event to have a stream position, %d without", unpublished)
+    }
+}
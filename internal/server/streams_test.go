@@ -0,0 +1,109 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/google/uuid"
+)
+
+func TestTrackerStream_InvalidLastEventID_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    req := httptest.NewRequest(http.MethodGet, "/trackers/TRACK123/stream", nil)
+    req.Header.Set("Last-Event-ID", "abc")
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var e stdError
+    if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+        t.Fatalf("unmarshal error: %v", err)
+    }
+    if e.Error.Code != "invalid_request" {
+        t.Fatalf("unexpected error code: %s", e.Error.Code)
+    }
+}
+
+func TestOrgTrackerStream_MissingOrg_ErrorJSON(t *testing.T) {
+    h := New(nil)
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/trackers/stream", nil))
+    if rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+}
+
+func TestStreamBroker_Limits(t *testing.T) {
+    b := newStreamBroker(nil)
+    b.maxTotal, b.maxPerKey = 3, 2
+    subs := []*streamSub{{key: "a"}, {key: "a"}}
+    for _, s := range subs {
+        if _, err := b.subscribe(s); err != nil {
+            t.Fatalf("subscribe: %v", err)
+        }
+    }
+    if _, err := b.subscribe(&streamSub{key: "a"}); !errors.Is(err, errTooManyStreams) {
+        t.Fatalf("expected per-key limit, got %v", err)
+    }
+    if _, err := b.subscribe(&streamSub{key: "b"}); err != nil {
+        t.Fatalf("subscribe other key: %v", err)
+    }
+    if _, err := b.subscribe(&streamSub{key: "c"}); !errors.Is(err, errTooManyStreams) {
+        t.Fatalf("expected total limit, got %v", err)
+    }
+    b.unsubscribe(subs[0])
+    if _, err := b.subscribe(&streamSub{key: "a"}); err != nil {
+        t.Fatalf("expected slot freed after unsubscribe: %v", err)
+    }
+}
+
+func TestStreamBroker_DeliverFiltersAndDropsSlowStreams(t *testing.T) {
+    b := newStreamBroker(nil)
+    trackerID, orgID := uuid.New(), uuid.New()
+    byTracker := &streamSub{key: "tracker", trackerID: trackerID}
+    byOrg := &streamSub{key: "org", orgID: orgID}
+    other := &streamSub{key: "other", trackerID: uuid.New()}
+    for _, s := range []*streamSub{byTracker, byOrg, other} {
+        if _, err := b.subscribe(s); err != nil {
+            t.Fatalf("subscribe: %v", err)
+        }
+    }
+
+    b.deliver(streamNotice{Seq: 1, TrackerID: trackerID, OrgID: &orgID}, TrackerStreamEvent{Seq: 1})
+    if len(byTracker.ch) != 1 || len(byOrg.ch) != 1 || len(other.ch) != 0 {
+        t.Fatalf("unexpected fan-out: %d %d %d", len(byTracker.ch), len(byOrg.ch), len(other.ch))
+    }
+
+    // Unlinked trackers have no org and only reach tracker streams
+    b.deliver(streamNotice{Seq: 2, TrackerID: trackerID}, TrackerStreamEvent{Seq: 2})
+    if len(byTracker.ch) != 2 || len(byOrg.ch) != 1 {
+        t.Fatalf("unexpected fan-out without org: %d %d", len(byTracker.ch), len(byOrg.ch))
+    }
+
+    // A stream that stops reading is closed once its buffer is full
+    for i := 0; i < streamBuffer; i++ {
+        b.deliver(streamNotice{TrackerID: trackerID}, TrackerStreamEvent{Seq: int64(3 + i)})
+    }
+    if _, ok := b.subs[byTracker]; ok {
+        t.Fatalf("expected slow stream to be dropped")
+    }
+    for range byTracker.ch {
+    }
+    b.unsubscribe(byTracker) // no-op after drop
+}
+
+func TestWriteSSE(t *testing.T) {
+    var buf bytes.Buffer
+    if err := writeSSE(&buf, TrackerStreamEvent{Seq: 42, Code: "TRACK123", Status: "in_transit", Location: json.RawMessage(`{}`)}); err != nil {
+        t.Fatalf("writeSSE: %v", err)
+    }
+    want := "id: 42\nevent: tracking_event\ndata: {\"id\":\"\",\"code\":\"TRACK123\",\"occurred_at\":\"\",\"status\":\"in_transit\",\"location\":{}}\n\n"
+    if buf.String() != want {
+        t.Fatalf("unexpected SSE frame:\n%q\nwant\n%q", buf.String(), want)
+    }
+}
@@ -52,7 +52,7 @@ func TestPostTrackerEventAndGet(t *testing.T) {
     if rr2.Code != http.StatusOK {
         t.Fatalf("expected 200 on get, got %d; body=%s", rr2.Code, rr2.Body.String())
     }
-    var res struct{
+    var res struct {
         Code        string          `json:"code"`
         Status      string          `json:"status"`
         LastEventAt string          `json:"last_event_at"`
@@ -64,4 +64,246 @@ func TestPostTrackerEventAndGet(t *testing.T) {
     if res.Code != code || res.Status != "in_transit" || res.LastEventAt == "" || len(res.LastEvent) == 0 {
         t.Fatalf("unexpected tracker response: %+v", res)
     }
-}
\ No newline at end of file
+}
+func TestGetTrackerEventsTimeline(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    h := New(pool)
+
+    code := "ITESTTIMELINE001"
+    _, _ = pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = $1`, code)
+
+    // Post events out of chronological order
+    for _, ev := range []map[string]any{
+        {"status": "in_transit", "description": "Departed", "occurred_at": "2025-01-02T00:00:00Z", "raw": map[string]any{"seq": 2}},
+        {"status": "pre_transit", "description": "Label created", "occurred_at": "2025-01-01T00:00:00Z", "raw": map[string]any{"seq": 1}},
+        {"status": "delivered", "description": "Delivered", "occurred_at": "2025-01-03T00:00:00Z", "raw": map[string]any{"seq": 3}},
+    } {
+        body, _ := json.Marshal(ev)
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trackers/"+code+"/events", bytes.NewReader(body)))
+        if rr.Code != http.StatusOK {
+            t.Fatalf("expected 200, got %d; body=%s", rr.Code, rr.Body.String())
+        }
+    }
+
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/trackers/"+code+"/events?limit=2", nil))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var res TrackerEventsResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+        t.Fatalf("unmarshal failed: %v", err)
+    }
+    if res.Total != 3 || len(res.Events) != 2 {
+        t.Fatalf("unexpected page: %+v", res)
+    }
+    if res.Events[0].Description != "Label created" || res.Events[1].Description != "Departed" {
+        t.Fatalf("events not ordered by occurred_at: %+v", res.Events)
+    }
+    if len(res.Events[0].Raw) != 0 {
+        t.Fatalf("raw should be omitted by default")
+    }
+
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/trackers/"+code+"/events?offset=2&include_raw=true", nil))
+    res = TrackerEventsResponse{}
+    if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+        t.Fatalf("unmarshal failed: %v", err)
+    }
+    if len(res.Events) != 1 || res.Events[0].Status != "delivered" || len(res.Events[0].Raw) == 0 {
+        t.Fatalf("unexpected second page: %+v", res)
+    }
+
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/trackers/NO_SUCH_TRACKER_CODE/events", nil))
+    if rr.Code != http.StatusNotFound {
+        t.Fatalf("expected 404, got %d", rr.Code)
+    }
+
+    _, _ = pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = $1`, code)
+}
+
+func TestPostTrackerEvent_NormalizesCarrierStatus(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    h := New(pool)
+
+    code := "ITESTNORMALIZE001"
+    _, _ = pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = $1`, code)
+
+    body, _ := json.Marshal(map[string]any{
+        "status":      "配達完了",
+        "source":      "yamato",
+        "description": "お届けしました",
+        "occurred_at": "2025-01-03T00:00:00Z",
+    })
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trackers/"+code+"/events", bytes.NewReader(body)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var posted TrackerEventResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &posted); err != nil {
+        t.Fatalf("unmarshal failed: %v", err)
+    }
+    if posted.Status != "delivered" {
+        t.Fatalf("expected canonical status, got %+v", posted)
+    }
+
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/trackers/"+code+"/events", nil))
+    var res TrackerEventsResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+        t.Fatalf("unmarshal failed: %v", err)
+    }
+    if res.Status != "delivered" || len(res.Events) != 1 || res.Events[0].CarrierStatus != "配達完了" {
+        t.Fatalf("unexpected timeline: %+v", res)
+    }
+
+    _, _ = pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = $1`, code)
+}
+
+func TestPostTrackerEvent_OutOfOrder(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    h := New(pool)
+
+    code := "ITESTOUTOFORDER001"
+    _, _ = pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = $1`, code)
+
+    // Delivered arrives before earlier scans and a late, skewed in_transit re-scan.
+    events := []map[string]any{
+        {"status": "delivered", "description": "Delivered", "occurred_at": "2025-01-03T10:00:00Z"},
+        {"status": "pre_transit", "description": "Label created", "occurred_at": "2025-01-01T00:00:00Z"},
+        {"status": "in_transit", "description": "Hub re-scan", "occurred_at": "2025-01-03T12:00:00Z"},
+        {"status": "in_transit", "description": "Departed", "occurred_at": "2025-01-02T00:00:00Z"},
+        // Replay of the first event must stay idempotent
+        {"status": "delivered", "description": "Delivered", "occurred_at": "2025-01-03T10:00:00Z"},
+    }
+    for _, ev := range events {
+        body, _ := json.Marshal(ev)
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trackers/"+code+"/events", bytes.NewReader(body)))
+        if rr.Code != http.StatusOK {
+            t.Fatalf("expected 200, got %d; body=%s", rr.Code, rr.Body.String())
+        }
+    }
+
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/trackers/"+code, nil))
+    var res TrackerResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+        t.Fatalf("unmarshal failed: %v", err)
+    }
+    if res.Status != "delivered" || res.LastEventAt != "2025-01-03T12:00:00Z" {
+        t.Fatalf("unexpected tracker state: %+v", res)
+    }
+
+    var n int
+    if err := pool.QueryRow(t.Context(), `
+        SELECT COUNT(*) FROM tracking_events e JOIN trackers t ON t.id = e.tracker_id
+        WHERE t.carrier_tracking_code = $1
+    `, code).Scan(&n); err != nil || n != 4 {
+        t.Fatalf("expected 4 stored events, got %d (err=%v)", n, err)
+    }
+
+    _, _ = pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = $1`, code)
+}
+
+func TestRegisterTrackers(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    h := New(pool)
+
+    codes := []string{"1Z999AA10123456784", "1234567891", "123456789013"}
+    _, _ = pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = ANY($1)`, codes)
+    defer pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = ANY($1)`, codes)
+    _, _ = pool.Exec(t.Context(), `DELETE FROM jobs WHERE unique_key = ANY($1)`, codes)
+    defer pool.Exec(t.Context(), `DELETE FROM jobs WHERE unique_key = ANY($1)`, codes)
+
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trackers", bytes.NewReader([]byte(`{"tracking_number":"1z999aa1 0123456784"}`))))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var created TrackerCreateResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+        t.Fatalf("unmarshal failed: %v", err)
+    }
+    if !created.Created || created.Code != codes[0] || created.CarrierCode != "ups" {
+        t.Fatalf("unexpected registration: %+v", created)
+    }
+    // A new tracker's first fetch is queued for the worker
+    var queued int
+    if err := pool.QueryRow(t.Context(), `SELECT COUNT(*) FROM jobs WHERE kind = $1 AND unique_key = $2`,
+        TrackingUpdateJob.Name, codes[0]).Scan(&queued); err != nil {
+        t.Fatalf("count jobs: %v", err)
+    }
+    if queued != 1 {
+        t.Fatalf("expected one tracking.update job, got %d", queued)
+    }
+
+    body, _ := json.Marshal(TrackerBulkRequest{Trackers: []TrackerCreateRequest{
+        {TrackingNumber: codes[0]},
+        {TrackingNumber: codes[1]},
+        {TrackingNumber: codes[2]},
+        {TrackingNumber: "1234567890", CarrierCode: "dhl"},
+    }})
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/trackers/bulk", bytes.NewReader(body)))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var res TrackerBulkResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+        t.Fatalf("unmarshal failed: %v", err)
+    }
+    if res.Created != 2 || res.Existing != 1 || res.Failed != 1 {
+        t.Fatalf("unexpected bulk summary: %+v", res)
+    }
+    if res.Results[1].CarrierCode != "dhl" || res.Results[2].CarrierCode != "" || len(res.Results[2].CarrierCandidates) != 3 {
+        t.Fatalf("unexpected bulk results: %+v", res.Results)
+    }
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+
+    "deliveryinfra/internal/tracking"
+)
+
+// maxBulkTrackers bounds POST /trackers/bulk.
+const maxBulkTrackers = 1000
+
+var errInvalidTrackingNumber = errors.New("invalid tracking number")
+
+// Tracker registration
+type TrackerCreateRequest struct {
+    TrackingNumber string `json:"tracking_number"`
+    CarrierCode    string `json:"carrier_code"`
+    // OrgSlug, when set, makes the org the owner of a new tracker, so the
+    // org's webhook endpoints may report its events.
+    OrgSlug string `json:"org_slug,omitempty"`
+}
+
+type TrackerCreateResponse struct {
+    TrackerID         string   `json:"tracker_id"`
+    Code              string   `json:"code"`
+    CarrierCode       string   `json:"carrier_code,omitempty"`
+    CarrierCandidates []string `json:"carrier_candidates,omitempty"`
+    Status            string   `json:"status"`
+    Created           bool     `json:"created"`
+}
+
+// TrackerBulkRequest registers many numbers; OrgSlug applies to all of them
+// and the items' own org_slug is ignored.
+type TrackerBulkRequest struct {
+    OrgSlug  string                 `json:"org_slug,omitempty"`
+    Trackers []TrackerCreateRequest `json:"trackers"`
+}
+
+type TrackerBulkResult struct {
+    TrackingNumber    string   `json:"tracking_number"`
+    Result            string   `json:"result"` // created|existing|error
+    TrackerID         string   `json:"tracker_id,omitempty"`
+    CarrierCode       string   `json:"carrier_code,omitempty"`
+    CarrierCandidates []string `json:"carrier_candidates,omitempty"`
+    Error             string   `json:"error,omitempty"`
+}
+
+type TrackerBulkResponse struct {
+    Created  int                 `json:"created"`
+    Existing int                 `json:"existing"`
+    Failed   int                 `json:"failed"`
+    Results  []TrackerBulkResult `json:"results"`
+}
+
+// handleCreateTracker starts tracking a number that has no shipment here, e.g.
+// a label bought outside this service. Registering a known number is not an
+// error; the existing tracker is returned with created=false.
+func (s *Server) handleCreateTracker(w http.ResponseWriter, r *http.Request) {
+    var req TrackerCreateRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    number, carrier, candidates, err := resolveTrackerCarrier(req.TrackingNumber, req.CarrierCode)
+    if err != nil {
+        if errors.Is(err, errInvalidTrackingNumber) {
+            writeErrorJSON(w, http.StatusBadRequest, "invalid_tracking_number", err.Error())
+            return
+        }
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+    orgID, ok := s.trackerOwner(w, r, req.OrgSlug)
+    if !ok {
+        return
+    }
+    resp, err := s.createTracker(r.Context(), number, carrier, orgID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    resp.CarrierCandidates = candidates
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// handleBulkCreateTrackers registers many numbers at once. Items are
+// independent: an invalid number is reported in its result without failing
+// the rest.
+func (s *Server) handleBulkCreateTrackers(w http.ResponseWriter, r *http.Request) {
+    var req TrackerBulkRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    if len(req.Trackers) == 0 {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "trackers required")
+        return
+    }
+    if len(req.Trackers) > maxBulkTrackers {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("at most %d trackers per request", maxBulkTrackers))
+        return
+    }
+
+    orgID, ok := s.trackerOwner(w, r, req.OrgSlug)
+    if !ok {
+        return
+    }
+    ctx := r.Context()
+    resp := TrackerBulkResponse{Results: make([]TrackerBulkResult, 0, len(req.Trackers))}
+    for _, item := range req.Trackers {
+        res := TrackerBulkResult{TrackingNumber: item.TrackingNumber}
+        number, carrier, candidates, err := resolveTrackerCarrier(item.TrackingNumber, item.CarrierCode)
+        if err != nil {
+            res.Result, res.Error = "error", err.Error()
+            resp.Failed++
+            resp.Results = append(resp.Results, res)
+            continue
+        }
+        tr, err := s.createTracker(ctx, number, carrier, orgID)
+        if err != nil {
+            res.Result, res.Error = "error", "db error"
+            resp.Failed++
+            resp.Results = append(resp.Results, res)
+            continue
+        }
+        res.TrackingNumber = tr.Code
+        res.TrackerID = tr.TrackerID
+        res.CarrierCode = tr.CarrierCode
+        res.CarrierCandidates = candidates
+        if tr.Created {
+            res.Result = "created"
+            resp.Created++
+        } else {
+            res.Result = "existing"
+            resp.Existing++
+        }
+        resp.Results = append(resp.Results, res)
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// resolveTrackerCarrier normalises a tracking number and picks its carrier.
+// An explicit carrier must accept the number when its format is known.
+// Without one, a single detected carrier is used; when several match, the
+// carrier is left empty and the candidates are returned for the caller to
+// choose from.
+func resolveTrackerCarrier(number, carrier string) (string, string, []string, error) {
+    number = tracking.NormalizeNumber(number)
+    if number == "" {
+        return "", "", nil, errors.New("tracking_number required")
+    }
+    carrier = strings.ToLower(strings.TrimSpace(carrier))
+    if carrier != "" {
+        if valid, known := tracking.ValidNumber(carrier, number); known && !valid {
+            return "", "", nil, fmt.Errorf("%w for carrier %s", errInvalidTrackingNumber, carrier)
+        }
+        return number, carrier, nil, nil
+    }
+    candidates := tracking.Detect(number)
+    if len(candidates) == 1 {
+        return number, candidates[0], nil, nil
+    }
+    return number, "", candidates, nil
+}
+
+// trackerOwner resolves the optional org_slug of a registration; it writes
+// the error response and reports false when the org does not exist.
+func (s *Server) trackerOwner(w http.ResponseWriter, r *http.Request, slug string) (*uuid.UUID, bool) {
+    slug = strings.TrimSpace(slug)
+    if slug == "" {
+        return nil, true
+    }
+    orgID, err := resolveOrgID(r.Context(), s.db, slug)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "org not found")
+            return nil, false
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return nil, false
+    }
+    return &orgID, true
+}
+
+// createTracker registers number and, when the tracker is new, enqueues its
+// first fetch in the same transaction.
+func (s *Server) createTracker(ctx context.Context, number, carrier string, orgID *uuid.UUID) (TrackerCreateResponse, error) {
+    tx, err := s.db.Begin(ctx)
+    if err != nil {
+        return TrackerCreateResponse{}, err
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+    resp, err := registerTracker(ctx, tx, number, carrier, orgID)
+    if err != nil {
+        return TrackerCreateResponse{}, err
+    }
+    if resp.Created {
+        if err := enqueueTrackingUpdate(ctx, tx, resp.CarrierCode, resp.Code); err != nil {
+            return TrackerCreateResponse{}, err
+        }
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return TrackerCreateResponse{}, err
+    }
+    return resp, nil
+}
+
+// registerTracker creates the tracker for number, or returns the existing one.
+// A carrier is only filled in when the tracker does not have one yet, and
+// orgID only becomes the owner of a tracker without an owner or a shipment.
+func registerTracker(ctx context.Context, q dbtx, number, carrier string, orgID *uuid.UUID) (TrackerCreateResponse, error) {
+    var (
+        id          uuid.UUID
+        carrierCode *string
+        resp        = TrackerCreateResponse{Code: number}
+    )
+    err := q.QueryRow(ctx, `
+        INSERT INTO trackers (id, carrier_tracking_code, carrier_code, status, metadata, created_by_org_id)
+        VALUES ($1, $2, $3, 'unknown', '{}'::jsonb, $4)
+        ON CONFLICT (carrier_tracking_code) DO UPDATE
+        SET carrier_code = COALESCE(trackers.carrier_code, EXCLUDED.carrier_code),
+            created_by_org_id = CASE
+                WHEN trackers.shipment_id IS NULL THEN COALESCE(trackers.created_by_org_id, EXCLUDED.created_by_org_id)
+                ELSE trackers.created_by_org_id
+            END
+        RETURNING id, carrier_code, COALESCE(status, 'unknown'), (xmax = 0)
+    `, uuid.New(), number, nullIfEmpty(carrier), orgID).Scan(&id, &carrierCode, &resp.Status, &resp.Created)
+    if err != nil {
+        return TrackerCreateResponse{}, err
+    }
+    resp.TrackerID = id.String()
+    if carrierCode != nil {
+        resp.CarrierCode = *carrierCode
+    }
+    return resp, nil
+}
+
+// Tracker event timeline
+type TrackingEventItem struct {
+    ID            string          `json:"id"`
+    OccurredAt    string          `json:"occurred_at"`
+    Status        string          `json:"status"`
+    Substatus     string          `json:"substatus,omitempty"`
+    CarrierStatus string          `json:"carrier_status,omitempty"`
+    Description   string          `json:"description,omitempty"`
+    Location      json.RawMessage `json:"location"`
+    Raw           json.RawMessage `json:"raw,omitempty"`
+}
+
+type TrackerEventsResponse struct {
+    Code   string              `json:"code"`
+    Status string              `json:"status"`
+    Total  int                 `json:"total"`
+    Limit  int                 `json:"limit"`
+    Offset int                 `json:"offset"`
+    Events []TrackingEventItem `json:"events"`
+}
+
+// handleGetTrackerEvents returns a tracker's events ordered by occurred_at
+// (oldest first, or newest first with order=desc). The raw provider payload is
+// omitted unless include_raw=true.
+func (s *Server) handleGetTrackerEvents(w http.ResponseWriter, r *http.Request) {
+    code := chi.URLParam(r, "code")
+    if strings.TrimSpace(code) == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "code required")
+        return
+    }
+    q := r.URL.Query()
+    limit, offset, ok := parsePagination(w, r, 100, 500)
+    if !ok {
+        return
+    }
+    direction := "ASC"
+    switch strings.ToLower(q.Get("order")) {
+    case "", "asc":
+    case "desc":
+        direction = "DESC"
+    default:
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "order must be asc or desc")
+        return
+    }
+    includeRaw := q.Get("include_raw") == "true" || q.Get("include_raw") == "1"
+
+    ctx := r.Context()
+    var (
+        trackerID uuid.UUID
+        status    *string
+        total     int
+    )
+    err := s.db.QueryRow(ctx, `
+        SELECT t.id, t.status, (SELECT COUNT(*) FROM tracking_events e WHERE e.tracker_id = t.id)
+        FROM trackers t
+        WHERE t.carrier_tracking_code = $1
+    `, code).Scan(&trackerID, &status, &total)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+
+    // direction is one of two constants above, never user input.
+    rows, err := s.db.Query(ctx, `
+        SELECT id, occurred_at, COALESCE(status, 'unknown'), COALESCE(substatus, ''), COALESCE(carrier_status, ''),
+               COALESCE(description, ''), location,
+               CASE WHEN $2 THEN raw END
+        FROM tracking_events
+        WHERE tracker_id = $1
+        ORDER BY occurred_at `+direction+`, created_at `+direction+`, id
+        LIMIT $3 OFFSET $4
+    `, trackerID, includeRaw, limit, offset)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer rows.Close()
+
+    resp := TrackerEventsResponse{Code: code, Total: total, Limit: limit, Offset: offset, Events: []TrackingEventItem{}}
+    if status != nil {
+        resp.Status = *status
+    }
+    for rows.Next() {
+        var (
+            id       uuid.UUID
+            occurred time.Time
+            ev       TrackingEventItem
+            location string
+            raw      *string
+        )
+        if err := rows.Scan(&id, &occurred, &ev.Status, &ev.Substatus, &ev.CarrierStatus, &ev.Description, &location, &raw); err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+            return
+        }
+        ev.ID = id.String()
+        ev.OccurredAt = occurred.UTC().Format(time.RFC3339)
+        ev.Location = json.RawMessage(location)
+        if raw != nil {
+            ev.Raw = json.RawMessage(*raw)
+        }
+        resp.Events = append(resp.Events, ev)
+    }
+    if err := rows.Err(); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,483 @@
+package server
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "math/rand"
+    "net"
+    "net/http"
+    "net/netip"
+    "strconv"
+    "syscall"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "deliveryinfra/internal/jobs"
+    "deliveryinfra/internal/secrets"
+)
+
+// Outbound event types. A webhook subscribes to one type, a "shipment.*"
+// prefix or "*".
+const (
+    eventShipmentCreated   = "shipment.created"
+    eventShipmentDelivered = "shipment.delivered"
+    eventTrackerUpdated    = "tracker.updated"
+)
+
+// Dispatch statuses: pending until delivered or out of attempts (failed).
+const (
+    dispatchStatusPending   = "pending"
+    dispatchStatusDelivered = "delivered"
+    dispatchStatusFailed    = "failed"
+)
+
+const (
+    // Retry delays double per attempt up to six hours, with jitter so retries
+    // of many dispatches spread out.
+    dispatchBackoffBase = 10 * time.Second
+    dispatchBackoffMax  = 6 * time.Hour
+    // dispatchResponseLimit caps the response body kept per attempt.
+    dispatchResponseLimit = 4096
+    dispatchUserAgent     = "deliveryinfra-webhooks/1"
+)
+
+// webhookSubscribed matches webhooks of org $1 subscribed to event type $2.
+const webhookSubscribed = `
+    w.org_id = $1 AND w.enabled
+    AND (w.event = $2 OR w.event = '*'
+         OR (right(w.event, 2) = '.*' AND $2 LIKE left(w.event, -1) || '%'))`
+
+// EventEnvelope is the body of every outbound webhook.
+type EventEnvelope struct {
+    ID        string          `json:"id"`
+    Type      string          `json:"type"`
+    CreatedAt string          `json:"created_at"`
+    Data      json.RawMessage `json:"data"`
+}
+
+// isWebhookEventType reports whether t is an event type webhooks receive.
+func isWebhookEventType(t string) bool {
+    for _, et := range webhookEventTypes {
+        if et == t {
+            return true
+        }
+    }
+    return false
+}
+
+// emitEvent records an event and a dispatch per subscribed webhook. The
+// event keeps its outbox id, so a redelivered outbox message is a no-op;
+// events nobody subscribes to are not recorded.
+func emitEvent(ctx context.Context, q dbtx, id, orgID uuid.UUID, eventType string, createdAt time.Time, data json.RawMessage) error {
+    payload, err := eventPayload(id, eventType, createdAt, data)
+    if err != nil {
+        return err
+    }
+    _, err = q.Exec(ctx, `
+        WITH subs AS (
+            SELECT w.id FROM webhooks w WHERE `+webhookSubscribed+`
+        ), ev AS (
+            INSERT INTO webhook_events (id, org_id, type, payload, created_at)
+            SELECT $3, $1, $2, $4::jsonb, $5
+            WHERE EXISTS (SELECT 1 FROM subs)
+            ON CONFLICT (id) DO NOTHING
+            RETURNING id
+        )
+        INSERT INTO webhook_dispatches (webhook_id, event_id)
+        SELECT subs.id, ev.id FROM subs, ev
+    `, orgID, eventType, id, string(payload), createdAt)
+    return err
+}
+
+// eventPayload builds the envelope sent for an event.
+func eventPayload(id uuid.UUID, eventType string, createdAt time.Time, data any) ([]byte, error) {
+    raw, err := json.Marshal(data)
+    if err != nil {
+        return nil, err
+    }
+    return json.Marshal(EventEnvelope{
+        ID:        id.String(),
+        Type:      eventType,
+        CreatedAt: createdAt.UTC().Format(time.RFC3339),
+        Data:      raw,
+    })
+}
+
+// WebhookDispatcherConfig controls the outbound webhook dispatcher. Zero
+// values use the defaults.
+type WebhookDispatcherConfig struct {
+    // Tick is how often due dispatches are looked for (default 1s).
+    Tick time.Duration
+    // Concurrency is the number of requests in flight (default 4).
+    Concurrency int
+    // MaxAttempts marks a dispatch failed after this many attempts (default 12).
+    MaxAttempts int
+    // Timeout bounds each request (default 10s).
+    Timeout time.Duration
+    // A webhook is disabled once DisableFailures consecutive attempts have
+    // failed over at least DisableAfter (defaults 20 and 24h).
+    DisableFailures int
+    DisableAfter    time.Duration
+    // Retention is how long finished dispatches and their attempts are kept
+    // (default 30 days).
+    Retention time.Duration
+    // AllowPrivateURLs lets deliveries reach loopback, private and link-local
+    // addresses, for receivers on a development machine.
+    AllowPrivateURLs bool
+}
+
+// WebhookDispatcher delivers pending dispatches to subscribed URLs. Each
+// dispatch is leased with SKIP LOCKED so every replica can run one, and no
+// row lock is held during the request. Every attempt is logged in
+// webhook_attempts.
+type WebhookDispatcher struct {
+    db     *pgxpool.Pool
+    keys   *secrets.Keyring
+    client *http.Client
+    cfg    WebhookDispatcherConfig
+    now    func() time.Time
+    jitter func(n int64) int64
+}
+
+// NewWebhookDispatcher creates a dispatcher; keys decrypt the webhooks'
+// signing secrets.
+func NewWebhookDispatcher(db *pgxpool.Pool, keys *secrets.Keyring, cfg WebhookDispatcherConfig) *WebhookDispatcher {
+    if cfg.Tick <= 0 {
+        cfg.Tick = time.Second
+    }
+    if cfg.Concurrency <= 0 {
+        cfg.Concurrency = 4
+    }
+    if cfg.MaxAttempts <= 0 {
+        cfg.MaxAttempts = 12
+    }
+    if cfg.Timeout <= 0 {
+        cfg.Timeout = 10 * time.Second
+    }
+    if cfg.DisableFailures <= 0 {
+        cfg.DisableFailures = 20
+    }
+    if cfg.DisableAfter <= 0 {
+        cfg.DisableAfter = 24 * time.Hour
+    }
+    if cfg.Retention <= 0 {
+        cfg.Retention = 30 * 24 * time.Hour
+    }
+    return &WebhookDispatcher{
+        db:     db,
+        keys:   keys,
+        client: newWebhookClient(cfg.Timeout, cfg.AllowPrivateURLs),
+        cfg:    cfg,
+        now:    time.Now,
+        jitter: rand.Int63n,
+    }
+}
+
+// errForbiddenAddress is returned for webhook destinations on loopback,
+// private, link-local or otherwise internal addresses.
+var errForbiddenAddress = errors.New("destination address not allowed")
+
+// forbiddenAddr reports whether subscribers may not reach ip: loopback,
+// private (RFC 1918, fc00::/7), link-local (including the 169.254.169.254
+// metadata service), carrier-grade NAT, unspecified and multicast addresses.
+func forbiddenAddr(ip netip.Addr) bool {
+    ip = ip.Unmap()
+    return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+        ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// sharedAddressSpace is the RFC 6598 carrier-grade NAT range.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// webhookDialControl refuses connections to forbidden addresses. It runs for
+// every address actually dialled, after DNS resolution, so a hostname that
+// resolves or rebinds to an internal address is refused too.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+    ap, err := netip.ParseAddrPort(address)
+    if err != nil {
+        return err
+    }
+    if forbiddenAddr(ap.Addr()) {
+        return fmt.Errorf("%w: %s", errForbiddenAddress, ap.Addr())
+    }
+    return nil
+}
+
+// newWebhookClient returns the client for outbound deliveries. It dials
+// only public addresses unless allowPrivate, bypasses proxies, which would
+// dial on its behalf, and never follows redirects: a 3xx is recorded as the
+// attempt's response, so a receiver cannot bounce requests elsewhere.
+func newWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+    dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+    if !allowPrivate {
+        dialer.Control = webhookDialControl
+    }
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.Proxy = nil
+    transport.DialContext = dialer.DialContext
+    return &http.Client{
+        Timeout:   timeout,
+        Transport: transport,
+        CheckRedirect: func(*http.Request, []*http.Request) error {
+            return http.ErrUseLastResponse
+        },
+    }
+}
+
+// Run delivers due dispatches until ctx is done.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+    jobs.Poll(ctx, "webhook dispatcher", d.cfg.Tick, d.DispatchPending, d.prune)
+}
+
+// DispatchPending delivers due dispatches until none are left and returns
+// how many attempts it made.
+func (d *WebhookDispatcher) DispatchPending(ctx context.Context) (int, error) {
+    return jobs.Drain(ctx, d.cfg.Concurrency, d.dispatchNext)
+}
+
+type dispatchJob struct {
+    id        uuid.UUID
+    webhookID uuid.UUID
+    eventID   uuid.UUID
+    eventType string
+    attempt   int
+    targetURL string
+    sealed    []byte
+    // prevSealed is the rotated-out secret while its grace period lasts.
+    prevSealed []byte
+    payload    []byte
+}
+
+// dispatchAttempt is one logged request.
+type dispatchAttempt struct {
+    headers  http.Header
+    status   int
+    response string
+    latency  time.Duration
+    err      error
+}
+
+// dispatchNext leases one due dispatch of an enabled webhook, delivers it and
+// records the outcome. It reports false when nothing was due.
+func (d *WebhookDispatcher) dispatchNext(ctx context.Context) (bool, error) {
+    var job dispatchJob
+    lease := d.now().Add(d.cfg.Timeout + 30*time.Second)
+    err := d.db.QueryRow(ctx, `
+        UPDATE webhook_dispatches
+        SET next_attempt_at = $1, attempts = attempts + 1, updated_at = NOW()
+        WHERE id = (
+            SELECT d.id
+            FROM webhook_dispatches d JOIN webhooks w ON w.id = d.webhook_id
+            WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() AND w.enabled
+            ORDER BY d.next_attempt_at
+            LIMIT 1
+            FOR UPDATE OF d SKIP LOCKED
+        )
+        RETURNING id, webhook_id, event_id, attempts
+    `, lease).Scan(&job.id, &job.webhookID, &job.eventID, &job.attempt)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return false, nil
+        }
+        return false, err
+    }
+    err = d.db.QueryRow(ctx, `
+        SELECT w.target_url, w.secret_encrypted,
+               CASE WHEN w.previous_secret_expires_at > NOW() THEN w.previous_secret_encrypted END,
+               e.type, e.payload::text
+        FROM webhooks w, webhook_events e
+        WHERE w.id = $1 AND e.id = $2
+    `, job.webhookID, job.eventID).Scan(&job.targetURL, &job.sealed, &job.prevSealed, &job.eventType, &job.payload)
+    if err != nil {
+        return true, err
+    }
+    return true, d.record(ctx, job, d.send(ctx, job))
+}
+
+// send makes one signed request. Only 2xx responses count as delivered.
+func (d *WebhookDispatcher) send(ctx context.Context, job dispatchJob) dispatchAttempt {
+    var a dispatchAttempt
+    keys, err := d.signingSecrets(job)
+    if err != nil {
+        a.err = err
+        return a
+    }
+    if err := validWebhookURL(job.targetURL, d.cfg.AllowPrivateURLs); err != nil {
+        a.err = fmt.Errorf("invalid target url %q: %w", job.targetURL, err)
+        return a
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.targetURL, bytes.NewReader(job.payload))
+    if err != nil {
+        a.err = err
+        return a
+    }
+    ts := strconv.FormatInt(d.now().Unix(), 10)
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", dispatchUserAgent)
+    req.Header.Set("X-Event-Id", job.eventID.String())
+    req.Header.Set("X-Event-Type", job.eventType)
+    req.Header.Set("X-Delivery-Id", job.id.String())
+    sig := "t=" + ts
+    for _, secret := range keys {
+        sig += ",v1=" + hex.EncodeToString(webhookMAC(secret, []byte(ts+"."+string(job.payload))))
+    }
+    req.Header.Set("X-Signature", sig)
+    a.headers = req.Header.Clone()
+
+    start := time.Now()
+    resp, err := d.client.Do(req)
+    a.latency = time.Since(start)
+    if err != nil {
+        a.err = err
+        return a
+    }
+    defer resp.Body.Close()
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, dispatchResponseLimit))
+    a.status = resp.StatusCode
+    a.response = string(body)
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        a.err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+    }
+    return a
+}
+
+// signingSecrets decrypts the webhook's secret, then the previous one while
+// a rotation's grace period lasts; every payload must be signed.
+func (d *WebhookDispatcher) signingSecrets(job dispatchJob) ([]string, error) {
+    if len(job.sealed) == 0 {
+        return nil, errors.New("webhook has no signing secret")
+    }
+    if d.keys == nil {
+        return nil, errors.New("secret keys not configured")
+    }
+    plain, err := d.keys.Open(job.sealed, job.webhookID[:])
+    if err != nil {
+        return nil, err
+    }
+    out := []string{string(plain)}
+    if len(job.prevSealed) > 0 {
+        if prev, err := d.keys.Open(job.prevSealed, job.webhookID[:]); err == nil {
+            out = append(out, string(prev))
+        }
+    }
+    return out, nil
+}
+
+// record logs the attempt, schedules a retry or finishes the dispatch, and
+// tracks the webhook's health, disabling it after sustained failures.
+func (d *WebhookDispatcher) record(ctx context.Context, job dispatchJob, a dispatchAttempt) error {
+    tx, err := d.db.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+
+    headers, _ := json.Marshal(a.headers)
+    var (
+        errText *string
+        status  *int
+    )
+    if a.err != nil {
+        msg := a.err.Error()
+        errText = &msg
+    }
+    if a.status != 0 {
+        status = &a.status
+    }
+    _, err = tx.Exec(ctx, `
+        INSERT INTO webhook_attempts (
+            dispatch_id, webhook_id, event_id, attempt, request_url, request_headers, request_body,
+            response_status, response_body, latency_ms, error
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+    `, job.id, job.webhookID, job.eventID, job.attempt, job.targetURL, headers, string(job.payload),
+        status, nullIfEmpty(a.response), a.latency.Milliseconds(), errText)
+    if err != nil {
+        return err
+    }
+
+    if a.err == nil {
+        _, err = tx.Exec(ctx, `
+            UPDATE webhook_dispatches
+            SET status = 'delivered', delivered_at = NOW(), last_error = NULL, updated_at = NOW()
+            WHERE id = $1
+        `, job.id)
+        if err == nil {
+            _, err = tx.Exec(ctx, `
+                UPDATE webhooks
+                SET consecutive_failures = 0, failing_since = NULL, last_success_at = NOW()
+                WHERE id = $1
+            `, job.webhookID)
+        }
+        if err != nil {
+            return err
+        }
+        return tx.Commit(ctx)
+    }
+
+    status2, next := dispatchStatusPending, d.now().Add(jobs.Backoff(job.attempt, dispatchBackoffBase, dispatchBackoffMax, d.jitter))
+    if job.attempt >= d.cfg.MaxAttempts {
+        status2 = dispatchStatusFailed
+    }
+    _, err = tx.Exec(ctx, `
+        UPDATE webhook_dispatches
+        SET status = $2, next_attempt_at = $3, last_error = $4, updated_at = NOW()
+        WHERE id = $1
+    `, job.id, status2, next, a.err.Error())
+    if err != nil {
+        return err
+    }
+    var (
+        failures     int
+        failingSince time.Time
+    )
+    err = tx.QueryRow(ctx, `
+        UPDATE webhooks
+        SET consecutive_failures = consecutive_failures + 1,
+            failing_since = COALESCE(failing_since, NOW()),
+            last_failure_at = NOW()
+        WHERE id = $1
+        RETURNING consecutive_failures, failing_since
+    `, job.webhookID).Scan(&failures, &failingSince)
+    if err != nil {
+        return err
+    }
+    if failures >= d.cfg.DisableFailures && d.now().Sub(failingSince) >= d.cfg.DisableAfter {
+        reason := fmt.Sprintf("%d consecutive failures since %s; last: %v", failures, failingSince.UTC().Format(time.RFC3339), a.err)
+        _, err = tx.Exec(ctx, `
+            UPDATE webhooks SET enabled = FALSE, disabled_at = NOW(), disabled_reason = $2
+            WHERE id = $1
+        `, job.webhookID, reason)
+        if err != nil {
+            return err
+        }
+        log.Printf("webhook dispatcher: disabled webhook %s: %s", job.webhookID, reason)
+    }
+    return tx.Commit(ctx)
+}
+
+// prune deletes finished dispatches (and their attempts) past retention and
+// events no dispatch refers to any more.
+func (d *WebhookDispatcher) prune(ctx context.Context) error {
+    cutoff := d.now().Add(-d.cfg.Retention)
+    _, err := d.db.Exec(ctx, `
+        DELETE FROM webhook_dispatches WHERE status <> 'pending' AND updated_at < $1
+    `, cutoff)
+    if err != nil {
+        return err
+    }
+    _, err = d.db.Exec(ctx, `
+        DELETE FROM webhook_events e
+        WHERE e.created_at < $1
+          AND NOT EXISTS (SELECT 1 FROM webhook_dispatches d WHERE d.event_id = e.id)
+    `, cutoff)
+    return err
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+
+    "deliveryinfra/internal/db"
+    "deliveryinfra/internal/secrets"
+)
+
+func TestWebhookDispatcher_DeliversAndDisables(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    keys, err := secrets.NewKeyring("1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{9}, 32)))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    slug := fmt.Sprintf("whd-%d", time.Now().UnixNano())
+    var orgID uuid.UUID
+    if err := pool.QueryRow(t.Context(), `INSERT INTO orgs (slug, name) VALUES ($1, 'Dispatch') RETURNING id`, slug).Scan(&orgID); err != nil {
+        t.Fatalf("create org: %v", err)
+    }
+
+    var (
+        mu       sync.Mutex
+        received []*http.Request
+        bodies   [][]byte
+        failing  bool
+    )
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        b, _ := io.ReadAll(r.Body)
+        mu.Lock()
+        defer mu.Unlock()
+        received = append(received, r)
+        bodies = append(bodies, b)
+        if failing {
+            w.WriteHeader(http.StatusInternalServerError)
+        }
+    }))
+    defer srv.Close()
+
+    webhookID := uuid.New()
+    sealed, _ := keys.Seal([]byte("whsec_dispatch"), webhookID[:])
+    _, err = pool.Exec(t.Context(), `
+        INSERT INTO webhooks (id, org_id, event, target_url, secret_encrypted)
+        VALUES ($1, $2, 'shipment.*', $3, $4)
+    `, webhookID, orgID, srv.URL, sealed)
+    if err != nil {
+        t.Fatalf("create webhook: %v", err)
+    }
+
+    h := New(pool)
+    relay := NewOutboxRelay(pool, OutboxRelayConfig{})
+    createShipment := func() {
+        b, _ := json.Marshal(map[string]any{
+            "org_slug":        slug,
+            "tracking_number": fmt.Sprintf("WHD%d", time.Now().UnixNano()),
+            "rate_currency":   "JPY",
+            "ship_to":         map[string]any{"country": "JP"},
+            "ship_from":       map[string]any{"country": "JP"},
+            "package":         map[string]any{"weight_oz": 5},
+        })
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/shipments", bytes.NewReader(b)))
+        if rr.Code != http.StatusOK {
+            t.Fatalf("create shipment: %d %s", rr.Code, rr.Body.String())
+        }
+        if _, err := relay.PublishPending(t.Context()); err != nil {
+            t.Fatalf("publish outbox: %v", err)
+        }
+    }
+
+    d := NewWebhookDispatcher(pool, keys, WebhookDispatcherConfig{MaxAttempts: 1, DisableFailures: 2, DisableAfter: time.Nanosecond, AllowPrivateURLs: true})
+    createShipment()
+    if _, err := d.DispatchPending(t.Context()); err != nil {
+        t.Fatalf("dispatch: %v", err)
+    }
+    mu.Lock()
+    if len(received) != 1 {
+        mu.Unlock()
+        t.Fatalf("expected 1 delivery, got %d", len(received))
+    }
+    var env EventEnvelope
+    _ = json.Unmarshal(bodies[0], &env)
+    req := httptest.NewRequest(http.MethodPost, "/", nil)
+    req.Header.Set("X-Signature", received[0].Header.Get("X-Signature"))
+    _, verr := (&DefaultVerifier{}).Verify(req, bodies[0], "whsec_dispatch", time.Now())
+    failing = true
+    mu.Unlock()
+    if env.Type != eventShipmentCreated || verr != nil {
+        t.Fatalf("unexpected delivery %s: %v", bodies[0], verr)
+    }
+
+    // Two failed deliveries in a row disable the webhook
+    createShipment()
+    createShipment()
+    if _, err := d.DispatchPending(t.Context()); err != nil {
+        t.Fatalf("dispatch: %v", err)
+    }
+    var (
+        enabled  bool
+        attempts int
+        failed   int
+    )
+    _ = pool.QueryRow(t.Context(), `SELECT enabled FROM webhooks WHERE id = $1`, webhookID).Scan(&enabled)
+    _ = pool.QueryRow(t.Context(), `SELECT COUNT(*) FROM webhook_attempts WHERE webhook_id = $1`, webhookID).Scan(&attempts)
+    _ = pool.QueryRow(t.Context(), `SELECT COUNT(*) FROM webhook_dispatches WHERE webhook_id = $1 AND status = 'failed'`, webhookID).Scan(&failed)
+    if enabled || attempts != 3 || failed != 2 {
+        t.Fatalf("enabled=%v attempts=%d failed=%d", enabled, attempts, failed)
+    }
+
+    // Disabled webhooks get no new events
+    createShipment()
+    var n int
+    _ = pool.QueryRow(t.Context(), `SELECT COUNT(*) FROM webhook_dispatches WHERE webhook_id = $1`, webhookID).Scan(&n)
+    if n != 3 {
+        t.Fatalf("expected 3 dispatches, got %d", n)
+    }
+}
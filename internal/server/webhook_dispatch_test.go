@@ -0,0 +1,141 @@
+package server
+
+import (
+    "bytes"
+    "encoding/base64"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/netip"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+
+    "deliveryinfra/internal/jobs"
+    "deliveryinfra/internal/secrets"
+)
+
+func TestDispatchBackoff(t *testing.T) {
+    none := func(int64) int64 { return 0 }
+    full := func(n int64) int64 { return n - 1 }
+    cases := map[int]time.Duration{
+        1:  10 * time.Second,
+        2:  20 * time.Second,
+        5:  160 * time.Second,
+        12: 20480 * time.Second,
+        13: dispatchBackoffMax,
+        50: dispatchBackoffMax,
+    }
+    for attempt, d := range cases {
+        if got := jobs.Backoff(attempt, dispatchBackoffBase, dispatchBackoffMax, none); got != d/2 {
+            t.Errorf("dispatch backoff(%d) without jitter = %v, want %v", attempt, got, d/2)
+        }
+        if got := jobs.Backoff(attempt, dispatchBackoffBase, dispatchBackoffMax, full); got != d {
+            t.Errorf("dispatch backoff(%d) with full jitter = %v, want %v", attempt, got, d)
+        }
+    }
+}
+
+func TestWebhookDispatcherSend(t *testing.T) {
+    keys, err := secrets.NewKeyring("1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got *http.Request
+    var gotBody []byte
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        got = r
+        gotBody, _ = io.ReadAll(r.Body)
+        w.WriteHeader(http.StatusAccepted)
+        _, _ = w.Write([]byte("ok"))
+    }))
+    defer srv.Close()
+
+    job := dispatchJob{
+        id:        uuid.New(),
+        webhookID: uuid.New(),
+        eventID:   uuid.New(),
+        eventType: eventShipmentCreated,
+        attempt:   1,
+        targetURL: srv.URL,
+        payload:   []byte(`{"type":"shipment.created"}`),
+    }
+    job.sealed, err = keys.Seal([]byte("whsec_test"), job.webhookID[:])
+    if err != nil {
+        t.Fatal(err)
+    }
+    d := NewWebhookDispatcher(nil, keys, WebhookDispatcherConfig{AllowPrivateURLs: true})
+    a := d.send(t.Context(), job)
+    if a.err != nil || a.status != http.StatusAccepted || a.response != "ok" {
+        t.Fatalf("unexpected attempt: %+v", a)
+    }
+    if string(gotBody) != string(job.payload) || got.Header.Get("X-Event-Type") != eventShipmentCreated ||
+        got.Header.Get("X-Delivery-Id") != job.id.String() || got.Header.Get("X-Event-Id") != job.eventID.String() {
+        t.Fatalf("unexpected request: %v %s", got.Header, gotBody)
+    }
+    // Receivers can check the signature like any inbound webhook
+    req := httptest.NewRequest(http.MethodPost, "/", nil)
+    req.Header.Set("X-Signature", got.Header.Get("X-Signature"))
+    if _, err := (&DefaultVerifier{}).Verify(req, gotBody, "whsec_test", time.Now()); err != nil {
+        t.Fatalf("signature does not verify: %v", err)
+    }
+
+    // Unsigned deliveries are never sent
+    job.sealed = nil
+    if a := d.send(t.Context(), job); a.err == nil || a.status != 0 {
+        t.Fatalf("expected failure without a secret, got %+v", a)
+    }
+    d.keys = nil
+    job.sealed = []byte{1}
+    if a := d.send(t.Context(), job); a.err == nil {
+        t.Fatalf("expected failure without keys")
+    }
+}
+
+func TestForbiddenAddr(t *testing.T) {
+    for addr, want := range map[string]bool{
+        "127.0.0.1":        true,
+        "::1":              true,
+        "10.1.2.3":         true,
+        "172.16.0.1":       true,
+        "192.168.1.1":      true,
+        "169.254.169.254":  true,
+        "fe80::1":          true,
+        "fd00::1":          true,
+        "100.64.0.1":       true,
+        "0.0.0.0":          true,
+        "::ffff:127.0.0.1": true,
+        "93.184.216.34":    false,
+        "2606:4700::1111":  false,
+    } {
+        if got := forbiddenAddr(netip.MustParseAddr(addr)); got != want {
+            t.Errorf("forbiddenAddr(%s) = %v, want %v", addr, got, want)
+        }
+    }
+}
+
+func TestWebhookClient(t *testing.T) {
+    redirected := false
+    target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { redirected = true }))
+    defer target.Close()
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Redirect(w, r, target.URL, http.StatusFound)
+    }))
+    defer srv.Close()
+
+    // Loopback receivers are refused at dial time
+    if _, err := newWebhookClient(time.Second, false).Get(srv.URL); !errors.Is(err, errForbiddenAddress) {
+        t.Fatalf("expected errForbiddenAddress, got %v", err)
+    }
+    // Redirects are reported, not followed
+    resp, err := newWebhookClient(time.Second, true).Get(srv.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusFound || redirected {
+        t.Fatalf("expected unfollowed 302, got %d (redirected=%v)", resp.StatusCode, redirected)
+    }
+}
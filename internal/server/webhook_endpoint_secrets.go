@@ -0,0 +1,422 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+
+    "deliveryinfra/internal/secrets"
+)
+
+// defaultSecretGrace is how long the previous primary secret stays valid
+// after a promotion, so senders still signing with it are not rejected.
+const defaultSecretGrace = 24 * time.Hour
+
+// Secret states, derived from the validity window and the primary flag.
+const (
+    secretStatePrimary   = "primary"
+    secretStateStaged    = "staged"
+    secretStateScheduled = "scheduled"
+    secretStateRetiring  = "retiring"
+    secretStateExpired   = "expired"
+)
+
+var errRetirePrimary = errors.New("cannot retire the primary secret; promote another first")
+
+// WebhookEndpointSecret is one of an endpoint's signing secrets. Deliveries
+// verify against every secret valid now (active_from <= now < expires_at),
+// so a rotation stages the new secret, promotes it once the provider signs
+// with it and lets the old one expire.
+type WebhookEndpointSecret struct {
+    ID         string `json:"id"`
+    Primary    bool   `json:"primary"`
+    State      string `json:"state"`
+    KeyVersion int    `json:"key_version"`
+    ActiveFrom string `json:"active_from"`
+    ExpiresAt  string `json:"expires_at,omitempty"`
+    // LastMatchedAt and MatchCount show whether senders still use a secret.
+    LastMatchedAt string `json:"last_matched_at,omitempty"`
+    MatchCount    int64  `json:"match_count"`
+    // Secret is only returned when staged.
+    Secret    string `json:"secret,omitempty"`
+    CreatedAt string `json:"created_at"`
+}
+
+type WebhookSecretStageRequest struct {
+    // Secret is the provider's new signing secret; generated when empty.
+    Secret string `json:"secret"`
+    // ActiveFrom and ExpiresAt are RFC3339; ActiveFrom defaults to now.
+    ActiveFrom string `json:"active_from"`
+    ExpiresAt  string `json:"expires_at"`
+}
+
+type WebhookSecretPromoteRequest struct {
+    // Grace keeps the previous primary valid for this long ("1h"); default
+    // 24h, "0s" expires it at once.
+    Grace string `json:"grace"`
+}
+
+type WebhookSecretRetireRequest struct {
+    // ExpiresAt is RFC3339; defaults to now.
+    ExpiresAt string `json:"expires_at"`
+}
+
+// validEndpointSecrets decrypts the endpoint's secrets valid now, primary
+// first. Secrets that fail to decrypt (e.g. under a removed key) are skipped.
+func (s *Server) validEndpointSecrets(ctx context.Context, endpointID uuid.UUID) ([]webhookSecret, error) {
+    rows, err := s.db.Query(ctx, `
+        SELECT id, secret_encrypted
+        FROM webhook_endpoint_secrets
+        WHERE endpoint_id = $1 AND active_from <= NOW() AND (expires_at IS NULL OR expires_at > NOW())
+        ORDER BY is_primary DESC, active_from DESC
+    `, endpointID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []webhookSecret
+    for rows.Next() {
+        var (
+            id     uuid.UUID
+            sealed []byte
+        )
+        if err := rows.Scan(&id, &sealed); err != nil {
+            return nil, err
+        }
+        plain, err := s.keys.Open(sealed, endpointID[:])
+        if err != nil {
+            log.Printf("webhook endpoint %s: secret %s: %v", endpointID, id, err)
+            continue
+        }
+        out = append(out, webhookSecret{id: id, value: string(plain)})
+    }
+    return out, rows.Err()
+}
+
+// touchWebhookEndpointSecret records that a delivery was verified with the
+// secret.
+func touchWebhookEndpointSecret(ctx context.Context, q dbtx, id uuid.UUID) error {
+    _, err := q.Exec(ctx, `
+        UPDATE webhook_endpoint_secrets
+        SET last_matched_at = NOW(), match_count = match_count + 1
+        WHERE id = $1
+    `, id)
+    return err
+}
+
+func (s *Server) handleListWebhookEndpointSecrets(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookEndpointID(w, r)
+    if !ok {
+        return
+    }
+    ctx := r.Context()
+    if _, err := loadWebhookEndpoint(ctx, s.db, id); err != nil {
+        writeWebhookEndpointError(w, err)
+        return
+    }
+    rows, err := s.db.Query(ctx, webhookSecretSelect+` WHERE endpoint_id = $1 ORDER BY created_at, id`, id)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer rows.Close()
+    list := []WebhookEndpointSecret{}
+    now := time.Now()
+    for rows.Next() {
+        sec, err := scanWebhookSecret(rows, now)
+        if err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+            return
+        }
+        list = append(list, sec)
+    }
+    if err := rows.Err(); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]any{"secrets": list})
+}
+
+// handleStageWebhookEndpointSecret adds a non-primary secret. It verifies
+// deliveries from active_from on, so the provider can switch before it is
+// promoted.
+func (s *Server) handleStageWebhookEndpointSecret(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookEndpointID(w, r)
+    if !ok {
+        return
+    }
+    var req WebhookSecretStageRequest
+    if err := decodeOptionalJSON(r, &req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    activeFrom, err := parseOptionalTime(req.ActiveFrom)
+    if err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid active_from")
+        return
+    }
+    expiresAt, err := parseOptionalTime(req.ExpiresAt)
+    if err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid expires_at")
+        return
+    }
+    if activeFrom != nil && expiresAt != nil && !expiresAt.After(*activeFrom) {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "expires_at must be after active_from")
+        return
+    }
+    if s.keys == nil {
+        writeErrorJSON(w, http.StatusServiceUnavailable, "secrets_not_configured", "secret keys not configured")
+        return
+    }
+    secret := strings.TrimSpace(req.Secret)
+    if secret == "" {
+        if secret, err = generateWebhookSecret(); err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "secret_error", "failed to generate secret")
+            return
+        }
+    }
+    sealed, err := s.keys.Seal([]byte(secret), id[:])
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "secret_error", "failed to encrypt secret")
+        return
+    }
+    ctx := r.Context()
+    if _, err := loadWebhookEndpoint(ctx, s.db, id); err != nil {
+        writeWebhookEndpointError(w, err)
+        return
+    }
+    var secretID uuid.UUID
+    err = s.db.QueryRow(ctx, `
+        INSERT INTO webhook_endpoint_secrets (endpoint_id, secret_encrypted, active_from, expires_at)
+        VALUES ($1, $2, COALESCE($3, NOW()), $4)
+        RETURNING id
+    `, id, sealed, activeFrom, expiresAt).Scan(&secretID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to stage secret")
+        return
+    }
+    resp, err := loadWebhookSecret(ctx, s.db, id, secretID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    resp.Secret = secret
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// handlePromoteWebhookEndpointSecret makes a secret primary. The previous
+// primary stays valid for the grace period.
+func (s *Server) handlePromoteWebhookEndpointSecret(w http.ResponseWriter, r *http.Request) {
+    id, secretID, ok := parseWebhookSecretIDs(w, r)
+    if !ok {
+        return
+    }
+    var req WebhookSecretPromoteRequest
+    if err := decodeOptionalJSON(r, &req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    grace := defaultSecretGrace
+    if strings.TrimSpace(req.Grace) != "" {
+        d, err := time.ParseDuration(strings.TrimSpace(req.Grace))
+        if err != nil || d < 0 {
+            writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid grace")
+            return
+        }
+        grace = d
+    }
+
+    ctx := r.Context()
+    tx, err := s.db.Begin(ctx)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+    // Serialize rotations of the same endpoint
+    if _, err := tx.Exec(ctx, `SELECT 1 FROM webhook_endpoints WHERE id = $1 FOR UPDATE`, id); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    cur, err := loadWebhookSecret(ctx, tx, id, secretID)
+    if err != nil {
+        writeWebhookSecretError(w, err)
+        return
+    }
+    if cur.State == secretStateExpired {
+        writeErrorJSON(w, http.StatusConflict, "conflict", "secret has expired")
+        return
+    }
+    if !cur.Primary {
+        _, err = tx.Exec(ctx, `
+            UPDATE webhook_endpoint_secrets
+            SET is_primary = FALSE,
+                expires_at = LEAST(COALESCE(expires_at, 'infinity'), $2)
+            WHERE endpoint_id = $1 AND is_primary
+        `, id, time.Now().Add(grace))
+        if err == nil {
+            _, err = tx.Exec(ctx, `
+                UPDATE webhook_endpoint_secrets
+                SET is_primary = TRUE, active_from = LEAST(active_from, NOW()), expires_at = NULL
+                WHERE id = $1
+            `, secretID)
+        }
+        if err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to promote secret")
+            return
+        }
+    }
+    resp, err := loadWebhookSecret(ctx, tx, id, secretID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if err := tx.Commit(ctx); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to promote secret")
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// handleRetireWebhookEndpointSecret expires a non-primary secret, now or at
+// expires_at.
+func (s *Server) handleRetireWebhookEndpointSecret(w http.ResponseWriter, r *http.Request) {
+    id, secretID, ok := parseWebhookSecretIDs(w, r)
+    if !ok {
+        return
+    }
+    var req WebhookSecretRetireRequest
+    if err := decodeOptionalJSON(r, &req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    expiresAt, err := parseOptionalTime(req.ExpiresAt)
+    if err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid expires_at")
+        return
+    }
+    ctx := r.Context()
+    var primary bool
+    err = s.db.QueryRow(ctx, `
+        UPDATE webhook_endpoint_secrets
+        SET expires_at = CASE
+                WHEN is_primary OR expires_at <= NOW() THEN expires_at
+                ELSE COALESCE($3, NOW())
+            END
+        WHERE endpoint_id = $1 AND id = $2
+        RETURNING is_primary
+    `, id, secretID, expiresAt).Scan(&primary)
+    if err != nil {
+        writeWebhookSecretError(w, err)
+        return
+    }
+    if primary {
+        writeErrorJSON(w, http.StatusConflict, "conflict", errRetirePrimary.Error())
+        return
+    }
+    resp, err := loadWebhookSecret(ctx, s.db, id, secretID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func parseWebhookSecretIDs(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+    id, ok := parseWebhookEndpointID(w, r)
+    if !ok {
+        return uuid.Nil, uuid.Nil, false
+    }
+    secretID, err := uuid.Parse(strings.TrimSpace(chi.URLParam(r, "secret_id")))
+    if err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid secret id")
+        return uuid.Nil, uuid.Nil, false
+    }
+    return id, secretID, true
+}
+
+func writeWebhookSecretError(w http.ResponseWriter, err error) {
+    if errors.Is(err, pgx.ErrNoRows) {
+        writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "webhook secret not found")
+        return
+    }
+    writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+}
+
+// decodeOptionalJSON decodes the body into v; an empty body leaves v as is.
+func decodeOptionalJSON(r *http.Request, v any) error {
+    err := json.NewDecoder(r.Body).Decode(v)
+    if errors.Is(err, io.EOF) {
+        return nil
+    }
+    return err
+}
+
+func parseOptionalTime(s string) (*time.Time, error) {
+    if strings.TrimSpace(s) == "" {
+        return nil, nil
+    }
+    t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+    if err != nil {
+        return nil, err
+    }
+    return &t, nil
+}
+
+const webhookSecretSelect = `
+    SELECT id, is_primary, secret_encrypted, active_from, expires_at, last_matched_at, match_count, created_at
+    FROM webhook_endpoint_secrets`
+
+func loadWebhookSecret(ctx context.Context, q dbtx, endpointID, id uuid.UUID) (WebhookEndpointSecret, error) {
+    return scanWebhookSecret(q.QueryRow(ctx, webhookSecretSelect+` WHERE endpoint_id = $1 AND id = $2`, endpointID, id), time.Now())
+}
+
+func scanWebhookSecret(row pgx.Row, now time.Time) (WebhookEndpointSecret, error) {
+    var (
+        sec         WebhookEndpointSecret
+        id          uuid.UUID
+        sealed      []byte
+        activeFrom  time.Time
+        expiresAt   *time.Time
+        lastMatched *time.Time
+        createdAt   time.Time
+    )
+    if err := row.Scan(&id, &sec.Primary, &sealed, &activeFrom, &expiresAt, &lastMatched, &sec.MatchCount, &createdAt); err != nil {
+        return WebhookEndpointSecret{}, err
+    }
+    sec.ID = id.String()
+    sec.KeyVersion = secrets.Version(sealed)
+    sec.State = webhookSecretState(sec.Primary, activeFrom, expiresAt, now)
+    sec.ActiveFrom = activeFrom.UTC().Format(time.RFC3339)
+    if expiresAt != nil {
+        sec.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+    }
+    if lastMatched != nil {
+        sec.LastMatchedAt = lastMatched.UTC().Format(time.RFC3339)
+    }
+    sec.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+    return sec, nil
+}
+
+func webhookSecretState(primary bool, activeFrom time.Time, expiresAt *time.Time, now time.Time) string {
+    switch {
+    case expiresAt != nil && !expiresAt.After(now):
+        return secretStateExpired
+    case primary:
+        return secretStatePrimary
+    case activeFrom.After(now):
+        return secretStateScheduled
+    case expiresAt != nil:
+        return secretStateRetiring
+    }
+    return secretStateStaged
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+    "testing"
+    "time"
+)
+
+func TestWebhookSecretState(t *testing.T) {
+    now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+    past, future := now.Add(-time.Hour), now.Add(time.Hour)
+    cases := []struct {
+        name       string
+        primary    bool
+        activeFrom time.Time
+        expiresAt  *time.Time
+        want       string
+    }{
+        {"primary", true, past, nil, secretStatePrimary},
+        {"staged", false, past, nil, secretStateStaged},
+        {"scheduled", false, future, nil, secretStateScheduled},
+        {"retiring", false, past, &future, secretStateRetiring},
+        {"expired", false, past, &past, secretStateExpired},
+        {"expires now", false, past, &now, secretStateExpired},
+    }
+    for _, c := range cases {
+        if got := webhookSecretState(c.primary, c.activeFrom, c.expiresAt, now); got != c.want {
+            t.Errorf("%s: state = %s, want %s", c.name, got, c.want)
+        }
+    }
+}
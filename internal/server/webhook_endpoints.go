@@ -0,0 +1,438 @@
+package server
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+)
+
+// WebhookEndpoint is an org's inbound webhook endpoint, served at
+// /webhooks/{source}/{id}. Its secrets are stored encrypted (see
+// WebhookEndpointSecret); the first is only returned when the endpoint is
+// created.
+type WebhookEndpoint struct {
+    ID      string `json:"id"`
+    OrgSlug string `json:"org_slug"`
+    Source  string `json:"source"`
+    // Normalizer names the payload normalizer; defaults to Source.
+    Normalizer  string        `json:"normalizer,omitempty"`
+    Verifier    *VerifierSpec `json:"verifier,omitempty"`
+    Description string        `json:"description,omitempty"`
+    Enabled     bool          `json:"enabled"`
+    URL         string        `json:"url"`
+    Secret      string        `json:"secret,omitempty"`
+    CreatedAt   string        `json:"created_at"`
+    UpdatedAt   string        `json:"updated_at"`
+}
+
+type WebhookEndpointCreateRequest struct {
+    OrgSlug    string        `json:"org_slug"`
+    Source     string        `json:"source"`
+    Normalizer string        `json:"normalizer"`
+    Verifier   *VerifierSpec `json:"verifier"`
+    // Secret is the provider's signing secret; generated when empty.
+    Secret      string `json:"secret"`
+    Description string `json:"description"`
+}
+
+// WebhookEndpointUpdateRequest carries a partial update; nil fields are left
+// unchanged.
+type WebhookEndpointUpdateRequest struct {
+    Enabled     *bool         `json:"enabled"`
+    Description *string       `json:"description"`
+    Verifier    *VerifierSpec `json:"verifier"`
+}
+
+// knownWebhookSource reports whether a source has a dedicated normalizer or a
+// registered mapping.
+func knownWebhookSource(source string) bool {
+    if _, ok := builtinWebhookSources[source]; ok {
+        return true
+    }
+    _, ok := mappingFor(source)
+    return ok
+}
+
+// generateWebhookSecret returns a random signing secret for endpoints created
+// without one.
+func generateWebhookSecret() (string, error) {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func (s *Server) handleEndpointWebhook(w http.ResponseWriter, r *http.Request) {
+    source := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "source")))
+    id, err := uuid.Parse(strings.TrimSpace(chi.URLParam(r, "endpoint_id")))
+    if err != nil {
+        writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "webhook endpoint not found")
+        return
+    }
+    if s.keys == nil {
+        writeErrorJSON(w, http.StatusServiceUnavailable, "secrets_not_configured", "secret keys not configured")
+        return
+    }
+    var (
+        orgID      uuid.UUID
+        normalizer string
+        rawSpec    []byte
+    )
+    // Unknown, disabled and other-source endpoints look the same
+    err = s.db.QueryRow(r.Context(), `
+        SELECT org_id, COALESCE(normalizer, ''), verifier
+        FROM webhook_endpoints
+        WHERE id = $1 AND source = $2 AND enabled
+    `, id, source).Scan(&orgID, &normalizer, &rawSpec)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "webhook endpoint not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    secrets, err := s.validEndpointSecrets(r.Context(), id)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "secret_error", "cannot load endpoint secrets")
+        return
+    }
+    if normalizer == "" {
+        normalizer = source
+    }
+    verifier, err := endpointVerifier(source, normalizer, rawSpec)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "invalid_verifier", err.Error())
+        return
+    }
+    s.serveWebhook(w, r, webhookTarget{
+        source:     source,
+        normalizer: normalizer,
+        scope:      source + "/" + id.String(),
+        secrets:    secrets,
+        verifier:   verifier,
+        orgID:      &orgID,
+        endpointID: &id,
+    })
+}
+
+// endpointVerifier builds the endpoint's verifier from its stored spec; an
+// empty spec falls back to the normalizer's mapping, then the source default.
+func endpointVerifier(source, normalizer string, rawSpec []byte) (WebhookVerifier, error) {
+    var spec VerifierSpec
+    if len(rawSpec) > 0 {
+        if err := json.Unmarshal(rawSpec, &spec); err != nil {
+            return nil, err
+        }
+    }
+    if spec != (VerifierSpec{}) {
+        return NewVerifier(spec)
+    }
+    if sp, ok := mappingFor(normalizer); ok && sp.verifier != nil {
+        return sp.verifier, nil
+    }
+    return webhookVerifierFor(source), nil
+}
+
+// webhookTrackerOwner returns the org an endpoint webhook must belong to for
+// writing to the tracker: its shipment's org, else the org whose endpoint
+// created it. Global trackers, created by unscoped sources, have none and
+// only take events from unscoped sources.
+func webhookTrackerOwner(ctx context.Context, q dbtx, trackerID uuid.UUID) (*uuid.UUID, error) {
+    var orgID *uuid.UUID
+    err := q.QueryRow(ctx, `
+        SELECT COALESCE(s.org_id, t.created_by_org_id)
+        FROM trackers t
+        LEFT JOIN shipments s ON s.id = t.shipment_id
+        WHERE t.id = $1
+    `, trackerID).Scan(&orgID)
+    return orgID, err
+}
+
+// trackerOrgID returns the org of the tracker's shipment; nil for trackers
+// without one.
+func trackerOrgID(ctx context.Context, q dbtx, trackerID uuid.UUID) (*uuid.UUID, error) {
+    var orgID *uuid.UUID
+    err := q.QueryRow(ctx, `
+        SELECT s.org_id
+        FROM trackers t
+        LEFT JOIN shipments s ON s.id = t.shipment_id
+        WHERE t.id = $1
+    `, trackerID).Scan(&orgID)
+    return orgID, err
+}
+
+func (s *Server) handleCreateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+    var req WebhookEndpointCreateRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    if strings.TrimSpace(req.OrgSlug) == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "org_slug required")
+        return
+    }
+    source := strings.ToLower(strings.TrimSpace(req.Source))
+    if source == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "source required")
+        return
+    }
+    if !knownWebhookSource(source) {
+        writeErrorJSON(w, http.StatusBadRequest, "unsupported_source", "unsupported source")
+        return
+    }
+    normalizer := strings.ToLower(strings.TrimSpace(req.Normalizer))
+    if normalizer != "" && !knownWebhookSource(normalizer) {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "unknown normalizer")
+        return
+    }
+    if req.Verifier != nil {
+        if _, err := NewVerifier(*req.Verifier); err != nil {
+            writeErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+            return
+        }
+    }
+    if s.keys == nil {
+        writeErrorJSON(w, http.StatusServiceUnavailable, "secrets_not_configured", "secret keys not configured")
+        return
+    }
+    secret := strings.TrimSpace(req.Secret)
+    if secret == "" {
+        var err error
+        if secret, err = generateWebhookSecret(); err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "secret_error", "failed to generate secret")
+            return
+        }
+    }
+
+    ctx := r.Context()
+    orgID, err := resolveOrgID(ctx, s.db, req.OrgSlug)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "org not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    id := uuid.New()
+    sealed, err := s.keys.Seal([]byte(secret), id[:])
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "secret_error", "failed to encrypt secret")
+        return
+    }
+    verifier := []byte("{}")
+    if req.Verifier != nil {
+        verifier, _ = json.Marshal(req.Verifier)
+    }
+    tx, err := s.db.Begin(ctx)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+    _, err = tx.Exec(ctx, `
+        INSERT INTO webhook_endpoints (id, org_id, source, normalizer, verifier, description)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `, id, orgID, source, nullIfEmpty(normalizer), verifier, nullIfEmpty(req.Description))
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create webhook endpoint")
+        return
+    }
+    _, err = tx.Exec(ctx, `
+        INSERT INTO webhook_endpoint_secrets (endpoint_id, secret_encrypted, is_primary)
+        VALUES ($1, $2, TRUE)
+    `, id, sealed)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create webhook endpoint")
+        return
+    }
+    if err := tx.Commit(ctx); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create webhook endpoint")
+        return
+    }
+    resp, err := loadWebhookEndpoint(ctx, s.db, id)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    resp.Secret = secret
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// handleListWebhookEndpoints lists an org's endpoints, oldest first.
+func (s *Server) handleListWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+    slug := strings.TrimSpace(r.URL.Query().Get("org_slug"))
+    if slug == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "org_slug required")
+        return
+    }
+    ctx := r.Context()
+    orgID, err := resolveOrgID(ctx, s.db, slug)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "org not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    rows, err := s.db.Query(ctx, webhookEndpointSelect+` WHERE e.org_id = $1 ORDER BY e.created_at, e.id`, orgID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer rows.Close()
+    endpoints := []WebhookEndpoint{}
+    for rows.Next() {
+        e, err := scanWebhookEndpoint(rows)
+        if err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+            return
+        }
+        endpoints = append(endpoints, e)
+    }
+    if err := rows.Err(); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]any{"webhook_endpoints": endpoints})
+}
+
+func (s *Server) handleGetWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookEndpointID(w, r)
+    if !ok {
+        return
+    }
+    resp, err := loadWebhookEndpoint(r.Context(), s.db, id)
+    if err != nil {
+        writeWebhookEndpointError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleUpdateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookEndpointID(w, r)
+    if !ok {
+        return
+    }
+    var req WebhookEndpointUpdateRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    var verifier []byte
+    if req.Verifier != nil {
+        if *req.Verifier != (VerifierSpec{}) {
+            if _, err := NewVerifier(*req.Verifier); err != nil {
+                writeErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+                return
+            }
+        }
+        verifier, _ = json.Marshal(req.Verifier)
+    }
+    ctx := r.Context()
+    tag, err := s.db.Exec(ctx, `
+        UPDATE webhook_endpoints
+        SET enabled = COALESCE($2, enabled),
+            description = COALESCE($3, description),
+            verifier = COALESCE($4, verifier),
+            updated_at = NOW()
+        WHERE id = $1
+    `, id, req.Enabled, req.Description, verifier)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to update webhook endpoint")
+        return
+    }
+    if tag.RowsAffected() == 0 {
+        writeWebhookEndpointError(w, pgx.ErrNoRows)
+        return
+    }
+    resp, err := loadWebhookEndpoint(ctx, s.db, id)
+    if err != nil {
+        writeWebhookEndpointError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleDeleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookEndpointID(w, r)
+    if !ok {
+        return
+    }
+    tag, err := s.db.Exec(r.Context(), `DELETE FROM webhook_endpoints WHERE id = $1`, id)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if tag.RowsAffected() == 0 {
+        writeWebhookEndpointError(w, pgx.ErrNoRows)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func parseWebhookEndpointID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+    id, err := uuid.Parse(strings.TrimSpace(chi.URLParam(r, "id")))
+    if err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid webhook endpoint id")
+        return uuid.Nil, false
+    }
+    return id, true
+}
+
+func writeWebhookEndpointError(w http.ResponseWriter, err error) {
+    if errors.Is(err, pgx.ErrNoRows) {
+        writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "webhook endpoint not found")
+        return
+    }
+    writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+}
+
+const webhookEndpointSelect = `
+    SELECT e.id, o.slug, e.source, COALESCE(e.normalizer, ''), e.verifier,
+           COALESCE(e.description, ''), e.enabled, e.created_at, e.updated_at
+    FROM webhook_endpoints e
+    JOIN orgs o ON o.id = e.org_id`
+
+func loadWebhookEndpoint(ctx context.Context, q dbtx, id uuid.UUID) (WebhookEndpoint, error) {
+    return scanWebhookEndpoint(q.QueryRow(ctx, webhookEndpointSelect+` WHERE e.id = $1`, id))
+}
+
+func scanWebhookEndpoint(row pgx.Row) (WebhookEndpoint, error) {
+    var (
+        e         WebhookEndpoint
+        id        uuid.UUID
+        rawSpec   []byte
+        createdAt time.Time
+        updatedAt time.Time
+    )
+    if err := row.Scan(&id, &e.OrgSlug, &e.Source, &e.Normalizer, &rawSpec, &e.Description, &e.Enabled, &createdAt, &updatedAt); err != nil {
+        return WebhookEndpoint{}, err
+    }
+    var spec VerifierSpec
+    if err := json.Unmarshal(rawSpec, &spec); err != nil {
+        return WebhookEndpoint{}, fmt.Errorf("webhook endpoint %s: verifier: %w", id, err)
+    }
+    if spec != (VerifierSpec{}) {
+        e.Verifier = &spec
+    }
+    e.ID = id.String()
+    e.URL = "/webhooks/" + e.Source + "/" + e.ID
+    e.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+    e.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
+    return e, nil
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "testing"
+    "time"
+
+    "deliveryinfra/internal/db"
+)
+
+func TestWebhookEndpoint_IngestsForOwnOrgOnly(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    t.Setenv("SECRET_KEYS", "1:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    _, _ = pool.Exec(t.Context(), `
+        INSERT INTO orgs (slug, name)
+        VALUES ('whep-acme', 'Acme'), ('whep-other', 'Other')
+        ON CONFLICT (slug) DO NOTHING
+    `)
+
+    h := New(pool)
+    do := func(method, path string, body any) *httptest.ResponseRecorder {
+        b, _ := json.Marshal(body)
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewReader(b)))
+        return rr
+    }
+
+    // A shipment of the other org whose tracker the endpoint must not touch
+    otherCode := fmt.Sprintf("WHEPOTHER%d", time.Now().UnixNano())
+    rr := do(http.MethodPost, "/shipments", map[string]any{
+        "org_slug":        "whep-other",
+        "tracking_number": otherCode,
+        "rate_currency":   "JPY",
+        "ship_to":         map[string]any{"country": "JP"},
+        "ship_from":       map[string]any{"country": "JP"},
+        "package":         map[string]any{"weight_oz": 5},
+    })
+    if rr.Code != http.StatusOK {
+        t.Fatalf("create shipment: %d %s", rr.Code, rr.Body.String())
+    }
+
+    rr = do(http.MethodPost, "/webhook-endpoints", map[string]any{"org_slug": "whep-acme", "source": "karrio"})
+    if rr.Code != http.StatusOK {
+        t.Fatalf("create endpoint: %d %s", rr.Code, rr.Body.String())
+    }
+    var ep WebhookEndpoint
+    if err := json.Unmarshal(rr.Body.Bytes(), &ep); err != nil {
+        t.Fatalf("unmarshal: %v", err)
+    }
+    if ep.Secret == "" || ep.URL != "/webhooks/karrio/"+ep.ID || !ep.Enabled {
+        t.Fatalf("unexpected endpoint: %+v", ep)
+    }
+    var stored []byte
+    if err := pool.QueryRow(t.Context(), `SELECT secret_encrypted FROM webhook_endpoints WHERE id = $1`, ep.ID).Scan(&stored); err != nil {
+        t.Fatalf("load secret: %v", err)
+    }
+    if bytes.Contains(stored, []byte(ep.Secret)) {
+        t.Fatalf("secret stored in plaintext")
+    }
+
+    // The secret is only returned on creation
+    rr = do(http.MethodGet, "/webhook-endpoints/"+ep.ID, nil)
+    var got WebhookEndpoint
+    _ = json.Unmarshal(rr.Body.Bytes(), &got)
+    if rr.Code != http.StatusOK || got.Secret != "" {
+        t.Fatalf("get endpoint: %d %s", rr.Code, rr.Body.String())
+    }
+
+    post := func(path string, body []byte) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+        req.Header.Set("X-Signature", signTimestamped(ep.Secret, time.Now().Unix(), body))
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, req)
+        return rr
+    }
+    ownCode := fmt.Sprintf("WHEPOWN%d", time.Now().UnixNano())
+    // An unlinked tracker created outside any org
+    globalCode := fmt.Sprintf("WHEPGLOBAL%d", time.Now().UnixNano())
+    if _, err := pool.Exec(t.Context(), `INSERT INTO trackers (carrier_tracking_code, status) VALUES ($1, 'unknown')`, globalCode); err != nil {
+        t.Fatalf("create global tracker: %v", err)
+    }
+    // A tracker the org registered itself
+    regCode := fmt.Sprintf("WHEPREG%d", time.Now().UnixNano())
+    if rr := do(http.MethodPost, "/trackers", map[string]any{"tracking_number": regCode, "org_slug": "whep-acme"}); rr.Code != http.StatusOK {
+        t.Fatalf("register tracker: %d %s", rr.Code, rr.Body.String())
+    }
+    tracker := func(code, status string) map[string]any {
+        return map[string]any{"tracking_number": code, "status": status, "events": []map[string]any{
+            {"date": "2025-03-05", "time": "08:12", "description": "Update", "status": status},
+        }}
+    }
+    body, _ := json.Marshal(map[string]any{"type": "tracker.updated", "data": []any{
+        tracker(ownCode, "in_transit"),
+        tracker(otherCode, "delivered"),
+        tracker(globalCode, "delivered"),
+        tracker(regCode, "in_transit"),
+    }})
+    res := acceptedEntry(t, pool, h, post(ep.URL, body)).Result
+    if res == nil || res.Created != 2 || res.Skipped != 2 || res.Events[1].Result != webhookResultSkipped ||
+        res.Events[2].Result != webhookResultSkipped || res.Events[3].Result != webhookResultCreated {
+        t.Fatalf("unexpected result: %+v", res)
+    }
+    var n int
+    _ = pool.QueryRow(t.Context(), `
+        SELECT COUNT(*) FROM tracking_events e JOIN trackers t ON t.id = e.tracker_id
+        WHERE t.carrier_tracking_code = $1
+    `, otherCode).Scan(&n)
+    if n != 0 {
+        t.Fatalf("other org's tracker got %d events", n)
+    }
+
+    // The tracker the endpoint created belongs to its org
+    rr = do(http.MethodPost, "/shipments", map[string]any{"org_slug": "whep-other", "tracking_number": ownCode})
+    if rr.Code != http.StatusConflict {
+        t.Fatalf("expected 409 linking another org's tracker, got %d %s", rr.Code, rr.Body.String())
+    }
+
+    // Disabled endpoints and mismatched sources look unknown
+    if rr := post("/webhooks/dummy/"+ep.ID, body); rr.Code != http.StatusNotFound {
+        t.Fatalf("expected 404 for wrong source, got %d", rr.Code)
+    }
+    if rr := do(http.MethodPatch, "/webhook-endpoints/"+ep.ID, map[string]any{"enabled": false}); rr.Code != http.StatusOK {
+        t.Fatalf("disable endpoint: %d %s", rr.Code, rr.Body.String())
+    }
+    if rr := post(ep.URL, body); rr.Code != http.StatusNotFound {
+        t.Fatalf("expected 404 for disabled endpoint, got %d", rr.Code)
+    }
+    if rr := do(http.MethodDelete, "/webhook-endpoints/"+ep.ID, nil); rr.Code != http.StatusNoContent {
+        t.Fatalf("delete endpoint: %d %s", rr.Code, rr.Body.String())
+    }
+}
+
+func TestWebhookEndpoint_SecretRotation(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    t.Setenv("SECRET_KEYS", "1:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    _, _ = pool.Exec(t.Context(), `INSERT INTO orgs (slug, name) VALUES ('whep-acme', 'Acme') ON CONFLICT (slug) DO NOTHING`)
+
+    h := New(pool)
+    do := func(method, path string, body any) *httptest.ResponseRecorder {
+        b, _ := json.Marshal(body)
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewReader(b)))
+        return rr
+    }
+    rr := do(http.MethodPost, "/webhook-endpoints", map[string]any{"org_slug": "whep-acme", "source": "dummy", "secret": "old-secret"})
+    if rr.Code != http.StatusOK {
+        t.Fatalf("create endpoint: %d %s", rr.Code, rr.Body.String())
+    }
+    var ep WebhookEndpoint
+    _ = json.Unmarshal(rr.Body.Bytes(), &ep)
+    base := "/webhook-endpoints/" + ep.ID + "/secrets"
+
+    deliver := func(secret string) int {
+        body, _ := json.Marshal(map[string]any{"code": fmt.Sprintf("WHROT%d", time.Now().UnixNano()), "status": "in_transit"})
+        req := httptest.NewRequest(http.MethodPost, ep.URL, bytes.NewReader(body))
+        req.Header.Set("X-Signature", signTimestamped(secret, time.Now().Unix(), body))
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, req)
+        return rr.Code
+    }
+    secretsByID := func() map[string]WebhookEndpointSecret {
+        rr := do(http.MethodGet, base, nil)
+        var res struct {
+            Secrets []WebhookEndpointSecret `json:"secrets"`
+        }
+        _ = json.Unmarshal(rr.Body.Bytes(), &res)
+        out := map[string]WebhookEndpointSecret{}
+        for _, sec := range res.Secrets {
+            out[sec.ID] = sec
+        }
+        return out
+    }
+
+    // Stage a new secret: both verify during the switch
+    rr = do(http.MethodPost, base, map[string]any{"secret": "new-secret"})
+    var staged WebhookEndpointSecret
+    _ = json.Unmarshal(rr.Body.Bytes(), &staged)
+    if rr.Code != http.StatusOK || staged.State != secretStateStaged || staged.Secret != "new-secret" {
+        t.Fatalf("stage: %d %s", rr.Code, rr.Body.String())
+    }
+    if deliver("old-secret") != http.StatusAccepted || deliver("new-secret") != http.StatusAccepted {
+        t.Fatalf("both secrets must verify while staged")
+    }
+    if got := secretsByID()[staged.ID]; got.MatchCount != 1 || got.LastMatchedAt == "" {
+        t.Fatalf("matched secret not recorded: %+v", got)
+    }
+
+    // Promote without grace: the old secret stops verifying
+    rr = do(http.MethodPost, base+"/"+staged.ID+"/promote", map[string]any{"grace": "0s"})
+    if rr.Code != http.StatusOK {
+        t.Fatalf("promote: %d %s", rr.Code, rr.Body.String())
+    }
+    for id, sec := range secretsByID() {
+        if id == staged.ID && sec.State != secretStatePrimary {
+            t.Fatalf("promoted secret state %s", sec.State)
+        }
+        if id != staged.ID && sec.State != secretStateExpired {
+            t.Fatalf("previous secret state %s", sec.State)
+        }
+    }
+    if deliver("old-secret") != http.StatusUnauthorized || deliver("new-secret") != http.StatusAccepted {
+        t.Fatalf("only the promoted secret must verify")
+    }
+
+    // The primary cannot be retired
+    rr = do(http.MethodPost, base+"/"+staged.ID+"/retire", nil)
+    if rr.Code != http.StatusConflict {
+        t.Fatalf("retire primary: expected 409, got %d", rr.Code)
+    }
+}
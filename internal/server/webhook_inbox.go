@@ -0,0 +1,412 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "deliveryinfra/internal/jobs"
+)
+
+// Inbox entry statuses. Pending entries are due at next_attempt_at; dead
+// entries wait for an operator to replay them.
+const (
+    inboxStatusPending   = "pending"
+    inboxStatusProcessed = "processed"
+    inboxStatusDead      = "dead"
+)
+
+// Retry delays double per attempt, capped at an hour.
+const (
+    inboxBackoffBase = 5 * time.Second
+    inboxBackoffMax  = time.Hour
+)
+
+// poisonError marks a payload that can never ingest as is (it does not
+// normalize or has invalid events); it is dead-lettered without retries.
+type poisonError struct{ err error }
+
+func (e *poisonError) Error() string { return e.err.Error() }
+func (e *poisonError) Unwrap() error { return e.err }
+
+// enqueueWebhook stores a verified delivery in the inbox. Each delivery key
+// is recorded under the target's scope in the same transaction, failing with
+// errReplayedDelivery when any was seen before. The first key is kept as the
+// entry's delivery ID.
+func enqueueWebhook(ctx context.Context, db *pgxpool.Pool, t webhookTarget, deliveryKeys []string, body []byte) (uuid.UUID, error) {
+    tx, err := db.Begin(ctx)
+    if err != nil {
+        return uuid.Nil, err
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+    for _, key := range deliveryKeys {
+        if err := recordWebhookDelivery(ctx, tx, t.scope, key); err != nil {
+            return uuid.Nil, err
+        }
+    }
+    var deliveryID string
+    if len(deliveryKeys) > 0 {
+        deliveryID = deliveryKeys[0]
+    }
+    var id uuid.UUID
+    err = tx.QueryRow(ctx, `
+        INSERT INTO webhook_inbox (source, normalizer, endpoint_id, org_id, delivery_id, body)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id
+    `, t.source, t.normalizer, t.endpointID, t.orgID, nullIfEmpty(deliveryID), body).Scan(&id)
+    if err != nil {
+        return uuid.Nil, err
+    }
+    return id, tx.Commit(ctx)
+}
+
+// InboxProcessorConfig controls the inbox processor. Zero values use the
+// defaults.
+type InboxProcessorConfig struct {
+    // Tick is how often due entries are looked for (default 1s).
+    Tick time.Duration
+    // Concurrency is the number of entries processed at once (default 4).
+    Concurrency int
+    // MaxAttempts dead-letters an entry after this many failures (default 10).
+    MaxAttempts int
+    // Retention is how long processed entries are kept (default 7 days).
+    Retention time.Duration
+}
+
+// InboxProcessor ingests inbox entries; every replica can run one. Failures
+// are retried with exponential backoff; poison payloads and entries out of
+// attempts are dead-lettered.
+type InboxProcessor struct {
+    db  *pgxpool.Pool
+    cfg InboxProcessorConfig
+    now func() time.Time
+}
+
+func NewInboxProcessor(db *pgxpool.Pool, cfg InboxProcessorConfig) *InboxProcessor {
+    if cfg.Tick <= 0 {
+        cfg.Tick = time.Second
+    }
+    if cfg.Concurrency <= 0 {
+        cfg.Concurrency = 4
+    }
+    if cfg.MaxAttempts <= 0 {
+        cfg.MaxAttempts = 10
+    }
+    if cfg.Retention <= 0 {
+        cfg.Retention = 7 * 24 * time.Hour
+    }
+    return &InboxProcessor{db: db, cfg: cfg, now: time.Now}
+}
+
+// Run processes due entries until ctx is done.
+func (p *InboxProcessor) Run(ctx context.Context) {
+    jobs.Poll(ctx, "webhook inbox", p.cfg.Tick, p.ProcessPending, p.prune)
+}
+
+// ProcessPending processes due entries until none are left and returns how
+// many it handled.
+func (p *InboxProcessor) ProcessPending(ctx context.Context) (int, error) {
+    return jobs.Drain(ctx, p.cfg.Concurrency, p.processNext)
+}
+
+type inboxEntry struct {
+    id         uuid.UUID
+    source     string
+    normalizer string
+    orgID      *uuid.UUID
+    body       []byte
+    attempts   int
+    receivedAt time.Time
+}
+
+// processNext claims one due entry and ingests it. The ingestion runs in a
+// savepoint so its failure can be recorded on the claimed entry. It reports
+// false when nothing was due.
+func (p *InboxProcessor) processNext(ctx context.Context) (bool, error) {
+    tx, err := p.db.Begin(ctx)
+    if err != nil {
+        return false, err
+    }
+    defer func() { _ = tx.Rollback(ctx) }()
+    var e inboxEntry
+    err = tx.QueryRow(ctx, `
+        SELECT id, source, normalizer, org_id, body, attempts, received_at
+        FROM webhook_inbox
+        WHERE status = 'pending' AND next_attempt_at <= NOW()
+        ORDER BY next_attempt_at, received_at
+        LIMIT 1
+        FOR UPDATE SKIP LOCKED
+    `).Scan(&e.id, &e.source, &e.normalizer, &e.orgID, &e.body, &e.attempts, &e.receivedAt)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return false, nil
+        }
+        return false, err
+    }
+
+    resp, perr := p.ingest(ctx, tx, e)
+    attempts := e.attempts + 1
+    if perr == nil {
+        result, _ := json.Marshal(resp)
+        _, err = tx.Exec(ctx, `
+            UPDATE webhook_inbox
+            SET status = 'processed', attempts = $2, result = $3, last_error = NULL, processed_at = NOW()
+            WHERE id = $1
+        `, e.id, attempts, result)
+    } else {
+        var poison *poisonError
+        status, next := inboxStatusPending, p.now().Add(jobs.Backoff(attempts, inboxBackoffBase, inboxBackoffMax, nil))
+        if errors.As(perr, &poison) || attempts >= p.cfg.MaxAttempts {
+            status = inboxStatusDead
+            log.Printf("webhook inbox: %s dead-lettered after %d attempts: %v", e.id, attempts, perr)
+        }
+        _, err = tx.Exec(ctx, `
+            UPDATE webhook_inbox
+            SET status = $2, attempts = $3, next_attempt_at = $4, last_error = $5
+            WHERE id = $1
+        `, e.id, status, attempts, next, perr.Error())
+    }
+    if err != nil {
+        return true, err
+    }
+    return true, tx.Commit(ctx)
+}
+
+// ingest stamps events without occurred_at with the entry's arrival, so
+// retries and replays produce the same events and deduplicate.
+func (p *InboxProcessor) ingest(ctx context.Context, tx pgx.Tx, e inboxEntry) (WebhookResponse, error) {
+    items, err := buildWebhookItems(e.source, e.normalizer, e.body, e.receivedAt.UTC())
+    if err != nil {
+        return WebhookResponse{}, &poisonError{err}
+    }
+    sp, err := tx.Begin(ctx)
+    if err != nil {
+        return WebhookResponse{}, err
+    }
+    results, err := ingestWebhookItems(ctx, sp, e.orgID, items)
+    if err != nil {
+        _ = sp.Rollback(ctx)
+        return WebhookResponse{}, err
+    }
+    if err := sp.Commit(ctx); err != nil {
+        return WebhookResponse{}, err
+    }
+    resp := WebhookResponse{Received: len(results), Events: results}
+    for _, res := range results {
+        switch res.Result {
+        case webhookResultCreated:
+            resp.Created++
+        case webhookResultDuplicate:
+            resp.Duplicates++
+        case webhookResultSkipped:
+            resp.Skipped++
+        }
+    }
+    return resp, nil
+}
+
+// prune deletes processed entries past retention; dead entries are kept
+// until replayed. Replay-protection delivery IDs are forgotten here too.
+func (p *InboxProcessor) prune(ctx context.Context) error {
+    if _, err := p.db.Exec(ctx, `
+        DELETE FROM webhook_inbox WHERE status = 'processed' AND processed_at < $1
+    `, p.now().Add(-p.cfg.Retention)); err != nil {
+        return err
+    }
+    return pruneWebhookDeliveries(ctx, p.db, webhookTolerance())
+}
+
+// WebhookInboxEntry is an inbox entry as shown to operators.
+type WebhookInboxEntry struct {
+    ID            string `json:"id"`
+    Source        string `json:"source"`
+    Normalizer    string `json:"normalizer"`
+    EndpointID    string `json:"endpoint_id,omitempty"`
+    DeliveryID    string `json:"delivery_id,omitempty"`
+    Status        string `json:"status"`
+    Attempts      int    `json:"attempts"`
+    NextAttemptAt string `json:"next_attempt_at,omitempty"`
+    LastError     string `json:"last_error,omitempty"`
+    // Body is the payload as received: JSON when it parses, else a string.
+    Body        json.RawMessage  `json:"body,omitempty"`
+    Result      *WebhookResponse `json:"result,omitempty"`
+    ReceivedAt  string           `json:"received_at"`
+    ProcessedAt string           `json:"processed_at,omitempty"`
+}
+
+// handleListWebhookInbox lists inbox entries, newest first, filtered by
+// status, source and org_slug. Bodies are left out; fetch an entry for them.
+func (s *Server) handleListWebhookInbox(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    status := strings.TrimSpace(q.Get("status"))
+    switch status {
+    case "", inboxStatusPending, inboxStatusProcessed, inboxStatusDead:
+    default:
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid status")
+        return
+    }
+    limit, offset, ok := parsePagination(w, r, 50, 200)
+    if !ok {
+        return
+    }
+    ctx := r.Context()
+    var orgID *uuid.UUID
+    if slug := strings.TrimSpace(q.Get("org_slug")); slug != "" {
+        id, err := resolveOrgID(ctx, s.db, slug)
+        if err != nil {
+            if errors.Is(err, pgx.ErrNoRows) {
+                writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "org not found")
+                return
+            }
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+            return
+        }
+        orgID = &id
+    }
+    rows, err := s.db.Query(ctx, webhookInboxSelect+`
+        WHERE ($1::text IS NULL OR status = $1)
+          AND ($2::text IS NULL OR source = $2)
+          AND ($3::uuid IS NULL OR org_id = $3)
+        ORDER BY received_at DESC, id
+        LIMIT $4 OFFSET $5
+    `, nullIfEmpty(status), nullIfEmpty(strings.ToLower(q.Get("source"))), orgID, limit, offset)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer rows.Close()
+    entries := []WebhookInboxEntry{}
+    for rows.Next() {
+        e, err := scanWebhookInboxEntry(rows)
+        if err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+            return
+        }
+        e.Body = nil
+        entries = append(entries, e)
+    }
+    if err := rows.Err(); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]any{"entries": entries})
+}
+
+func (s *Server) handleGetWebhookInbox(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookInboxID(w, r)
+    if !ok {
+        return
+    }
+    e, err := loadWebhookInboxEntry(r.Context(), s.db, id)
+    if err != nil {
+        writeWebhookInboxError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, e)
+}
+
+// handleReplayWebhookInbox queues an entry for processing again with fresh
+// attempts. Replaying a processed entry is safe: its events deduplicate.
+func (s *Server) handleReplayWebhookInbox(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookInboxID(w, r)
+    if !ok {
+        return
+    }
+    ctx := r.Context()
+    tag, err := s.db.Exec(ctx, `
+        UPDATE webhook_inbox
+        SET status = 'pending', attempts = 0, next_attempt_at = NOW(), processed_at = NULL
+        WHERE id = $1
+    `, id)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if tag.RowsAffected() == 0 {
+        writeWebhookInboxError(w, pgx.ErrNoRows)
+        return
+    }
+    e, err := loadWebhookInboxEntry(ctx, s.db, id)
+    if err != nil {
+        writeWebhookInboxError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, e)
+}
+
+func parseWebhookInboxID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+    id, err := uuid.Parse(strings.TrimSpace(chi.URLParam(r, "id")))
+    if err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid inbox entry id")
+        return uuid.Nil, false
+    }
+    return id, true
+}
+
+func writeWebhookInboxError(w http.ResponseWriter, err error) {
+    if errors.Is(err, pgx.ErrNoRows) {
+        writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "inbox entry not found")
+        return
+    }
+    writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+}
+
+const webhookInboxSelect = `
+    SELECT id, source, normalizer, endpoint_id, COALESCE(delivery_id, ''), status, attempts,
+           next_attempt_at, COALESCE(last_error, ''), body, result, received_at, processed_at
+    FROM webhook_inbox`
+
+func loadWebhookInboxEntry(ctx context.Context, q dbtx, id uuid.UUID) (WebhookInboxEntry, error) {
+    return scanWebhookInboxEntry(q.QueryRow(ctx, webhookInboxSelect+` WHERE id = $1`, id))
+}
+
+func scanWebhookInboxEntry(row pgx.Row) (WebhookInboxEntry, error) {
+    var (
+        e           WebhookInboxEntry
+        id          uuid.UUID
+        endpointID  *uuid.UUID
+        nextAttempt time.Time
+        body        []byte
+        result      []byte
+        receivedAt  time.Time
+        processedAt *time.Time
+    )
+    if err := row.Scan(&id, &e.Source, &e.Normalizer, &endpointID, &e.DeliveryID, &e.Status, &e.Attempts,
+        &nextAttempt, &e.LastError, &body, &result, &receivedAt, &processedAt); err != nil {
+        return WebhookInboxEntry{}, err
+    }
+    e.ID = id.String()
+    if endpointID != nil {
+        e.EndpointID = endpointID.String()
+    }
+    if e.Status == inboxStatusPending {
+        e.NextAttemptAt = nextAttempt.UTC().Format(time.RFC3339)
+    }
+    if json.Valid(body) {
+        e.Body = body
+    } else {
+        e.Body, _ = json.Marshal(string(body))
+    }
+    if len(result) > 0 {
+        var res WebhookResponse
+        if err := json.Unmarshal(result, &res); err != nil {
+            return WebhookInboxEntry{}, fmt.Errorf("inbox entry %s: result: %w", id, err)
+        }
+        e.Result = &res
+    }
+    e.ReceivedAt = receivedAt.UTC().Format(time.RFC3339)
+    if processedAt != nil {
+        e.ProcessedAt = processedAt.UTC().Format(time.RFC3339)
+    }
+    return e, nil
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "deliveryinfra/internal/jobs"
+)
+
+func TestInboxBackoff(t *testing.T) {
+    cases := map[int]time.Duration{
+        1:  5 * time.Second,
+        2:  10 * time.Second,
+        5:  80 * time.Second,
+        10: 2560 * time.Second,
+        11: time.Hour,
+        50: time.Hour,
+    }
+    for attempts, want := range cases {
+        if got := jobs.Backoff(attempts, inboxBackoffBase, inboxBackoffMax, nil); got != want {
+            t.Errorf("inbox backoff(%d) = %v, want %v", attempts, got, want)
+        }
+    }
+}
+
+func TestBuildWebhookItems(t *testing.T) {
+    now := time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)
+    items, err := buildWebhookItems("dummy", "dummy", []byte(`{"code":"X1","status":"in_transit","occurred_at":"2025-03-03T10:00:00+09:00"}`), now)
+    if err != nil || len(items) != 1 {
+        t.Fatalf("items = %+v, err = %v", items, err)
+    }
+    if items[0].code != "X1" || !items[0].occurred.Equal(time.Date(2025, 3, 3, 1, 0, 0, 0, time.UTC)) {
+        t.Fatalf("unexpected item: %+v", items[0])
+    }
+    if items, _ := buildWebhookItems("dummy", "dummy", []byte(`{"code":"X1"}`), now); !items[0].occurred.Equal(now) {
+        t.Fatalf("missing occurred_at should default to now, got %v", items[0].occurred)
+    }
+
+    // Payloads that can never ingest
+    for body, want := range map[string]string{
+        `not json`:                              "invalid json",
+        `{"status":"in_transit"}`:               "code required",
+        `{"code":"X1","occurred_at":"someday"}`: "invalid occurred_at (event 0)",
+    } {
+        if _, err := buildWebhookItems("dummy", "dummy", []byte(body), now); err == nil || !strings.Contains(err.Error(), want) {
+            t.Errorf("%s: err = %v, want %q", body, err, want)
+        }
+    }
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "errors"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    // defaultWebhookTolerance is how far a signed timestamp may be from now,
+    // in either direction, unless WEBHOOK_TOLERANCE overrides it.
+    defaultWebhookTolerance = 5 * time.Minute
+    // webhookDeliveryRetention is how long delivery IDs are remembered. It is
+    // raised to the tolerance when that is longer.
+    webhookDeliveryRetention = 72 * time.Hour
+)
+
+// Signature verification failures, each with its own error code.
+var (
+    errMissingSignature       = errors.New("missing signature")
+    errInvalidSignatureFormat = errors.New("invalid signature format")
+    errSignatureMismatch      = errors.New("signature mismatch")
+    errTimestampRequired      = errors.New("signed timestamp required")
+    errTimestampOutOfWindow   = errors.New("timestamp outside tolerance window")
+    errReplayedDelivery       = errors.New("delivery already received")
+)
+
+// webhookErrorCodes maps verification failures to writeErrorJSON codes.
+var webhookErrorCodes = map[error]struct {
+    status int
+    code   string
+}{
+    errMissingSignature:       {http.StatusUnauthorized, "missing_signature"},
+    errInvalidSignatureFormat: {http.StatusUnauthorized, "invalid_signature_format"},
+    errSignatureMismatch:      {http.StatusUnauthorized, "signature_mismatch"},
+    errTimestampRequired:      {http.StatusUnauthorized, "timestamp_required"},
+    errTimestampOutOfWindow:   {http.StatusUnauthorized, "timestamp_out_of_window"},
+    errReplayedDelivery:       {http.StatusConflict, "replayed_delivery"},
+}
+
+func writeWebhookError(w http.ResponseWriter, err error) {
+    e, ok := webhookErrorCodes[err]
+    if !ok {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeErrorJSON(w, e.status, e.code, err.Error())
+}
+
+func webhookMAC(secret string, msg []byte) []byte {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(msg)
+    return mac.Sum(nil)
+}
+
+// webhookTolerance reads WEBHOOK_TOLERANCE (a Go duration), like the
+// per-source secrets, at request time.
+func webhookTolerance() time.Duration {
+    if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("WEBHOOK_TOLERANCE"))); err == nil && d > 0 {
+        return d
+    }
+    return defaultWebhookTolerance
+}
+
+// webhookRequireTimestamp reports whether WEBHOOK_REQUIRE_TIMESTAMP rejects
+// legacy body-only signatures.
+func webhookRequireTimestamp() bool {
+    ok, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("WEBHOOK_REQUIRE_TIMESTAMP")))
+    return ok
+}
+
+// webhookDeliveryKeys lists the keys a delivery is remembered under for
+// replay protection. The signature of a timestamped request is unique per
+// delivery and always recorded. X-Delivery-Id is not signed, so it is only an
+// extra key that also catches redeliveries signed anew; it never replaces the
+// signature. Legacy body-only signatures yield no signature key, as providers
+// legitimately redeliver identical bodies.
+func webhookDeliveryKeys(r *http.Request, sig WebhookSignature) []string {
+    var keys []string
+    if id := strings.TrimSpace(r.Header.Get("X-Delivery-Id")); id != "" {
+        keys = append(keys, id)
+    }
+    if !sig.Timestamp.IsZero() {
+        keys = append(keys, "sig:"+sig.Value)
+    }
+    return keys
+}
+
+// recordWebhookDelivery remembers a delivery ID in the caller's transaction,
+// so a delivery that fails to ingest can be retried. errReplayedDelivery
+// means the ID was seen before.
+func recordWebhookDelivery(ctx context.Context, q dbtx, source, deliveryID string) error {
+    tag, err := q.Exec(ctx, `
+        INSERT INTO webhook_deliveries (source, delivery_id)
+        VALUES ($1, $2)
+        ON CONFLICT (source, delivery_id) DO NOTHING
+    `, source, deliveryID)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return errReplayedDelivery
+    }
+    return nil
+}
+
+// pruneWebhookDeliveries forgets delivery IDs past retention; older replays
+// fail the timestamp window instead.
+func pruneWebhookDeliveries(ctx context.Context, q dbtx, tolerance time.Duration) error {
+    retention := webhookDeliveryRetention
+    if 2*tolerance > retention {
+        retention = 2 * tolerance
+    }
+    _, err := q.Exec(ctx, `DELETE FROM webhook_deliveries WHERE received_at < $1`, time.Now().Add(-retention))
+    return err
+}
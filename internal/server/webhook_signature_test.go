@@ -0,0 +1,29 @@
+package server
+
+import (
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func signTimestamped(secret string, ts int64, body []byte) string {
+    return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(webhookMAC(secret, []byte(fmt.Sprintf("%d.%s", ts, body)))))
+}
+
+func TestWebhookDeliveryKeys(t *testing.T) {
+    r := httptest.NewRequest(http.MethodPost, "/webhooks/dummy", nil)
+    if keys := webhookDeliveryKeys(r, WebhookSignature{Value: "abc"}); len(keys) != 0 {
+        t.Errorf("legacy signature without delivery ID should have no keys, got %q", keys)
+    }
+    if keys := webhookDeliveryKeys(r, WebhookSignature{Timestamp: time.Now(), Value: "abc"}); len(keys) != 1 || keys[0] != "sig:abc" {
+        t.Errorf("timestamped signature keys = %q", keys)
+    }
+    // The unsigned delivery ID is an extra key, never a replacement
+    r.Header.Set("X-Delivery-Id", "evt_1")
+    if keys := webhookDeliveryKeys(r, WebhookSignature{Timestamp: time.Now(), Value: "abc"}); len(keys) != 2 || keys[0] != "evt_1" || keys[1] != "sig:abc" {
+        t.Errorf("keys with delivery ID = %q", keys)
+    }
+}
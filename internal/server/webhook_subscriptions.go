@@ -0,0 +1,770 @@
+package server
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/netip"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/go-chi/chi/v5"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+)
+
+// eventWebhookTest is only sent by POST /webhook-subscriptions/{id}/test.
+const eventWebhookTest = "webhook.test"
+
+// webhookEventTypes are the event types a subscription can name.
+var webhookEventTypes = []string{eventShipmentCreated, eventShipmentDelivered, eventTrackerUpdated}
+
+var errWebhookDisabled = errors.New("webhook subscription is disabled")
+
+// WebhookSubscription is an org's outbound webhook (a webhooks row). The
+// signing secret is stored encrypted and only returned on creation and
+// rotation.
+type WebhookSubscription struct {
+    ID          string `json:"id"`
+    OrgSlug     string `json:"org_slug"`
+    Event       string `json:"event"`
+    URL         string `json:"url"`
+    Description string `json:"description,omitempty"`
+    Enabled     bool   `json:"enabled"`
+    Secret      string `json:"secret,omitempty"`
+    // PreviousSecretExpiresAt is set while a rotated-out secret still signs
+    // deliveries alongside the current one.
+    PreviousSecretExpiresAt string `json:"previous_secret_expires_at,omitempty"`
+    SecretRotatedAt         string `json:"secret_rotated_at,omitempty"`
+    // Delivery health, maintained by the dispatcher.
+    ConsecutiveFailures int    `json:"consecutive_failures"`
+    FailingSince        string `json:"failing_since,omitempty"`
+    LastSuccessAt       string `json:"last_success_at,omitempty"`
+    LastFailureAt       string `json:"last_failure_at,omitempty"`
+    DisabledAt          string `json:"disabled_at,omitempty"`
+    DisabledReason      string `json:"disabled_reason,omitempty"`
+    CreatedAt           string `json:"created_at"`
+    UpdatedAt           string `json:"updated_at"`
+}
+
+type WebhookSubscriptionCreateRequest struct {
+    OrgSlug string `json:"org_slug"`
+    // Event is an event type, a "shipment.*" prefix or "*".
+    Event string `json:"event"`
+    URL   string `json:"url"`
+    // Secret signs deliveries; generated when empty.
+    Secret      string `json:"secret"`
+    Description string `json:"description"`
+}
+
+// WebhookSubscriptionUpdateRequest carries a partial update; nil fields are
+// left unchanged. Re-enabling clears the failure state.
+type WebhookSubscriptionUpdateRequest struct {
+    Event       *string `json:"event"`
+    URL         *string `json:"url"`
+    Description *string `json:"description"`
+    Enabled     *bool   `json:"enabled"`
+}
+
+type WebhookSecretRotateRequest struct {
+    // Secret is the new signing secret; generated when empty.
+    Secret string `json:"secret"`
+    // Grace keeps signing with the previous secret too for this long
+    // ("1h"); default 24h, "0s" drops it at once.
+    Grace string `json:"grace"`
+}
+
+// WebhookDelivery is one dispatch of an event to a subscription, with its
+// attempts oldest first.
+type WebhookDelivery struct {
+    ID            string           `json:"id"`
+    EventID       string           `json:"event_id"`
+    EventType     string           `json:"event_type"`
+    Status        string           `json:"status"`
+    AttemptCount  int              `json:"attempt_count"`
+    NextAttemptAt string           `json:"next_attempt_at,omitempty"`
+    LastError     string           `json:"last_error,omitempty"`
+    DeliveredAt   string           `json:"delivered_at,omitempty"`
+    CreatedAt     string           `json:"created_at"`
+    Payload       json.RawMessage  `json:"payload,omitempty"`
+    Attempts      []WebhookAttempt `json:"attempts"`
+}
+
+// WebhookAttempt is one logged request. Bodies are only returned for a
+// single delivery.
+type WebhookAttempt struct {
+    Attempt        int             `json:"attempt"`
+    RequestURL     string          `json:"request_url"`
+    RequestHeaders json.RawMessage `json:"request_headers"`
+    RequestBody    string          `json:"request_body,omitempty"`
+    ResponseStatus *int            `json:"response_status,omitempty"`
+    ResponseBody   string          `json:"response_body,omitempty"`
+    LatencyMS      int64           `json:"latency_ms"`
+    Error          string          `json:"error,omitempty"`
+    AttemptedAt    string          `json:"attempted_at"`
+}
+
+// knownWebhookEvent reports whether a subscription's event names a known
+// type, a prefix of one ("shipment.*") or everything ("*").
+func knownWebhookEvent(event string) bool {
+    if event == "*" {
+        return true
+    }
+    prefix, wildcard := strings.CutSuffix(event, ".*")
+    for _, t := range webhookEventTypes {
+        if t == event || (wildcard && strings.HasPrefix(t, prefix+".")) {
+            return true
+        }
+    }
+    return false
+}
+
+// webhookAllowPrivateURLs reports whether WEBHOOK_ALLOW_PRIVATE_URLS permits
+// http and internal hosts in subscription URLs, for local development.
+func webhookAllowPrivateURLs() bool {
+    ok, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("WEBHOOK_ALLOW_PRIVATE_URLS")))
+    return ok
+}
+
+// validWebhookURL checks that raw is an absolute https URL whose host is not
+// localhost or an internal IP literal; allowPrivate also admits http and
+// internal hosts. Hostnames resolving to internal addresses are refused by
+// the dispatcher when dialling.
+func validWebhookURL(raw string, allowPrivate bool) error {
+    u, err := url.Parse(raw)
+    if err != nil || u.Host == "" || (u.Scheme != "https" && !(allowPrivate && u.Scheme == "http")) {
+        if allowPrivate {
+            return errors.New("url must be an absolute http(s) url")
+        }
+        return errors.New("url must be an absolute https url")
+    }
+    if allowPrivate {
+        return nil
+    }
+    host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+    if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+        return errors.New("url must not point to a private, loopback or link-local address")
+    }
+    if ip, err := netip.ParseAddr(host); err == nil && forbiddenAddr(ip) {
+        return errors.New("url must not point to a private, loopback or link-local address")
+    }
+    return nil
+}
+
+// emitTestEvent queues a webhook.test event for one subscription regardless
+// of the event it subscribes to.
+func emitTestEvent(ctx context.Context, q dbtx, orgID, webhookID uuid.UUID) (uuid.UUID, error) {
+    id := uuid.New()
+    payload, err := eventPayload(id, eventWebhookTest, time.Now(), map[string]any{
+        "webhook_id": webhookID.String(),
+        "message":    "This is a test event.",
+    })
+    if err != nil {
+        return uuid.Nil, err
+    }
+    var dispatchID uuid.UUID
+    err = q.QueryRow(ctx, `
+        WITH ev AS (
+            INSERT INTO webhook_events (id, org_id, type, payload)
+            VALUES ($1, $2, $3, $4::jsonb)
+            RETURNING id
+        )
+        INSERT INTO webhook_dispatches (webhook_id, event_id)
+        SELECT $5, ev.id FROM ev
+        RETURNING id
+    `, id, orgID, eventWebhookTest, string(payload), webhookID).Scan(&dispatchID)
+    return dispatchID, err
+}
+
+func (s *Server) handleCreateWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+    var req WebhookSubscriptionCreateRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    if strings.TrimSpace(req.OrgSlug) == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "org_slug required")
+        return
+    }
+    event := strings.TrimSpace(req.Event)
+    if !knownWebhookEvent(event) {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "unknown event")
+        return
+    }
+    target := strings.TrimSpace(req.URL)
+    if err := validWebhookURL(target, webhookAllowPrivateURLs()); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+    if s.keys == nil {
+        writeErrorJSON(w, http.StatusServiceUnavailable, "secrets_not_configured", "secret keys not configured")
+        return
+    }
+    secret := strings.TrimSpace(req.Secret)
+    if secret == "" {
+        var err error
+        if secret, err = generateWebhookSecret(); err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "secret_error", "failed to generate secret")
+            return
+        }
+    }
+
+    ctx := r.Context()
+    orgID, err := resolveOrgID(ctx, s.db, req.OrgSlug)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "org not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    id := uuid.New()
+    sealed, err := s.keys.Seal([]byte(secret), id[:])
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "secret_error", "failed to encrypt secret")
+        return
+    }
+    _, err = s.db.Exec(ctx, `
+        INSERT INTO webhooks (id, org_id, event, target_url, secret_encrypted, description)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `, id, orgID, event, target, sealed, nullIfEmpty(req.Description))
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to create webhook subscription")
+        return
+    }
+    resp, err := loadWebhookSubscription(ctx, s.db, id)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    resp.Secret = secret
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// handleListWebhookSubscriptions lists an org's subscriptions, oldest first.
+func (s *Server) handleListWebhookSubscriptions(w http.ResponseWriter, r *http.Request) {
+    slug := strings.TrimSpace(r.URL.Query().Get("org_slug"))
+    if slug == "" {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "org_slug required")
+        return
+    }
+    ctx := r.Context()
+    orgID, err := resolveOrgID(ctx, s.db, slug)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "org not found")
+            return
+        }
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    rows, err := s.db.Query(ctx, webhookSubscriptionSelect+` WHERE w.org_id = $1 ORDER BY w.created_at, w.id`, orgID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer rows.Close()
+    subs := []WebhookSubscription{}
+    for rows.Next() {
+        sub, err := scanWebhookSubscription(rows)
+        if err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+            return
+        }
+        subs = append(subs, sub)
+    }
+    if err := rows.Err(); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]any{"webhook_subscriptions": subs})
+}
+
+func (s *Server) handleGetWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookSubscriptionID(w, r)
+    if !ok {
+        return
+    }
+    resp, err := loadWebhookSubscription(r.Context(), s.db, id)
+    if err != nil {
+        writeWebhookSubscriptionError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleUpdateWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookSubscriptionID(w, r)
+    if !ok {
+        return
+    }
+    var req WebhookSubscriptionUpdateRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    if req.Event != nil {
+        *req.Event = strings.TrimSpace(*req.Event)
+        if !knownWebhookEvent(*req.Event) {
+            writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "unknown event")
+            return
+        }
+    }
+    if req.URL != nil {
+        *req.URL = strings.TrimSpace(*req.URL)
+        if err := validWebhookURL(*req.URL, webhookAllowPrivateURLs()); err != nil {
+            writeErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+            return
+        }
+    }
+    ctx := r.Context()
+    // SET expressions see the old row, so "enabled" below is the state
+    // before this update
+    tag, err := s.db.Exec(ctx, `
+        UPDATE webhooks
+        SET event = COALESCE($2, event),
+            target_url = COALESCE($3, target_url),
+            description = COALESCE($4, description),
+            enabled = COALESCE($5::boolean, enabled),
+            consecutive_failures = CASE WHEN $5::boolean AND NOT enabled THEN 0 ELSE consecutive_failures END,
+            failing_since = CASE WHEN $5::boolean AND NOT enabled THEN NULL ELSE failing_since END,
+            disabled_at = CASE
+                WHEN $5::boolean AND NOT enabled THEN NULL
+                WHEN NOT $5::boolean AND enabled THEN NOW()
+                ELSE disabled_at END,
+            disabled_reason = CASE
+                WHEN $5::boolean AND NOT enabled THEN NULL
+                WHEN NOT $5::boolean AND enabled THEN 'disabled manually'
+                ELSE disabled_reason END,
+            updated_at = NOW()
+        WHERE id = $1 AND org_id IS NOT NULL
+    `, id, req.Event, req.URL, req.Description, req.Enabled)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to update webhook subscription")
+        return
+    }
+    if tag.RowsAffected() == 0 {
+        writeWebhookSubscriptionError(w, pgx.ErrNoRows)
+        return
+    }
+    resp, err := loadWebhookSubscription(ctx, s.db, id)
+    if err != nil {
+        writeWebhookSubscriptionError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleDeleteWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookSubscriptionID(w, r)
+    if !ok {
+        return
+    }
+    tag, err := s.db.Exec(r.Context(), `DELETE FROM webhooks WHERE id = $1 AND org_id IS NOT NULL`, id)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if tag.RowsAffected() == 0 {
+        writeWebhookSubscriptionError(w, pgx.ErrNoRows)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRotateWebhookSubscriptionSecret replaces the signing secret. During
+// the grace period deliveries carry signatures by both secrets, so receivers
+// can switch without rejecting anything.
+func (s *Server) handleRotateWebhookSubscriptionSecret(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookSubscriptionID(w, r)
+    if !ok {
+        return
+    }
+    var req WebhookSecretRotateRequest
+    if err := decodeOptionalJSON(r, &req); err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "invalid json")
+        return
+    }
+    grace := defaultSecretGrace
+    if strings.TrimSpace(req.Grace) != "" {
+        d, err := time.ParseDuration(strings.TrimSpace(req.Grace))
+        if err != nil || d < 0 {
+            writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid grace")
+            return
+        }
+        grace = d
+    }
+    if s.keys == nil {
+        writeErrorJSON(w, http.StatusServiceUnavailable, "secrets_not_configured", "secret keys not configured")
+        return
+    }
+    secret := strings.TrimSpace(req.Secret)
+    if secret == "" {
+        var err error
+        if secret, err = generateWebhookSecret(); err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "secret_error", "failed to generate secret")
+            return
+        }
+    }
+    sealed, err := s.keys.Seal([]byte(secret), id[:])
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "secret_error", "failed to encrypt secret")
+        return
+    }
+    ctx := r.Context()
+    tag, err := s.db.Exec(ctx, `
+        UPDATE webhooks
+        SET previous_secret_encrypted = secret_encrypted,
+            previous_secret_expires_at = NOW() + $3 * INTERVAL '1 millisecond',
+            secret_encrypted = $2,
+            secret_rotated_at = NOW(),
+            updated_at = NOW()
+        WHERE id = $1 AND org_id IS NOT NULL
+    `, id, sealed, grace.Milliseconds())
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to rotate secret")
+        return
+    }
+    if tag.RowsAffected() == 0 {
+        writeWebhookSubscriptionError(w, pgx.ErrNoRows)
+        return
+    }
+    resp, err := loadWebhookSubscription(ctx, s.db, id)
+    if err != nil {
+        writeWebhookSubscriptionError(w, err)
+        return
+    }
+    resp.Secret = secret
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// handleTestWebhookSubscription queues a webhook.test event for the
+// subscription; its outcome shows up in the deliveries.
+func (s *Server) handleTestWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookSubscriptionID(w, r)
+    if !ok {
+        return
+    }
+    ctx := r.Context()
+    var (
+        orgID   uuid.UUID
+        enabled bool
+    )
+    err := s.db.QueryRow(ctx, `SELECT org_id, enabled FROM webhooks WHERE id = $1 AND org_id IS NOT NULL`, id).Scan(&orgID, &enabled)
+    if err != nil {
+        writeWebhookSubscriptionError(w, err)
+        return
+    }
+    if !enabled {
+        writeWebhookSubscriptionError(w, errWebhookDisabled)
+        return
+    }
+    dispatchID, err := emitTestEvent(ctx, s.db, orgID, id)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to queue test event")
+        return
+    }
+    s.writeWebhookDelivery(w, r, id, dispatchID, http.StatusAccepted)
+}
+
+// handleListWebhookDeliveries lists a subscription's deliveries, newest
+// first, with their attempts (without bodies). Filters: status, event_type.
+func (s *Server) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookSubscriptionID(w, r)
+    if !ok {
+        return
+    }
+    status := strings.TrimSpace(r.URL.Query().Get("status"))
+    switch status {
+    case "", dispatchStatusPending, dispatchStatusDelivered, dispatchStatusFailed:
+    default:
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid status")
+        return
+    }
+    limit, offset, ok := parsePagination(w, r, 50, 200)
+    if !ok {
+        return
+    }
+    ctx := r.Context()
+    if _, err := loadWebhookSubscription(ctx, s.db, id); err != nil {
+        writeWebhookSubscriptionError(w, err)
+        return
+    }
+    rows, err := s.db.Query(ctx, webhookDeliverySelect+`
+        WHERE d.webhook_id = $1
+          AND ($2 = '' OR d.status = $2)
+          AND ($3 = '' OR e.type = $3)
+        ORDER BY d.created_at DESC, d.id
+        LIMIT $4 OFFSET $5
+    `, id, status, strings.TrimSpace(r.URL.Query().Get("event_type")), limit, offset)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    defer rows.Close()
+    deliveries := []WebhookDelivery{}
+    index := map[string]int{}
+    var ids []uuid.UUID
+    for rows.Next() {
+        d, err := scanWebhookDelivery(rows, false)
+        if err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+            return
+        }
+        index[d.ID] = len(deliveries)
+        ids = append(ids, uuid.MustParse(d.ID))
+        deliveries = append(deliveries, d)
+    }
+    if err := rows.Err(); err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    rows.Close()
+    if len(ids) > 0 {
+        attempts, err := loadWebhookAttempts(ctx, s.db, ids, false)
+        if err != nil {
+            writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+            return
+        }
+        for dispatchID, as := range attempts {
+            deliveries[index[dispatchID]].Attempts = as
+        }
+    }
+    writeJSON(w, http.StatusOK, map[string]any{"deliveries": deliveries, "limit": limit, "offset": offset})
+}
+
+// handleGetWebhookDelivery returns one delivery with the event payload and
+// full request and response bodies of every attempt.
+func (s *Server) handleGetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookSubscriptionID(w, r)
+    if !ok {
+        return
+    }
+    deliveryID, ok := parseWebhookDeliveryID(w, r)
+    if !ok {
+        return
+    }
+    s.writeWebhookDelivery(w, r, id, deliveryID, http.StatusOK)
+}
+
+// handleRedeliverWebhookDelivery queues the delivery's event again as a new
+// delivery with the same event id, so receivers can deduplicate.
+func (s *Server) handleRedeliverWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+    id, ok := parseWebhookSubscriptionID(w, r)
+    if !ok {
+        return
+    }
+    deliveryID, ok := parseWebhookDeliveryID(w, r)
+    if !ok {
+        return
+    }
+    ctx := r.Context()
+    var (
+        eventID uuid.UUID
+        enabled bool
+    )
+    err := s.db.QueryRow(ctx, `
+        SELECT d.event_id, w.enabled
+        FROM webhook_dispatches d JOIN webhooks w ON w.id = d.webhook_id
+        WHERE d.webhook_id = $1 AND d.id = $2
+    `, id, deliveryID).Scan(&eventID, &enabled)
+    if err != nil {
+        writeWebhookDeliveryError(w, err)
+        return
+    }
+    if !enabled {
+        writeWebhookSubscriptionError(w, errWebhookDisabled)
+        return
+    }
+    var newID uuid.UUID
+    err = s.db.QueryRow(ctx, `
+        INSERT INTO webhook_dispatches (webhook_id, event_id) VALUES ($1, $2) RETURNING id
+    `, id, eventID).Scan(&newID)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "failed to queue redelivery")
+        return
+    }
+    s.writeWebhookDelivery(w, r, id, newID, http.StatusAccepted)
+}
+
+func (s *Server) writeWebhookDelivery(w http.ResponseWriter, r *http.Request, webhookID, deliveryID uuid.UUID, status int) {
+    ctx := r.Context()
+    d, err := scanWebhookDelivery(s.db.QueryRow(ctx, webhookDeliverySelect+` WHERE d.webhook_id = $1 AND d.id = $2`, webhookID, deliveryID), true)
+    if err != nil {
+        writeWebhookDeliveryError(w, err)
+        return
+    }
+    attempts, err := loadWebhookAttempts(ctx, s.db, []uuid.UUID{deliveryID}, true)
+    if err != nil {
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+        return
+    }
+    if as, ok := attempts[d.ID]; ok {
+        d.Attempts = as
+    }
+    writeJSON(w, status, d)
+}
+
+func parseWebhookSubscriptionID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+    id, err := uuid.Parse(strings.TrimSpace(chi.URLParam(r, "id")))
+    if err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid webhook subscription id")
+        return uuid.Nil, false
+    }
+    return id, true
+}
+
+func parseWebhookDeliveryID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+    id, err := uuid.Parse(strings.TrimSpace(chi.URLParam(r, "delivery_id")))
+    if err != nil {
+        writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid delivery id")
+        return uuid.Nil, false
+    }
+    return id, true
+}
+
+func writeWebhookSubscriptionError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, pgx.ErrNoRows):
+        writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "webhook subscription not found")
+    case errors.Is(err, errWebhookDisabled):
+        writeErrorJSON(w, http.StatusConflict, "conflict", err.Error())
+    default:
+        writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+    }
+}
+
+func writeWebhookDeliveryError(w http.ResponseWriter, err error) {
+    if errors.Is(err, pgx.ErrNoRows) {
+        writeErrorJSON(w, http.StatusNotFound, "resource_not_found", "delivery not found")
+        return
+    }
+    writeErrorJSON(w, http.StatusInternalServerError, "db_error", "db error")
+}
+
+// Subscriptions without an org predate the API and are not served by it.
+const webhookSubscriptionSelect = `
+    SELECT w.id, o.slug, w.event, w.target_url, COALESCE(w.description, ''), w.enabled,
+           CASE WHEN w.previous_secret_expires_at > NOW() THEN w.previous_secret_expires_at END,
+           w.secret_rotated_at, w.consecutive_failures, w.failing_since, w.last_success_at,
+           w.last_failure_at, w.disabled_at, COALESCE(w.disabled_reason, ''), w.created_at, w.updated_at
+    FROM webhooks w
+    JOIN orgs o ON o.id = w.org_id`
+
+func loadWebhookSubscription(ctx context.Context, q dbtx, id uuid.UUID) (WebhookSubscription, error) {
+    return scanWebhookSubscription(q.QueryRow(ctx, webhookSubscriptionSelect+` WHERE w.id = $1`, id))
+}
+
+func scanWebhookSubscription(row pgx.Row) (WebhookSubscription, error) {
+    var (
+        sub           WebhookSubscription
+        id            uuid.UUID
+        prevExpiresAt *time.Time
+        rotatedAt     *time.Time
+        failingSince  *time.Time
+        lastSuccessAt *time.Time
+        lastFailureAt *time.Time
+        disabledAt    *time.Time
+        createdAt     time.Time
+        updatedAt     time.Time
+    )
+    err := row.Scan(&id, &sub.OrgSlug, &sub.Event, &sub.URL, &sub.Description, &sub.Enabled,
+        &prevExpiresAt, &rotatedAt, &sub.ConsecutiveFailures, &failingSince, &lastSuccessAt,
+        &lastFailureAt, &disabledAt, &sub.DisabledReason, &createdAt, &updatedAt)
+    if err != nil {
+        return WebhookSubscription{}, err
+    }
+    sub.ID = id.String()
+    sub.PreviousSecretExpiresAt = formatOptionalTime(prevExpiresAt)
+    sub.SecretRotatedAt = formatOptionalTime(rotatedAt)
+    sub.FailingSince = formatOptionalTime(failingSince)
+    sub.LastSuccessAt = formatOptionalTime(lastSuccessAt)
+    sub.LastFailureAt = formatOptionalTime(lastFailureAt)
+    sub.DisabledAt = formatOptionalTime(disabledAt)
+    sub.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+    sub.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
+    return sub, nil
+}
+
+// formatOptionalTime formats t as RFC3339 UTC; nil is empty.
+func formatOptionalTime(t *time.Time) string {
+    if t == nil {
+        return ""
+    }
+    return t.UTC().Format(time.RFC3339)
+}
+
+const webhookDeliverySelect = `
+    SELECT d.id, d.event_id, e.type, d.status, d.attempts, d.next_attempt_at,
+           COALESCE(d.last_error, ''), d.delivered_at, d.created_at, e.payload
+    FROM webhook_dispatches d
+    JOIN webhook_events e ON e.id = d.event_id`
+
+func scanWebhookDelivery(row pgx.Row, withPayload bool) (WebhookDelivery, error) {
+    var (
+        d             WebhookDelivery
+        id, eventID   uuid.UUID
+        nextAttemptAt time.Time
+        deliveredAt   *time.Time
+        createdAt     time.Time
+        payload       []byte
+    )
+    err := row.Scan(&id, &eventID, &d.EventType, &d.Status, &d.AttemptCount, &nextAttemptAt,
+        &d.LastError, &deliveredAt, &createdAt, &payload)
+    if err != nil {
+        return WebhookDelivery{}, err
+    }
+    d.ID = id.String()
+    d.EventID = eventID.String()
+    if d.Status == dispatchStatusPending {
+        d.NextAttemptAt = nextAttemptAt.UTC().Format(time.RFC3339)
+    }
+    d.DeliveredAt = formatOptionalTime(deliveredAt)
+    d.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+    if withPayload {
+        d.Payload = payload
+    }
+    d.Attempts = []WebhookAttempt{}
+    return d, nil
+}
+
+// loadWebhookAttempts returns the attempts of the given dispatches keyed by
+// dispatch id, oldest first.
+func loadWebhookAttempts(ctx context.Context, q dbtx, dispatchIDs []uuid.UUID, withBodies bool) (map[string][]WebhookAttempt, error) {
+    rows, err := q.Query(ctx, `
+        SELECT dispatch_id, attempt, request_url, request_headers,
+               CASE WHEN $2 THEN COALESCE(request_body, '') ELSE '' END,
+               response_status,
+               CASE WHEN $2 THEN COALESCE(response_body, '') ELSE '' END,
+               COALESCE(latency_ms, 0), COALESCE(error, ''), attempted_at
+        FROM webhook_attempts
+        WHERE dispatch_id = ANY($1)
+        ORDER BY attempted_at, attempt
+    `, dispatchIDs, withBodies)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := map[string][]WebhookAttempt{}
+    for rows.Next() {
+        var (
+            a           WebhookAttempt
+            dispatchID  uuid.UUID
+            headers     []byte
+            attemptedAt time.Time
+        )
+        if err := rows.Scan(&dispatchID, &a.Attempt, &a.RequestURL, &headers, &a.RequestBody,
+            &a.ResponseStatus, &a.ResponseBody, &a.LatencyMS, &a.Error, &attemptedAt); err != nil {
+            return nil, err
+        }
+        a.RequestHeaders = headers
+        a.AttemptedAt = attemptedAt.UTC().Format(time.RFC3339)
+        out[dispatchID.String()] = append(out[dispatchID.String()], a)
+    }
+    return out, rows.Err()
+}
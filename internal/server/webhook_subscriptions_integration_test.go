@@ -0,0 +1,154 @@
+package server
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "deliveryinfra/internal/db"
+    "deliveryinfra/internal/secrets"
+)
+
+func TestWebhookSubscriptions_TestFireDeliveriesAndRedelivery(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    keyConfig := "1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{5}, 32))
+    t.Setenv("SECRET_KEYS", keyConfig)
+    // The receiver below listens on loopback over http
+    t.Setenv("WEBHOOK_ALLOW_PRIVATE_URLS", "true")
+    keys, _ := secrets.NewKeyring(keyConfig)
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+    slug := fmt.Sprintf("whs-%d", time.Now().UnixNano())
+    if _, err := pool.Exec(t.Context(), `INSERT INTO orgs (slug, name) VALUES ($1, 'Subs')`, slug); err != nil {
+        t.Fatalf("create org: %v", err)
+    }
+
+    var status atomic.Int32
+    status.Store(http.StatusOK)
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(int(status.Load()))
+        _, _ = w.Write([]byte("thanks"))
+    }))
+    defer srv.Close()
+
+    h := New(pool)
+    do := func(method, path string, body any) *httptest.ResponseRecorder {
+        var b []byte
+        if body != nil {
+            b, _ = json.Marshal(body)
+        }
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewReader(b)))
+        return rr
+    }
+
+    if rr := do(http.MethodPost, "/webhook-subscriptions", map[string]any{"org_slug": slug, "event": "parcel.*", "url": srv.URL}); rr.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400 for unknown event, got %d", rr.Code)
+    }
+    rr := do(http.MethodPost, "/webhook-subscriptions", map[string]any{"org_slug": slug, "event": "shipment.*", "url": srv.URL})
+    if rr.Code != http.StatusOK {
+        t.Fatalf("create subscription: %d %s", rr.Code, rr.Body.String())
+    }
+    var sub WebhookSubscription
+    _ = json.Unmarshal(rr.Body.Bytes(), &sub)
+    if sub.Secret == "" || !sub.Enabled || sub.OrgSlug != slug {
+        t.Fatalf("unexpected subscription: %+v", sub)
+    }
+    base := "/webhook-subscriptions/" + sub.ID
+
+    // Test fire, delivered by the dispatcher
+    rr = do(http.MethodPost, base+"/test", nil)
+    if rr.Code != http.StatusAccepted {
+        t.Fatalf("test fire: %d %s", rr.Code, rr.Body.String())
+    }
+    var queued WebhookDelivery
+    _ = json.Unmarshal(rr.Body.Bytes(), &queued)
+    if queued.EventType != eventWebhookTest || queued.Status != dispatchStatusPending {
+        t.Fatalf("unexpected queued delivery: %+v", queued)
+    }
+    d := NewWebhookDispatcher(pool, keys, WebhookDispatcherConfig{MaxAttempts: 1, AllowPrivateURLs: true})
+    if _, err := d.DispatchPending(t.Context()); err != nil {
+        t.Fatalf("dispatch: %v", err)
+    }
+    rr = do(http.MethodGet, base+"/deliveries/"+queued.ID, nil)
+    var got WebhookDelivery
+    _ = json.Unmarshal(rr.Body.Bytes(), &got)
+    if rr.Code != http.StatusOK || got.Status != dispatchStatusDelivered || len(got.Attempts) != 1 {
+        t.Fatalf("get delivery: %d %s", rr.Code, rr.Body.String())
+    }
+    a := got.Attempts[0]
+    if a.ResponseStatus == nil || *a.ResponseStatus != http.StatusOK || a.ResponseBody != "thanks" || a.RequestBody == "" || a.RequestURL != srv.URL {
+        t.Fatalf("unexpected attempt: %+v", a)
+    }
+
+    // A failed delivery shows its error and can be redelivered
+    status.Store(http.StatusServiceUnavailable)
+    rr = do(http.MethodPost, base+"/deliveries/"+queued.ID+"/redeliver", nil)
+    if rr.Code != http.StatusAccepted {
+        t.Fatalf("redeliver: %d %s", rr.Code, rr.Body.String())
+    }
+    var again WebhookDelivery
+    _ = json.Unmarshal(rr.Body.Bytes(), &again)
+    if again.ID == queued.ID || again.EventID != queued.EventID {
+        t.Fatalf("redelivery should be a new delivery of the same event: %+v", again)
+    }
+    if _, err := d.DispatchPending(t.Context()); err != nil {
+        t.Fatalf("dispatch: %v", err)
+    }
+    rr = do(http.MethodGet, base+"/deliveries?status=failed", nil)
+    var list struct {
+        Deliveries []WebhookDelivery `json:"deliveries"`
+    }
+    _ = json.Unmarshal(rr.Body.Bytes(), &list)
+    if rr.Code != http.StatusOK || len(list.Deliveries) != 1 || list.Deliveries[0].ID != again.ID {
+        t.Fatalf("list failed deliveries: %d %s", rr.Code, rr.Body.String())
+    }
+    fa := list.Deliveries[0].Attempts
+    if len(fa) != 1 || fa[0].Error == "" || fa[0].ResponseStatus == nil || *fa[0].ResponseStatus != http.StatusServiceUnavailable || fa[0].RequestBody != "" {
+        t.Fatalf("unexpected failed attempts: %+v", fa)
+    }
+
+    // Rotation returns the new secret and keeps the old one signing
+    rr = do(http.MethodPost, base+"/rotate-secret", map[string]any{"grace": "1h"})
+    var rotated WebhookSubscription
+    _ = json.Unmarshal(rr.Body.Bytes(), &rotated)
+    if rr.Code != http.StatusOK || rotated.Secret == "" || rotated.Secret == sub.Secret || rotated.PreviousSecretExpiresAt == "" {
+        t.Fatalf("rotate: %d %s", rr.Code, rr.Body.String())
+    }
+
+    // Disabled subscriptions refuse test fires; re-enabling clears failures
+    if rr := do(http.MethodPatch, base, map[string]any{"enabled": false}); rr.Code != http.StatusOK {
+        t.Fatalf("disable: %d %s", rr.Code, rr.Body.String())
+    }
+    if rr := do(http.MethodPost, base+"/test", nil); rr.Code != http.StatusConflict {
+        t.Fatalf("expected 409 for disabled subscription, got %d", rr.Code)
+    }
+    rr = do(http.MethodPatch, base, map[string]any{"enabled": true})
+    var enabled WebhookSubscription
+    _ = json.Unmarshal(rr.Body.Bytes(), &enabled)
+    if rr.Code != http.StatusOK || !enabled.Enabled || enabled.ConsecutiveFailures != 0 || enabled.DisabledAt != "" {
+        t.Fatalf("re-enable: %d %s", rr.Code, rr.Body.String())
+    }
+
+    if rr := do(http.MethodDelete, base, nil); rr.Code != http.StatusNoContent {
+        t.Fatalf("delete: %d %s", rr.Code, rr.Body.String())
+    }
+    if rr := do(http.MethodGet, base, nil); rr.Code != http.StatusNotFound {
+        t.Fatalf("expected 404 after delete, got %d", rr.Code)
+    }
+}
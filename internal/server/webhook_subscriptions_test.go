@@ -0,0 +1,93 @@
+package server
+
+import (
+    "bytes"
+    "encoding/base64"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+
+    "deliveryinfra/internal/secrets"
+)
+
+func TestKnownWebhookEvent(t *testing.T) {
+    for event, want := range map[string]bool{
+        "shipment.created":   true,
+        "shipment.delivered": true,
+        "tracker.updated":    true,
+        "shipment.*":         true,
+        "*":                  true,
+        "ship.*":             false,
+        "shipment.returned":  false,
+        "webhook.test":       false,
+        "":                   false,
+    } {
+        if got := knownWebhookEvent(event); got != want {
+            t.Errorf("knownWebhookEvent(%q) = %v, want %v", event, got, want)
+        }
+    }
+}
+
+func TestValidWebhookURL(t *testing.T) {
+    for raw, want := range map[string]bool{
+        "https://example.com/hooks":                true,
+        "http://example.com/hooks":                 false,
+        "https://localhost:9000":                   false,
+        "https://127.0.0.1/hooks":                  false,
+        "https://10.0.0.5/hooks":                   false,
+        "https://[::1]/hooks":                      false,
+        "https://169.254.169.254/latest/meta-data": false,
+        "https://93.184.216.34/hooks":              true,
+        "ftp://example.com":                        false,
+        "/hooks":                                   false,
+        "https://":                                 false,
+    } {
+        if got := validWebhookURL(raw, false) == nil; got != want {
+            t.Errorf("validWebhookURL(%q) = %v, want %v", raw, got, want)
+        }
+    }
+    // Development mode admits http and local receivers
+    for raw, want := range map[string]bool{
+        "http://localhost:9000": true,
+        "http://127.0.0.1:9000": true,
+        "ftp://example.com":     false,
+    } {
+        if got := validWebhookURL(raw, true) == nil; got != want {
+            t.Errorf("validWebhookURL(%q, allowPrivate) = %v, want %v", raw, got, want)
+        }
+    }
+}
+
+// During a rotation's grace period both secrets sign each delivery.
+func TestWebhookDispatcherSignsWithPreviousSecret(t *testing.T) {
+    keys, err := secrets.NewKeyring("1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{4}, 32)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var sig string
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        sig = r.Header.Get("X-Signature")
+    }))
+    defer srv.Close()
+
+    job := dispatchJob{id: uuid.New(), webhookID: uuid.New(), eventID: uuid.New(), targetURL: srv.URL, payload: []byte(`{}`)}
+    job.sealed, _ = keys.Seal([]byte("whsec_new"), job.webhookID[:])
+    job.prevSealed, _ = keys.Seal([]byte("whsec_old"), job.webhookID[:])
+    if a := NewWebhookDispatcher(nil, keys, WebhookDispatcherConfig{AllowPrivateURLs: true}).send(t.Context(), job); a.err != nil {
+        t.Fatalf("send: %v", a.err)
+    }
+    if strings.Count(sig, "v1=") != 2 {
+        t.Fatalf("expected two signatures, got %q", sig)
+    }
+    for _, secret := range []string{"whsec_new", "whsec_old"} {
+        req := httptest.NewRequest(http.MethodPost, "/", nil)
+        req.Header.Set("X-Signature", sig)
+        if _, err := (&DefaultVerifier{}).Verify(req, job.payload, secret, time.Now()); err != nil {
+            t.Fatalf("%s does not verify: %v", secret, err)
+        }
+    }
+}
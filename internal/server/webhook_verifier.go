@@ -0,0 +1,336 @@
+package server
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// WebhookVerifier authenticates an inbound webhook request with the source's
+// secret. Providers differ in header, encoding and what is signed, so each
+// source selects its own (see webhookVerifierFor).
+type WebhookVerifier interface {
+    Verify(r *http.Request, body []byte, secret string, now time.Time) (WebhookSignature, error)
+}
+
+// WebhookSignature is a verified request signature.
+type WebhookSignature struct {
+    // Timestamp is the signed time; zero for schemes without one.
+    Timestamp time.Time
+    // Value is the matching signature, used as the nonce when the sender
+    // gives no delivery ID.
+    Value string
+}
+
+// webhookSecret is one secret a webhook target accepts; id identifies stored
+// endpoint secrets and is nil for environment secrets.
+type webhookSecret struct {
+    id    uuid.UUID
+    value string
+}
+
+// verifyAny checks the request against each secret in turn, so a sender may
+// sign with any currently valid one, and returns the secret that matched.
+// Failures other than a mismatch do not depend on the secret and are returned
+// at once.
+func verifyAny(v WebhookVerifier, r *http.Request, body []byte, secrets []webhookSecret, now time.Time) (WebhookSignature, webhookSecret, error) {
+    for _, sec := range secrets {
+        sig, err := v.Verify(r, body, sec.value, now)
+        if err == nil {
+            return sig, sec, nil
+        }
+        if err != errSignatureMismatch {
+            return WebhookSignature{}, webhookSecret{}, err
+        }
+    }
+    return WebhookSignature{}, webhookSecret{}, errSignatureMismatch
+}
+
+// VerifierSpec configures a verifier by scheme, for mapping specs and
+// registered endpoints:
+//
+//	default         X-Signature: body HMAC or t=...,v1=... (DefaultVerifier)
+//	hmac            body HMAC in Header (HMACVerifier)
+//	signed_payload  Stripe-style t=...,v1=... in Header (SignedPayloadVerifier)
+//	timestamp_hmac  HMAC over Payload with the time in TimestampHeader (TimestampHMACVerifier)
+//	token           shared secret in Header (TokenVerifier)
+//	17track         17TRACK sign header (Track17Verifier)
+type VerifierSpec struct {
+    Scheme          string `json:"scheme"`
+    Header          string `json:"header,omitempty"`
+    TimestampHeader string `json:"timestamp_header,omitempty"`
+    // Encoding of signatures: hex (default) or base64.
+    Encoding string `json:"encoding,omitempty"`
+    // Prefix is stripped from the header value, e.g. "sha256=" or "Bearer ".
+    Prefix string `json:"prefix,omitempty"`
+    // Payload is the signed message for timestamp_hmac, with {timestamp} and
+    // {body} placeholders; default "{timestamp}.{body}".
+    Payload string `json:"payload,omitempty"`
+    // Tolerance overrides WEBHOOK_TOLERANCE for timestamped schemes ("5m").
+    Tolerance string `json:"tolerance,omitempty"`
+}
+
+// NewVerifier builds the verifier a spec describes.
+func NewVerifier(spec VerifierSpec) (WebhookVerifier, error) {
+    var tolerance time.Duration
+    if spec.Tolerance != "" {
+        d, err := time.ParseDuration(spec.Tolerance)
+        if err != nil || d <= 0 {
+            return nil, fmt.Errorf("verifier: invalid tolerance %q", spec.Tolerance)
+        }
+        tolerance = d
+    }
+    switch spec.Encoding {
+    case "", "hex", "base64":
+    default:
+        return nil, fmt.Errorf("verifier: unknown encoding %q", spec.Encoding)
+    }
+    switch strings.ToLower(strings.TrimSpace(spec.Scheme)) {
+    case "", "default":
+        return &DefaultVerifier{Tolerance: tolerance}, nil
+    case "hmac":
+        return &HMACVerifier{Header: spec.Header, Encoding: spec.Encoding, Prefix: spec.Prefix}, nil
+    case "signed_payload":
+        return &SignedPayloadVerifier{Header: spec.Header, Tolerance: tolerance}, nil
+    case "timestamp_hmac":
+        if spec.Header == "" || spec.TimestampHeader == "" {
+            return nil, fmt.Errorf("verifier: timestamp_hmac needs header and timestamp_header")
+        }
+        if spec.Payload != "" && (!strings.Contains(spec.Payload, "{timestamp}") || !strings.Contains(spec.Payload, "{body}")) {
+            return nil, fmt.Errorf("verifier: payload must contain {timestamp} and {body}")
+        }
+        return &TimestampHMACVerifier{
+            Header: spec.Header, TimestampHeader: spec.TimestampHeader, Payload: spec.Payload,
+            Encoding: spec.Encoding, Prefix: spec.Prefix, Tolerance: tolerance,
+        }, nil
+    case "token":
+        if spec.Header == "" {
+            return nil, fmt.Errorf("verifier: token needs header")
+        }
+        return &TokenVerifier{Header: spec.Header, Prefix: spec.Prefix}, nil
+    case "17track":
+        return &Track17Verifier{}, nil
+    default:
+        return nil, fmt.Errorf("verifier: unknown scheme %q", spec.Scheme)
+    }
+}
+
+// webhookVerifierFor selects the verifier of a built-in source.
+func webhookVerifierFor(source string) WebhookVerifier {
+    switch source {
+    case "17track":
+        return &Track17Verifier{}
+    default:
+        return &DefaultVerifier{}
+    }
+}
+
+// DefaultVerifier checks X-Signature in either form:
+//
+//	t=1741000000,v1=<hex hmac-sha256 of "1741000000.<body>">
+//	<hex hmac-sha256 of body>, optionally prefixed "sha256="
+//
+// The body-only form is rejected when RequireTimestamp or
+// WEBHOOK_REQUIRE_TIMESTAMP is set.
+type DefaultVerifier struct {
+    Tolerance        time.Duration
+    RequireTimestamp bool
+}
+
+func (v *DefaultVerifier) Verify(r *http.Request, body []byte, secret string, now time.Time) (WebhookSignature, error) {
+    header := strings.TrimSpace(r.Header.Get("X-Signature"))
+    if strings.HasPrefix(header, "t=") || strings.Contains(header, ",") {
+        return verifySignedPayload(header, secret, body, now, toleranceOr(v.Tolerance))
+    }
+    if header != "" && (v.RequireTimestamp || webhookRequireTimestamp()) {
+        return WebhookSignature{}, errTimestampRequired
+    }
+    return verifyMAC(header, "sha256=", "hex", webhookMAC(secret, body))
+}
+
+// HMACVerifier checks an HMAC-SHA256 of the body, e.g. Shopify's base64
+// X-Shopify-Hmac-Sha256. Header defaults to X-Signature.
+type HMACVerifier struct {
+    Header   string
+    Encoding string
+    Prefix   string
+}
+
+func (v *HMACVerifier) Verify(r *http.Request, body []byte, secret string, now time.Time) (WebhookSignature, error) {
+    return verifyMAC(r.Header.Get(orDefault(v.Header, "X-Signature")), v.Prefix, v.Encoding, webhookMAC(secret, body))
+}
+
+// SignedPayloadVerifier checks Stripe-style signatures: the header holds
+// t=<unix seconds> and one or more v1=<hex hmac-sha256 of "<t>.<body>">; any
+// v1 may match, which lets senders roll secrets. Header defaults to
+// X-Signature.
+type SignedPayloadVerifier struct {
+    Header    string
+    Tolerance time.Duration
+}
+
+func (v *SignedPayloadVerifier) Verify(r *http.Request, body []byte, secret string, now time.Time) (WebhookSignature, error) {
+    return verifySignedPayload(r.Header.Get(orDefault(v.Header, "X-Signature")), secret, body, now, toleranceOr(v.Tolerance))
+}
+
+// TimestampHMACVerifier checks an HMAC-SHA256 over a message built from a
+// separate timestamp header and the body, e.g. Slack's "v0:{timestamp}:{body}"
+// with X-Slack-Request-Timestamp and a "v0=" prefixed X-Slack-Signature.
+type TimestampHMACVerifier struct {
+    Header          string
+    TimestampHeader string
+    // Payload defaults to "{timestamp}.{body}".
+    Payload   string
+    Encoding  string
+    Prefix    string
+    Tolerance time.Duration
+}
+
+func (v *TimestampHMACVerifier) Verify(r *http.Request, body []byte, secret string, now time.Time) (WebhookSignature, error) {
+    if strings.TrimSpace(r.Header.Get(v.Header)) == "" {
+        return WebhookSignature{}, errMissingSignature
+    }
+    ts := strings.TrimSpace(r.Header.Get(v.TimestampHeader))
+    if ts == "" {
+        return WebhookSignature{}, errTimestampRequired
+    }
+    signedAt, err := checkTimestamp(ts, now, toleranceOr(v.Tolerance))
+    if err != nil {
+        return WebhookSignature{}, err
+    }
+    msg := strings.NewReplacer("{timestamp}", ts, "{body}", string(body)).Replace(orDefault(v.Payload, "{timestamp}.{body}"))
+    sig, err := verifyMAC(r.Header.Get(v.Header), v.Prefix, v.Encoding, webhookMAC(secret, []byte(msg)))
+    if err != nil {
+        return WebhookSignature{}, err
+    }
+    sig.Timestamp = signedAt
+    return sig, nil
+}
+
+// TokenVerifier compares a shared secret sent as-is in a header, e.g.
+// "Authorization: Bearer <secret>" with Prefix "Bearer ". It proves only that
+// the sender knows the secret, not that the body is intact.
+type TokenVerifier struct {
+    Header string
+    Prefix string
+}
+
+func (v *TokenVerifier) Verify(r *http.Request, body []byte, secret string, now time.Time) (WebhookSignature, error) {
+    token := strings.TrimSpace(r.Header.Get(v.Header))
+    if token == "" {
+        return WebhookSignature{}, errMissingSignature
+    }
+    if !strings.HasPrefix(token, v.Prefix) {
+        return WebhookSignature{}, errInvalidSignatureFormat
+    }
+    // Compare digests so the comparison time does not depend on length.
+    got, want := sha256.Sum256([]byte(strings.TrimPrefix(token, v.Prefix))), sha256.Sum256([]byte(secret))
+    if subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+        return WebhookSignature{}, errSignatureMismatch
+    }
+    return WebhookSignature{}, nil
+}
+
+// Track17Verifier checks 17TRACK pushes: the sign header is the hex SHA-256
+// of "<body>/<security key>".
+type Track17Verifier struct{}
+
+func (v *Track17Verifier) Verify(r *http.Request, body []byte, secret string, now time.Time) (WebhookSignature, error) {
+    sum := sha256.Sum256([]byte(string(body) + "/" + secret))
+    return verifyMAC(r.Header.Get("sign"), "", "hex", sum[:])
+}
+
+// verifyMAC decodes a signature header and compares it with expected.
+func verifyMAC(header, prefix, encoding string, expected []byte) (WebhookSignature, error) {
+    header = strings.TrimSpace(header)
+    if header == "" {
+        return WebhookSignature{}, errMissingSignature
+    }
+    provided, err := decodeSignature(strings.TrimPrefix(header, prefix), encoding)
+    if err != nil {
+        return WebhookSignature{}, errInvalidSignatureFormat
+    }
+    if !hmac.Equal(provided, expected) {
+        return WebhookSignature{}, errSignatureMismatch
+    }
+    return WebhookSignature{Value: hex.EncodeToString(provided)}, nil
+}
+
+func verifySignedPayload(header, secret string, body []byte, now time.Time, tolerance time.Duration) (WebhookSignature, error) {
+    header = strings.TrimSpace(header)
+    if header == "" {
+        return WebhookSignature{}, errMissingSignature
+    }
+    var (
+        ts   string
+        sigs [][]byte
+    )
+    for _, part := range strings.Split(header, ",") {
+        k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+        if !ok {
+            return WebhookSignature{}, errInvalidSignatureFormat
+        }
+        switch k {
+        case "t":
+            ts = v
+        case "v1":
+            b, err := hex.DecodeString(v)
+            if err != nil {
+                return WebhookSignature{}, errInvalidSignatureFormat
+            }
+            sigs = append(sigs, b)
+        }
+        // Unknown schemes are ignored so senders can add newer ones.
+    }
+    if ts == "" || len(sigs) == 0 {
+        return WebhookSignature{}, errInvalidSignatureFormat
+    }
+    signedAt, err := checkTimestamp(ts, now, tolerance)
+    if err != nil {
+        return WebhookSignature{}, err
+    }
+    expected := webhookMAC(secret, []byte(ts+"."+string(body)))
+    for _, sig := range sigs {
+        if hmac.Equal(sig, expected) {
+            return WebhookSignature{Timestamp: signedAt, Value: hex.EncodeToString(sig)}, nil
+        }
+    }
+    return WebhookSignature{}, errSignatureMismatch
+}
+
+// checkTimestamp parses unix seconds and checks they are within tolerance of
+// now, in either direction.
+func checkTimestamp(ts string, now time.Time, tolerance time.Duration) (time.Time, error) {
+    unix, err := strconv.ParseInt(ts, 10, 64)
+    if err != nil {
+        return time.Time{}, errInvalidSignatureFormat
+    }
+    signedAt := time.Unix(unix, 0)
+    if d := now.Sub(signedAt); d > tolerance || d < -tolerance {
+        return time.Time{}, errTimestampOutOfWindow
+    }
+    return signedAt, nil
+}
+
+func decodeSignature(s, encoding string) ([]byte, error) {
+    if encoding == "base64" {
+        return base64.StdEncoding.DecodeString(s)
+    }
+    return hex.DecodeString(s)
+}
+
+func toleranceOr(d time.Duration) time.Duration {
+    if d > 0 {
+        return d
+    }
+    return webhookTolerance()
+}
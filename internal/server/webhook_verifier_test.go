@@ -0,0 +1,203 @@
+package server
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strconv"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+type verifierCase struct {
+    name    string
+    headers map[string]string
+    want    error
+}
+
+func runVerifierCases(t *testing.T, v WebhookVerifier, secret string, body []byte, now time.Time, cases []verifierCase) {
+    t.Helper()
+    for _, c := range cases {
+        r := httptest.NewRequest(http.MethodPost, "/webhooks/x", nil)
+        for k, val := range c.headers {
+            r.Header.Set(k, val)
+        }
+        if _, err := v.Verify(r, body, secret, now); err != c.want {
+            t.Errorf("%s: err = %v, want %v", c.name, err, c.want)
+        }
+    }
+}
+
+var (
+    verifierSecret = "s3cret"
+    verifierBody   = []byte(`{"code":"X"}`)
+    verifierNow    = time.Unix(1741000000, 0)
+)
+
+func TestDefaultVerifier(t *testing.T) {
+    legacy := hex.EncodeToString(webhookMAC(verifierSecret, verifierBody))
+    now := verifierNow.Unix()
+    cases := []verifierCase{
+        {"legacy", map[string]string{"X-Signature": legacy}, nil},
+        {"legacy prefixed", map[string]string{"X-Signature": "sha256=" + legacy}, nil},
+        {"timestamped", map[string]string{"X-Signature": signTimestamped(verifierSecret, now-60, verifierBody)}, nil},
+        {"future within window", map[string]string{"X-Signature": signTimestamped(verifierSecret, now+60, verifierBody)}, nil},
+        {"stale", map[string]string{"X-Signature": signTimestamped(verifierSecret, now-301, verifierBody)}, errTimestampOutOfWindow},
+        {"wrong secret", map[string]string{"X-Signature": signTimestamped("other", now, verifierBody)}, errSignatureMismatch},
+        {"timestamp swapped", map[string]string{"X-Signature": fmt.Sprintf("t=%d,v1=%s", now, signTimestamped(verifierSecret, now-10, verifierBody)[len("t=1741000000,v1="):])}, errSignatureMismatch},
+        {"no v1", map[string]string{"X-Signature": fmt.Sprintf("t=%d", now)}, errInvalidSignatureFormat},
+        {"bad hex", map[string]string{"X-Signature": "zz"}, errInvalidSignatureFormat},
+        {"missing", nil, errMissingSignature},
+    }
+    runVerifierCases(t, &DefaultVerifier{}, verifierSecret, verifierBody, verifierNow, cases)
+
+    required := []verifierCase{
+        {"legacy when required", map[string]string{"X-Signature": legacy}, errTimestampRequired},
+        {"timestamped when required", map[string]string{"X-Signature": signTimestamped(verifierSecret, now, verifierBody)}, nil},
+    }
+    runVerifierCases(t, &DefaultVerifier{RequireTimestamp: true}, verifierSecret, verifierBody, verifierNow, required)
+    os.Setenv("WEBHOOK_REQUIRE_TIMESTAMP", "1")
+    defer os.Unsetenv("WEBHOOK_REQUIRE_TIMESTAMP")
+    runVerifierCases(t, &DefaultVerifier{}, verifierSecret, verifierBody, verifierNow, required)
+}
+
+func TestHMACVerifier(t *testing.T) {
+    mac := webhookMAC(verifierSecret, verifierBody)
+    b64, hx := base64.StdEncoding.EncodeToString(mac), hex.EncodeToString(mac)
+    shopify := &HMACVerifier{Header: "X-Shopify-Hmac-Sha256", Encoding: "base64"}
+    runVerifierCases(t, shopify, verifierSecret, verifierBody, verifierNow, []verifierCase{
+        {"base64", map[string]string{"X-Shopify-Hmac-Sha256": b64}, nil},
+        {"hex where base64 expected", map[string]string{"X-Shopify-Hmac-Sha256": hx}, errSignatureMismatch},
+        {"other header", map[string]string{"X-Signature": b64}, errMissingSignature},
+        {"not base64", map[string]string{"X-Shopify-Hmac-Sha256": "%%%"}, errInvalidSignatureFormat},
+    })
+    github := &HMACVerifier{Header: "X-Hub-Signature-256", Prefix: "sha256="}
+    runVerifierCases(t, github, verifierSecret, verifierBody, verifierNow, []verifierCase{
+        {"prefixed hex", map[string]string{"X-Hub-Signature-256": "sha256=" + hx}, nil},
+        {"tampered", map[string]string{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(webhookMAC(verifierSecret, []byte("{}")))}, errSignatureMismatch},
+    })
+}
+
+func TestSignedPayloadVerifier(t *testing.T) {
+    now := verifierNow.Unix()
+    v := &SignedPayloadVerifier{Header: "Stripe-Signature", Tolerance: time.Minute}
+    runVerifierCases(t, v, verifierSecret, verifierBody, verifierNow, []verifierCase{
+        {"valid", map[string]string{"Stripe-Signature": signTimestamped(verifierSecret, now, verifierBody)}, nil},
+        {"rolled secret", map[string]string{"Stripe-Signature": signTimestamped("old", now, verifierBody) + ",v1=" + signTimestamped(verifierSecret, now, verifierBody)[len("t=1741000000,v1="):]}, nil},
+        {"unknown scheme ignored", map[string]string{"Stripe-Signature": signTimestamped(verifierSecret, now, verifierBody) + ",v0=abc"}, nil},
+        {"outside own tolerance", map[string]string{"Stripe-Signature": signTimestamped(verifierSecret, now-120, verifierBody)}, errTimestampOutOfWindow},
+        {"malformed", map[string]string{"Stripe-Signature": "t=1,v1"}, errInvalidSignatureFormat},
+        {"missing", nil, errMissingSignature},
+    })
+}
+
+func TestTimestampHMACVerifier(t *testing.T) {
+    ts := strconv.FormatInt(verifierNow.Unix(), 10)
+    slackSig := "v0=" + hex.EncodeToString(webhookMAC(verifierSecret, []byte("v0:"+ts+":"+string(verifierBody))))
+    slack := &TimestampHMACVerifier{
+        Header: "X-Slack-Signature", TimestampHeader: "X-Slack-Request-Timestamp",
+        Payload: "v0:{timestamp}:{body}", Prefix: "v0=",
+    }
+    stale := strconv.FormatInt(verifierNow.Add(-time.Hour).Unix(), 10)
+    runVerifierCases(t, slack, verifierSecret, verifierBody, verifierNow, []verifierCase{
+        {"valid", map[string]string{"X-Slack-Signature": slackSig, "X-Slack-Request-Timestamp": ts}, nil},
+        {"timestamp changed", map[string]string{"X-Slack-Signature": slackSig, "X-Slack-Request-Timestamp": strconv.FormatInt(verifierNow.Unix()-1, 10)}, errSignatureMismatch},
+        {"stale", map[string]string{"X-Slack-Signature": slackSig, "X-Slack-Request-Timestamp": stale}, errTimestampOutOfWindow},
+        {"no timestamp", map[string]string{"X-Slack-Signature": slackSig}, errTimestampRequired},
+        {"bad timestamp", map[string]string{"X-Slack-Signature": slackSig, "X-Slack-Request-Timestamp": "soon"}, errInvalidSignatureFormat},
+        {"no signature", map[string]string{"X-Slack-Request-Timestamp": ts}, errMissingSignature},
+    })
+
+    b64 := &TimestampHMACVerifier{Header: "X-Sig", TimestampHeader: "X-Ts", Encoding: "base64"}
+    sig := base64.StdEncoding.EncodeToString(webhookMAC(verifierSecret, []byte(ts+"."+string(verifierBody))))
+    runVerifierCases(t, b64, verifierSecret, verifierBody, verifierNow, []verifierCase{
+        {"default payload base64", map[string]string{"X-Sig": sig, "X-Ts": ts}, nil},
+    })
+}
+
+func TestTokenVerifier(t *testing.T) {
+    v := &TokenVerifier{Header: "Authorization", Prefix: "Bearer "}
+    runVerifierCases(t, v, verifierSecret, verifierBody, verifierNow, []verifierCase{
+        {"valid", map[string]string{"Authorization": "Bearer " + verifierSecret}, nil},
+        {"wrong token", map[string]string{"Authorization": "Bearer nope"}, errSignatureMismatch},
+        {"no prefix", map[string]string{"Authorization": verifierSecret}, errInvalidSignatureFormat},
+        {"missing", nil, errMissingSignature},
+    })
+}
+
+func TestTrack17Verifier(t *testing.T) {
+    sum := sha256.Sum256([]byte(string(verifierBody) + "/" + verifierSecret))
+    runVerifierCases(t, &Track17Verifier{}, verifierSecret, verifierBody, verifierNow, []verifierCase{
+        {"valid", map[string]string{"sign": hex.EncodeToString(sum[:])}, nil},
+        {"hmac is not accepted", map[string]string{"sign": hex.EncodeToString(webhookMAC(verifierSecret, verifierBody))}, errSignatureMismatch},
+        {"missing", nil, errMissingSignature},
+    })
+}
+
+func TestNewVerifier(t *testing.T) {
+    valid := []VerifierSpec{
+        {},
+        {Scheme: "hmac", Header: "X-Shopify-Hmac-Sha256", Encoding: "base64"},
+        {Scheme: "signed_payload", Header: "Stripe-Signature", Tolerance: "10m"},
+        {Scheme: "timestamp_hmac", Header: "X-Sig", TimestampHeader: "X-Ts", Payload: "v0:{timestamp}:{body}"},
+        {Scheme: "token", Header: "X-Token"},
+        {Scheme: "17track"},
+    }
+    for _, spec := range valid {
+        if _, err := NewVerifier(spec); err != nil {
+            t.Errorf("NewVerifier(%+v): %v", spec, err)
+        }
+    }
+    invalid := []VerifierSpec{
+        {Scheme: "rsa"},
+        {Scheme: "hmac", Encoding: "base32"},
+        {Scheme: "signed_payload", Tolerance: "-1m"},
+        {Scheme: "timestamp_hmac", Header: "X-Sig"},
+        {Scheme: "timestamp_hmac", Header: "X-Sig", TimestampHeader: "X-Ts", Payload: "{body}"},
+        {Scheme: "token"},
+    }
+    for _, spec := range invalid {
+        if _, err := NewVerifier(spec); err == nil {
+            t.Errorf("NewVerifier(%+v) should fail", spec)
+        }
+    }
+}
+
+func TestVerifyAny(t *testing.T) {
+    current := webhookSecret{id: uuid.New(), value: "current"}
+    next := webhookSecret{id: uuid.New(), value: "next"}
+    secrets := []webhookSecret{current, next}
+    now := verifierNow.Unix()
+    verify := func(sig string) (webhookSecret, error) {
+        r := httptest.NewRequest(http.MethodPost, "/webhooks/x", nil)
+        if sig != "" {
+            r.Header.Set("X-Signature", sig)
+        }
+        _, matched, err := verifyAny(&DefaultVerifier{}, r, verifierBody, secrets, verifierNow)
+        return matched, err
+    }
+
+    // Either secret verifies, and the one that matched is reported
+    for _, sec := range secrets {
+        matched, err := verify(signTimestamped(sec.value, now, verifierBody))
+        if err != nil || matched.id != sec.id {
+            t.Errorf("%s: matched %v, err %v", sec.value, matched.id, err)
+        }
+    }
+    if _, err := verify(signTimestamped("retired", now, verifierBody)); err != errSignatureMismatch {
+        t.Errorf("unknown secret: err = %v, want mismatch", err)
+    }
+    // Secret-independent failures are not masked as mismatches
+    if _, err := verify(signTimestamped("next", now-3600, verifierBody)); err != errTimestampOutOfWindow {
+        t.Errorf("stale: err = %v, want out of window", err)
+    }
+    if _, err := verify(""); err != errMissingSignature {
+        t.Errorf("missing: err = %v, want missing signature", err)
+    }
+}
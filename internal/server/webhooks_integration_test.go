@@ -6,10 +6,15 @@ import (
     "crypto/sha256"
     "encoding/hex"
     "encoding/json"
+    "fmt"
     "net/http"
     "net/http/httptest"
     "os"
+    "strings"
     "testing"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
 
     "deliveryinfra/internal/db"
 )
@@ -49,8 +54,8 @@ func TestWebhookDummy_IngestsEvent(t *testing.T) {
     req.Header.Set("X-Signature", sig)
     rr := httptest.NewRecorder()
     h.ServeHTTP(rr, req)
-    if rr.Code != http.StatusOK {
-        t.Fatalf("expected 200, got %d; body=%s", rr.Code, rr.Body.String())
+    if e := acceptedEntry(t, pool, h, rr); e.Status != inboxStatusProcessed {
+        t.Fatalf("expected processed entry, got %+v", e)
     }
 
     // Verify tracker exists and last event status
@@ -149,9 +154,7 @@ func TestWebhookDummy_IdempotentDuplicate(t *testing.T) {
     req1.Header.Set("X-Signature", sig)
     rr1 := httptest.NewRecorder()
     h.ServeHTTP(rr1, req1)
-    if rr1.Code != http.StatusOK {
-        t.Fatalf("expected 200, got %d; body=%s", rr1.Code, rr1.Body.String())
-    }
+    acceptedEntry(t, pool, h, rr1)
 
     // Second duplicate send
     req2 := httptest.NewRequest(http.MethodPost, "/webhooks/dummy", bytes.NewReader(body))
@@ -159,8 +162,8 @@ func TestWebhookDummy_IdempotentDuplicate(t *testing.T) {
     req2.Header.Set("X-Signature", sig)
     rr2 := httptest.NewRecorder()
     h.ServeHTTP(rr2, req2)
-    if rr2.Code != http.StatusOK {
-        t.Fatalf("expected 200 on duplicate, got %d; body=%s", rr2.Code, rr2.Body.String())
+    if e := acceptedEntry(t, pool, h, rr2); e.Status != inboxStatusProcessed || e.Result.Duplicates != 1 {
+        t.Fatalf("expected processed duplicate, got %+v", e)
     }
 
     // Verify only one event stored for the occurred/status/description
@@ -220,8 +223,8 @@ func TestWebhookKarrio_IngestsEventWithAlternateFields(t *testing.T) {
     req.Header.Set("X-Signature", sig)
     rr := httptest.NewRecorder()
     h.ServeHTTP(rr, req)
-    if rr.Code != http.StatusOK {
-        t.Fatalf("expected 200, got %d; body=%s", rr.Code, rr.Body.String())
+    if e := acceptedEntry(t, pool, h, rr); e.Status != inboxStatusProcessed {
+        t.Fatalf("expected processed entry, got %+v", e)
     }
 
     // Verify tracker exists and last event status
@@ -243,4 +246,270 @@ func TestWebhookKarrio_IngestsEventWithAlternateFields(t *testing.T) {
     if res.Code != code || res.Status != "in_transit" || res.LastEventAt == "" || len(res.LastEvent) == 0 {
         t.Fatalf("unexpected tracker response: %+v", res)
     }
-}
\ No newline at end of file
+}
+
+func TestWebhookYamato_IngestsBatch(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    secret := "testsecret"
+    os.Setenv("YAMATO_WEBHOOK_SECRET", secret)
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    h := New(pool)
+
+    suffix := time.Now().UnixNano() % 1000000
+    delivered := fmt.Sprintf("9000-0000-%06d", suffix)
+    attempted := fmt.Sprintf("9100-0000-%06d", suffix)
+    payload := map[string]any{
+        "notifications": []any{
+            map[string]any{"slip_no": delivered, "statuses": []any{
+                map[string]any{"status": "荷物受付", "date": "2025/03/03", "time": "18:20", "branch": "渋谷神南センター"},
+                map[string]any{"status": "配達完了", "date": "2025/03/04", "time": "10:15", "branch": "大阪中央センター"},
+            }},
+            map[string]any{"slip_no": attempted, "statuses": []any{
+                map[string]any{"status": "持戻（ご不在）", "date": "2025/03/04", "time": "11:05", "branch": "福岡天神センター"},
+            }},
+        },
+    }
+    body, _ := json.Marshal(payload)
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    sig := hex.EncodeToString(mac.Sum(nil))
+
+    req := httptest.NewRequest(http.MethodPost, "/webhooks/yamato", bytes.NewReader(body))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Signature", sig)
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    e := acceptedEntry(t, pool, h, rr)
+    resp := e.Result
+    if e.Status != inboxStatusProcessed || resp == nil || resp.Received != 3 || resp.Created != 3 || len(resp.Events) != 3 {
+        t.Fatalf("expected 3 created events, got %+v", e)
+    }
+    for i, ev := range resp.Events {
+        if ev.Index != i || ev.Result != "created" {
+            t.Fatalf("unexpected result %d: %+v", i, ev)
+        }
+    }
+
+    // Redelivery of the batch is reported per item as duplicates
+    req = httptest.NewRequest(http.MethodPost, "/webhooks/yamato", bytes.NewReader(body))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Signature", sig)
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    if resp := acceptedEntry(t, pool, h, rr).Result; resp == nil || resp.Duplicates != 3 || resp.Created != 0 {
+        t.Fatalf("expected 3 duplicates, got %+v", resp)
+    }
+
+    // Hyphens are dropped from slip numbers; each slip gets its own tracker
+    for code, want := range map[string]string{
+        "90000000" + fmt.Sprintf("%06d", suffix): "delivered",
+        "91000000" + fmt.Sprintf("%06d", suffix): "failure",
+    } {
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/trackers/"+code, nil))
+        if rr.Code != http.StatusOK {
+            t.Fatalf("expected 200 on get %s, got %d; body=%s", code, rr.Code, rr.Body.String())
+        }
+        var res struct {
+            Status      string `json:"status"`
+            LastEventAt string `json:"last_event_at"`
+        }
+        if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+            t.Fatalf("unmarshal failed: %v", err)
+        }
+        if res.Status != want {
+            t.Fatalf("tracker %s: expected %s, got %+v", code, want, res)
+        }
+    }
+}
+
+func TestWebhook_InvalidItemDeadLettersBatch(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    secret := "testsecret"
+    os.Setenv("DHL_WEBHOOK_SECRET", secret)
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    h := New(pool)
+
+    good := fmt.Sprintf("DHLBATCHOK%d", time.Now().UnixNano())
+    payload := map[string]any{
+        "shipments": []any{
+            map[string]any{"id": good, "events": []any{
+                map[string]any{"timestamp": "2025-03-04T09:15:00Z", "statusCode": "transit", "status": "Processed"},
+            }},
+            map[string]any{"id": good + "B", "events": []any{
+                map[string]any{"timestamp": "next tuesday", "statusCode": "transit", "status": "Processed"},
+            }},
+        },
+    }
+    body, _ := json.Marshal(payload)
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    sig := hex.EncodeToString(mac.Sum(nil))
+
+    req := httptest.NewRequest(http.MethodPost, "/webhooks/dhl", bytes.NewReader(body))
+    req.Header.Set("X-Signature", sig)
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    // The payload is accepted but dead-lettered without retries
+    e := acceptedEntry(t, pool, h, rr)
+    if e.Status != inboxStatusDead || e.Attempts != 1 || !strings.Contains(e.LastError, "invalid occurred_at (event 1)") {
+        t.Fatalf("expected dead entry, got %+v", e)
+    }
+
+    // Nothing from the batch was stored
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/trackers/"+good, nil))
+    if rr.Code != http.StatusNotFound {
+        t.Fatalf("expected 404 for tracker from rejected batch, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+}
+
+func TestWebhook_ReplayedDeliveryRejected(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    secret := "testsecret"
+    os.Setenv("DUMMY_WEBHOOK_SECRET", secret)
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    h := New(pool)
+
+    body, _ := json.Marshal(map[string]any{"code": fmt.Sprintf("WHREPLAY%d", time.Now().UnixNano()), "status": "in_transit"})
+    post := func(sig, deliveryID string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodPost, "/webhooks/dummy", bytes.NewReader(body))
+        req.Header.Set("X-Signature", sig)
+        if deliveryID != "" {
+            req.Header.Set("X-Delivery-Id", deliveryID)
+        }
+        rr := httptest.NewRecorder()
+        h.ServeHTTP(rr, req)
+        return rr
+    }
+
+    // A captured timestamped request cannot be replayed
+    sig := signTimestamped(secret, time.Now().Unix(), body)
+    if rr := post(sig, ""); rr.Code != http.StatusAccepted {
+        t.Fatalf("expected 202, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    if rr := post(sig, ""); rr.Code != http.StatusConflict {
+        t.Fatalf("expected 409 on replay, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    // A fresh, unsigned delivery ID does not make the replay new
+    if rr := post(sig, fmt.Sprintf("dlv_fresh_%d", time.Now().UnixNano())); rr.Code != http.StatusConflict {
+        t.Fatalf("expected 409 on replay with new delivery ID, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+
+    // Delivery IDs are remembered per source
+    id := fmt.Sprintf("dlv_%d", time.Now().UnixNano())
+    if rr := post(signTimestamped(secret, time.Now().Unix()-1, body), id); rr.Code != http.StatusAccepted {
+        t.Fatalf("expected 202, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    rr := post(signTimestamped(secret, time.Now().Unix(), body), id)
+    var e stdError
+    _ = json.Unmarshal(rr.Body.Bytes(), &e)
+    if rr.Code != http.StatusConflict || e.Error.Code != "replayed_delivery" {
+        t.Fatalf("expected 409 replayed_delivery, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+}
+
+func TestWebhookInbox_ReplayWithoutTimestampDeduplicates(t *testing.T) {
+    dbURL := os.Getenv("DATABASE_URL")
+    if dbURL == "" {
+        t.Skip("DATABASE_URL not set; skipping integration test")
+        return
+    }
+    secret := "testsecret"
+    os.Setenv("DUMMY_WEBHOOK_SECRET", secret)
+
+    pool, err := db.NewPool(t.Context(), dbURL)
+    if err != nil {
+        t.Fatalf("failed to connect db: %v", err)
+    }
+    defer pool.Close()
+
+    h := New(pool)
+
+    code := fmt.Sprintf("WHINBOXREPLAY%d", time.Now().UnixNano())
+    defer pool.Exec(t.Context(), `DELETE FROM trackers WHERE carrier_tracking_code = $1`, code)
+    body, _ := json.Marshal(map[string]any{"code": code, "status": "in_transit", "description": "Departed"})
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    req := httptest.NewRequest(http.MethodPost, "/webhooks/dummy", bytes.NewReader(body))
+    req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+    rr := httptest.NewRecorder()
+    h.ServeHTTP(rr, req)
+    e := acceptedEntry(t, pool, h, rr)
+    if e.Status != inboxStatusProcessed || e.Result == nil || e.Result.Created != 1 {
+        t.Fatalf("expected one created event, got %+v", e)
+    }
+
+    // Processed later, the replay stamps the event with the same arrival time
+    time.Sleep(1100 * time.Millisecond)
+    rr = httptest.NewRecorder()
+    h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/webhook-inbox/"+e.ID+"/replay", nil))
+    if rr.Code != http.StatusOK {
+        t.Fatalf("replay: expected 200, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    if _, err := NewInboxProcessor(pool, InboxProcessorConfig{}).ProcessPending(t.Context()); err != nil {
+        t.Fatalf("process inbox: %v", err)
+    }
+    var events int
+    if err := pool.QueryRow(t.Context(), `
+        SELECT COUNT(*) FROM tracking_events e JOIN trackers t ON t.id = e.tracker_id
+        WHERE t.carrier_tracking_code = $1`, code).Scan(&events); err != nil {
+        t.Fatalf("count events: %v", err)
+    }
+    if events != 1 {
+        t.Fatalf("expected the replayed event to deduplicate, got %d events", events)
+    }
+}
+
+// acceptedEntry checks that a webhook was accepted into the inbox, processes
+// the inbox as the API's background processor would and returns the entry.
+func acceptedEntry(t *testing.T, pool *pgxpool.Pool, h http.Handler, rr *httptest.ResponseRecorder) WebhookInboxEntry {
+    t.Helper()
+    if rr.Code != http.StatusAccepted {
+        t.Fatalf("expected 202, got %d; body=%s", rr.Code, rr.Body.String())
+    }
+    var acc WebhookAcceptedResponse
+    if err := json.Unmarshal(rr.Body.Bytes(), &acc); err != nil {
+        t.Fatalf("unmarshal failed: %v", err)
+    }
+    if _, err := NewInboxProcessor(pool, InboxProcessorConfig{}).ProcessPending(t.Context()); err != nil {
+        t.Fatalf("process inbox: %v", err)
+    }
+    got := httptest.NewRecorder()
+    h.ServeHTTP(got, httptest.NewRequest(http.MethodGet, "/webhook-inbox/"+acc.ID, nil))
+    var e WebhookInboxEntry
+    if err := json.Unmarshal(got.Body.Bytes(), &e); err != nil || got.Code != http.StatusOK {
+        t.Fatalf("get inbox entry: %d %s", got.Code, got.Body.String())
+    }
+    return e
+}
@@ -0,0 +1,184 @@
+package tracking
+
+import (
+    "regexp"
+    "strings"
+)
+
+// Carrier codes recognised by Detect. They match the Normalize source keys
+// where a mapping table exists.
+const (
+    CarrierDHL       = "dhl"
+    CarrierYamato    = "yamato"
+    CarrierJapanPost = "japanpost"
+    CarrierSagawa    = "sagawa"
+    CarrierUPS       = "ups"
+    CarrierFedEx     = "fedex"
+    CarrierUSPS      = "usps"
+)
+
+type detector struct {
+    carrier string
+    formats []format
+}
+
+type format struct {
+    re    *regexp.Regexp
+    check func(string) bool
+}
+
+// detectors are tried in order; Detect reports every carrier that matches, so
+// numbers shared by several formats (12-digit Japanese domestic numbers) come
+// back as multiple candidates.
+var detectors = []detector{
+    {CarrierUPS, []format{
+        {regexp.MustCompile(`^1Z[0-9A-Z]{16}$`), upsCheck},
+    }},
+    {CarrierUSPS, []format{
+        {regexp.MustCompile(`^9[1-5][0-9]{18,20}$`), mod10Check},
+        {regexp.MustCompile(`^[A-Z]{2}[0-9]{9}US$`), s10Check},
+    }},
+    {CarrierFedEx, []format{
+        {regexp.MustCompile(`^[0-9]{12}$`), fedexExpressCheck},
+        {regexp.MustCompile(`^[0-9]{15}$`), mod10Check},
+    }},
+    {CarrierDHL, []format{
+        {regexp.MustCompile(`^[0-9]{10}$`), mod7Check},
+        {regexp.MustCompile(`^J{1,2}D[0-9]{10,18}$`), nil},
+    }},
+    {CarrierYamato, []format{
+        {regexp.MustCompile(`^[0-9]{12}$`), mod7Check},
+    }},
+    {CarrierSagawa, []format{
+        {regexp.MustCompile(`^[0-9]{12}$`), mod7Check},
+    }},
+    {CarrierJapanPost, []format{
+        {regexp.MustCompile(`^[0-9]{12}$`), mod7Check},
+        {regexp.MustCompile(`^[A-Z]{2}[0-9]{9}JP$`), s10Check},
+    }},
+}
+
+// NormalizeNumber uppercases a tracking number and drops spaces and hyphens,
+// which carriers print for readability.
+func NormalizeNumber(number string) string {
+    return strings.Map(func(r rune) rune {
+        switch r {
+        case ' ', '\t', '-':
+            return -1
+        }
+        if r >= 'a' && r <= 'z' {
+            return r - 'a' + 'A'
+        }
+        return r
+    }, strings.TrimSpace(number))
+}
+
+// Detect returns the carriers whose number format and check digit match.
+// The result is empty for unrecognised numbers.
+func Detect(number string) []string {
+    number = NormalizeNumber(number)
+    var out []string
+    for _, d := range detectors {
+        if d.match(number) {
+            out = append(out, d.carrier)
+        }
+    }
+    return out
+}
+
+// ValidNumber checks number against carrier's formats. known is false when
+// Detect has no rules for the carrier, in which case valid is meaningless.
+func ValidNumber(carrier, number string) (valid, known bool) {
+    c := key(carrier)
+    for _, d := range detectors {
+        if d.carrier == c {
+            return d.match(NormalizeNumber(number)), true
+        }
+    }
+    return false, false
+}
+
+func (d detector) match(number string) bool {
+    for _, f := range d.formats {
+        if f.re.MatchString(number) && (f.check == nil || f.check(number)) {
+            return true
+        }
+    }
+    return false
+}
+
+// mod7Check: the last digit is the preceding digits, read as a number, mod 7
+// (DHL Express waybills; Yamato, Sagawa and Japan Post domestic numbers).
+func mod7Check(s string) bool {
+    rem := 0
+    for _, c := range s[:len(s)-1] {
+        rem = (rem*10 + int(c-'0')) % 7
+    }
+    return rem == int(s[len(s)-1]-'0')
+}
+
+// mod10Check is the GS1 check digit: from the right, digits are weighted 3
+// and 1 alternately (USPS IMpb, FedEx Ground).
+func mod10Check(s string) bool {
+    sum := 0
+    body := s[:len(s)-1]
+    for i := len(body) - 1; i >= 0; i-- {
+        d := int(body[i] - '0')
+        if (len(body)-1-i)%2 == 0 {
+            d *= 3
+        }
+        sum += d
+    }
+    return (10-sum%10)%10 == int(s[len(s)-1]-'0')
+}
+
+// fedexExpressCheck weights the first 11 digits 1,3,7 from the right; the sum
+// mod 11 (10 counting as 0) is the check digit.
+func fedexExpressCheck(s string) bool {
+    weights := [3]int{1, 3, 7}
+    sum := 0
+    for i := 10; i >= 0; i-- {
+        sum += int(s[i]-'0') * weights[(10-i)%3]
+    }
+    return sum%11%10 == int(s[11]-'0')
+}
+
+// upsCheck validates 1Z numbers: letters map to digits, odd positions count
+// once and even positions twice.
+func upsCheck(s string) bool {
+    body := s[2:]
+    sum := 0
+    for i := 0; i < len(body)-1; i++ {
+        c := body[i]
+        var d int
+        if c >= 'A' && c <= 'Z' {
+            d = int(c-'A'+2) % 10
+        } else {
+            d = int(c - '0')
+        }
+        if i%2 == 1 {
+            d *= 2
+        }
+        sum += d
+    }
+    last := body[len(body)-1]
+    return last >= '0' && last <= '9' && (10-sum%10)%10 == int(last-'0')
+}
+
+// s10Check validates UPU S10 international numbers (e.g. EMS "EE123456785JP").
+func s10Check(s string) bool {
+    weights := [8]int{8, 6, 4, 2, 3, 5, 9, 7}
+    digits := s[2:11]
+    sum := 0
+    for i, w := range weights {
+        sum += int(digits[i]-'0') * w
+    }
+    c := 11 - sum%11
+    switch c {
+    case 10:
+        c = 0
+    case 11:
+        c = 5
+    }
+    return c == int(digits[8]-'0')
+}
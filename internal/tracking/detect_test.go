@@ -0,0 +1,52 @@
+package tracking
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestDetect(t *testing.T) {
+    cases := []struct {
+        number string
+        want   []string
+    }{
+        {"1Z999AA10123456784", []string{CarrierUPS}},
+        {"1z 999 aa1 01234 5678 4", []string{CarrierUPS}},
+        {"1Z999AA10123456785", nil}, // bad check digit
+        {"9400111899223100000000", []string{CarrierUSPS}},
+        {"RA123456785US", []string{CarrierUSPS}},
+        {"449044304137821", []string{CarrierFedEx}},
+        {"123456789012", []string{CarrierFedEx}},
+        {"1234567891", []string{CarrierDHL}},
+        {"1234567895", nil},
+        {"JD014600006281230704", []string{CarrierDHL}},
+        {"EE123456785JP", []string{CarrierJapanPost}},
+        {"EE123456786JP", nil},
+        // 12-digit mod-7 numbers are shared by the Japanese domestic carriers
+        {"1234-5678-9013", []string{CarrierYamato, CarrierSagawa, CarrierJapanPost}},
+        {"hello", nil},
+    }
+    for _, c := range cases {
+        if got := Detect(c.number); !reflect.DeepEqual(got, c.want) {
+            t.Errorf("Detect(%q) = %v, want %v", c.number, got, c.want)
+        }
+    }
+}
+
+func TestValidNumber(t *testing.T) {
+    if valid, known := ValidNumber("UPS", "1Z999AA10123456784"); !valid || !known {
+        t.Fatalf("expected valid UPS number, got valid=%v known=%v", valid, known)
+    }
+    if valid, known := ValidNumber("yamato", "123456789012"); valid || !known {
+        t.Fatalf("expected invalid Yamato check digit, got valid=%v known=%v", valid, known)
+    }
+    if _, known := ValidNumber("seino", "123"); known {
+        t.Fatalf("expected carrier without rules to be unknown")
+    }
+}
+
+func TestNormalizeNumber(t *testing.T) {
+    if got := NormalizeNumber(" 1z-999 aa1\t"); got != "1Z999AA1" {
+        t.Fatalf("NormalizeNumber = %q", got)
+    }
+}
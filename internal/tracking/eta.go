@@ -0,0 +1,76 @@
+package tracking
+
+import (
+    "sort"
+    "time"
+)
+
+const (
+    // MinETASamples is the fewest historical deliveries a lane needs before
+    // its transit times are trusted for a prediction.
+    MinETASamples = 10
+    // ETAConfidence is the share of deliveries expected inside
+    // [Earliest, Latest]: the 10th to 90th percentile of transit times.
+    ETAConfidence = 0.8
+)
+
+// Prediction is a predicted delivery time with its confidence interval.
+type Prediction struct {
+    EstimatedAt time.Time
+    Earliest    time.Time
+    Latest      time.Time
+    // Samples is the number of historical transit times the prediction used.
+    Samples int
+}
+
+// PredictETA predicts delivery from a lane's historical pickup-to-delivery
+// transit times. pickedUp is when the carrier took the parcel; zero means not
+// yet, and pickup is assumed to be now.
+//
+// The prediction is conditioned on the time already spent in transit: only
+// deliveries that took longer than that are considered, so it moves as scans
+// arrive. When the parcel is slower than nearly all of the history, the
+// slowest MinETASamples deliveries are used instead. No bound is earlier than
+// now. ok is false with fewer than MinETASamples samples.
+func PredictETA(samples []time.Duration, pickedUp, now time.Time) (p Prediction, ok bool) {
+    if len(samples) < MinETASamples {
+        return Prediction{}, false
+    }
+    sorted := append([]time.Duration(nil), samples...)
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+    start := pickedUp
+    if start.IsZero() || start.After(now) {
+        start = now
+    }
+    elapsed := now.Sub(start)
+    first := sort.Search(len(sorted), func(i int) bool { return sorted[i] > elapsed })
+    if len(sorted)-first < MinETASamples {
+        first = len(sorted) - MinETASamples
+    }
+    remaining := sorted[first:]
+    at := func(q float64) time.Time {
+        t := start.Add(quantile(remaining, q))
+        if t.Before(now) {
+            return now
+        }
+        return t
+    }
+    low := (1 - ETAConfidence) / 2
+    return Prediction{
+        EstimatedAt: at(0.5),
+        Earliest:    at(low),
+        Latest:      at(1 - low),
+        Samples:     len(remaining),
+    }, true
+}
+
+// quantile interpolates linearly between the closest ranks of sorted.
+func quantile(sorted []time.Duration, q float64) time.Duration {
+    pos := q * float64(len(sorted)-1)
+    i := int(pos)
+    if i >= len(sorted)-1 {
+        return sorted[len(sorted)-1]
+    }
+    frac := pos - float64(i)
+    return sorted[i] + time.Duration(frac*float64(sorted[i+1]-sorted[i]))
+}
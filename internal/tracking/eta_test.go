@@ -0,0 +1,58 @@
+package tracking
+
+import (
+    "testing"
+    "time"
+)
+
+func days(ds ...float64) []time.Duration {
+    out := make([]time.Duration, len(ds))
+    for i, d := range ds {
+        out[i] = time.Duration(d * 24 * float64(time.Hour))
+    }
+    return out
+}
+
+func TestPredictETA(t *testing.T) {
+    day := 24 * time.Hour
+    pickup := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+    // 1..11 days, median 6
+    samples := days(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11)
+
+    p, ok := PredictETA(samples, pickup, pickup)
+    if !ok || !p.EstimatedAt.Equal(pickup.Add(6*day)) || !p.Earliest.Equal(pickup.Add(2*day)) || !p.Latest.Equal(pickup.Add(10*day)) {
+        t.Fatalf("at pickup: %+v %v", p, ok)
+    }
+    if p.Samples != 11 {
+        t.Fatalf("expected all samples, got %d", p.Samples)
+    }
+
+    // Before pickup the clock starts now.
+    now := pickup.Add(-day)
+    p, _ = PredictETA(samples, time.Time{}, now)
+    if !p.EstimatedAt.Equal(now.Add(6 * day)) {
+        t.Fatalf("before pickup: %+v", p)
+    }
+
+    // Nine days in, only the slowest deliveries are relevant, and nothing is in the past.
+    now = pickup.Add(9*day + time.Hour)
+    p, _ = PredictETA(samples, pickup, now)
+    if p.Samples != MinETASamples || p.Earliest.Before(now) || p.EstimatedAt.Before(p.Earliest) || p.Latest.Before(p.EstimatedAt) {
+        t.Fatalf("late parcel: %+v", p)
+    }
+
+    if _, ok := PredictETA(days(1, 2, 3), pickup, pickup); ok {
+        t.Fatalf("expected no prediction for a thin lane")
+    }
+}
+
+func TestPredictETA_ConditionsOnElapsed(t *testing.T) {
+    day := 24 * time.Hour
+    pickup := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+    samples := append(days(1, 1, 1, 1, 1, 1, 1, 1, 1, 1), days(3, 3, 3, 3, 3, 3, 3, 3, 3, 3)...)
+    early, _ := PredictETA(samples, pickup, pickup)
+    late, _ := PredictETA(samples, pickup, pickup.Add(2*day))
+    if !late.EstimatedAt.After(early.EstimatedAt) || !late.EstimatedAt.Equal(pickup.Add(3*day)) {
+        t.Fatalf("expected prediction to move with elapsed time: early=%v late=%v", early.EstimatedAt, late.EstimatedAt)
+    }
+}
@@ -0,0 +1,196 @@
+package tracking
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// ExceptionReason says why a tracker needs attention.
+type ExceptionReason string
+
+const (
+    // ReasonStalled: no scan for longer than Thresholds.StallAfter.
+    ReasonStalled ExceptionReason = "stalled"
+    // ReasonDeliveryFailures: at least Thresholds.MaxFailedAttempts failed deliveries.
+    ReasonDeliveryFailures ExceptionReason = "delivery_failures"
+    // ReasonReturnToSender: the carrier is returning the parcel.
+    ReasonReturnToSender ExceptionReason = "return_to_sender"
+    // ReasonETABreached: undelivered past the estimated delivery plus Thresholds.ETAGrace.
+    ReasonETABreached ExceptionReason = "eta_breached"
+)
+
+// Thresholds tune exception detection. Zero fields fall back to the
+// defaults when rules are resolved.
+type Thresholds struct {
+    StallAfter        time.Duration
+    MaxFailedAttempts int
+    ETAGrace          time.Duration
+}
+
+// DefaultThresholds: five days without a scan, two failed attempts, one day
+// past the ETA.
+var DefaultThresholds = Thresholds{
+    StallAfter:        5 * 24 * time.Hour,
+    MaxFailedAttempts: 2,
+    ETAGrace:          24 * time.Hour,
+}
+
+// ExceptionRules holds the default thresholds and overrides keyed by carrier
+// ("yamato") or carrier and service ("yamato/cool").
+type ExceptionRules struct {
+    Default   Thresholds
+    Overrides map[string]Thresholds
+}
+
+// For resolves thresholds for a carrier and service: the carrier/service
+// override, then the carrier override, then the defaults, field by field.
+func (r ExceptionRules) For(carrier, service string) Thresholds {
+    th := r.Default.merge(DefaultThresholds)
+    carrier = strings.ToLower(strings.TrimSpace(carrier))
+    service = strings.ToLower(strings.TrimSpace(service))
+    if o, ok := r.Overrides[carrier]; ok {
+        th = o.merge(th)
+    }
+    if service != "" {
+        if o, ok := r.Overrides[carrier+"/"+service]; ok {
+            th = o.merge(th)
+        }
+    }
+    return th
+}
+
+func (t Thresholds) merge(base Thresholds) Thresholds {
+    if t.StallAfter <= 0 {
+        t.StallAfter = base.StallAfter
+    }
+    if t.MaxFailedAttempts <= 0 {
+        t.MaxFailedAttempts = base.MaxFailedAttempts
+    }
+    if t.ETAGrace <= 0 {
+        t.ETAGrace = base.ETAGrace
+    }
+    return t
+}
+
+// ParseExceptionRules reads rules from JSON, e.g.
+//
+//	{"default": {"stall_after": "120h", "max_failed_attempts": 2, "eta_grace": "24h"},
+//	 "carriers": {"dhl": {"stall_after": "72h"}, "yamato/cool": {"stall_after": "48h"}}}
+//
+// An empty string yields the defaults.
+func ParseExceptionRules(s string) (ExceptionRules, error) {
+    type thresholdsJSON struct {
+        StallAfter        string `json:"stall_after"`
+        MaxFailedAttempts int    `json:"max_failed_attempts"`
+        ETAGrace          string `json:"eta_grace"`
+    }
+    rules := ExceptionRules{Default: DefaultThresholds, Overrides: map[string]Thresholds{}}
+    if strings.TrimSpace(s) == "" {
+        return rules, nil
+    }
+    var in struct {
+        Default  thresholdsJSON            `json:"default"`
+        Carriers map[string]thresholdsJSON `json:"carriers"`
+    }
+    if err := json.Unmarshal([]byte(s), &in); err != nil {
+        return rules, fmt.Errorf("exception rules: %w", err)
+    }
+    convert := func(name string, j thresholdsJSON) (Thresholds, error) {
+        var (
+            th  = Thresholds{MaxFailedAttempts: j.MaxFailedAttempts}
+            err error
+        )
+        if j.StallAfter != "" {
+            if th.StallAfter, err = time.ParseDuration(j.StallAfter); err != nil {
+                return th, fmt.Errorf("exception rules: %s stall_after: %w", name, err)
+            }
+        }
+        if j.ETAGrace != "" {
+            if th.ETAGrace, err = time.ParseDuration(j.ETAGrace); err != nil {
+                return th, fmt.Errorf("exception rules: %s eta_grace: %w", name, err)
+            }
+        }
+        return th, nil
+    }
+    def, err := convert("default", in.Default)
+    if err != nil {
+        return rules, err
+    }
+    rules.Default = def.merge(DefaultThresholds)
+    for name, j := range in.Carriers {
+        th, err := convert(name, j)
+        if err != nil {
+            return rules, err
+        }
+        rules.Overrides[strings.ToLower(strings.TrimSpace(name))] = th
+    }
+    return rules, nil
+}
+
+// TrackerSnapshot is what exception detection looks at for one tracker.
+type TrackerSnapshot struct {
+    Status Status
+    // LastEventAt is zero when the tracker has no events; CreatedAt is used
+    // instead so labels that are never scanned also stall.
+    LastEventAt    time.Time
+    CreatedAt      time.Time
+    FailedAttempts int
+    // EstimatedDelivery is zero when unknown.
+    EstimatedDelivery time.Time
+}
+
+// Exception is one detected problem with details for notifications.
+type Exception struct {
+    Reason ExceptionReason `json:"reason"`
+    Detail map[string]any  `json:"detail,omitempty"`
+}
+
+// DetectExceptions returns the exceptions that apply to s at now. Delivered
+// trackers have none, so earlier exceptions resolve once a parcel arrives.
+func DetectExceptions(s TrackerSnapshot, th Thresholds, now time.Time) []Exception {
+    var out []Exception
+    switch s.Status {
+    case StatusDelivered:
+        return nil
+    case StatusReturnToSender:
+        return []Exception{{Reason: ReasonReturnToSender}}
+    }
+    since := s.LastEventAt
+    if since.IsZero() {
+        since = s.CreatedAt
+    }
+    if !since.IsZero() && now.Sub(since) > th.StallAfter {
+        out = append(out, Exception{Reason: ReasonStalled, Detail: map[string]any{
+            "since":  since.UTC().Format(time.RFC3339),
+            "days":   int(now.Sub(since).Hours() / 24),
+            "scans":  !s.LastEventAt.IsZero(),
+            "status": string(s.Status),
+        }})
+    }
+    if th.MaxFailedAttempts > 0 && s.FailedAttempts >= th.MaxFailedAttempts {
+        out = append(out, Exception{Reason: ReasonDeliveryFailures, Detail: map[string]any{
+            "attempts": s.FailedAttempts,
+        }})
+    }
+    if !s.EstimatedDelivery.IsZero() && now.After(s.EstimatedDelivery.Add(th.ETAGrace)) {
+        out = append(out, Exception{Reason: ReasonETABreached, Detail: map[string]any{
+            "estimated_delivery": s.EstimatedDelivery.UTC().Format(time.RFC3339),
+        }})
+    }
+    return out
+}
+
+// ParseETA accepts an RFC 3339 timestamp or a date ("2006-01-02"). A date
+// covers the whole day, so it is returned as the end of that day in UTC.
+func ParseETA(s string) (time.Time, bool) {
+    s = strings.TrimSpace(s)
+    if t, err := time.Parse(time.RFC3339, s); err == nil {
+        return t, true
+    }
+    if t, err := time.Parse("2006-01-02", s); err == nil {
+        return t.Add(24 * time.Hour), true
+    }
+    return time.Time{}, false
+}
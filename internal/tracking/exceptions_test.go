@@ -0,0 +1,81 @@
+package tracking
+
+import (
+    "testing"
+    "time"
+)
+
+func reasons(exs []Exception) []ExceptionReason {
+    var out []ExceptionReason
+    for _, e := range exs {
+        out = append(out, e.Reason)
+    }
+    return out
+}
+
+func TestDetectExceptions(t *testing.T) {
+    now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+    day := 24 * time.Hour
+    th := DefaultThresholds
+    cases := []struct {
+        name string
+        snap TrackerSnapshot
+        want []ExceptionReason
+    }{
+        {"moving", TrackerSnapshot{Status: StatusInTransit, LastEventAt: now.Add(-day)}, nil},
+        {"stalled", TrackerSnapshot{Status: StatusInTransit, LastEventAt: now.Add(-6 * day)}, []ExceptionReason{ReasonStalled}},
+        {"never scanned", TrackerSnapshot{Status: StatusUnknown, CreatedAt: now.Add(-6 * day)}, []ExceptionReason{ReasonStalled}},
+        {"failures", TrackerSnapshot{Status: StatusFailure, LastEventAt: now, FailedAttempts: 2}, []ExceptionReason{ReasonDeliveryFailures}},
+        {"one failure", TrackerSnapshot{Status: StatusFailure, LastEventAt: now, FailedAttempts: 1}, nil},
+        {"returning", TrackerSnapshot{Status: StatusReturnToSender, LastEventAt: now.Add(-30 * day)}, []ExceptionReason{ReasonReturnToSender}},
+        {"late", TrackerSnapshot{Status: StatusInTransit, LastEventAt: now, EstimatedDelivery: now.Add(-2 * day)}, []ExceptionReason{ReasonETABreached}},
+        {"within grace", TrackerSnapshot{Status: StatusInTransit, LastEventAt: now, EstimatedDelivery: now.Add(-time.Hour)}, nil},
+        {"delivered", TrackerSnapshot{Status: StatusDelivered, LastEventAt: now.Add(-30 * day), FailedAttempts: 3, EstimatedDelivery: now.Add(-20 * day)}, nil},
+    }
+    for _, c := range cases {
+        got := reasons(DetectExceptions(c.snap, th, now))
+        if len(got) != len(c.want) {
+            t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+            continue
+        }
+        for i := range got {
+            if got[i] != c.want[i] {
+                t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+            }
+        }
+    }
+}
+
+func TestExceptionRules_For(t *testing.T) {
+    rules, err := ParseExceptionRules(`{
+        "default": {"stall_after": "96h"},
+        "carriers": {"dhl": {"stall_after": "72h", "max_failed_attempts": 3}, "DHL/Express": {"eta_grace": "6h"}}
+    }`)
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+    if th := rules.For("yamato", ""); th.StallAfter != 96*time.Hour || th.MaxFailedAttempts != 2 || th.ETAGrace != 24*time.Hour {
+        t.Fatalf("default: %+v", th)
+    }
+    if th := rules.For("dhl", "economy"); th.StallAfter != 72*time.Hour || th.MaxFailedAttempts != 3 || th.ETAGrace != 24*time.Hour {
+        t.Fatalf("carrier: %+v", th)
+    }
+    if th := rules.For("DHL", "express"); th.StallAfter != 72*time.Hour || th.ETAGrace != 6*time.Hour {
+        t.Fatalf("service: %+v", th)
+    }
+    if _, err := ParseExceptionRules(`{"default": {"stall_after": "5 days"}}`); err == nil {
+        t.Fatalf("expected error for invalid duration")
+    }
+}
+
+func TestParseETA(t *testing.T) {
+    if got, ok := ParseETA("2026-03-10"); !ok || !got.Equal(time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC)) {
+        t.Fatalf("date: %v %v", got, ok)
+    }
+    if got, ok := ParseETA("2026-03-10T09:00:00+09:00"); !ok || !got.Equal(time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)) {
+        t.Fatalf("timestamp: %v %v", got, ok)
+    }
+    if _, ok := ParseETA("soon"); ok {
+        t.Fatalf("expected parse failure")
+    }
+}
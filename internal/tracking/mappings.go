@@ -0,0 +1,134 @@
+package tracking
+
+// Mapping tables keyed by key(carrierStatus). Add a source by adding a table
+// to sourceMappings; entries there take precedence over genericMappings.
+
+var genericMappings = map[string]Mapping{
+    "pretransit":         {StatusPreTransit, ""},
+    "labelcreated":       {StatusPreTransit, "label_created"},
+    "inforeceived":       {StatusPreTransit, "info_received"},
+    "pending":            {StatusPreTransit, ""},
+    "created":            {StatusPreTransit, "label_created"},
+    "transit":            {StatusInTransit, ""},
+    "intransit":          {StatusInTransit, ""},
+    "pickedup":           {StatusInTransit, "picked_up"},
+    "accepted":           {StatusInTransit, "picked_up"},
+    "departed":           {StatusInTransit, "departed_facility"},
+    "arrived":            {StatusInTransit, "arrived_at_facility"},
+    "delayed":            {StatusInTransit, "delayed"},
+    "deliverydelayed":    {StatusInTransit, "delayed"},
+    "customs":            {StatusInTransit, "customs"},
+    "outfordelivery":     {StatusOutForDelivery, ""},
+    "delivered":          {StatusDelivered, ""},
+    "availableforpickup": {StatusAvailableForPickup, ""},
+    "readyforpickup":     {StatusAvailableForPickup, ""},
+    "returntosender":     {StatusReturnToSender, ""},
+    "returned":           {StatusReturnToSender, ""},
+    "failure":            {StatusFailure, ""},
+    "deliveryfailed":     {StatusFailure, "delivery_attempted"},
+    "attemptfail":        {StatusFailure, "delivery_attempted"},
+    "exception":          {StatusException, ""},
+    "onhold":             {StatusException, "held"},
+    "cancelled":          {StatusException, "canceled"},
+    "canceled":           {StatusException, "canceled"},
+    "expired":            {StatusException, "expired"},
+    "notfound":           {StatusUnknown, "not_found"},
+    "unknown":            {StatusUnknown, ""},
+}
+
+var sourceMappings = map[string]map[string]Mapping{
+    // Karrio tracker statuses
+    "karrio": {
+        "pending":         {StatusPreTransit, ""},
+        "onhold":          {StatusException, "held"},
+        "intransit":       {StatusInTransit, ""},
+        "deliverydelayed": {StatusInTransit, "delayed"},
+        "outfordelivery":  {StatusOutForDelivery, ""},
+        "readyforpickup":  {StatusAvailableForPickup, ""},
+        "delivered":       {StatusDelivered, ""},
+        "deliveryfailed":  {StatusFailure, "delivery_attempted"},
+        "returntosender":  {StatusReturnToSender, ""},
+        "cancelled":       {StatusException, "canceled"},
+        "unknown":         {StatusUnknown, ""},
+    },
+    // 17TRACK main statuses
+    "17track": {
+        "notfound":           {StatusUnknown, "not_found"},
+        "inforeceived":       {StatusPreTransit, "info_received"},
+        "intransit":          {StatusInTransit, ""},
+        "expired":            {StatusException, "expired"},
+        "availableforpickup": {StatusAvailableForPickup, ""},
+        "outfordelivery":     {StatusOutForDelivery, ""},
+        "deliveryfailure":    {StatusFailure, "delivery_attempted"},
+        "delivered":          {StatusDelivered, ""},
+        "exception":          {StatusException, ""},
+    },
+    // DHL Shipment Tracking statusCode values
+    "dhl": {
+        "pretransit": {StatusPreTransit, ""},
+        "transit":    {StatusInTransit, ""},
+        "delivered":  {StatusDelivered, ""},
+        "failure":    {StatusFailure, ""},
+        "unknown":    {StatusUnknown, ""},
+    },
+    // Yamato Transport status names
+    "yamato": {
+        "荷物受付":    {StatusPreTransit, "info_received"},
+        "発送済み":    {StatusInTransit, "picked_up"},
+        "輸送中":     {StatusInTransit, ""},
+        "作業店通過":   {StatusInTransit, "departed_facility"},
+        "配達中":     {StatusOutForDelivery, ""},
+        "配達完了":    {StatusDelivered, ""},
+        "投函完了":    {StatusDelivered, "mailbox"},
+        "持戻（ご不在）": {StatusFailure, "delivery_attempted"},
+        "持戻(ご不在)": {StatusFailure, "delivery_attempted"},
+        "保管中":     {StatusAvailableForPickup, ""},
+        "返品":      {StatusReturnToSender, ""},
+        "調査中":     {StatusException, "investigating"},
+    },
+    // Japan Post status names
+    "japanpost": {
+        "引受":         {StatusInTransit, "picked_up"},
+        "到着":         {StatusInTransit, "arrived_at_facility"},
+        "通過":         {StatusInTransit, "departed_facility"},
+        "中継":         {StatusInTransit, ""},
+        "国際交換局から発送":  {StatusInTransit, "customs"},
+        "お届け先にお届け済み": {StatusDelivered, ""},
+        "窓口でお渡し":     {StatusDelivered, "picked_up_by_recipient"},
+        "ご不在のため持ち戻り": {StatusFailure, "delivery_attempted"},
+        "保管":         {StatusAvailableForPickup, ""},
+        "差出人に返送":     {StatusReturnToSender, ""},
+    },
+    // Sagawa Express status names
+    "sagawa": {
+        "集荷":    {StatusInTransit, "picked_up"},
+        "輸送中":   {StatusInTransit, ""},
+        "配達中":   {StatusOutForDelivery, ""},
+        "配達完了":  {StatusDelivered, ""},
+        "ご不在":   {StatusFailure, "delivery_attempted"},
+        "営業所保管": {StatusAvailableForPickup, ""},
+        "返送":    {StatusReturnToSender, ""},
+    },
+}
+
+// keywordRules catch free-form carrier text the exact tables miss. Order
+// matters: more specific outcomes are listed before generic transit words.
+var keywordRules = []struct {
+    keyword string
+    Mapping
+}{
+    {"配達完了", Mapping{StatusDelivered, ""}},
+    {"お届け済み", Mapping{StatusDelivered, ""}},
+    {"返送", Mapping{StatusReturnToSender, ""}},
+    {"返品", Mapping{StatusReturnToSender, ""}},
+    {"持戻", Mapping{StatusFailure, "delivery_attempted"}},
+    {"持ち戻り", Mapping{StatusFailure, "delivery_attempted"}},
+    {"不在", Mapping{StatusFailure, "delivery_attempted"}},
+    {"配達中", Mapping{StatusOutForDelivery, ""}},
+    {"保管", Mapping{StatusAvailableForPickup, ""}},
+    {"輸送中", Mapping{StatusInTransit, ""}},
+    {"発送", Mapping{StatusInTransit, ""}},
+    {"到着", Mapping{StatusInTransit, "arrived_at_facility"}},
+    {"通過", Mapping{StatusInTransit, "departed_facility"}},
+    {"受付", Mapping{StatusPreTransit, "info_received"}},
+}
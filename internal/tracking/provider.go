@@ -0,0 +1,84 @@
+package tracking
+
+import (
+    "context"
+    "encoding/json"
+    "strings"
+    "time"
+)
+
+// Provider fetches a tracking number's events from a carrier API or an
+// aggregator. Events carry the carrier's own status; callers normalise them.
+type Provider interface {
+    Track(ctx context.Context, carrier, number string) ([]ProviderEvent, error)
+}
+
+// ProviderEvent is one scan as reported by a Provider.
+type ProviderEvent struct {
+    Status      string
+    Substatus   string
+    Description string
+    Location    json.RawMessage
+    OccurredAt  time.Time
+    Raw         json.RawMessage
+}
+
+// Noop reports no events. It keeps the poller runnable until a real
+// provider is configured.
+type Noop struct{}
+
+func NewNoop() *Noop { return &Noop{} }
+
+func (n *Noop) Track(ctx context.Context, carrier, number string) ([]ProviderEvent, error) {
+    return nil, nil
+}
+
+// NewProviderByName returns a Provider by name. Unknown names fall back to Noop.
+func NewProviderByName(name string) Provider {
+    switch strings.ToLower(strings.TrimSpace(name)) {
+    case "noop", "":
+        return NewNoop()
+    default:
+        // Placeholder: carrier APIs / aggregators (e.g., karrio, 17track)
+        return NewNoop()
+    }
+}
+
+// Poll intervals by status. Trackers close to delivery are polled more often;
+// terminal trackers are not polled at all.
+const (
+    pollOutForDelivery = 30 * time.Minute
+    pollException      = time.Hour
+    pollInTransit      = 2 * time.Hour
+    pollPickup         = 3 * time.Hour
+    pollIdle           = 6 * time.Hour
+    pollMaxBackoff     = 24 * time.Hour
+)
+
+// PollInterval returns how long to wait before polling a tracker in status s
+// again, doubling per consecutive failure up to a day. ok is false for
+// terminal statuses, which stop polling.
+func PollInterval(s Status, failures int) (d time.Duration, ok bool) {
+    if s.Terminal() {
+        return 0, false
+    }
+    switch s {
+    case StatusOutForDelivery:
+        d = pollOutForDelivery
+    case StatusException, StatusFailure:
+        d = pollException
+    case StatusInTransit:
+        d = pollInTransit
+    case StatusAvailableForPickup:
+        d = pollPickup
+    default:
+        d = pollIdle
+    }
+    for i := 0; i < failures && d < pollMaxBackoff; i++ {
+        d *= 2
+    }
+    if d > pollMaxBackoff {
+        d = pollMaxBackoff
+    }
+    return d, true
+}
@@ -0,0 +1,29 @@
+package tracking
+
+import (
+    "testing"
+    "time"
+)
+
+func TestPollInterval(t *testing.T) {
+    cases := []struct {
+        status   Status
+        failures int
+        want     time.Duration
+        ok       bool
+    }{
+        {StatusOutForDelivery, 0, 30 * time.Minute, true},
+        {StatusInTransit, 0, 2 * time.Hour, true},
+        {StatusUnknown, 0, 6 * time.Hour, true},
+        {StatusInTransit, 2, 8 * time.Hour, true},
+        {StatusInTransit, 10, 24 * time.Hour, true},
+        {StatusDelivered, 0, 0, false},
+        {StatusReturnToSender, 0, 0, false},
+    }
+    for _, c := range cases {
+        got, ok := PollInterval(c.status, c.failures)
+        if got != c.want || ok != c.ok {
+            t.Errorf("PollInterval(%s, %d) = %v, %v; want %v, %v", c.status, c.failures, got, ok, c.want, c.ok)
+        }
+    }
+}
@@ -0,0 +1,106 @@
+package tracking
+
+import "time"
+
+// Event is the subset of a tracking event needed to derive tracker state.
+type Event struct {
+    Status     Status
+    Substatus  string
+    OccurredAt time.Time
+}
+
+// State is the summary stored on a tracker.
+type State struct {
+    Status      Status
+    Substatus   string
+    LastEventAt time.Time
+    // PickedUpAt is the earliest event showing the carrier has the parcel
+    // (anything past pre_transit); zero before pickup.
+    PickedUpAt time.Time
+    // DeliveredAt is the occurred_at of the delivery when Status is delivered.
+    DeliveredAt time.Time
+}
+
+// Terminal reports whether s ends a tracker's lifecycle. Once a tracker has a
+// terminal event, non-terminal events can no longer change its status.
+func (s Status) Terminal() bool {
+    return s == StatusDelivered || s == StatusReturnToSender
+}
+
+// rank orders statuses by lifecycle progress. It only breaks ties between
+// events sharing the same occurred_at.
+func (s Status) rank() int {
+    switch s {
+    case StatusPreTransit:
+        return 1
+    case StatusInTransit:
+        return 2
+    case StatusOutForDelivery:
+        return 3
+    case StatusAvailableForPickup:
+        return 4
+    case StatusFailure, StatusException:
+        return 5
+    case StatusReturnToSender:
+        return 6
+    case StatusDelivered:
+        return 7
+    default:
+        return 0
+    }
+}
+
+// Derive computes tracker state from its events regardless of arrival order:
+//   - LastEventAt is the latest occurred_at of any event.
+//   - If any terminal event exists, the latest terminal event sets the status,
+//     so a late or skewed in_transit scan cannot rewind a delivered tracker.
+//   - Otherwise the latest event with a known status sets it; events without
+//     a status or with unknown never override a known one.
+//   - Events at the same instant are ordered by lifecycle progress.
+//   - PickedUpAt and DeliveredAt bound the transit time used for ETAs.
+func Derive(events []Event) State {
+    var (
+        st                  State
+        latest, latestFinal *Event
+    )
+    for i := range events {
+        ev := &events[i]
+        if ev.OccurredAt.After(st.LastEventAt) {
+            st.LastEventAt = ev.OccurredAt
+        }
+        if ev.Status == "" || ev.Status == StatusUnknown {
+            continue
+        }
+        if ev.Status != StatusPreTransit && (st.PickedUpAt.IsZero() || ev.OccurredAt.Before(st.PickedUpAt)) {
+            st.PickedUpAt = ev.OccurredAt
+        }
+        if later(ev, latest) {
+            latest = ev
+        }
+        if ev.Status.Terminal() && later(ev, latestFinal) {
+            latestFinal = ev
+        }
+    }
+    switch {
+    case latestFinal != nil:
+        st.Status, st.Substatus = latestFinal.Status, latestFinal.Substatus
+        if st.Status == StatusDelivered {
+            st.DeliveredAt = latestFinal.OccurredAt
+        }
+    case latest != nil:
+        st.Status, st.Substatus = latest.Status, latest.Substatus
+    default:
+        st.Status = StatusUnknown
+    }
+    return st
+}
+
+func later(a, b *Event) bool {
+    if b == nil {
+        return true
+    }
+    if !a.OccurredAt.Equal(b.OccurredAt) {
+        return a.OccurredAt.After(b.OccurredAt)
+    }
+    return a.Status.rank() > b.Status.rank()
+}
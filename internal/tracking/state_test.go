@@ -0,0 +1,84 @@
+package tracking
+
+import (
+    "math/rand"
+    "testing"
+    "time"
+)
+
+func at(h int) time.Time { return time.Date(2025, 1, 1, h, 0, 0, 0, time.UTC) }
+
+func deriveShuffled(t *testing.T, events []Event, want State) {
+    t.Helper()
+    rng := rand.New(rand.NewSource(1))
+    for i := 0; i < 50; i++ {
+        shuffled := append([]Event(nil), events...)
+        rng.Shuffle(len(shuffled), func(a, b int) { shuffled[a], shuffled[b] = shuffled[b], shuffled[a] })
+        if got := Derive(shuffled); got != want {
+            t.Fatalf("Derive(%+v) = %+v, want %+v", shuffled, got, want)
+        }
+    }
+}
+
+func TestDerive_LatestEventWins(t *testing.T) {
+    events := []Event{
+        {Status: StatusPreTransit, OccurredAt: at(1)},
+        {Status: StatusInTransit, Substatus: "departed_facility", OccurredAt: at(2)},
+        {Status: StatusOutForDelivery, OccurredAt: at(3)},
+    }
+    deriveShuffled(t, events, State{Status: StatusOutForDelivery, LastEventAt: at(3), PickedUpAt: at(2)})
+}
+
+func TestDerive_TerminalPrecedence(t *testing.T) {
+    // A later in_transit scan (clock skew, sorting hub re-scan) must not rewind delivered.
+    events := []Event{
+        {Status: StatusInTransit, OccurredAt: at(1)},
+        {Status: StatusDelivered, Substatus: "mailbox", OccurredAt: at(2)},
+        {Status: StatusInTransit, OccurredAt: at(3)},
+    }
+    deriveShuffled(t, events, State{Status: StatusDelivered, Substatus: "mailbox", LastEventAt: at(3), PickedUpAt: at(1), DeliveredAt: at(2)})
+
+    // Between terminal events the latest one wins.
+    events = []Event{
+        {Status: StatusDelivered, OccurredAt: at(1)},
+        {Status: StatusReturnToSender, OccurredAt: at(2)},
+        {Status: StatusFailure, OccurredAt: at(4)},
+    }
+    deriveShuffled(t, events, State{Status: StatusReturnToSender, LastEventAt: at(4), PickedUpAt: at(1)})
+}
+
+func TestDerive_UnknownDoesNotOverride(t *testing.T) {
+    events := []Event{
+        {Status: StatusInTransit, OccurredAt: at(1)},
+        {Status: StatusUnknown, OccurredAt: at(2)},
+        {Status: "", OccurredAt: at(3)},
+    }
+    deriveShuffled(t, events, State{Status: StatusInTransit, LastEventAt: at(3), PickedUpAt: at(1)})
+
+    if got := Derive(nil); got.Status != StatusUnknown {
+        t.Fatalf("no events should derive unknown, got %+v", got)
+    }
+}
+
+func TestDerive_SameInstantUsesProgress(t *testing.T) {
+    events := []Event{
+        {Status: StatusOutForDelivery, OccurredAt: at(5)},
+        {Status: StatusInTransit, OccurredAt: at(5)},
+        {Status: StatusPreTransit, OccurredAt: at(5)},
+    }
+    deriveShuffled(t, events, State{Status: StatusOutForDelivery, LastEventAt: at(5), PickedUpAt: at(5)})
+}
+
+func TestDerive_TransitBounds(t *testing.T) {
+    events := []Event{
+        {Status: StatusPreTransit, OccurredAt: at(1)},
+        {Status: StatusInTransit, OccurredAt: at(3)},
+        {Status: StatusInTransit, OccurredAt: at(6)},
+        {Status: StatusDelivered, OccurredAt: at(9)},
+    }
+    deriveShuffled(t, events, State{Status: StatusDelivered, LastEventAt: at(9), PickedUpAt: at(3), DeliveredAt: at(9)})
+
+    // Returned parcels have no delivery time.
+    events = append(events[:2], Event{Status: StatusReturnToSender, OccurredAt: at(12)})
+    deriveShuffled(t, events, State{Status: StatusReturnToSender, LastEventAt: at(12), PickedUpAt: at(3)})
+}
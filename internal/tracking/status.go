@@ -0,0 +1,93 @@
+package tracking
+
+import (
+    "strings"
+    "unicode"
+)
+
+// Status is the canonical tracking status stored on trackers and tracking_events.
+type Status string
+
+const (
+    StatusPreTransit         Status = "pre_transit"
+    StatusInTransit          Status = "in_transit"
+    StatusOutForDelivery     Status = "out_for_delivery"
+    StatusDelivered          Status = "delivered"
+    StatusAvailableForPickup Status = "available_for_pickup"
+    StatusReturnToSender     Status = "return_to_sender"
+    StatusFailure            Status = "failure"
+    StatusException          Status = "exception"
+    StatusUnknown            Status = "unknown"
+)
+
+// Statuses lists every canonical status.
+var Statuses = []Status{
+    StatusPreTransit,
+    StatusInTransit,
+    StatusOutForDelivery,
+    StatusDelivered,
+    StatusAvailableForPickup,
+    StatusReturnToSender,
+    StatusFailure,
+    StatusException,
+    StatusUnknown,
+}
+
+// Valid reports whether s is one of the canonical statuses.
+func (s Status) Valid() bool {
+    for _, c := range Statuses {
+        if s == c {
+            return true
+        }
+    }
+    return false
+}
+
+// Mapping is the canonical status and optional substatus for a carrier status.
+type Mapping struct {
+    Status    Status
+    Substatus string
+}
+
+// Normalize maps a carrier-reported status into the canonical taxonomy.
+// The source's own table is consulted first, then the generic aliases, then
+// keyword rules for free-form (including Japanese) descriptions. Statuses
+// that are already canonical pass through; anything unrecognised is unknown.
+// An empty input yields an empty status so callers can treat it as absent.
+func Normalize(source, carrierStatus string) Mapping {
+    raw := strings.TrimSpace(carrierStatus)
+    if raw == "" {
+        return Mapping{}
+    }
+    k := key(raw)
+    if table, ok := sourceMappings[key(source)]; ok {
+        if m, ok := table[k]; ok {
+            return m
+        }
+    }
+    if m, ok := genericMappings[k]; ok {
+        return m
+    }
+    if s := Status(strings.ToLower(raw)); s.Valid() {
+        return Mapping{Status: s}
+    }
+    for _, rule := range keywordRules {
+        if strings.Contains(raw, rule.keyword) {
+            return rule.Mapping
+        }
+    }
+    return Mapping{Status: StatusUnknown}
+}
+
+// key folds case and drops separators and whitespace so "In Transit",
+// "in_transit", "InTransit" and "IN-TRANSIT" compare equal.
+func key(s string) string {
+    var b strings.Builder
+    for _, r := range strings.ToLower(s) {
+        if unicode.IsSpace(r) || r == '_' || r == '-' || r == '.' {
+            continue
+        }
+        b.WriteRune(r)
+    }
+    return b.String()
+}
@@ -0,0 +1,65 @@
+package tracking
+
+import "testing"
+
+func TestNormalize_GenericAliases(t *testing.T) {
+    for _, raw := range []string{"in_transit", "InTransit", "transit", "IN TRANSIT", "in-transit"} {
+        if got := Normalize("", raw); got.Status != StatusInTransit {
+            t.Errorf("Normalize(%q) = %+v, want in_transit", raw, got)
+        }
+    }
+    if got := Normalize("", ""); got.Status != "" {
+        t.Errorf("empty status should stay empty, got %+v", got)
+    }
+    if got := Normalize("", "teleported"); got.Status != StatusUnknown {
+        t.Errorf("unrecognised status should be unknown, got %+v", got)
+    }
+}
+
+func TestNormalize_PerSource(t *testing.T) {
+    cases := []struct {
+        source, raw string
+        want        Mapping
+    }{
+        {"karrio", "delivery_delayed", Mapping{StatusInTransit, "delayed"}},
+        {"karrio", "ready_for_pickup", Mapping{StatusAvailableForPickup, ""}},
+        {"17track", "DeliveryFailure", Mapping{StatusFailure, "delivery_attempted"}},
+        {"17track", "NotFound", Mapping{StatusUnknown, "not_found"}},
+        {"dhl", "pre-transit", Mapping{StatusPreTransit, ""}},
+        {"DHL", "transit", Mapping{StatusInTransit, ""}},
+        {"yamato", "配達完了", Mapping{StatusDelivered, ""}},
+        {"yamato", "持戻（ご不在）", Mapping{StatusFailure, "delivery_attempted"}},
+        {"japan_post", "お届け先にお届け済み", Mapping{StatusDelivered, ""}},
+        {"sagawa", "営業所保管", Mapping{StatusAvailableForPickup, ""}},
+    }
+    for _, c := range cases {
+        if got := Normalize(c.source, c.raw); got != c.want {
+            t.Errorf("Normalize(%q, %q) = %+v, want %+v", c.source, c.raw, got, c.want)
+        }
+    }
+}
+
+func TestNormalize_JapaneseKeywords(t *testing.T) {
+    cases := map[string]Status{
+        "お荷物は配達完了しました":  StatusDelivered,
+        "ご不在のため持ち帰りました": StatusFailure,
+        "ただいま配達中です":     StatusOutForDelivery,
+        "東京ベースを発送しました":  StatusInTransit,
+    }
+    for raw, want := range cases {
+        if got := Normalize("", raw); got.Status != want {
+            t.Errorf("Normalize(%q) = %+v, want %s", raw, got, want)
+        }
+    }
+}
+
+func TestStatusValid(t *testing.T) {
+    for _, s := range Statuses {
+        if !s.Valid() {
+            t.Errorf("%s should be valid", s)
+        }
+    }
+    if Status("InTransit").Valid() {
+        t.Errorf("non-canonical status should not be valid")
+    }
+}